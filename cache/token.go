@@ -1,37 +1,144 @@
 package cache
 
 import (
-	"github.com/patrickmn/go-cache"
+	"context"
 	"github.com/prok05/ecom/service/alpha"
+	"golang.org/x/sync/singleflight"
 	"log"
+	"net/http"
+	"sync"
 	"time"
 )
 
+// TokenStatus - состояние токена AlfaCRM школы для мониторинга.
+type TokenStatus struct {
+	Account       string     `json:"account"`
+	Valid         bool       `json:"valid"`
+	IssuedAt      *time.Time `json:"issued_at,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	Logins        int        `json:"logins"`
+	Failures      int        `json:"failures"`
+	Invalidations int        `json:"invalidations"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorAt   *time.Time `json:"last_error_at,omitempty"`
+}
+
+type tokenEntry struct {
+	token    string
+	issuedAt time.Time
+	status   TokenStatus
+}
+
+// TokenCache выдает токены AlfaCRM, у каждой школы свой токен.
+// Одновременные запросы нового токена объединяются в один вход в CRM.
+// Токен обновляется в фоне за refreshBefore до истечения, а отклоненный
+// CRM токен сбрасывается через Invalidate.
 type TokenCache struct {
-	cache  *cache.Cache
-	expiry time.Duration
+	expiry        time.Duration
+	refreshBefore time.Duration
+	httpClient    *http.Client
+
+	mu      sync.Mutex
+	entries map[string]*tokenEntry
+	group   singleflight.Group
 }
 
-func NewTokenCache(expiry time.Duration) *TokenCache {
-	c := cache.New(expiry, expiry)
+func NewTokenCache(expiry, refreshBefore time.Duration) *TokenCache {
 	return &TokenCache{
-		cache:  c,
-		expiry: expiry,
+		expiry:        expiry,
+		refreshBefore: refreshBefore,
+		httpClient:    alpha.NewHTTPClient(),
+		entries:       make(map[string]*tokenEntry),
 	}
 }
 
-func (tc *TokenCache) GetToken() (string, error) {
-	token, found := tc.cache.Get("alpha_token")
-	if found {
-		return token.(string), nil
+// GetToken возвращает действующий токен школы, при необходимости входит в CRM.
+func (tc *TokenCache) GetToken(account alpha.Account) (string, error) {
+	tc.mu.Lock()
+	entry := tc.entry(account.Key)
+	token, age := entry.token, time.Since(entry.issuedAt)
+	tc.mu.Unlock()
+
+	if token != "" && age < tc.expiry {
+		if age >= tc.expiry-tc.refreshBefore {
+			// токен еще действует, новый получаем не задерживая запрос
+			go func() {
+				if _, err := tc.login(account); err != nil {
+					log.Printf("background alpha token refresh for %s failed: %v", account.Key, err)
+				}
+			}()
+		}
+		return token, nil
 	}
 
-	newToken, err := alpha.GetAlphaToken()
+	return tc.login(account)
+}
+
+// Invalidate сбрасывает токен, который отклонила CRM. Если токен уже
+// заменен другим запросом, новый не трогается.
+func (tc *TokenCache) Invalidate(account alpha.Account, token string) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	entry := tc.entry(account.Key)
+	if entry.token != token {
+		return
+	}
+	entry.token = ""
+	entry.status.Invalidations++
+	log.Printf("Alpha token for %s rejected by CRM, invalidated", account.Key)
+}
+
+// Status возвращает состояние токена школы.
+func (tc *TokenCache) Status(account alpha.Account) TokenStatus {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	entry := tc.entry(account.Key)
+	status := entry.status
+	status.Account = account.Key
+	if entry.token != "" {
+		issuedAt := entry.issuedAt
+		expiresAt := issuedAt.Add(tc.expiry)
+		status.IssuedAt = &issuedAt
+		status.ExpiresAt = &expiresAt
+		status.Valid = time.Now().Before(expiresAt)
+	}
+	return status
+}
+
+func (tc *TokenCache) login(account alpha.Account) (string, error) {
+	token, err, _ := tc.group.Do(account.Key, func() (any, error) {
+		token, err := alpha.Login(context.Background(), tc.httpClient, account)
+
+		tc.mu.Lock()
+		defer tc.mu.Unlock()
+		entry := tc.entry(account.Key)
+		if err != nil {
+			now := time.Now()
+			entry.status.Failures++
+			entry.status.LastError = err.Error()
+			entry.status.LastErrorAt = &now
+			return "", err
+		}
+		entry.token = token
+		entry.issuedAt = time.Now()
+		entry.status.Logins++
+		log.Printf("Token restored for %s", account.Key)
+		return token, nil
+	})
 	if err != nil {
 		return "", err
 	}
+	return token.(string), nil
+}
 
-	tc.cache.Set("alpha_token", newToken, tc.expiry)
-	log.Println("Token restored")
-	return newToken, nil
+// entry вызывается под tc.mu
+func (tc *TokenCache) entry(key string) *tokenEntry {
+	entry, ok := tc.entries[key]
+	if !ok {
+		entry = &tokenEntry{}
+		tc.entries[key] = entry
+	}
+	return entry
 }
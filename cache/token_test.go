@@ -0,0 +1,156 @@
+package cache
+
+import (
+	"github.com/prok05/ecom/service/alpha"
+	"github.com/prok05/ecom/service/alpha/alphatest"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newLoginServer поднимает fake-сервер AlfaCRM, который считает входы и отвечает на них с задержкой.
+func newLoginServer(t *testing.T, delay time.Duration, logins *atomic.Int32) alpha.Account {
+	t.Helper()
+	fake := alphatest.NewServer(&alphatest.Fixtures{Email: "crm@school.ru", APIKey: "key"})
+	handler := fake.Handler()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2api/auth/login" {
+			logins.Add(1)
+			time.Sleep(delay)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return alpha.Account{Key: "test", Host: srv.URL, BranchID: 1, Email: "crm@school.ru", APIKey: "key"}
+}
+
+func TestConcurrentLoginsAreDeduplicated(t *testing.T) {
+	var logins atomic.Int32
+	account := newLoginServer(t, 50*time.Millisecond, &logins)
+	tc := NewTokenCache(time.Hour, time.Minute)
+
+	const callers = 20
+	tokens := make([]string, callers)
+	errs := make([]error, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tokens[i], errs[i] = tc.GetToken(account)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range tokens {
+		if errs[i] != nil {
+			t.Fatalf("GetToken: %v", errs[i])
+		}
+		if tokens[i] != tokens[0] {
+			t.Fatalf("callers got different tokens: %q and %q", tokens[0], tokens[i])
+		}
+	}
+	if n := logins.Load(); n != 1 {
+		t.Errorf("%d logins for %d concurrent callers, want 1", n, callers)
+	}
+}
+
+func TestTokenIsRefreshedEarly(t *testing.T) {
+	var logins atomic.Int32
+	account := newLoginServer(t, 0, &logins)
+	tc := NewTokenCache(400*time.Millisecond, 300*time.Millisecond)
+
+	first, err := tc.GetToken(account)
+	if err != nil {
+		t.Fatalf("GetToken: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		wait       time.Duration
+		sameToken  bool
+		wantLogins int32
+	}{
+		{"fresh token is reused", 0, true, 1},
+		// токен в окне обновления отдается сразу, новый запрашивается в фоне
+		{"token in refresh window", 150 * time.Millisecond, true, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			time.Sleep(tt.wait)
+			token, err := tc.GetToken(account)
+			if err != nil {
+				t.Fatalf("GetToken: %v", err)
+			}
+			if (token == first) != tt.sameToken {
+				t.Errorf("token reused = %v, want %v", token == first, tt.sameToken)
+			}
+			waitFor(t, func() bool { return logins.Load() == tt.wantLogins })
+		})
+	}
+
+	waitFor(t, func() bool { return tc.Status(account).Logins == 2 })
+	token, err := tc.GetToken(account)
+	if err != nil {
+		t.Fatalf("GetToken: %v", err)
+	}
+	if token == first {
+		t.Error("refreshed token was not used")
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	var logins atomic.Int32
+	account := newLoginServer(t, 0, &logins)
+	tc := NewTokenCache(time.Hour, time.Minute)
+
+	first, err := tc.GetToken(account)
+	if err != nil {
+		t.Fatalf("GetToken: %v", err)
+	}
+	tc.Invalidate(account, "stale")
+	if token, _ := tc.GetToken(account); token != first || logins.Load() != 1 {
+		t.Errorf("invalidation of a stale token replaced the current one")
+	}
+
+	tc.Invalidate(account, first)
+	second, err := tc.GetToken(account)
+	if err != nil {
+		t.Fatalf("GetToken: %v", err)
+	}
+	if second == first || logins.Load() != 2 {
+		t.Errorf("rejected token was not replaced: logins %d", logins.Load())
+	}
+	if status := tc.Status(account); status.Invalidations != 1 || !status.Valid {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestLoginFailure(t *testing.T) {
+	var logins atomic.Int32
+	account := newLoginServer(t, 0, &logins)
+	account.APIKey = "wrong"
+	tc := NewTokenCache(time.Hour, time.Minute)
+
+	if _, err := tc.GetToken(account); err == nil {
+		t.Fatal("GetToken succeeded with wrong credentials")
+	}
+	status := tc.Status(account)
+	if status.Failures != 1 || status.Valid || status.LastError == "" {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -4,15 +4,37 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/prok05/ecom/cache"
+	"github.com/prok05/ecom/config"
+	"github.com/prok05/ecom/service/alpha"
+	"github.com/prok05/ecom/service/attendance"
+	"github.com/prok05/ecom/service/auth"
+	"github.com/prok05/ecom/service/balance"
+	"github.com/prok05/ecom/service/calendar"
 	"github.com/prok05/ecom/service/chat"
+	"github.com/prok05/ecom/service/crmsync"
 	"github.com/prok05/ecom/service/homework"
 	"github.com/prok05/ecom/service/lesson"
+	"github.com/prok05/ecom/service/material"
 	"github.com/prok05/ecom/service/message"
+	"github.com/prok05/ecom/service/notification"
+	"github.com/prok05/ecom/service/outbox"
+	"github.com/prok05/ecom/service/parent"
+	"github.com/prok05/ecom/service/reference"
+	"github.com/prok05/ecom/service/session"
+	"github.com/prok05/ecom/service/sms"
+	"github.com/prok05/ecom/service/stats"
+	"github.com/prok05/ecom/service/status"
+	"github.com/prok05/ecom/service/tenant"
+	"github.com/prok05/ecom/service/throttle"
 	"github.com/prok05/ecom/service/user"
+	"github.com/prok05/ecom/service/verification"
+	"github.com/prok05/ecom/service/webhook"
 	"github.com/prok05/ecom/service/ws"
+	"github.com/prok05/ecom/utils"
 	"github.com/rs/cors"
 	"log"
 	"net/http"
+	"time"
 )
 
 type APIServer struct {
@@ -35,35 +57,125 @@ func (s *APIServer) Run() error {
 	router := mux.NewRouter()
 	subrouter := router.PathPrefix("/api/v1").Subrouter()
 
+	smsSender, err := sms.NewSender()
+	if err != nil {
+		return err
+	}
+	verificationService := verification.NewService(verification.NewStore(s.dbpool), smsSender)
+
+	crm := alpha.NewClientProvider(s.tokenCache)
+	tenantResolver := tenant.NewResolver(tenant.NewStore(s.dbpool))
+	router.Use(tenantResolver.Middleware)
+
 	userStore := user.NewStore(s.dbpool)
-	userHandler := user.NewHandler(userStore)
+	sessionStore := session.NewStore(s.dbpool)
+	authorizer := auth.NewAuthorizer(userStore, sessionStore)
+
+	loginLimiter := throttle.NewLimiter(throttle.NewStore(s.dbpool))
+	parentStore := parent.NewStore(s.dbpool)
+	lessonStore := lesson.NewStore(s.dbpool)
+	attendanceStore := attendance.NewStore(s.dbpool)
+	materialStore := material.NewStore(s.dbpool)
+
+	userHandler := user.NewHandler(userStore, sessionStore, parentStore, verificationService, loginLimiter, authorizer, crm, s.hub)
 	userHandler.RegisterRoutes(subrouter)
 
 	chatStore := chat.NewStore(s.dbpool)
 	messageStore := message.NewStore(s.dbpool)
-	messageHandler := message.NewHandler(messageStore, chatStore, s.tokenCache)
+	messageHandler := message.NewHandler(messageStore, chatStore, authorizer, s.tokenCache)
 	messageHandler.RegisterRoutes(subrouter)
 
-	chatHandler := chat.NewHandler(chatStore, userStore, messageStore, s.tokenCache)
+	chatHandler := chat.NewHandler(chatStore, userStore, messageStore, lessonStore, authorizer, crm)
 	chatHandler.RegisterRoutes(subrouter)
 
+	referenceStore := reference.NewStore(s.dbpool)
+	referenceSyncer := reference.NewSyncer(referenceStore, tenantResolver, crm)
+	referenceHandler := reference.NewHandler(referenceStore, referenceSyncer, authorizer)
+	referenceHandler.RegisterRoutes(subrouter)
+	if interval := config.Envs.ReferenceSyncIntervalSeconds; interval > 0 {
+		go referenceSyncer.Schedule(time.Second * time.Duration(interval))
+	}
+
 	homeworkStore := homework.NewStore(s.dbpool)
-	homeworkHandler := homework.NewHandler(homeworkStore, userStore)
+	homeworkHandler := homework.NewHandler(homeworkStore, userStore, referenceStore, lessonStore, authorizer, crm)
 	homeworkHandler.RegisterRoutes(subrouter)
 
-	lessonStore := lesson.NewStore(s.dbpool)
-	lessonHandler := lesson.NewHandler(homeworkStore, lessonStore, s.tokenCache)
+	lessonSyncer := lesson.NewSyncer(lessonStore, tenantResolver, crm, lesson.SyncWindow{
+		DaysBack:     int(config.Envs.LessonSyncDaysBack),
+		DaysAhead:    int(config.Envs.LessonSyncDaysAhead),
+		FullDaysBack: int(config.Envs.LessonFullSyncDaysBack),
+	})
+	lessonHandler := lesson.NewHandler(homeworkStore, lessonStore, attendanceStore, materialStore, authorizer, crm, lessonSyncer, referenceStore,
+		time.Hour*time.Duration(config.Envs.LessonRatingWindowHours))
 	lessonHandler.RegisterRoutes(subrouter)
+	if interval := config.Envs.LessonSyncIntervalSeconds; interval > 0 {
+		go lessonSyncer.Schedule(time.Second * time.Duration(interval))
+	}
+
+	calendarHandler := calendar.NewHandler(calendar.NewStore(s.dbpool), lessonStore, parentStore, referenceStore, lessonSyncer, tenantResolver, crm, authorizer)
+	calendarHandler.RegisterRoutes(subrouter)
+
+	attendanceHandler := attendance.NewHandler(attendanceStore, lessonStore, parentStore, authorizer, crm, config.Envs.AttendancePushToCRM)
+	attendanceHandler.RegisterRoutes(subrouter)
+
+	parentHandler := parent.NewHandler(parentStore, userStore, lessonStore, authorizer, crm)
+	parentHandler.RegisterRoutes(subrouter)
+
+	crmSyncStore := crmsync.NewStore(s.dbpool)
+	crmSyncer := crmsync.NewSyncer(crmSyncStore, tenantResolver, crm, s.hub)
+	crmSyncHandler := crmsync.NewHandler(crmSyncer, crmSyncStore, s.tokenCache, authorizer)
+	crmSyncHandler.RegisterRoutes(subrouter)
+	if interval := config.Envs.CRMSyncIntervalSeconds; interval > 0 {
+		go crmSyncer.Schedule(time.Second * time.Duration(interval))
+	}
+
+	outboxStore := outbox.NewStore(s.dbpool)
+	outboxHandler := outbox.NewHandler(outboxStore, authorizer)
+	outboxHandler.RegisterRoutes(subrouter)
+	outboxWorker := outbox.NewWorker(outboxStore, tenantResolver, crm, outbox.Retry{
+		Base:        time.Second * time.Duration(config.Envs.OutboxRetryBaseSeconds),
+		Max:         time.Second * time.Duration(config.Envs.OutboxRetryMaxSeconds),
+		MaxAttempts: int(config.Envs.OutboxMaxAttempts),
+	})
+	if interval := config.Envs.OutboxPollSeconds; interval > 0 {
+		go outboxWorker.Schedule(time.Second * time.Duration(interval))
+	}
+
+	notificationStore := notification.NewStore(s.dbpool)
+	notificationHandler := notification.NewHandler(notificationStore, authorizer)
+	notificationHandler.RegisterRoutes(subrouter)
+
+	balanceStore := balance.NewStore(s.dbpool)
+	balanceHandler := balance.NewHandler(balanceStore, parentStore, authorizer, int(config.Envs.LowPaidLessonsThreshold))
+	balanceHandler.RegisterRoutes(subrouter)
+	balanceAlerter := balance.NewAlerter(balanceStore, tenantResolver, notification.NewNotifier(notificationStore, s.hub),
+		int(config.Envs.LowPaidLessonsThreshold))
+	if interval := config.Envs.BalanceAlertIntervalSeconds; interval > 0 {
+		go balanceAlerter.Schedule(time.Second * time.Duration(interval))
+	}
+
+	materialHandler := material.NewHandler(materialStore, lessonStore, referenceStore, authorizer, crm, config.Envs.MaterialMaxSizeMB<<20)
+	materialHandler.RegisterRoutes(subrouter)
+
+	statsHandler := stats.NewHandler(stats.NewStore(s.dbpool), authorizer)
+	statsHandler.RegisterRoutes(subrouter)
+
+	statusHandler := status.NewHandler(crm, s.tokenCache, lessonStore, authorizer)
+	statusHandler.RegisterRoutes(subrouter)
+
+	webhookHandler := webhook.NewHandler(tenantResolver, lessonStore, crmSyncer, crm, s.hub)
+	webhookHandler.RegisterRoutes(subrouter)
 
-	router.HandleFunc("/ws", ws.Handler(s.hub, messageStore, chatStore, userStore, s.tokenCache))
+	router.HandleFunc("/ws", authorizer.RequirePermissions(
+		ws.Handler(s.hub, messageStore, chatStore, userStore, s.tokenCache), auth.PermChatRead))
 
 	c := cors.New(cors.Options{
 		AllowedOrigins: []string{"http://localhost:3000"},
 		//AllowedOrigins:   []string{"http://93.183.81.6:3000"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"}, // Разрешаемые методы
-		AllowedHeaders:   []string{"Authorization", "Content-Type"},
-		ExposedHeaders:   []string{"Content-Disposition"}, // Разрешаемые заголовки
-		AllowCredentials: true,                            // Разрешение для отправки куков и других креденшалов
+		AllowedHeaders:   []string{"Authorization", "Content-Type", tenant.Header},
+		ExposedHeaders:   []string{"Content-Disposition", utils.StaleHeader}, // Разрешаемые заголовки
+		AllowCredentials: true,                                               // Разрешение для отправки куков и других креденшалов
 	})
 
 	handler := c.Handler(router)
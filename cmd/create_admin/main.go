@@ -5,8 +5,10 @@ import (
 	"flag"
 	"fmt"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prok05/ecom/config"
 	"github.com/prok05/ecom/db"
 	"github.com/prok05/ecom/service/auth"
+	"github.com/prok05/ecom/service/tenant"
 	"github.com/prok05/ecom/service/user"
 	"github.com/prok05/ecom/types"
 	"log"
@@ -16,6 +18,7 @@ import (
 func main() {
 	phone := flag.String("phone", "", "Phone number for the supervisor")
 	password := flag.String("password", "", "Password for the supervisor")
+	tenantSlug := flag.String("tenant", config.Envs.DefaultTenant, "Slug of the supervisor's school")
 
 	flag.Parse()
 
@@ -36,18 +39,30 @@ func main() {
 		log.Fatalf("could not hash password: %v", err)
 	}
 
+	t, err := findTenant(dbpool, *tenantSlug)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	userStore := user.NewStore(dbpool)
+
+	// у администратора нет карточки в AlfaCRM, ID выдается из локальной последовательности
+	userID, err := userStore.NextLocalUserID(t.IDOffset)
+	if err != nil {
+		log.Fatalf("could not allocate user id: %v", err)
+	}
+
 	u := types.User{
-		ID:         999999,
+		ID:         userID,
+		TenantID:   t.ID,
 		FirstName:  "Админ",
 		LastName:   "Админ",
 		MiddleName: "Админ",
 		Phone:      *phone,
 		Password:   hashedPassword,
-		Role:       "supervisor",
+		Role:       types.RoleSupervisor,
 	}
 
-	userStore := user.NewStore(dbpool)
-
 	err = userStore.CreateUser(u)
 	if err != nil {
 		log.Fatalf("Error creating admin: %v", err)
@@ -56,6 +71,19 @@ func main() {
 	log.Println("Admin created successfully!")
 }
 
+func findTenant(dbpool *pgxpool.Pool, slug string) (*types.Tenant, error) {
+	tenants, err := tenant.NewStore(dbpool).GetTenants()
+	if err != nil {
+		return nil, err
+	}
+	for i := range tenants {
+		if tenants[i].Slug == slug {
+			return &tenants[i], nil
+		}
+	}
+	return nil, fmt.Errorf("tenant %q not found", slug)
+}
+
 func initStorage(dbpool *pgxpool.Pool) {
 	err := dbpool.Ping(context.Background())
 	if err != nil {
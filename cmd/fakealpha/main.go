@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"github.com/prok05/ecom/service/alpha/alphatest"
+	"log"
+	"net/http"
+)
+
+// fakealpha - локальный сервер AlfaCRM. Чтобы платформа работала с ним,
+// укажите ALPHA_HOST=http://localhost:8090 (или адрес из -addr).
+func main() {
+	addr := flag.String("addr", ":8090", "Address to listen on")
+	fixturesPath := flag.String("fixtures", "", "JSON file with teachers, customers and lessons (built-in sample data if empty)")
+
+	flag.Parse()
+
+	fixtures := alphatest.DefaultFixtures()
+	if *fixturesPath != "" {
+		f, err := alphatest.LoadFixtures(*fixturesPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fixtures = f
+	}
+
+	server := alphatest.NewServer(fixtures)
+	log.Printf("Fake AlfaCRM: %d teachers, %d customers, %d lessons",
+		len(fixtures.Teachers), len(fixtures.Customers), len(fixtures.Lessons))
+	log.Println("Listening on:", *addr)
+	log.Fatal(http.ListenAndServe(*addr, server.Handler()))
+}
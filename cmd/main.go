@@ -5,7 +5,9 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/prok05/ecom/cache"
 	"github.com/prok05/ecom/cmd/api"
+	"github.com/prok05/ecom/config"
 	"github.com/prok05/ecom/db"
+	"github.com/prok05/ecom/service/alpha"
 	"github.com/prok05/ecom/service/ws"
 	"log"
 	"time"
@@ -22,12 +24,18 @@ func main() {
 	hub := ws.NewHub()
 	go hub.Run()
 
-	tokenCache := cache.NewTokenCache(60 * time.Minute)
-	_, err = tokenCache.GetToken()
-	if err != nil {
-		log.Fatal("cant initialize token cache")
-	}
-	log.Println("Token cache initialized")
+	tokenCache := cache.NewTokenCache(
+		time.Second*time.Duration(config.Envs.AlphaTokenTTLSeconds),
+		time.Second*time.Duration(config.Envs.AlphaTokenRefreshSeconds))
+	// без CRM платформа запускается, токен будет получен при первом обращении.
+	// Вход выполняется в фоне, чтобы недоступная CRM не задерживала запуск
+	go func() {
+		if _, err := tokenCache.GetToken(alpha.DefaultAccount()); err != nil {
+			log.Printf("cant initialize token cache: %v", err)
+		} else {
+			log.Println("Token cache initialized")
+		}
+	}()
 
 	server := api.NewAPIServer(":8080", dbpool, hub, tokenCache)
 	if err := server.Run(); err != nil {
@@ -1,8 +1,10 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"github.com/joho/godotenv"
+	"io/fs"
 	"log"
 	"os"
 	"strconv"
@@ -17,12 +19,82 @@ type Config struct {
 	DBAddress  string
 	DBName     string
 
-	AlphaEmail   string
-	AlphaApiKey  string
-	AlphaXAppKey string
+	AlphaHost     string
+	AlphaBranchID int64
+	AlphaEmail    string
+	AlphaApiKey   string
+	AlphaXAppKey  string
+	// AlphaTimeoutSeconds - таймаут одного запроса к AlfaCRM
+	AlphaTimeoutSeconds int64
+	// токен AlfaCRM действует AlphaTokenTTLSeconds и обновляется за AlphaTokenRefreshSeconds до истечения
+	AlphaTokenTTLSeconds     int64
+	AlphaTokenRefreshSeconds int64
+	// после AlphaBreakerFailures сбоев подряд запросы к AlfaCRM не отправляются
+	// AlphaBreakerCooldownSeconds, затем проходит один пробный запрос
+	AlphaBreakerFailures        int64
+	AlphaBreakerCooldownSeconds int64
+	// AlphaWebhookSecret - секрет вебхука AlfaCRM для школ без своего, пустой отключает вебхук
+	AlphaWebhookSecret string
 
-	JWTExpirationInSeconds int64
-	JWTSecret              string
+	DefaultTenant string
+	// DefaultTimezone - часовой пояс школ, у которых он не указан
+	DefaultTimezone string
+
+	JWTExpirationInSeconds        int64
+	JWTRefreshExpirationInSeconds int64
+	JWTSecret                     string
+
+	SMSProvider string
+	SMSFilePath string
+
+	VerificationCodeTTLSeconds  int64
+	VerificationMaxAttempts     int64
+	VerificationResendSeconds   int64
+	VerificationMaxCodesPerHour int64
+
+	LoginWindowSeconds       int64
+	LoginFreeAttempts        int64
+	LoginDelayBaseSeconds    int64
+	LoginDelayMaxSeconds     int64
+	LoginMaxFailuresPerPhone int64
+	LoginMaxFailuresPerIP    int64
+	LoginLockoutSeconds      int64
+	// TrustedProxies - адреса и подсети reverse proxy через запятую, только от них
+	// учитывается X-Forwarded-For. Пустой - IP клиента берется из соединения
+	TrustedProxies string
+
+	CRMSyncIntervalSeconds int64
+	// справочники AlfaCRM (предметы, аудитории, группы) меняются редко
+	ReferenceSyncIntervalSeconds int64
+
+	// очередь изменений для AlfaCRM проверяется каждые OutboxPollSeconds, повторы идут
+	// с задержкой от OutboxRetryBaseSeconds с удвоением до OutboxRetryMaxSeconds,
+	// после OutboxMaxAttempts попыток сообщение считается недоставляемым
+	OutboxPollSeconds      int64
+	OutboxRetryBaseSeconds int64
+	OutboxRetryMaxSeconds  int64
+	OutboxMaxAttempts      int64
+
+	// уроки синхронизируются каждые LessonSyncIntervalSeconds за период
+	// LessonSyncDaysBack..LessonSyncDaysAhead дней, первый раз - с LessonFullSyncDaysBack
+	LessonSyncIntervalSeconds int64
+	LessonSyncDaysBack        int64
+	LessonSyncDaysAhead       int64
+	LessonFullSyncDaysBack    int64
+
+	// остатки учеников проверяются каждые BalanceAlertIntervalSeconds, ученик и родители
+	// получают уведомление, когда оплаченных занятий меньше LowPaidLessonsThreshold
+	BalanceAlertIntervalSeconds int64
+	LowPaidLessonsThreshold     int64
+
+	// отметки посещаемости отправляются в AlfaCRM через очередь изменений
+	AttendancePushToCRM bool
+
+	// урок можно оценить в течение LessonRatingWindowHours после его окончания
+	LessonRatingWindowHours int64
+
+	// максимальный размер файла в библиотеке материалов, МБ
+	MaterialMaxSizeMB int64
 }
 
 var Envs = initConfig()
@@ -37,8 +109,9 @@ func initConfig() Config {
 	//envPath := filepath.Join(workDir, ".env")
 	//log.Println(envPath)
 
+	// без .env настройки берутся из окружения, например в тестах и контейнере
 	err := godotenv.Load(".env")
-	if err != nil {
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		log.Fatal("Error loading .env file")
 	}
 
@@ -50,12 +123,52 @@ func initConfig() Config {
 		DBAddress: fmt.Sprintf("%s:%s",
 			getEnv("DB_HOST", "localhost"),
 			getEnv("DB_PORT", "5432")),
-		DBName:                 getEnv("DB_NAME", "centriym-db"),
-		AlphaEmail:             getEnv("ALPHA_EMAIL", "email"),
-		AlphaApiKey:            getEnv("ALPHA_API_KEY", "api-key"),
-		AlphaXAppKey:           getEnv("ALPHA_X_APP_KEY", "x-app-key"),
-		JWTSecret:              getEnv("JWT_SECRET", "secret"),
-		JWTExpirationInSeconds: getEnvAsInt("JWT_EXP", 3600*24*30),
+		DBName:                        getEnv("DB_NAME", "centriym-db"),
+		AlphaHost:                     getEnv("ALPHA_HOST", "centriym.s20.online"),
+		AlphaBranchID:                 getEnvAsInt("ALPHA_BRANCH_ID", 1),
+		AlphaEmail:                    getEnv("ALPHA_EMAIL", "email"),
+		AlphaApiKey:                   getEnv("ALPHA_API_KEY", "api-key"),
+		AlphaXAppKey:                  getEnv("ALPHA_X_APP_KEY", "x-app-key"),
+		AlphaTimeoutSeconds:           getEnvAsInt("ALPHA_TIMEOUT", 15),
+		AlphaTokenTTLSeconds:          getEnvAsInt("ALPHA_TOKEN_TTL", 60*60),
+		AlphaTokenRefreshSeconds:      getEnvAsInt("ALPHA_TOKEN_REFRESH_BEFORE", 60*5),
+		AlphaBreakerFailures:          getEnvAsInt("ALPHA_BREAKER_FAILURES", 5),
+		AlphaBreakerCooldownSeconds:   getEnvAsInt("ALPHA_BREAKER_COOLDOWN", 30),
+		AlphaWebhookSecret:            getEnv("ALPHA_WEBHOOK_SECRET", ""),
+		DefaultTenant:                 getEnv("DEFAULT_TENANT", "default"),
+		DefaultTimezone:               getEnv("DEFAULT_TIMEZONE", "Europe/Moscow"),
+		JWTSecret:                     getEnv("JWT_SECRET", "secret"),
+		JWTExpirationInSeconds:        getEnvAsInt("JWT_EXP", 60*15),
+		JWTRefreshExpirationInSeconds: getEnvAsInt("JWT_REFRESH_EXP", 3600*24*30),
+		SMSProvider:                   getEnv("SMS_PROVIDER", "console"),
+		SMSFilePath:                   getEnv("SMS_FILE_PATH", "sms.log"),
+		VerificationCodeTTLSeconds:    getEnvAsInt("VERIFICATION_CODE_TTL", 60*5),
+		VerificationMaxAttempts:       getEnvAsInt("VERIFICATION_MAX_ATTEMPTS", 5),
+		VerificationResendSeconds:     getEnvAsInt("VERIFICATION_RESEND_INTERVAL", 60),
+		VerificationMaxCodesPerHour:   getEnvAsInt("VERIFICATION_MAX_CODES_PER_HOUR", 5),
+		LoginWindowSeconds:            getEnvAsInt("LOGIN_WINDOW", 60*15),
+		LoginFreeAttempts:             getEnvAsInt("LOGIN_FREE_ATTEMPTS", 3),
+		LoginDelayBaseSeconds:         getEnvAsInt("LOGIN_DELAY_BASE", 2),
+		LoginDelayMaxSeconds:          getEnvAsInt("LOGIN_DELAY_MAX", 60),
+		LoginMaxFailuresPerPhone:      getEnvAsInt("LOGIN_MAX_FAILURES_PER_PHONE", 10),
+		LoginMaxFailuresPerIP:         getEnvAsInt("LOGIN_MAX_FAILURES_PER_IP", 50),
+		LoginLockoutSeconds:           getEnvAsInt("LOGIN_LOCKOUT", 60*15),
+		TrustedProxies:                getEnv("TRUSTED_PROXIES", ""),
+		CRMSyncIntervalSeconds:        getEnvAsInt("CRM_SYNC_INTERVAL", 3600*6),
+		ReferenceSyncIntervalSeconds:  getEnvAsInt("REFERENCE_SYNC_INTERVAL", 3600*6),
+		OutboxPollSeconds:             getEnvAsInt("OUTBOX_POLL_INTERVAL", 5),
+		OutboxRetryBaseSeconds:        getEnvAsInt("OUTBOX_RETRY_BASE", 30),
+		OutboxRetryMaxSeconds:         getEnvAsInt("OUTBOX_RETRY_MAX", 3600),
+		OutboxMaxAttempts:             getEnvAsInt("OUTBOX_MAX_ATTEMPTS", 10),
+		LessonSyncIntervalSeconds:     getEnvAsInt("LESSON_SYNC_INTERVAL", 60*5),
+		LessonSyncDaysBack:            getEnvAsInt("LESSON_SYNC_DAYS_BACK", 30),
+		LessonSyncDaysAhead:           getEnvAsInt("LESSON_SYNC_DAYS_AHEAD", 60),
+		LessonFullSyncDaysBack:        getEnvAsInt("LESSON_FULL_SYNC_DAYS_BACK", 365),
+		BalanceAlertIntervalSeconds:   getEnvAsInt("BALANCE_ALERT_INTERVAL", 3600),
+		LowPaidLessonsThreshold:       getEnvAsInt("LOW_PAID_LESSONS_THRESHOLD", 2),
+		AttendancePushToCRM:           getEnvAsBool("ATTENDANCE_PUSH_TO_CRM", false),
+		LessonRatingWindowHours:       getEnvAsInt("LESSON_RATING_WINDOW", 24*7),
+		MaterialMaxSizeMB:             getEnvAsInt("MATERIAL_MAX_SIZE", 50),
 	}
 }
 
@@ -76,3 +189,14 @@ func getEnvAsInt(key string, fallback int64) int64 {
 	}
 	return fallback
 }
+
+func getEnvAsBool(key string, fallback bool) bool {
+	if value, ok := os.LookupEnv(key); ok {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fallback
+		}
+		return b
+	}
+	return fallback
+}
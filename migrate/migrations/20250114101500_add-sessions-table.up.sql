@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS sessions
+(
+    id                  SERIAL PRIMARY KEY,
+    user_id             BIGINT                   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    refresh_token_hash  VARCHAR(64)              NOT NULL UNIQUE,
+    previous_token_hash VARCHAR(64),
+    user_agent          TEXT,
+    ip                  VARCHAR(45),
+    created_at          TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    last_used_at        TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at          TIMESTAMP WITH TIME ZONE NOT NULL,
+    revoked_at          TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_sessions_user_id ON sessions (user_id);
+CREATE INDEX idx_sessions_previous_token_hash ON sessions (previous_token_hash);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS verification_codes;
\ No newline at end of file
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS verification_codes
+(
+    id          SERIAL PRIMARY KEY,
+    phone       VARCHAR(15)              NOT NULL,
+    purpose     VARCHAR(32)              NOT NULL,
+    code_hash   VARCHAR(64)              NOT NULL,
+    attempts    SMALLINT                 NOT NULL DEFAULT 0,
+    expires_at  TIMESTAMP WITH TIME ZONE NOT NULL,
+    consumed_at TIMESTAMP WITH TIME ZONE,
+    created_at  TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_verification_codes_phone_purpose ON verification_codes (phone, purpose, created_at);
@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS login_attempts
+(
+    id         SERIAL PRIMARY KEY,
+    phone      VARCHAR(15)              NOT NULL,
+    user_id    INT REFERENCES users (id) ON DELETE SET NULL,
+    ip         VARCHAR(45)              NOT NULL,
+    user_agent TEXT,
+    success    BOOLEAN                  NOT NULL,
+    reason     VARCHAR(32),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_login_attempts_phone ON login_attempts (phone, created_at);
+CREATE INDEX idx_login_attempts_ip ON login_attempts (ip, created_at);
+CREATE INDEX idx_login_attempts_user_id ON login_attempts (user_id, created_at);
+
+CREATE TABLE IF NOT EXISTS login_lockouts
+(
+    id           SERIAL PRIMARY KEY,
+    scope        VARCHAR(8)               NOT NULL,
+    key          VARCHAR(45)              NOT NULL,
+    locked_until TIMESTAMP WITH TIME ZONE NOT NULL,
+    reason       VARCHAR(64),
+    unlocked_at  TIMESTAMP WITH TIME ZONE,
+    unlocked_by  INT REFERENCES users (id) ON DELETE SET NULL,
+    created_at   TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_login_lockouts_scope_key ON login_lockouts (scope, key, locked_until);
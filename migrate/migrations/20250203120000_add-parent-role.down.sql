@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS parent_students;
+DROP SEQUENCE IF EXISTS local_user_id_seq;
+-- значение 'parent' из user_role не удаляется: Postgres не поддерживает удаление значений enum
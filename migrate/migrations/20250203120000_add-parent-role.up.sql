@@ -0,0 +1,16 @@
+ALTER TYPE user_role ADD VALUE IF NOT EXISTS 'parent';
+
+-- ID пользователей, которых нет в AlfaCRM (родители, администраторы)
+CREATE SEQUENCE IF NOT EXISTS local_user_id_seq START WITH 1000000000;
+
+CREATE TABLE IF NOT EXISTS parent_students
+(
+    parent_id  BIGINT      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    student_id BIGINT      NOT NULL, -- ID клиента AlfaCRM, ученик может быть еще не зарегистрирован
+    source     VARCHAR(16) NOT NULL DEFAULT 'manual',
+    created_by BIGINT REFERENCES users (id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (parent_id, student_id)
+);
+
+CREATE INDEX idx_parent_students_student_id ON parent_students (student_id);
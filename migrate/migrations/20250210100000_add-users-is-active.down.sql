@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_users_role;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS deactivated_at,
+    DROP COLUMN IF EXISTS is_active;
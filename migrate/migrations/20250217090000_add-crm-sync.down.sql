@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS crm_sync_runs;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS deactivation_reason,
+    DROP COLUMN IF EXISTS crm_synced_at,
+    DROP COLUMN IF EXISTS paid_lesson_count,
+    DROP COLUMN IF EXISTS balance;
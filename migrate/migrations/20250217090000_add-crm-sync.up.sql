@@ -0,0 +1,21 @@
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS balance             NUMERIC(12, 2),
+    ADD COLUMN IF NOT EXISTS paid_lesson_count   INT,
+    ADD COLUMN IF NOT EXISTS crm_synced_at       TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS deactivation_reason VARCHAR(16); -- admin - заблокирован супервизором, crm - удален или в архиве AlfaCRM
+
+UPDATE users SET deactivation_reason = 'admin' WHERE NOT is_active;
+
+CREATE TABLE IF NOT EXISTS crm_sync_runs
+(
+    id          SERIAL PRIMARY KEY,
+    started_at  TIMESTAMP WITH TIME ZONE NOT NULL,
+    finished_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created     INT                      NOT NULL DEFAULT 0,
+    updated     INT                      NOT NULL DEFAULT 0,
+    deactivated INT                      NOT NULL DEFAULT 0,
+    reactivated INT                      NOT NULL DEFAULT 0,
+    skipped     INT                      NOT NULL DEFAULT 0,
+    errors      JSONB                    NOT NULL DEFAULT '[]',
+    changes     JSONB                    NOT NULL DEFAULT '[]'
+);
@@ -0,0 +1,9 @@
+ALTER TABLE crm_sync_runs DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE homeworks DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE chats DROP COLUMN IF EXISTS tenant_id;
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_tenant_id_phone_key;
+ALTER TABLE users ADD CONSTRAINT users_phone_key UNIQUE (phone);
+ALTER TABLE users DROP COLUMN IF EXISTS tenant_id;
+
+DROP TABLE IF EXISTS tenants;
@@ -0,0 +1,33 @@
+-- Школа (или филиал) со своим аккаунтом AlfaCRM. Пустые поля подключения к CRM
+-- берутся из переменных окружения, так работает школа по умолчанию.
+CREATE TABLE IF NOT EXISTS tenants
+(
+    id            SERIAL PRIMARY KEY,
+    slug          VARCHAR(64)  NOT NULL UNIQUE,
+    name          VARCHAR(255) NOT NULL,
+    host          VARCHAR(255) UNIQUE,
+    crm_host      VARCHAR(255),
+    crm_branch_id INT,
+    crm_email     VARCHAR(255),
+    crm_api_key   VARCHAR(255),
+    crm_app_key   VARCHAR(255),
+    -- ID из AlfaCRM разных школ пересекаются, поэтому локально к ним прибавляется смещение школы
+    id_offset     BIGINT       NOT NULL DEFAULT 0 UNIQUE,
+    is_active     BOOLEAN      NOT NULL DEFAULT TRUE,
+    created_at    TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO tenants (id, slug, name) VALUES (1, 'default', 'default') ON CONFLICT DO NOTHING;
+SELECT setval('tenants_id_seq', (SELECT MAX(id) FROM tenants));
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tenant_id INT NOT NULL DEFAULT 1 REFERENCES tenants (id);
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_phone_key;
+ALTER TABLE users ADD CONSTRAINT users_tenant_id_phone_key UNIQUE (tenant_id, phone);
+
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS tenant_id INT NOT NULL DEFAULT 1 REFERENCES tenants (id);
+CREATE INDEX IF NOT EXISTS idx_chats_tenant_id ON chats (tenant_id);
+
+ALTER TABLE homeworks ADD COLUMN IF NOT EXISTS tenant_id INT NOT NULL DEFAULT 1 REFERENCES tenants (id);
+CREATE INDEX IF NOT EXISTS idx_homeworks_tenant_id ON homeworks (tenant_id);
+
+ALTER TABLE crm_sync_runs ADD COLUMN IF NOT EXISTS tenant_id INT NOT NULL DEFAULT 1 REFERENCES tenants (id);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS lesson_sync_state;
+DROP TABLE IF EXISTS lesson_customers;
+DROP TABLE IF EXISTS lesson_teachers;
+DROP TABLE IF EXISTS lessons;
@@ -0,0 +1,51 @@
+-- Копия уроков AlfaCRM, которую обновляет фоновая синхронизация.
+-- ID урока и участников локальные, со смещением школы.
+CREATE TABLE IF NOT EXISTS lessons
+(
+    id         BIGINT PRIMARY KEY,
+    tenant_id  INT       NOT NULL REFERENCES tenants (id),
+    status     SMALLINT  NOT NULL, -- 1 - запланирован, 2 - отменен, 3 - проведен
+    date       DATE      NOT NULL,
+    time_from  TIMESTAMP,
+    time_to    TIMESTAMP,
+    subject_id INT,
+    room_id    INT,
+    topic      TEXT      NOT NULL DEFAULT '',
+    note       TEXT      NOT NULL DEFAULT '',
+    streaming  JSONB,
+    homework   JSONB,
+    synced_at  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_lessons_tenant_id_date ON lessons (tenant_id, date);
+
+CREATE TABLE IF NOT EXISTS lesson_teachers
+(
+    lesson_id  BIGINT NOT NULL REFERENCES lessons (id) ON DELETE CASCADE,
+    teacher_id BIGINT NOT NULL,
+    PRIMARY KEY (lesson_id, teacher_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_lesson_teachers_teacher_id ON lesson_teachers (teacher_id);
+
+CREATE TABLE IF NOT EXISTS lesson_customers
+(
+    lesson_id   BIGINT NOT NULL REFERENCES lessons (id) ON DELETE CASCADE,
+    customer_id BIGINT NOT NULL,
+    PRIMARY KEY (lesson_id, customer_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_lesson_customers_customer_id ON lesson_customers (customer_id);
+
+-- Последняя синхронизация уроков школы
+CREATE TABLE IF NOT EXISTS lesson_sync_state
+(
+    tenant_id     INT PRIMARY KEY REFERENCES tenants (id),
+    synced_at     TIMESTAMP WITH TIME ZONE,
+    window_from   DATE,
+    window_to     DATE,
+    upserted      INT NOT NULL DEFAULT 0,
+    deleted       INT NOT NULL DEFAULT 0,
+    last_error    TEXT,
+    last_error_at TIMESTAMP WITH TIME ZONE
+);
@@ -0,0 +1,2 @@
+-- Секрет в адресе вебхука AlfaCRM. Если не задан, берется ALPHA_WEBHOOK_SECRET.
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS webhook_secret VARCHAR(255);
@@ -0,0 +1,19 @@
+-- Изменения, которые нужно отправить в AlfaCRM. Запись создается в той же
+-- транзакции, что и изменение в платформе, и удаляется из очереди после доставки.
+CREATE TABLE IF NOT EXISTS crm_outbox
+(
+    id              BIGSERIAL PRIMARY KEY,
+    tenant_id       INT                      NOT NULL REFERENCES tenants (id),
+    kind            VARCHAR(32)              NOT NULL, -- lesson.update
+    lesson_id       BIGINT                   NOT NULL,
+    payload         JSONB                    NOT NULL,
+    status          VARCHAR(16)              NOT NULL DEFAULT 'pending', -- pending, delivered, dead
+    attempts        INT                      NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_error      TEXT,
+    created_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    delivered_at    TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_crm_outbox_pending ON crm_outbox (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_crm_outbox_tenant_id_status ON crm_outbox (tenant_id, status);
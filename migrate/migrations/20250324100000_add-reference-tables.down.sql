@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_homeworks_tenant_id_subject_id;
+ALTER TABLE homeworks DROP COLUMN IF EXISTS subject_id;
+ALTER TABLE lessons DROP COLUMN IF EXISTS group_ids;
+
+DROP TABLE IF EXISTS learning_group_customers;
+DROP TABLE IF EXISTS learning_groups;
+DROP TABLE IF EXISTS rooms;
+DROP TABLE IF EXISTS subjects;
@@ -0,0 +1,52 @@
+-- Справочники AlfaCRM. ID справочников в разных школах пересекаются и хранятся
+-- как в CRM, без смещения школы, поэтому ключ включает tenant_id.
+CREATE TABLE IF NOT EXISTS subjects
+(
+    tenant_id INT          NOT NULL REFERENCES tenants (id),
+    id        INT          NOT NULL,
+    name      VARCHAR(255) NOT NULL,
+    is_active BOOLEAN      NOT NULL DEFAULT TRUE, -- FALSE - предмета больше нет в CRM
+    synced_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (tenant_id, id)
+);
+
+CREATE TABLE IF NOT EXISTS rooms
+(
+    tenant_id INT          NOT NULL REFERENCES tenants (id),
+    id        INT          NOT NULL,
+    name      VARCHAR(255) NOT NULL,
+    is_active BOOLEAN      NOT NULL DEFAULT TRUE,
+    synced_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (tenant_id, id)
+);
+
+CREATE TABLE IF NOT EXISTS learning_groups
+(
+    tenant_id   INT          NOT NULL REFERENCES tenants (id),
+    id          INT          NOT NULL,
+    name        VARCHAR(255) NOT NULL,
+    teacher_ids BIGINT[]     NOT NULL DEFAULT '{}', -- локальные ID преподавателей
+    b_date      DATE,
+    e_date      DATE,
+    is_active   BOOLEAN      NOT NULL DEFAULT TRUE,
+    synced_at   TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (tenant_id, id)
+);
+
+-- Текущие ученики группы
+CREATE TABLE IF NOT EXISTS learning_group_customers
+(
+    tenant_id   INT    NOT NULL,
+    group_id    INT    NOT NULL,
+    customer_id BIGINT NOT NULL,
+    PRIMARY KEY (tenant_id, group_id, customer_id),
+    FOREIGN KEY (tenant_id, group_id) REFERENCES learning_groups (tenant_id, id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_learning_group_customers_customer_id ON learning_group_customers (customer_id);
+
+ALTER TABLE lessons ADD COLUMN IF NOT EXISTS group_ids INT[] NOT NULL DEFAULT '{}';
+
+-- Предмет ДЗ из справочника. subject_title остается для старых ДЗ и как подпись
+ALTER TABLE homeworks ADD COLUMN IF NOT EXISTS subject_id INT;
+CREATE INDEX IF NOT EXISTS idx_homeworks_tenant_id_subject_id ON homeworks (tenant_id, subject_id);
@@ -0,0 +1,25 @@
+-- Уведомления внутри платформы
+CREATE TABLE IF NOT EXISTS notifications
+(
+    id         BIGSERIAL PRIMARY KEY,
+    tenant_id  INT                      NOT NULL REFERENCES tenants (id),
+    user_id    BIGINT                   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    type       VARCHAR(64)              NOT NULL, -- balance.low
+    title      TEXT                     NOT NULL,
+    body       TEXT                     NOT NULL DEFAULT '',
+    data       JSONB,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    read_at    TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_user_id_created_at ON notifications (user_id, created_at DESC);
+
+-- Ученики, которым уже отправлено предупреждение о заканчивающихся занятиях.
+-- Запись удаляется, когда занятия пополнены, и следующее снижение снова вызывает уведомление.
+CREATE TABLE IF NOT EXISTS balance_alerts
+(
+    student_id        BIGINT PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    tenant_id         INT                      NOT NULL REFERENCES tenants (id),
+    paid_lesson_count INT                      NOT NULL,
+    notified_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
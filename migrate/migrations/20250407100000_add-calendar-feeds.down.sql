@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS calendar_feeds;
+
+ALTER TABLE tenants
+    DROP COLUMN IF EXISTS timezone;
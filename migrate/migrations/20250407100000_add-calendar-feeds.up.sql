@@ -0,0 +1,13 @@
+-- Часовой пояс школы, в нем AlfaCRM отдает время уроков. Пустой берется из config.Envs
+ALTER TABLE tenants
+    ADD COLUMN IF NOT EXISTS timezone VARCHAR(64);
+
+-- Ссылки на календарь уроков (ICS). Хранится только хэш токена из ссылки
+CREATE TABLE IF NOT EXISTS calendar_feeds
+(
+    user_id         BIGINT PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    token_hash      VARCHAR(64)              NOT NULL UNIQUE,
+    created_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    rotated_at      TIMESTAMP WITH TIME ZONE,
+    last_fetched_at TIMESTAMP WITH TIME ZONE
+);
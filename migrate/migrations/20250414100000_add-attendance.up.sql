@@ -0,0 +1,18 @@
+-- Посещаемость уроков. Урок может пропасть из копии расписания, поэтому дата
+-- и преподаватели урока хранятся здесь. Ученик может быть еще не зарегистрирован
+CREATE TABLE IF NOT EXISTS attendance
+(
+    lesson_id   BIGINT                   NOT NULL,
+    student_id  BIGINT                   NOT NULL,
+    tenant_id   INT                      NOT NULL REFERENCES tenants (id),
+    lesson_date DATE                     NOT NULL,
+    teacher_ids BIGINT[]                 NOT NULL DEFAULT '{}',
+    status      VARCHAR(16)              NOT NULL, -- present, absent, late
+    note        TEXT                     NOT NULL DEFAULT '',
+    marked_by   BIGINT                   NOT NULL REFERENCES users (id),
+    marked_at   TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (lesson_id, student_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_attendance_student_id_lesson_date ON attendance (student_id, lesson_date);
+CREATE INDEX IF NOT EXISTS idx_attendance_tenant_id_lesson_date ON attendance (tenant_id, lesson_date);
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS lesson_rate_scores;
+
+DROP INDEX IF EXISTS idx_lesson_rates_tenant_id_lesson_date;
+DROP INDEX IF EXISTS idx_lesson_rates_student_teacher_lesson;
+
+ALTER TABLE lesson_rates
+    DROP COLUMN IF EXISTS comment,
+    DROP COLUMN IF EXISTS subject_id,
+    DROP COLUMN IF EXISTS tenant_id;
@@ -0,0 +1,33 @@
+-- Отзыв к оценке, школа и предмет урока для сводок, оценки по категориям
+ALTER TABLE lesson_rates
+    ADD COLUMN IF NOT EXISTS tenant_id  INT REFERENCES tenants (id),
+    ADD COLUMN IF NOT EXISTS subject_id INT,
+    ADD COLUMN IF NOT EXISTS comment    TEXT NOT NULL DEFAULT '';
+
+UPDATE lesson_rates lr
+SET tenant_id = u.tenant_id
+FROM users u
+WHERE u.id = lr.student_id AND lr.tenant_id IS NULL;
+
+UPDATE lesson_rates lr
+SET subject_id = l.subject_id
+FROM lessons l
+WHERE l.id = lr.lesson_id AND lr.subject_id IS NULL;
+
+ALTER TABLE lesson_rates ALTER COLUMN tenant_id SET NOT NULL;
+
+-- повторные оценки одного урока могли появиться при одновременных запросах
+DELETE FROM lesson_rates a
+USING lesson_rates b
+WHERE a.student_id = b.student_id AND a.teacher_id = b.teacher_id AND a.lesson_id = b.lesson_id AND a.id > b.id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_lesson_rates_student_teacher_lesson ON lesson_rates (student_id, teacher_id, lesson_id);
+CREATE INDEX IF NOT EXISTS idx_lesson_rates_tenant_id_lesson_date ON lesson_rates (tenant_id, lesson_date);
+
+CREATE TABLE IF NOT EXISTS lesson_rate_scores
+(
+    rate_id  INT         NOT NULL REFERENCES lesson_rates (id) ON DELETE CASCADE,
+    category VARCHAR(32) NOT NULL,
+    score    SMALLINT    NOT NULL,
+    PRIMARY KEY (rate_id, category)
+);
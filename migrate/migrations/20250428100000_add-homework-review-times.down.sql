@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_messages_chat_id_created_at;
+DROP INDEX IF EXISTS idx_homeworks_teacher_id;
+DROP INDEX IF EXISTS idx_homework_solutions_reviewed_at;
+
+ALTER TABLE homework_solutions
+    DROP COLUMN IF EXISTS reviewed_at,
+    DROP COLUMN IF EXISTS submitted_at;
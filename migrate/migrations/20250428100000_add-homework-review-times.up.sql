@@ -0,0 +1,9 @@
+-- Время сдачи решения и его проверки для статистики преподавателей.
+-- При повторной сдаче после отклонения оба поля относятся к последней сдаче
+ALTER TABLE homework_solutions
+    ADD COLUMN IF NOT EXISTS submitted_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS reviewed_at  TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_homework_solutions_reviewed_at ON homework_solutions (reviewed_at);
+CREATE INDEX IF NOT EXISTS idx_homeworks_teacher_id ON homeworks (teacher_id);
+CREATE INDEX IF NOT EXISTS idx_messages_chat_id_created_at ON messages (chat_id, created_at);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS lesson_materials;
+DROP TABLE IF EXISTS materials;
+DROP TABLE IF EXISTS material_folders;
@@ -0,0 +1,53 @@
+-- Библиотека материалов школы: папки, файлы и ссылки
+CREATE TABLE IF NOT EXISTS material_folders
+(
+    id         SERIAL PRIMARY KEY,
+    tenant_id  INT                      NOT NULL REFERENCES tenants (id),
+    parent_id  INT REFERENCES material_folders (id),
+    name       VARCHAR(255)             NOT NULL,
+    owner_id   BIGINT                   NOT NULL REFERENCES users (id),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_material_folders_tenant_id_parent_id ON material_folders (tenant_id, parent_id);
+
+CREATE TABLE IF NOT EXISTS materials
+(
+    id          SERIAL PRIMARY KEY,
+    tenant_id   INT                      NOT NULL REFERENCES tenants (id),
+    folder_id   INT REFERENCES material_folders (id),
+    subject_id  INT,
+    kind        VARCHAR(16)              NOT NULL, -- file, link
+    title       TEXT                     NOT NULL,
+    description TEXT                     NOT NULL DEFAULT '',
+    filepath    TEXT,
+    filename    TEXT,
+    size        BIGINT,
+    url         TEXT,
+    owner_id    BIGINT                   NOT NULL REFERENCES users (id),
+    created_at  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_materials_tenant_id_folder_id ON materials (tenant_id, folder_id);
+CREATE INDEX IF NOT EXISTS idx_materials_tenant_id_subject_id ON materials (tenant_id, subject_id);
+
+-- Материалы урока. Участники и дата урока хранятся здесь, чтобы ученики видели
+-- материалы прошлых уроков, которых уже нет в копии расписания
+CREATE TABLE IF NOT EXISTS lesson_materials
+(
+    lesson_id    BIGINT                   NOT NULL,
+    material_id  INT                      NOT NULL REFERENCES materials (id) ON DELETE CASCADE,
+    tenant_id    INT                      NOT NULL REFERENCES tenants (id),
+    lesson_date  DATE                     NOT NULL,
+    subject_id   INT,
+    teacher_ids  BIGINT[]                 NOT NULL DEFAULT '{}',
+    customer_ids BIGINT[]                 NOT NULL DEFAULT '{}',
+    shared_by    BIGINT                   NOT NULL REFERENCES users (id),
+    shared_at    TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (lesson_id, material_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_lesson_materials_material_id ON lesson_materials (material_id);
+CREATE INDEX IF NOT EXISTS idx_lesson_materials_customer_ids ON lesson_materials USING GIN (customer_ids);
+CREATE INDEX IF NOT EXISTS idx_lesson_materials_teacher_ids ON lesson_materials USING GIN (teacher_ids);
@@ -0,0 +1,2 @@
+ALTER TABLE login_lockouts ALTER COLUMN unlocked_by TYPE INT;
+ALTER TABLE login_attempts ALTER COLUMN user_id TYPE INT;
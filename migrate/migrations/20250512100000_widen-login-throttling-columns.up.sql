@@ -0,0 +1,3 @@
+-- ID пользователей выходят за INT: локальные ID начинаются с 1e9, к ним прибавляется смещение школы
+ALTER TABLE login_attempts ALTER COLUMN user_id TYPE BIGINT;
+ALTER TABLE login_lockouts ALTER COLUMN unlocked_by TYPE BIGINT;
@@ -0,0 +1,12 @@
+DROP INDEX IF EXISTS idx_login_lockouts_tenant_id_scope_key;
+DROP INDEX IF EXISTS idx_login_attempts_tenant_id_user_id;
+DROP INDEX IF EXISTS idx_login_attempts_tenant_id_ip;
+DROP INDEX IF EXISTS idx_login_attempts_tenant_id_phone;
+
+CREATE INDEX IF NOT EXISTS idx_login_attempts_phone ON login_attempts (phone, created_at);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_ip ON login_attempts (ip, created_at);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_user_id ON login_attempts (user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_login_lockouts_scope_key ON login_lockouts (scope, key, locked_until);
+
+ALTER TABLE login_lockouts DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE login_attempts DROP COLUMN IF EXISTS tenant_id;
@@ -0,0 +1,15 @@
+-- Телефон уникален только внутри школы, поэтому попытки входа и блокировки считаются по школе
+ALTER TABLE login_attempts ADD COLUMN IF NOT EXISTS tenant_id INT NOT NULL DEFAULT 1 REFERENCES tenants (id);
+ALTER TABLE login_lockouts ADD COLUMN IF NOT EXISTS tenant_id INT NOT NULL DEFAULT 1 REFERENCES tenants (id);
+
+UPDATE login_attempts a SET tenant_id = u.tenant_id FROM users u WHERE u.id = a.user_id;
+
+DROP INDEX IF EXISTS idx_login_attempts_phone;
+DROP INDEX IF EXISTS idx_login_attempts_ip;
+DROP INDEX IF EXISTS idx_login_attempts_user_id;
+DROP INDEX IF EXISTS idx_login_lockouts_scope_key;
+
+CREATE INDEX IF NOT EXISTS idx_login_attempts_tenant_id_phone ON login_attempts (tenant_id, phone, created_at);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_tenant_id_ip ON login_attempts (tenant_id, ip, created_at);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_tenant_id_user_id ON login_attempts (tenant_id, user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_login_lockouts_tenant_id_scope_key ON login_lockouts (tenant_id, scope, key, locked_until);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_verification_codes_tenant_id_phone_purpose;
+CREATE INDEX IF NOT EXISTS idx_verification_codes_phone_purpose ON verification_codes (phone, purpose, created_at);
+
+ALTER TABLE verification_codes DROP COLUMN IF EXISTS tenant_id;
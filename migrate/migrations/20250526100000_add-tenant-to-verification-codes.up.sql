@@ -0,0 +1,5 @@
+-- Телефон уникален только внутри школы, код одной школы не должен подходить в другой
+ALTER TABLE verification_codes ADD COLUMN IF NOT EXISTS tenant_id INT NOT NULL DEFAULT 1 REFERENCES tenants (id);
+
+DROP INDEX IF EXISTS idx_verification_codes_phone_purpose;
+CREATE INDEX IF NOT EXISTS idx_verification_codes_tenant_id_phone_purpose ON verification_codes (tenant_id, phone, purpose, created_at);
@@ -0,0 +1,112 @@
+package alpha
+
+import (
+	"fmt"
+	"github.com/prok05/ecom/config"
+	"github.com/prok05/ecom/types"
+	"strings"
+)
+
+// Account - подключение к AlfaCRM одной школы.
+//
+// ID клиентов, преподавателей и уроков в разных аккаунтах AlfaCRM пересекаются,
+// поэтому локально к ним прибавляется IDOffset школы. Функции пакета принимают
+// и возвращают локальные ID, перевод в ID CRM происходит только здесь.
+// ID справочников (предметы, аудитории, группы) не смещаются, они хранятся вместе с ID школы.
+type Account struct {
+	Key      string
+	Host     string
+	BranchID int
+	Email    string
+	APIKey   string
+	AppKey   string
+	IDOffset int
+}
+
+// AccountFor собирает подключение школы. Незаполненные поля берутся из config.Envs.
+func AccountFor(t *types.Tenant) Account {
+	account := DefaultAccount()
+	if t == nil {
+		return account
+	}
+
+	account.Key = t.Slug
+	account.IDOffset = t.IDOffset
+	if t.CRMHost != "" {
+		account.Host = t.CRMHost
+	}
+	if t.CRMBranchID != 0 {
+		account.BranchID = t.CRMBranchID
+	}
+	if t.CRMEmail != "" {
+		account.Email = t.CRMEmail
+	}
+	if t.CRMAPIKey != "" {
+		account.APIKey = t.CRMAPIKey
+	}
+	if t.CRMAppKey != "" {
+		account.AppKey = t.CRMAppKey
+	}
+	return account
+}
+
+// DefaultAccount - подключение из переменных окружения.
+func DefaultAccount() Account {
+	return Account{
+		Key:      config.Envs.DefaultTenant,
+		Host:     config.Envs.AlphaHost,
+		BranchID: int(config.Envs.AlphaBranchID),
+		Email:    config.Envs.AlphaEmail,
+		APIKey:   config.Envs.AlphaApiKey,
+		AppKey:   config.Envs.AlphaXAppKey,
+	}
+}
+
+// BaseURL - адрес AlfaCRM. Host задается без схемы (тогда используется https)
+// или целиком, например http://localhost:8090 для локального fake-сервера.
+func (a Account) BaseURL() string {
+	if strings.Contains(a.Host, "://") {
+		return strings.TrimSuffix(a.Host, "/")
+	}
+	return "https://" + a.Host
+}
+
+// URL - адрес метода API филиала, например URL("lesson/index").
+func (a Account) URL(method string) string {
+	return fmt.Sprintf("%s/v2api/%d/%s", a.BaseURL(), a.BranchID, method)
+}
+
+func (a Account) AuthURL() string {
+	return a.BaseURL() + "/v2api/auth/login"
+}
+
+// CRMID переводит локальный ID в ID AlfaCRM.
+func (a Account) CRMID(localID int) int {
+	if localID == 0 {
+		return 0
+	}
+	return localID - a.IDOffset
+}
+
+// LocalID переводит ID AlfaCRM в локальный ID.
+func (a Account) LocalID(crmID int) int {
+	if crmID == 0 {
+		return 0
+	}
+	return crmID + a.IDOffset
+}
+
+func (a Account) LocalIDs(crmIDs []int) []int {
+	ids := make([]int, len(crmIDs))
+	for i, id := range crmIDs {
+		ids[i] = a.LocalID(id)
+	}
+	return ids
+}
+
+// LocalizeLesson переводит ID урока, учеников и преподавателей в локальные.
+func (a Account) LocalizeLesson(lesson *types.GetLessonsResponseItem) {
+	lesson.ID = a.LocalID(lesson.ID)
+	lesson.TeacherIDs = a.LocalIDs(lesson.TeacherIDs)
+	lesson.CustomerIDs = a.LocalIDs(lesson.CustomerIDs)
+}
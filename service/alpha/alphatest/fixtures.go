@@ -0,0 +1,107 @@
+package alphatest
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/prok05/ecom/types"
+	"os"
+	"time"
+)
+
+// Fixtures - данные, которые отдает fake-сервер. ID указываются в том виде,
+// в каком они хранятся в AlfaCRM, то есть без смещения школы.
+type Fixtures struct {
+	// Учетные данные, с которыми принимается auth/login. Пустое поле не проверяется.
+	Email  string `json:"email"`
+	APIKey string `json:"api_key"`
+	AppKey string `json:"app_key"`
+
+	// PageSize - размер страницы в ответах index, по умолчанию 50, как в AlfaCRM.
+	PageSize int `json:"page_size"`
+
+	Teachers  []types.GetUserResponseItem    `json:"teachers"`
+	Customers []types.GetUserResponseItem    `json:"customers"`
+	Lessons   []types.GetLessonsResponseItem `json:"lessons"`
+
+	Subjects       []types.AlphaSubject       `json:"subjects"`
+	Rooms          []types.AlphaRoom          `json:"rooms"`
+	Groups         []types.AlphaGroup         `json:"groups"`
+	GroupCustomers []types.AlphaGroupCustomer `json:"group_customers"`
+}
+
+// LoadFixtures читает фикстуры из JSON-файла.
+func LoadFixtures(path string) (*Fixtures, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f Fixtures
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse fixtures %s: %v", path, err)
+	}
+	return &f, nil
+}
+
+// DefaultFixtures - небольшая школа для локальной разработки: два преподавателя,
+// два ученика с общим телефоном родителя, уроки на соседние дни, два предмета
+// и группа, из которой второй ученик уже выбыл.
+func DefaultFixtures() *Fixtures {
+	day := func(offset int) string {
+		return time.Now().AddDate(0, 0, offset).Format("2006-01-02")
+	}
+	lesson := func(id, status, offset, teacherID int, customerIDs []int, topic string) types.GetLessonsResponseItem {
+		// преподаватель 1 ведет математику в группе 1, преподаватель 2 - английский индивидуально
+		l := types.GetLessonsResponseItem{
+			ID:          id,
+			Status:      status,
+			Date:        day(offset),
+			TimeFrom:    day(offset) + " 15:00:00",
+			TimeTo:      day(offset) + " 16:00:00",
+			SubjectID:   teacherID,
+			RoomID:      teacherID,
+			TeacherIDs:  []int{teacherID},
+			CustomerIDs: customerIDs,
+			GroupIDs:    []int{},
+			Topic:       topic,
+		}
+		if teacherID == 1 {
+			l.GroupIDs = []int{1}
+		}
+		return l
+	}
+
+	return &Fixtures{
+		Teachers: []types.GetUserResponseItem{
+			{ID: 1, Name: "Иванова Мария Петровна", Phone: []string{"+79990000001"}},
+			{ID: 2, Name: "Петров Сергей Андреевич", Phone: []string{"+79990000002"}},
+		},
+		Customers: []types.GetUserResponseItem{
+			{ID: 101, Name: "Смирнов Артем Олегович", Phone: []string{"+79990000101", "+79990000100"},
+				LegalName: "Смирнова Ольга Викторовна", Balance: "4500.00", PaidLessonCount: 3, IsStudy: 1},
+			{ID: 102, Name: "Смирнова Анна Олеговна", Phone: []string{"+79990000102", "+79990000100"},
+				LegalName: "Смирнова Ольга Викторовна", Balance: "0.00", PaidLessonCount: 0, IsStudy: 1},
+		},
+		Lessons: []types.GetLessonsResponseItem{
+			lesson(1001, 3, -7, 1, []int{101}, "Дроби"),
+			lesson(1002, 3, -2, 1, []int{101, 102}, "Проценты"),
+			lesson(1003, 2, -1, 2, []int{102}, "Present Simple"),
+			lesson(1004, 1, 1, 1, []int{101}, "Уравнения"),
+			lesson(1005, 1, 2, 2, []int{101, 102}, "Past Simple"),
+		},
+		Subjects: []types.AlphaSubject{
+			{ID: 1, Name: "Математика"},
+			{ID: 2, Name: "Английский язык"},
+		},
+		Rooms: []types.AlphaRoom{
+			{ID: 1, Name: "Кабинет 1", IsEnabled: 1},
+			{ID: 2, Name: "Кабинет 2", IsEnabled: 1},
+		},
+		Groups: []types.AlphaGroup{
+			{ID: 1, Name: "Математика 5 класс", TeacherIDs: []int{1}, BDate: day(-90)},
+		},
+		GroupCustomers: []types.AlphaGroupCustomer{
+			{ID: 1, CustomerID: 101, GroupID: 1, BDate: day(-90)},
+			{ID: 2, CustomerID: 102, GroupID: 1, BDate: day(-90), EDate: day(-30)},
+		},
+	}
+}
@@ -0,0 +1,371 @@
+// Package alphatest - fake-сервер AlfaCRM для локальной разработки и интеграционных тестов.
+// Поддерживает auth/login, teacher/index, customer/index, lesson/index, lesson/update
+// и справочники subject/index, room/index, group/index, cgi/index.
+package alphatest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/prok05/ecom/types"
+	"github.com/prok05/ecom/utils"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+)
+
+const defaultPageSize = 50
+
+// Server хранит фикстуры в памяти и отвечает в формате API AlfaCRM v2.
+// Номер филиала в пути не проверяется.
+type Server struct {
+	mu       sync.Mutex
+	fixtures Fixtures
+	tokens   map[string]bool
+}
+
+func NewServer(fixtures *Fixtures) *Server {
+	s := &Server{
+		tokens: make(map[string]bool),
+	}
+	if fixtures != nil {
+		s.fixtures = *fixtures
+	}
+	if s.fixtures.PageSize <= 0 {
+		s.fixtures.PageSize = defaultPageSize
+	}
+	return s
+}
+
+// NewHTTPTestServer запускает Server на случайном порту. Адрес из URL
+// подставляется в ALPHA_HOST или в tenants.crm_host.
+func NewHTTPTestServer(fixtures *Fixtures) (*httptest.Server, *Server) {
+	s := NewServer(fixtures)
+	return httptest.NewServer(s.Handler()), s
+}
+
+func (s *Server) Handler() http.Handler {
+	router := mux.NewRouter()
+	router.HandleFunc("/v2api/auth/login", s.handleLogin).Methods(http.MethodPost)
+	router.HandleFunc("/v2api/{branch}/teacher/index", s.authorized(s.handleTeachers)).Methods(http.MethodPost)
+	router.HandleFunc("/v2api/{branch}/customer/index", s.authorized(s.handleCustomers)).Methods(http.MethodPost)
+	router.HandleFunc("/v2api/{branch}/lesson/index", s.authorized(s.handleLessons)).Methods(http.MethodPost)
+	router.HandleFunc("/v2api/{branch}/lesson/update", s.authorized(s.handleUpdateLesson)).Methods(http.MethodPost)
+	router.HandleFunc("/v2api/{branch}/subject/index", s.authorized(s.handleSubjects)).Methods(http.MethodPost)
+	router.HandleFunc("/v2api/{branch}/room/index", s.authorized(s.handleRooms)).Methods(http.MethodPost)
+	router.HandleFunc("/v2api/{branch}/group/index", s.authorized(s.handleGroups)).Methods(http.MethodPost)
+	router.HandleFunc("/v2api/{branch}/cgi/index", s.authorized(s.handleGroupCustomers)).Methods(http.MethodPost)
+	return router
+}
+
+func (s *Server) AddTeacher(u types.GetUserResponseItem) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fixtures.Teachers = append(s.fixtures.Teachers, u)
+}
+
+func (s *Server) AddCustomer(u types.GetUserResponseItem) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fixtures.Customers = append(s.fixtures.Customers, u)
+}
+
+func (s *Server) AddLesson(l types.GetLessonsResponseItem) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fixtures.Lessons = append(s.fixtures.Lessons, l)
+}
+
+// Lesson возвращает текущее состояние урока, например чтобы проверить результат lesson/update.
+func (s *Server) Lesson(id int) (types.GetLessonsResponseItem, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, l := range s.fixtures.Lessons {
+		if l.ID == id {
+			return l, true
+		}
+	}
+	return types.GetLessonsResponseItem{}, false
+}
+
+// RevokeTokens делает все выданные токены недействительными, как при их истечении в AlfaCRM.
+func (s *Server) RevokeTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = make(map[string]bool)
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var payload types.AlphaAuthRequest
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !matches(s.fixtures.Email, payload.Email) ||
+		!matches(s.fixtures.APIKey, payload.APIKey) ||
+		!matches(s.fixtures.AppKey, r.Header.Get("X-APP-KEY")) {
+		writeError(w, http.StatusForbidden, "invalid credentials")
+		return
+	}
+
+	token, err := newToken()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot issue token")
+		return
+	}
+	s.tokens[token] = true
+	writeJSON(w, types.AlphaAuthResponse{Token: token})
+}
+
+func (s *Server) handleTeachers(w http.ResponseWriter, r *http.Request) {
+	s.handleUsers(w, r, func(f *Fixtures) []types.GetUserResponseItem { return f.Teachers })
+}
+
+func (s *Server) handleCustomers(w http.ResponseWriter, r *http.Request) {
+	s.handleUsers(w, r, func(f *Fixtures) []types.GetUserResponseItem { return f.Customers })
+}
+
+func (s *Server) handleUsers(w http.ResponseWriter, r *http.Request, source func(f *Fixtures) []types.GetUserResponseItem) {
+	var query types.AlphaUserQuery
+	if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found := make([]types.GetUserResponseItem, 0)
+	for _, u := range source(&s.fixtures) {
+		if query.ID != 0 && u.ID != query.ID {
+			continue
+		}
+		if query.Phone != "" && !hasPhone(u.Phone, query.Phone) {
+			continue
+		}
+		found = append(found, u)
+	}
+
+	items, page := paginate(found, query.Page, s.fixtures.PageSize)
+	writeJSON(w, types.GetUserResponse{Total: len(found), Count: len(items), Page: page, Items: items})
+}
+
+func (s *Server) handleLessons(w http.ResponseWriter, r *http.Request) {
+	var query types.AlphaLessonQuery
+	if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found := make([]types.GetLessonsResponseItem, 0)
+	for _, l := range s.fixtures.Lessons {
+		switch {
+		case query.ID != 0 && l.ID != query.ID,
+			query.Status != 0 && l.Status != query.Status,
+			query.CustomerID != 0 && !containsID(l.CustomerIDs, query.CustomerID),
+			query.TeacherID != 0 && !containsID(l.TeacherIDs, query.TeacherID),
+			query.DateFrom != "" && l.Date < query.DateFrom,
+			query.DateTo != "" && l.Date > query.DateTo:
+			continue
+		}
+		found = append(found, l)
+	}
+
+	items, page := paginate(found, query.Page, s.fixtures.PageSize)
+	writeJSON(w, types.GetLessonsResponse{Total: len(found), Count: len(items), Page: page, Items: items})
+}
+
+func (s *Server) handleUpdateLesson(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid lesson id")
+		return
+	}
+
+	var update map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.fixtures.Lessons {
+		if s.fixtures.Lessons[i].ID == id {
+			if err := applyLessonUpdate(&s.fixtures.Lessons[i], update); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			writeJSON(w, map[string]any{"success": true, "errors": []string{}, "model": s.fixtures.Lessons[i]})
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, fmt.Sprintf("lesson %d not found", id))
+}
+
+func (s *Server) handleSubjects(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	items := s.fixtures.Subjects
+	s.mu.Unlock()
+	writePage(w, r, items, s.fixtures.PageSize)
+}
+
+func (s *Server) handleRooms(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	items := s.fixtures.Rooms
+	s.mu.Unlock()
+	writePage(w, r, items, s.fixtures.PageSize)
+}
+
+func (s *Server) handleGroups(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	items := s.fixtures.Groups
+	s.mu.Unlock()
+	writePage(w, r, items, s.fixtures.PageSize)
+}
+
+func (s *Server) handleGroupCustomers(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.Atoi(r.URL.Query().Get("group_id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "group_id is required")
+		return
+	}
+
+	s.mu.Lock()
+	items := make([]types.AlphaGroupCustomer, 0)
+	for _, c := range s.fixtures.GroupCustomers {
+		if c.GroupID == groupID {
+			items = append(items, c)
+		}
+	}
+	s.mu.Unlock()
+	writePage(w, r, items, s.fixtures.PageSize)
+}
+
+// writePage отвечает страницей справочника, номер страницы берется из тела запроса.
+func writePage[T any](w http.ResponseWriter, r *http.Request, all []T, size int) {
+	var query types.AlphaPageQuery
+	if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	items, page := paginate(all, query.Page, size)
+	writeJSON(w, map[string]any{"total": len(all), "count": len(items), "page": page, "items": items})
+}
+
+// applyLessonUpdate меняет поля урока, которые хранит fake-сервер. Остальные поля игнорируются.
+func applyLessonUpdate(l *types.GetLessonsResponseItem, update map[string]any) error {
+	if v, ok := update["custom_homework_status"]; ok {
+		status, err := strconv.Atoi(fmt.Sprint(v))
+		if err != nil {
+			return fmt.Errorf("invalid custom_homework_status")
+		}
+		l.HomeworkStatus = status
+	}
+	if v, ok := update["topic"].(string); ok {
+		l.Topic = v
+	}
+	if v, ok := update["note"].(string); ok {
+		l.Note = v
+	}
+	if v, ok := update["details"]; ok {
+		// посещаемость не хранится, проверяется только формат
+		details, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("invalid details")
+		}
+		for _, d := range details {
+			detail, ok := d.(map[string]any)
+			if !ok || detail["customer_id"] == nil || detail["is_attend"] == nil {
+				return fmt.Errorf("invalid details")
+			}
+		}
+	}
+	return nil
+}
+
+// authorized пропускает запрос только с токеном, выданным auth/login.
+func (s *Server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		ok := s.tokens[r.Header.Get("X-ALFACRM-TOKEN")]
+		s.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "invalid token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// paginate возвращает страницу page (с нуля). Страница за концом списка пустая.
+func paginate[T any](items []T, page, size int) ([]T, int) {
+	if page < 0 {
+		page = 0
+	}
+	from := page * size
+	if from >= len(items) {
+		return []T{}, page
+	}
+	to := from + size
+	if to > len(items) {
+		to = len(items)
+	}
+	return items[from:to], page
+}
+
+func hasPhone(phones []string, phone string) bool {
+	want := utils.NormalizePhone(phone)
+	for _, p := range phones {
+		if utils.NormalizePhone(p) == want {
+			return true
+		}
+	}
+	return false
+}
+
+func containsID(ids []int, id int) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+func matches(expected, actual string) bool {
+	return expected == "" || expected == actual
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("fakealpha: failed to write response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"name": http.StatusText(status), "message": message, "status": status})
+}
@@ -0,0 +1,236 @@
+package alphatest
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/prok05/ecom/types"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestServer(t *testing.T, fixtures *Fixtures) (*httptest.Server, *Server) {
+	t.Helper()
+	srv, fake := NewHTTPTestServer(fixtures)
+	t.Cleanup(srv.Close)
+	return srv, fake
+}
+
+// post отправляет запрос к fake-серверу и разбирает ответ в response, если статус 200.
+func post(t *testing.T, url string, headers map[string]string, request, response any) int {
+	t.Helper()
+	body, err := json.Marshal(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK && response != nil {
+		if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func login(t *testing.T, srv *httptest.Server) string {
+	t.Helper()
+	var auth types.AlphaAuthResponse
+	status := post(t, srv.URL+"/v2api/auth/login", map[string]string{"X-APP-KEY": "app"},
+		types.AlphaAuthRequest{Email: "crm@school.ru", APIKey: "key"}, &auth)
+	if status != http.StatusOK || auth.Token == "" {
+		t.Fatalf("login failed with status %d", status)
+	}
+	return auth.Token
+}
+
+func TestLogin(t *testing.T) {
+	srv, _ := newTestServer(t, &Fixtures{Email: "crm@school.ru", APIKey: "key", AppKey: "app"})
+
+	tests := []struct {
+		name    string
+		request types.AlphaAuthRequest
+		appKey  string
+		want    int
+	}{
+		{"valid credentials", types.AlphaAuthRequest{Email: "crm@school.ru", APIKey: "key"}, "app", http.StatusOK},
+		{"wrong api key", types.AlphaAuthRequest{Email: "crm@school.ru", APIKey: "other"}, "app", http.StatusForbidden},
+		{"wrong email", types.AlphaAuthRequest{Email: "other@school.ru", APIKey: "key"}, "app", http.StatusForbidden},
+		{"missing app key", types.AlphaAuthRequest{Email: "crm@school.ru", APIKey: "key"}, "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := post(t, srv.URL+"/v2api/auth/login", map[string]string{"X-APP-KEY": tt.appKey}, tt.request, nil)
+			if status != tt.want {
+				t.Errorf("status %d, want %d", status, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	srv, fake := newTestServer(t, &Fixtures{Email: "crm@school.ru", APIKey: "key", AppKey: "app"})
+	url := srv.URL + "/v2api/1/teacher/index"
+
+	if status := post(t, url, nil, types.AlphaUserQuery{}, nil); status != http.StatusUnauthorized {
+		t.Errorf("request without token: status %d, want 401", status)
+	}
+
+	token := login(t, srv)
+	headers := map[string]string{"X-ALFACRM-TOKEN": token}
+	if status := post(t, url, headers, types.AlphaUserQuery{}, nil); status != http.StatusOK {
+		t.Errorf("request with token: status %d, want 200", status)
+	}
+
+	fake.RevokeTokens()
+	if status := post(t, url, headers, types.AlphaUserQuery{}, nil); status != http.StatusUnauthorized {
+		t.Errorf("request with revoked token: status %d, want 401", status)
+	}
+}
+
+func TestLessonFilters(t *testing.T) {
+	srv, _ := newTestServer(t, &Fixtures{
+		PageSize: 2,
+		Lessons: []types.GetLessonsResponseItem{
+			{ID: 1, Status: 1, Date: "2025-03-01", TeacherIDs: []int{1}, CustomerIDs: []int{10}},
+			{ID: 2, Status: 3, Date: "2025-03-02", TeacherIDs: []int{1}, CustomerIDs: []int{10, 11}},
+			{ID: 3, Status: 3, Date: "2025-03-03", TeacherIDs: []int{2}, CustomerIDs: []int{11}},
+			{ID: 4, Status: 2, Date: "2025-03-04", TeacherIDs: []int{2}, CustomerIDs: []int{10}},
+		},
+	})
+	headers := map[string]string{"X-ALFACRM-TOKEN": login(t, srv)}
+
+	tests := []struct {
+		name  string
+		query types.AlphaLessonQuery
+		total int
+		ids   []int
+	}{
+		{"first page", types.AlphaLessonQuery{}, 4, []int{1, 2}},
+		{"second page", types.AlphaLessonQuery{Page: 1}, 4, []int{3, 4}},
+		{"past the end", types.AlphaLessonQuery{Page: 5}, 4, []int{}},
+		{"by id", types.AlphaLessonQuery{ID: 3}, 1, []int{3}},
+		{"by status", types.AlphaLessonQuery{Status: 3}, 2, []int{2, 3}},
+		{"by customer", types.AlphaLessonQuery{CustomerID: 11}, 2, []int{2, 3}},
+		{"by teacher", types.AlphaLessonQuery{TeacherID: 2}, 2, []int{3, 4}},
+		{"by dates", types.AlphaLessonQuery{DateFrom: "2025-03-02", DateTo: "2025-03-03"}, 2, []int{2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp types.GetLessonsResponse
+			if status := post(t, srv.URL+"/v2api/1/lesson/index", headers, tt.query, &resp); status != http.StatusOK {
+				t.Fatalf("status %d", status)
+			}
+			if resp.Total != tt.total {
+				t.Errorf("total %d, want %d", resp.Total, tt.total)
+			}
+			ids := make([]int, len(resp.Items))
+			for i, l := range resp.Items {
+				ids[i] = l.ID
+			}
+			if !equalIDs(ids, tt.ids) {
+				t.Errorf("lessons %v, want %v", ids, tt.ids)
+			}
+		})
+	}
+}
+
+func TestUpdateLesson(t *testing.T) {
+	srv, fake := newTestServer(t, &Fixtures{
+		Lessons: []types.GetLessonsResponseItem{{ID: 1, Date: "2025-03-01"}},
+	})
+	headers := map[string]string{"X-ALFACRM-TOKEN": login(t, srv)}
+
+	tests := []struct {
+		name   string
+		id     string
+		update map[string]any
+		want   int
+	}{
+		{"homework status", "1", map[string]any{"custom_homework_status": 2}, http.StatusOK},
+		{"topic", "1", map[string]any{"topic": "Дроби"}, http.StatusOK},
+		{"attendance", "1", map[string]any{"details": []map[string]any{{"customer_id": 10, "is_attend": 1}}}, http.StatusOK},
+		{"invalid status", "1", map[string]any{"custom_homework_status": "x"}, http.StatusBadRequest},
+		{"invalid attendance", "1", map[string]any{"details": []map[string]any{{"customer_id": 10}}}, http.StatusBadRequest},
+		{"unknown lesson", "2", map[string]any{"topic": "x"}, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := post(t, srv.URL+"/v2api/1/lesson/update?id="+tt.id, headers, tt.update, nil); status != tt.want {
+				t.Errorf("status %d, want %d", status, tt.want)
+			}
+		})
+	}
+
+	lesson, _ := fake.Lesson(1)
+	if lesson.HomeworkStatus != 2 || lesson.Topic != "Дроби" {
+		t.Errorf("lesson was not updated: %+v", lesson)
+	}
+}
+
+func TestUsersByPhone(t *testing.T) {
+	fixtures := DefaultFixtures()
+	srv, _ := newTestServer(t, fixtures)
+	headers := map[string]string{"X-ALFACRM-TOKEN": login(t, srv)}
+
+	var resp types.GetUserResponse
+	// общий телефон родителя в другом формате записи
+	status := post(t, srv.URL+"/v2api/1/customer/index", headers, types.AlphaUserQuery{Phone: "8 (999) 000-01-00"}, &resp)
+	if status != http.StatusOK {
+		t.Fatalf("status %d", status)
+	}
+	if resp.Total != 2 {
+		t.Errorf("found %d customers by parent phone, want 2", resp.Total)
+	}
+}
+
+func TestLoadFixtures(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fixtures.json")
+	data, err := json.Marshal(DefaultFixtures())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	fixtures, err := LoadFixtures(path)
+	if err != nil {
+		t.Fatalf("LoadFixtures: %v", err)
+	}
+	if len(fixtures.Teachers) != 2 || len(fixtures.Customers) != 2 || len(fixtures.Lessons) != 5 {
+		t.Errorf("unexpected fixtures: %d teachers, %d customers, %d lessons",
+			len(fixtures.Teachers), len(fixtures.Customers), len(fixtures.Lessons))
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFixtures(path); err == nil {
+		t.Error("LoadFixtures accepted invalid JSON")
+	}
+}
+
+func equalIDs(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
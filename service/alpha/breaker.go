@@ -0,0 +1,146 @@
+package alpha
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrUnavailable - AlfaCRM недоступна, запросы к ней временно не отправляются.
+var ErrUnavailable = errors.New("AlfaCRM is unavailable")
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+// BreakerStatus - состояние связи с AlfaCRM школы для мониторинга.
+type BreakerStatus struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+	RetryAt             *time.Time `json:"retry_at,omitempty"`
+	LastSuccessAt       *time.Time `json:"last_success_at,omitempty"`
+	LastFailureAt       *time.Time `json:"last_failure_at,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+}
+
+// Breaker перестает отправлять запросы к CRM после threshold сбоев подряд.
+// Через cooldown пропускается один пробный запрос: если он прошел, запросы
+// снова идут, иначе ожидание начинается заново.
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu          sync.Mutex
+	state       string
+	failures    int
+	probing     bool
+	openedAt    time.Time
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastError   string
+}
+
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     BreakerClosed,
+	}
+}
+
+// Allow возвращает ErrUnavailable, если запрос отправлять не нужно.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return ErrUnavailable
+		}
+		b.state = BreakerHalfOpen
+	case BreakerHalfOpen:
+		if b.probing {
+			return ErrUnavailable
+		}
+	default:
+		return nil
+	}
+	b.probing = true
+	return nil
+}
+
+// Record учитывает результат запроса, пропущенного Allow.
+func (b *Breaker) Record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	switch {
+	case errors.Is(err, context.Canceled):
+		// запрос отменил клиент, о CRM это ничего не говорит
+	case !unavailable(err):
+		b.state = BreakerClosed
+		b.failures = 0
+		b.lastSuccess = time.Now()
+	default:
+		b.failures++
+		b.lastFailure = time.Now()
+		b.lastError = err.Error()
+		if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+			b.state = BreakerOpen
+			b.openedAt = b.lastFailure
+		}
+	}
+}
+
+func (b *Breaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := BreakerStatus{
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		LastSuccessAt:       timePtr(b.lastSuccess),
+		LastFailureAt:       timePtr(b.lastFailure),
+		LastError:           b.lastError,
+	}
+	if b.state != BreakerClosed {
+		status.OpenedAt = timePtr(b.openedAt)
+		status.RetryAt = timePtr(b.openedAt.Add(b.cooldown))
+	}
+	return status
+}
+
+// unavailable - CRM не ответила или ответила ошибкой сервера. Ответ 4xx
+// (кроме 429) и ErrNotFound означают, что CRM работает.
+func unavailable(err error) bool {
+	if err == nil || errors.Is(err, ErrNotFound) {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= http.StatusInternalServerError || apiErr.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// HTTPStatus - код ответа клиенту платформы при ошибке CRM.
+func HTTPStatus(err error) int {
+	if errors.Is(err, ErrUnavailable) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadGateway
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,161 @@
+package alpha
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/prok05/ecom/service/alpha/alphatest"
+	"github.com/prok05/ecom/types"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var (
+	errServer    = &APIError{Method: "lesson/index", StatusCode: http.StatusInternalServerError}
+	errTransport = errors.New("connection refused")
+)
+
+func TestBreakerOpensAfterThreshold(t *testing.T) {
+	b := NewBreaker(3, time.Hour)
+
+	for i := 0; i < 2; i++ {
+		if err := b.Allow(); err != nil {
+			t.Fatalf("Allow after %d failures: %v", i, err)
+		}
+		b.Record(errServer)
+	}
+	if state := b.Status().State; state != BreakerClosed {
+		t.Fatalf("state %s after 2 failures, want closed", state)
+	}
+
+	// успешный запрос сбрасывает счетчик сбоев подряд
+	b.Record(nil)
+	for i := 0; i < 3; i++ {
+		b.Record(errTransport)
+	}
+	status := b.Status()
+	if status.State != BreakerOpen || status.ConsecutiveFailures != 3 || status.RetryAt == nil {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if err := b.Allow(); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Allow while open: %v, want ErrUnavailable", err)
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	tests := []struct {
+		name  string
+		probe error
+		want  string
+	}{
+		{"probe succeeds", nil, BreakerClosed},
+		{"probe fails", errServer, BreakerOpen},
+		{"probe gets client error", &APIError{StatusCode: http.StatusBadRequest}, BreakerClosed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBreaker(1, 20*time.Millisecond)
+			b.Record(errServer)
+			time.Sleep(30 * time.Millisecond)
+
+			if err := b.Allow(); err != nil {
+				t.Fatalf("probe was not allowed: %v", err)
+			}
+			if state := b.Status().State; state != BreakerHalfOpen {
+				t.Fatalf("state %s, want half_open", state)
+			}
+			// пока идет пробный запрос, остальные не пропускаются
+			if err := b.Allow(); !errors.Is(err, ErrUnavailable) {
+				t.Fatalf("second probe: %v, want ErrUnavailable", err)
+			}
+
+			b.Record(tt.probe)
+			if state := b.Status().State; state != tt.want {
+				t.Errorf("state %s, want %s", state, tt.want)
+			}
+			if err := b.Allow(); (err == nil) != (tt.want == BreakerClosed) {
+				t.Errorf("Allow after probe: %v", err)
+			}
+		})
+	}
+}
+
+func TestBreakerIgnoresCanceledRequests(t *testing.T) {
+	b := NewBreaker(1, time.Hour)
+	b.Record(fmt.Errorf("lesson/index: %w", context.Canceled))
+	if status := b.Status(); status.State != BreakerClosed || status.ConsecutiveFailures != 0 {
+		t.Errorf("canceled request changed breaker: %+v", status)
+	}
+}
+
+func TestUnavailable(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		unavailable bool
+		status      int
+	}{
+		{"no error", nil, false, http.StatusBadGateway},
+		{"not found", fmt.Errorf("lesson 1: %w", ErrNotFound), false, http.StatusBadGateway},
+		{"bad request", &APIError{StatusCode: http.StatusBadRequest}, false, http.StatusBadGateway},
+		{"unauthorized", &APIError{StatusCode: http.StatusUnauthorized}, false, http.StatusBadGateway},
+		{"too many requests", &APIError{StatusCode: http.StatusTooManyRequests}, true, http.StatusBadGateway},
+		{"server error", errServer, true, http.StatusBadGateway},
+		{"transport error", errTransport, true, http.StatusBadGateway},
+		{"breaker open", ErrUnavailable, true, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unavailable(tt.err); got != tt.unavailable {
+				t.Errorf("unavailable = %v, want %v", got, tt.unavailable)
+			}
+			if tt.err != nil {
+				if got := HTTPStatus(tt.err); got != tt.status {
+					t.Errorf("HTTPStatus = %d, want %d", got, tt.status)
+				}
+			}
+		})
+	}
+}
+
+func TestClientBreaker(t *testing.T) {
+	var down atomic.Bool
+	var requests atomic.Int32
+	failing := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			if down.Load() {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	client, _, _ := newTestClient(t, failing, &alphatest.Fixtures{Teachers: teachers(1)})
+	client.breaker = NewBreaker(1, 50*time.Millisecond)
+	ctx := context.Background()
+
+	down.Store(true)
+	var apiErr *APIError
+	if _, err := client.GetUser(ctx, types.RoleTeacher, testOffset+1); !errors.As(err, &apiErr) {
+		t.Fatalf("first call: %v, want APIError", err)
+	}
+	sent := requests.Load()
+	if _, err := client.GetUser(ctx, types.RoleTeacher, testOffset+1); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("second call: %v, want ErrUnavailable", err)
+	}
+	if requests.Load() != sent {
+		t.Error("request was sent while the breaker was open")
+	}
+
+	down.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	if _, err := client.GetUser(ctx, types.RoleTeacher, testOffset+1); err != nil {
+		t.Fatalf("probe call: %v", err)
+	}
+	if state := client.breaker.Status().State; state != BreakerClosed {
+		t.Errorf("state %s after successful probe, want closed", state)
+	}
+}
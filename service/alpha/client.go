@@ -0,0 +1,413 @@
+package alpha
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/prok05/ecom/config"
+	"github.com/prok05/ecom/types"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxPages ограничивает обход страниц, если AlfaCRM не сообщает, что список закончился.
+const maxPages = 1000
+
+// API - методы AlfaCRM, которыми пользуется приложение.
+// ID во входных и выходных данных локальные (см. Account).
+type API interface {
+	// FindUserByPhone ищет преподавателя (роли teacher, supervisor) или клиента (student, parent).
+	FindUserByPhone(ctx context.Context, role, phone string) (*types.GetUserResponseItem, error)
+	GetUser(ctx context.Context, role string, id int) (*types.GetUserResponseItem, error)
+	// FindCustomersByPhone возвращает всех клиентов с номером, например детей одного родителя.
+	FindCustomersByPhone(ctx context.Context, phone string) ([]types.GetUserResponseItem, error)
+	// ListUsers возвращает всех преподавателей (role teacher) или клиентов (role student).
+	ListUsers(ctx context.Context, role string) ([]types.GetUserResponseItem, error)
+	// ListLessons возвращает уроки по фильтру начиная со страницы query.Page.
+	ListLessons(ctx context.Context, query types.AlphaLessonQuery) ([]types.GetLessonsResponseItem, error)
+	GetLesson(ctx context.Context, lessonID int) (*types.GetLessonsResponseItem, error)
+	// UpdateLesson меняет поля урока, например custom_homework_status.
+	UpdateLesson(ctx context.Context, lessonID int, fields map[string]any) error
+	// UpdateLessonAttendance отмечает посещение урока клиентами, ID клиентов локальные.
+	UpdateLessonAttendance(ctx context.Context, lessonID int, details []types.AlphaAttendance) error
+	ListSubjects(ctx context.Context) ([]types.AlphaSubject, error)
+	ListRooms(ctx context.Context) ([]types.AlphaRoom, error)
+	// ListGroups возвращает группы, ID преподавателей в них локальные.
+	ListGroups(ctx context.Context) ([]types.AlphaGroup, error)
+	// ListGroupCustomers возвращает участие клиентов в группе, включая завершенное.
+	ListGroupCustomers(ctx context.Context, groupID int) ([]types.AlphaGroupCustomer, error)
+}
+
+// Provider выдает клиента AlfaCRM для школы.
+type Provider interface {
+	For(account Account) API
+}
+
+// TokenSource выдает токен AlfaCRM школы, например cache.TokenCache.
+// Invalidate вызывается, когда CRM отклонила токен.
+type TokenSource interface {
+	GetToken(account Account) (string, error)
+	Invalidate(account Account, token string)
+}
+
+// ClientProvider создает клиентов с общим http.Client и источником токенов.
+// У каждой школы свой Breaker, общий для всех ее клиентов.
+type ClientProvider struct {
+	tokens     TokenSource
+	httpClient *http.Client
+
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+}
+
+func NewClientProvider(tokens TokenSource) *ClientProvider {
+	return &ClientProvider{
+		tokens:     tokens,
+		httpClient: NewHTTPClient(),
+		breakers:   make(map[string]*Breaker),
+	}
+}
+
+func (p *ClientProvider) For(account Account) API {
+	client := NewClient(account, p.tokens, p.httpClient)
+	client.breaker = p.breaker(account)
+	return client
+}
+
+// BreakerStatus возвращает состояние связи с CRM школы.
+func (p *ClientProvider) BreakerStatus(account Account) BreakerStatus {
+	return p.breaker(account).Status()
+}
+
+func (p *ClientProvider) breaker(account Account) *Breaker {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	b, ok := p.breakers[account.Key]
+	if !ok {
+		b = NewBreaker(int(config.Envs.AlphaBreakerFailures),
+			time.Second*time.Duration(config.Envs.AlphaBreakerCooldownSeconds))
+		p.breakers[account.Key] = b
+	}
+	return b
+}
+
+// NewHTTPClient - http.Client с таймаутом из config.Envs.
+func NewHTTPClient() *http.Client {
+	return &http.Client{
+		Timeout: time.Second * time.Duration(config.Envs.AlphaTimeoutSeconds),
+	}
+}
+
+// Client - клиент AlfaCRM одной школы. Без breaker запросы отправляются всегда.
+type Client struct {
+	account    Account
+	tokens     TokenSource
+	httpClient *http.Client
+	breaker    *Breaker
+}
+
+func NewClient(account Account, tokens TokenSource, httpClient *http.Client) *Client {
+	return &Client{
+		account:    account,
+		tokens:     tokens,
+		httpClient: httpClient,
+	}
+}
+
+// Login получает новый токен AlfaCRM по ключу API школы.
+func Login(ctx context.Context, httpClient *http.Client, account Account) (string, error) {
+	request := types.AlphaAuthRequest{
+		Email:  account.Email,
+		APIKey: account.APIKey,
+	}
+	var response types.AlphaAuthResponse
+	headers := map[string]string{"X-APP-KEY": account.AppKey}
+	if err := doJSON(ctx, httpClient, "auth/login", account.AuthURL(), headers, request, &response); err != nil {
+		return "", err
+	}
+	if response.Token == "" {
+		return "", fmt.Errorf("alpha auth/login: empty token")
+	}
+	return response.Token, nil
+}
+
+func (c *Client) FindUserByPhone(ctx context.Context, role, phone string) (*types.GetUserResponseItem, error) {
+	method, err := userMethod(role)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.usersPage(ctx, method, types.AlphaUserQuery{Phone: phone})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Items) == 0 {
+		return nil, fmt.Errorf("user with phone %s: %w", phone, ErrNotFound)
+	}
+	return &resp.Items[0], nil
+}
+
+func (c *Client) GetUser(ctx context.Context, role string, id int) (*types.GetUserResponseItem, error) {
+	method, err := userMethod(role)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.usersPage(ctx, method, types.AlphaUserQuery{ID: c.account.CRMID(id)})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Items) == 0 {
+		return nil, fmt.Errorf("user %d: %w", id, ErrNotFound)
+	}
+	return &resp.Items[0], nil
+}
+
+func (c *Client) FindCustomersByPhone(ctx context.Context, phone string) ([]types.GetUserResponseItem, error) {
+	return c.allUsers(ctx, "customer/index", types.AlphaUserQuery{Phone: phone})
+}
+
+func (c *Client) ListUsers(ctx context.Context, role string) ([]types.GetUserResponseItem, error) {
+	switch role {
+	case types.RoleTeacher, types.RoleStudent:
+	default:
+		return nil, fmt.Errorf("unsupported role: %s", role)
+	}
+	method, _ := userMethod(role)
+	return c.allUsers(ctx, method, types.AlphaUserQuery{})
+}
+
+func (c *Client) ListLessons(ctx context.Context, query types.AlphaLessonQuery) ([]types.GetLessonsResponseItem, error) {
+	query.ID = c.account.CRMID(query.ID)
+	query.CustomerID = c.account.CRMID(query.CustomerID)
+	query.TeacherID = c.account.CRMID(query.TeacherID)
+
+	lessons := make([]types.GetLessonsResponseItem, 0)
+	for i := 0; i < maxPages; i++ {
+		var resp types.GetLessonsResponse
+		if err := c.call(ctx, "lesson/index", nil, query, &resp); err != nil {
+			return nil, err
+		}
+		for j := range resp.Items {
+			c.account.LocalizeLesson(&resp.Items[j])
+		}
+		lessons = append(lessons, resp.Items...)
+		if lastPage(len(resp.Items), len(lessons), resp.Total) {
+			break
+		}
+		query.Page++
+	}
+	return lessons, nil
+}
+
+func (c *Client) GetLesson(ctx context.Context, lessonID int) (*types.GetLessonsResponseItem, error) {
+	// lesson/index возвращает список даже при поиске по id
+	var resp types.GetLessonsResponse
+	query := types.AlphaLessonQuery{ID: c.account.CRMID(lessonID)}
+	if err := c.call(ctx, "lesson/index", nil, query, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Items) == 0 {
+		return nil, fmt.Errorf("lesson %d: %w", lessonID, ErrNotFound)
+	}
+	lesson := resp.Items[0]
+	c.account.LocalizeLesson(&lesson)
+	return &lesson, nil
+}
+
+func (c *Client) UpdateLesson(ctx context.Context, lessonID int, fields map[string]any) error {
+	params := url.Values{"id": {strconv.Itoa(c.account.CRMID(lessonID))}}
+	return c.call(ctx, "lesson/update", params, fields, nil)
+}
+
+func (c *Client) UpdateLessonAttendance(ctx context.Context, lessonID int, details []types.AlphaAttendance) error {
+	crmDetails := make([]types.AlphaAttendance, len(details))
+	for i, d := range details {
+		d.CustomerID = c.account.CRMID(d.CustomerID)
+		crmDetails[i] = d
+	}
+	return c.UpdateLesson(ctx, lessonID, map[string]any{"details": crmDetails})
+}
+
+func (c *Client) ListSubjects(ctx context.Context) ([]types.AlphaSubject, error) {
+	return listAll[types.AlphaSubject](ctx, c, "subject/index", nil)
+}
+
+func (c *Client) ListRooms(ctx context.Context) ([]types.AlphaRoom, error) {
+	return listAll[types.AlphaRoom](ctx, c, "room/index", nil)
+}
+
+func (c *Client) ListGroups(ctx context.Context) ([]types.AlphaGroup, error) {
+	groups, err := listAll[types.AlphaGroup](ctx, c, "group/index", nil)
+	if err != nil {
+		return nil, err
+	}
+	for i := range groups {
+		groups[i].TeacherIDs = c.account.LocalIDs(groups[i].TeacherIDs)
+	}
+	return groups, nil
+}
+
+func (c *Client) ListGroupCustomers(ctx context.Context, groupID int) ([]types.AlphaGroupCustomer, error) {
+	// cgi/index принимает группу только в параметрах адреса
+	params := url.Values{"group_id": {strconv.Itoa(groupID)}}
+	items, err := listAll[types.AlphaGroupCustomer](ctx, c, "cgi/index", params)
+	if err != nil {
+		return nil, err
+	}
+	for i := range items {
+		items[i].CustomerID = c.account.LocalID(items[i].CustomerID)
+	}
+	return items, nil
+}
+
+// page - страница ответа index-методов AlfaCRM.
+type page[T any] struct {
+	Total int `json:"total"`
+	Count int `json:"count"`
+	Page  int `json:"page"`
+	Items []T `json:"items"`
+}
+
+// listAll обходит все страницы index-метода без фильтра в теле запроса.
+func listAll[T any](ctx context.Context, c *Client, method string, params url.Values) ([]T, error) {
+	items := make([]T, 0)
+	query := types.AlphaPageQuery{}
+	for i := 0; i < maxPages; i++ {
+		var resp page[T]
+		if err := c.call(ctx, method, params, query, &resp); err != nil {
+			return nil, err
+		}
+		items = append(items, resp.Items...)
+		if lastPage(len(resp.Items), len(items), resp.Total) {
+			break
+		}
+		query.Page++
+	}
+	return items, nil
+}
+
+func (c *Client) usersPage(ctx context.Context, method string, query types.AlphaUserQuery) (*types.GetUserResponse, error) {
+	var resp types.GetUserResponse
+	if err := c.call(ctx, method, nil, query, &resp); err != nil {
+		return nil, err
+	}
+	for i := range resp.Items {
+		resp.Items[i].ID = c.account.LocalID(resp.Items[i].ID)
+	}
+	return &resp, nil
+}
+
+func (c *Client) allUsers(ctx context.Context, method string, query types.AlphaUserQuery) ([]types.GetUserResponseItem, error) {
+	users := make([]types.GetUserResponseItem, 0)
+	for i := 0; i < maxPages; i++ {
+		resp, err := c.usersPage(ctx, method, query)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, resp.Items...)
+		if lastPage(len(resp.Items), len(users), resp.Total) {
+			break
+		}
+		query.Page++
+	}
+	return users, nil
+}
+
+// call выполняет метод API через breaker школы. Пока CRM недоступна,
+// запрос не отправляется и возвращается ErrUnavailable.
+func (c *Client) call(ctx context.Context, method string, params url.Values, request, response any) error {
+	if c.breaker == nil {
+		return c.send(ctx, method, params, request, response)
+	}
+	if err := c.breaker.Allow(); err != nil {
+		return fmt.Errorf("alpha %s: %w", method, err)
+	}
+	err := c.send(ctx, method, params, request, response)
+	c.breaker.Record(err)
+	return err
+}
+
+// send отправляет запрос. Если CRM отклонила токен (401 или 403), токен
+// сбрасывается и запрос один раз повторяется с новым.
+func (c *Client) send(ctx context.Context, method string, params url.Values, request, response any) error {
+	endpoint := c.account.URL(method)
+	if len(params) > 0 {
+		endpoint += "?" + params.Encode()
+	}
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		var token string
+		token, err = c.tokens.GetToken(c.account)
+		if err != nil {
+			return fmt.Errorf("alpha %s: failed to get token: %w", method, err)
+		}
+
+		headers := map[string]string{"X-ALFACRM-TOKEN": token}
+		err = doJSON(ctx, c.httpClient, method, endpoint, headers, request, response)
+		if !IsUnauthorized(err) {
+			return err
+		}
+		c.tokens.Invalidate(c.account, token)
+	}
+	return err
+}
+
+// doJSON отправляет POST с JSON-телом и разбирает JSON-ответ в response, если он не nil.
+func doJSON(ctx context.Context, httpClient *http.Client, method, endpoint string, headers map[string]string, request, response any) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("alpha %s: failed to marshal request: %w", method, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("alpha %s: failed to create request: %w", method, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("alpha %s: failed to send request: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("alpha %s: failed to read response: %w", method, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &APIError{Method: method, StatusCode: resp.StatusCode, Body: string(data)}
+	}
+
+	if response == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, response); err != nil {
+		return fmt.Errorf("alpha %s: failed to unmarshal response: %w", method, err)
+	}
+	return nil
+}
+
+func userMethod(role string) (string, error) {
+	switch role {
+	case types.RoleTeacher, types.RoleSupervisor:
+		return "teacher/index", nil
+	case types.RoleStudent, types.RoleParent:
+		return "customer/index", nil
+	}
+	return "", fmt.Errorf("unsupported role: %s", role)
+}
+
+// lastPage - пустая страница или все записи из total уже получены.
+func lastPage(pageItems, fetched, total int) bool {
+	return pageItems == 0 || (total > 0 && fetched >= total)
+}
@@ -0,0 +1,355 @@
+package alpha
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/prok05/ecom/service/alpha/alphatest"
+	"github.com/prok05/ecom/types"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+const testOffset = 1000
+
+// testTokens - TokenSource для тестов: входит в CRM при первом запросе и после Invalidate.
+type testTokens struct {
+	httpClient *http.Client
+
+	mu            sync.Mutex
+	token         string
+	logins        int
+	invalidations int
+}
+
+func (t *testTokens) GetToken(account Account) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" {
+		return t.token, nil
+	}
+	token, err := Login(context.Background(), t.httpClient, account)
+	if err != nil {
+		return "", err
+	}
+	t.token = token
+	t.logins++
+	return token, nil
+}
+
+func (t *testTokens) Invalidate(account Account, token string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token == token {
+		t.token = ""
+		t.invalidations++
+	}
+}
+
+// newTestClient поднимает fake-сервер с fixtures и клиента школы со смещением testOffset.
+func newTestClient(t *testing.T, handler func(next http.Handler) http.Handler, fixtures *alphatest.Fixtures) (*Client, *alphatest.Server, *testTokens) {
+	t.Helper()
+	fake := alphatest.NewServer(fixtures)
+	var h http.Handler = fake.Handler()
+	if handler != nil {
+		h = handler(h)
+	}
+	srv := newHTTPServer(t, h)
+	account := Account{Key: "test", Host: srv.URL, BranchID: 1, IDOffset: testOffset}
+	tokens := &testTokens{httpClient: srv.Client()}
+	return NewClient(account, tokens, srv.Client()), fake, tokens
+}
+
+func newHTTPServer(t *testing.T, handler http.Handler) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func teachers(n int) []types.GetUserResponseItem {
+	items := make([]types.GetUserResponseItem, n)
+	for i := range items {
+		items[i] = types.GetUserResponseItem{ID: i + 1, Name: fmt.Sprintf("Teacher %d", i+1), Phone: []string{fmt.Sprintf("+7999000000%d", i+1)}}
+	}
+	return items
+}
+
+func TestClientPagination(t *testing.T) {
+	lessons := make([]types.GetLessonsResponseItem, 7)
+	for i := range lessons {
+		lessons[i] = types.GetLessonsResponseItem{ID: 100 + i, Date: "2025-03-01", TeacherIDs: []int{1 + i%2}, CustomerIDs: []int{}}
+	}
+	subjects := make([]types.AlphaSubject, 4)
+	for i := range subjects {
+		subjects[i] = types.AlphaSubject{ID: i + 1, Name: fmt.Sprintf("Subject %d", i+1)}
+	}
+
+	tests := []struct {
+		name     string
+		pageSize int
+	}{
+		{"one item per page", 1},
+		{"partial last page", 2},
+		{"exact pages", 5},
+		{"single page", 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, _, _ := newTestClient(t, nil, &alphatest.Fixtures{
+				PageSize: tt.pageSize,
+				Teachers: teachers(5),
+				Lessons:  lessons,
+				Subjects: subjects,
+			})
+			ctx := context.Background()
+
+			users, err := client.ListUsers(ctx, types.RoleTeacher)
+			if err != nil {
+				t.Fatalf("ListUsers: %v", err)
+			}
+			if len(users) != 5 {
+				t.Fatalf("ListUsers returned %d users, want 5", len(users))
+			}
+			for i, u := range users {
+				if want := testOffset + i + 1; u.ID != want {
+					t.Errorf("user %d has ID %d, want local ID %d", i, u.ID, want)
+				}
+			}
+
+			all, err := client.ListLessons(ctx, types.AlphaLessonQuery{})
+			if err != nil {
+				t.Fatalf("ListLessons: %v", err)
+			}
+			if len(all) != len(lessons) {
+				t.Errorf("ListLessons returned %d lessons, want %d", len(all), len(lessons))
+			}
+			filtered, err := client.ListLessons(ctx, types.AlphaLessonQuery{TeacherID: testOffset + 1})
+			if err != nil {
+				t.Fatalf("ListLessons by teacher: %v", err)
+			}
+			if len(filtered) != 4 {
+				t.Errorf("ListLessons by teacher returned %d lessons, want 4", len(filtered))
+			}
+			for _, l := range filtered {
+				if l.TeacherIDs[0] != testOffset+1 || l.ID < testOffset {
+					t.Errorf("lesson %d is not localized: teachers %v", l.ID, l.TeacherIDs)
+				}
+			}
+
+			got, err := client.ListSubjects(ctx)
+			if err != nil {
+				t.Fatalf("ListSubjects: %v", err)
+			}
+			if len(got) != len(subjects) {
+				t.Errorf("ListSubjects returned %d subjects, want %d", len(got), len(subjects))
+			}
+		})
+	}
+}
+
+func TestLastPage(t *testing.T) {
+	tests := []struct {
+		name                      string
+		pageItems, fetched, total int
+		want                      bool
+	}{
+		{"empty page", 0, 10, 0, true},
+		{"more pages", 2, 2, 5, false},
+		{"all fetched", 1, 5, 5, true},
+		{"no total", 2, 4, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lastPage(tt.pageItems, tt.fetched, tt.total); got != tt.want {
+				t.Errorf("lastPage(%d, %d, %d) = %v, want %v", tt.pageItems, tt.fetched, tt.total, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	client, _, _ := newTestClient(t, nil, &alphatest.Fixtures{
+		Teachers: teachers(1),
+		Lessons:  []types.GetLessonsResponseItem{{ID: 100, Date: "2025-03-01"}},
+	})
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		call     func() error
+		notFound bool
+		status   int
+	}{
+		{
+			name: "unknown user",
+			call: func() error {
+				_, err := client.GetUser(ctx, types.RoleTeacher, testOffset+42)
+				return err
+			},
+			notFound: true,
+		},
+		{
+			name: "unknown phone",
+			call: func() error {
+				_, err := client.FindUserByPhone(ctx, types.RoleStudent, "+70000000000")
+				return err
+			},
+			notFound: true,
+		},
+		{
+			name: "unknown lesson",
+			call: func() error {
+				_, err := client.GetLesson(ctx, testOffset+999)
+				return err
+			},
+			notFound: true,
+		},
+		{
+			name: "update of unknown lesson",
+			call: func() error {
+				return client.UpdateLesson(ctx, testOffset+999, map[string]any{"topic": "x"})
+			},
+			status: http.StatusNotFound,
+		},
+		{
+			name: "invalid update",
+			call: func() error {
+				return client.UpdateLesson(ctx, testOffset+100, map[string]any{"custom_homework_status": "x"})
+			},
+			status: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if got := errors.Is(err, ErrNotFound); got != tt.notFound {
+				t.Errorf("errors.Is(%v, ErrNotFound) = %v, want %v", err, got, tt.notFound)
+			}
+			var apiErr *APIError
+			if tt.status != 0 && (!errors.As(err, &apiErr) || apiErr.StatusCode != tt.status) {
+				t.Errorf("got %v, want APIError with status %d", err, tt.status)
+			}
+			if IsUnauthorized(err) {
+				t.Errorf("%v must not be reported as unauthorized", err)
+			}
+		})
+	}
+
+	if _, err := client.GetUser(ctx, "admin", 1); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("unsupported role: got %v", err)
+	}
+}
+
+func TestClientLocalizesIDs(t *testing.T) {
+	client, fake, _ := newTestClient(t, nil, &alphatest.Fixtures{
+		Customers: []types.GetUserResponseItem{{ID: 7, Name: "Student", Phone: []string{"+79990000007"}, IsStudy: 1}},
+		Lessons:   []types.GetLessonsResponseItem{{ID: 100, Date: "2025-03-01", TeacherIDs: []int{1}, CustomerIDs: []int{7}}},
+	})
+	ctx := context.Background()
+
+	u, err := client.GetUser(ctx, types.RoleStudent, testOffset+7)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if u.ID != testOffset+7 {
+		t.Errorf("GetUser returned ID %d, want %d", u.ID, testOffset+7)
+	}
+
+	lesson, err := client.GetLesson(ctx, testOffset+100)
+	if err != nil {
+		t.Fatalf("GetLesson: %v", err)
+	}
+	if lesson.ID != testOffset+100 || lesson.TeacherIDs[0] != testOffset+1 || lesson.CustomerIDs[0] != testOffset+7 {
+		t.Errorf("lesson is not localized: %+v", lesson)
+	}
+
+	if err := client.UpdateLesson(ctx, testOffset+100, map[string]any{"topic": "Дроби"}); err != nil {
+		t.Fatalf("UpdateLesson: %v", err)
+	}
+	if stored, _ := fake.Lesson(100); stored.Topic != "Дроби" {
+		t.Errorf("UpdateLesson did not reach CRM lesson 100, topic %q", stored.Topic)
+	}
+}
+
+// rejectFirst отвечает status на первые n запросов к API, вход в CRM пропускает.
+func rejectFirst(n, status int, requests *int) func(next http.Handler) http.Handler {
+	var mu sync.Mutex
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/v2api/auth/login" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			mu.Lock()
+			*requests++
+			reject := *requests <= n
+			mu.Unlock()
+			if reject {
+				w.WriteHeader(status)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestClientRetriesOnceWithNewToken(t *testing.T) {
+	tests := []struct {
+		name          string
+		rejections    int
+		status        int
+		wantErrStatus int
+		wantRequests  int
+		wantLogins    int
+	}{
+		{"unauthorized", 1, http.StatusUnauthorized, 0, 2, 2},
+		{"forbidden", 1, http.StatusForbidden, 0, 2, 2},
+		{"rejected twice", 2, http.StatusUnauthorized, http.StatusUnauthorized, 2, 2},
+		{"server error is not retried", 1, http.StatusInternalServerError, http.StatusInternalServerError, 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int
+			client, _, tokens := newTestClient(t, rejectFirst(tt.rejections, tt.status, &requests),
+				&alphatest.Fixtures{Teachers: teachers(1)})
+
+			_, err := client.GetUser(context.Background(), types.RoleTeacher, testOffset+1)
+			var apiErr *APIError
+			switch {
+			case tt.wantErrStatus == 0 && err != nil:
+				t.Fatalf("GetUser: %v", err)
+			case tt.wantErrStatus != 0 && (!errors.As(err, &apiErr) || apiErr.StatusCode != tt.wantErrStatus):
+				t.Fatalf("got %v, want APIError with status %d", err, tt.wantErrStatus)
+			}
+			if requests != tt.wantRequests {
+				t.Errorf("%d requests, want %d", requests, tt.wantRequests)
+			}
+			if tokens.logins != tt.wantLogins {
+				t.Errorf("%d logins, want %d", tokens.logins, tt.wantLogins)
+			}
+		})
+	}
+}
+
+func TestClientRecoversFromExpiredToken(t *testing.T) {
+	client, fake, tokens := newTestClient(t, nil, &alphatest.Fixtures{Teachers: teachers(1)})
+	ctx := context.Background()
+
+	if _, err := client.GetUser(ctx, types.RoleTeacher, testOffset+1); err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	// CRM забыла токен раньше, чем он истек в кэше
+	fake.RevokeTokens()
+	if _, err := client.GetUser(ctx, types.RoleTeacher, testOffset+1); err != nil {
+		t.Fatalf("GetUser after revoke: %v", err)
+	}
+	if tokens.logins != 2 || tokens.invalidations != 1 {
+		t.Errorf("logins %d, invalidations %d, want 2 and 1", tokens.logins, tokens.invalidations)
+	}
+}
@@ -0,0 +1,30 @@
+package alpha
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrNotFound - AlfaCRM ответила успешно, но запись не нашлась.
+var ErrNotFound = errors.New("not found in AlfaCRM")
+
+// APIError - AlfaCRM ответила статусом, отличным от 200.
+type APIError struct {
+	Method     string
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("alpha %s: status %d: %s", e.Method, e.StatusCode, e.Body)
+}
+
+// IsUnauthorized сообщает, что AlfaCRM отклонила токен.
+func IsUnauthorized(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden
+}
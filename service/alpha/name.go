@@ -0,0 +1,18 @@
+package alpha
+
+import "strings"
+
+// SplitName разбирает ФИО из AlfaCRM в порядке "Фамилия Имя Отчество".
+func SplitName(name string) (lastName, firstName, middleName string) {
+	parts := strings.Fields(name)
+	if len(parts) > 0 {
+		lastName = parts[0]
+	}
+	if len(parts) > 1 {
+		firstName = parts[1]
+	}
+	if len(parts) > 2 {
+		middleName = strings.Join(parts[2:], " ")
+	}
+	return lastName, firstName, middleName
+}
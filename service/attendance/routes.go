@@ -0,0 +1,301 @@
+package attendance
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/prok05/ecom/service/alpha"
+	"github.com/prok05/ecom/service/auth"
+	"github.com/prok05/ecom/service/tenant"
+	"github.com/prok05/ecom/types"
+	"github.com/prok05/ecom/utils"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	crmDate     = "2006-01-02"
+	crmDateTime = "2006-01-02 15:04:05"
+)
+
+type Handler struct {
+	store       types.AttendanceStore
+	lessonStore types.LessonStore
+	parentStore types.ParentStore
+	authorizer  *auth.Authorizer
+	crm         alpha.Provider
+	// pushToCRM - отправлять отметки в AlfaCRM
+	pushToCRM bool
+}
+
+func NewHandler(store types.AttendanceStore, lessonStore types.LessonStore, parentStore types.ParentStore, authorizer *auth.Authorizer, crm alpha.Provider, pushToCRM bool) *Handler {
+	return &Handler{
+		store:       store,
+		lessonStore: lessonStore,
+		parentStore: parentStore,
+		authorizer:  authorizer,
+		crm:         crm,
+		pushToCRM:   pushToCRM,
+	}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/lessons/{lessonID}/attendance", h.authorizer.RequirePermissions(h.handleGetLessonAttendance, auth.PermAttendanceMark)).Methods(http.MethodGet)
+	router.HandleFunc("/lessons/{lessonID}/attendance", h.authorizer.RequirePermissions(h.handleMarkAttendance, auth.PermAttendanceMark)).Methods(http.MethodPut)
+
+	router.HandleFunc("/attendance/students/{studentID}", h.authorizer.RequirePermissions(h.handleGetStudentAttendance, auth.PermAttendanceRead)).Methods(http.MethodGet)
+	router.HandleFunc("/attendance/report", h.authorizer.RequirePermissions(h.handleGetReport, auth.PermReportRead)).Methods(http.MethodGet)
+}
+
+// Отметки учеников урока. Преподаватель отмечает только свои уроки, которые уже начались.
+// Отметки учеников, не попавших в запрос, не меняются
+func (h *Handler) handleMarkAttendance(w http.ResponseWriter, r *http.Request) {
+	var payload types.MarkAttendancePayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	lesson, ok := h.lessonFromPath(w, r)
+	if !ok {
+		return
+	}
+	if lesson.Status == 2 {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("lesson is cancelled"))
+		return
+	}
+	start, err := lessonStart(lesson, tenant.Location(tenant.FromContext(r.Context())))
+	if err != nil {
+		log.Printf("invalid date of lesson %d: %v", lesson.ID, err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot verify lesson"))
+		return
+	}
+	if start.After(time.Now()) {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("lesson has not started yet"))
+		return
+	}
+
+	seen := make(map[int]bool, len(payload.Items))
+	for _, m := range payload.Items {
+		if seen[m.StudentID] {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("student %d is marked twice", m.StudentID))
+			return
+		}
+		seen[m.StudentID] = true
+		if !containsID(lesson.CustomerIDs, m.StudentID) {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("student %d is not in the lesson", m.StudentID))
+			return
+		}
+	}
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	date, _ := time.Parse(crmDate, lesson.Date)
+	err = h.store.SaveAttendance(types.AttendanceLesson{
+		TenantID:   principal.TenantID,
+		LessonID:   lesson.ID,
+		LessonDate: date,
+		TeacherIDs: lesson.TeacherIDs,
+	}, principal.UserID, payload.Items, h.pushToCRM)
+	if err != nil {
+		log.Printf("failed to save attendance of lesson %d: %v", lesson.ID, err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot save attendance"))
+		return
+	}
+
+	h.writeLessonAttendance(w, principal.TenantID, lesson.ID)
+}
+
+func (h *Handler) handleGetLessonAttendance(w http.ResponseWriter, r *http.Request) {
+	lesson, ok := h.lessonFromPath(w, r)
+	if !ok {
+		return
+	}
+	h.writeLessonAttendance(w, auth.GetTenantIDFromContext(r.Context()), lesson.ID)
+}
+
+// История посещений ученика и итог за период ?date_from=&date_to= (YYYY-MM-DD).
+// Ученик видит свою историю, родитель - своих детей, преподаватель - только свои уроки
+func (h *Handler) handleGetStudentAttendance(w http.ResponseWriter, r *http.Request) {
+	studentID, err := strconv.Atoi(mux.Vars(r)["studentID"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid student ID"))
+		return
+	}
+	filter, err := parseFilter(r.URL.Query())
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	filter.TenantID = principal.TenantID
+	filter.StudentID = studentID
+	switch principal.Role {
+	case types.RoleStudent:
+		if studentID != principal.UserID {
+			utils.WriteError(w, http.StatusForbidden, fmt.Errorf("permission denied"))
+			return
+		}
+	case types.RoleParent:
+		linked, err := h.parentStore.IsParentOf(principal.UserID, studentID)
+		if err != nil {
+			log.Printf("failed to check parent %d of student %d: %v", principal.UserID, studentID, err)
+			utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get attendance"))
+			return
+		}
+		if !linked {
+			utils.WriteError(w, http.StatusForbidden, fmt.Errorf("permission denied"))
+			return
+		}
+	case types.RoleTeacher:
+		filter.TeacherID = principal.UserID
+	}
+
+	records, err := h.store.GetAttendance(filter)
+	if err != nil {
+		log.Printf("failed to get attendance of student %d: %v", studentID, err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get attendance"))
+		return
+	}
+
+	history := types.AttendanceHistory{
+		Summary: types.AttendanceSummary{StudentID: studentID, Total: len(records)},
+		Items:   records,
+	}
+	for _, rec := range records {
+		history.Summary.FirstName, history.Summary.LastName = rec.FirstName, rec.LastName
+		switch rec.Status {
+		case types.AttendancePresent:
+			history.Summary.Present++
+		case types.AttendanceLate:
+			history.Summary.Late++
+		case types.AttendanceAbsent:
+			history.Summary.Absent++
+		}
+	}
+	history.Summary.Rate = Rate(history.Summary)
+
+	utils.WriteJSON(w, http.StatusOK, history)
+}
+
+// Посещаемость учеников школы за период, начиная с самой низкой.
+// ?date_from=&date_to= (YYYY-MM-DD), ?teacher_id= - только уроки преподавателя
+func (h *Handler) handleGetReport(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r.URL.Query())
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	filter.TenantID = auth.GetTenantIDFromContext(r.Context())
+	if v := r.URL.Query().Get("teacher_id"); v != "" {
+		filter.TeacherID, err = strconv.Atoi(v)
+		if err != nil || filter.TeacherID <= 0 {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid teacher_id"))
+			return
+		}
+	}
+
+	summaries, err := h.store.GetAttendanceSummary(filter)
+	if err != nil {
+		log.Printf("failed to get attendance report: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get attendance report"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, summaries)
+}
+
+func (h *Handler) writeLessonAttendance(w http.ResponseWriter, tenantID, lessonID int) {
+	records, err := h.store.GetLessonAttendance(tenantID, lessonID)
+	if err != nil {
+		log.Printf("failed to get attendance of lesson %d: %v", lessonID, err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get attendance"))
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, records)
+}
+
+// lessonFromPath находит урок из пути в локальной копии, затем в AlfaCRM, и проверяет,
+// что преподаватель его ведет. При ошибке сам пишет ответ и возвращает false.
+func (h *Handler) lessonFromPath(w http.ResponseWriter, r *http.Request) (*types.GetLessonsResponseItem, bool) {
+	lessonID, err := strconv.Atoi(mux.Vars(r)["lessonID"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid lesson ID"))
+		return nil, false
+	}
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	lesson, err := h.lessonStore.GetLesson(principal.TenantID, lessonID)
+	if err != nil {
+		log.Printf("failed to get lesson %d: %v", lessonID, err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot verify lesson"))
+		return nil, false
+	}
+	if lesson == nil {
+		// урока еще нет в копии, например он создан после последней синхронизации
+		lesson, err = h.crm.For(tenant.Account(r.Context())).GetLesson(r.Context(), lessonID)
+	}
+	if errors.Is(err, alpha.ErrNotFound) {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("lesson not found"))
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("failed to get lesson %d: %v", lessonID, err)
+		utils.WriteError(w, alpha.HTTPStatus(err), fmt.Errorf("cannot verify lesson"))
+		return nil, false
+	}
+
+	if principal.Role == types.RoleTeacher && !containsID(lesson.TeacherIDs, principal.UserID) {
+		utils.WriteError(w, http.StatusForbidden, fmt.Errorf("permission denied"))
+		return nil, false
+	}
+	return lesson, true
+}
+
+// lessonStart - начало урока, без времени - начало дня урока.
+func lessonStart(lesson *types.GetLessonsResponseItem, loc *time.Location) (time.Time, error) {
+	if lesson.TimeFrom != "" {
+		if start, err := time.ParseInLocation(crmDateTime, lesson.TimeFrom, loc); err == nil {
+			return start, nil
+		}
+	}
+	return time.ParseInLocation(crmDate, lesson.Date, loc)
+}
+
+func parseFilter(values url.Values) (types.AttendanceFilter, error) {
+	var filter types.AttendanceFilter
+	for name, dest := range map[string]**time.Time{
+		"date_from": &filter.DateFrom,
+		"date_to":   &filter.DateTo,
+	} {
+		if v := values.Get(name); v != "" {
+			date, err := time.Parse(crmDate, v)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s, expected YYYY-MM-DD", name)
+			}
+			*dest = &date
+		}
+	}
+	if filter.DateFrom != nil && filter.DateTo != nil && filter.DateTo.Before(*filter.DateFrom) {
+		return filter, fmt.Errorf("date_to is before date_from")
+	}
+	return filter, nil
+}
+
+func containsID(ids []int, id int) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package attendance
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prok05/ecom/service/outbox"
+	"github.com/prok05/ecom/types"
+	"log"
+	"math"
+	"time"
+)
+
+// имя ученика берется из users, незарегистрированный ученик остается без имени
+const recordColumns = `a.lesson_id, a.lesson_date, a.student_id, COALESCE(u.first_name, ''), COALESCE(u.last_name, ''),
+	a.status, a.note, a.marked_by, a.marked_at`
+
+// filterCondition - условие по types.AttendanceFilter, параметры $1..$5
+const filterCondition = `a.tenant_id = $1
+	AND ($2 = 0 OR a.student_id = $2)
+	AND ($3 = 0 OR $3 = ANY(a.teacher_ids))
+	AND ($4::date IS NULL OR a.lesson_date >= $4)
+	AND ($5::date IS NULL OR a.lesson_date <= $5)`
+
+type Store struct {
+	dbpool *pgxpool.Pool
+}
+
+func NewStore(dbpool *pgxpool.Pool) *Store {
+	return &Store{
+		dbpool: dbpool,
+	}
+}
+
+func (s *Store) SaveAttendance(lesson types.AttendanceLesson, markedBy int, marks []types.AttendanceMark, push bool) error {
+	ctx := context.Background()
+	tx, err := s.dbpool.Begin(ctx)
+	if err != nil {
+		log.Println("Failed to start transaction: ", err)
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for _, m := range marks {
+		_, err = tx.Exec(ctx,
+			`INSERT INTO attendance (lesson_id, student_id, tenant_id, lesson_date, teacher_ids, status, note, marked_by)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			 ON CONFLICT (lesson_id, student_id) DO UPDATE
+			 SET lesson_date = EXCLUDED.lesson_date, teacher_ids = EXCLUDED.teacher_ids, status = EXCLUDED.status,
+			     note = EXCLUDED.note, marked_by = EXCLUDED.marked_by, marked_at = NOW()`,
+			lesson.LessonID, m.StudentID, lesson.TenantID, lesson.LessonDate.Format("2006-01-02"), lesson.TeacherIDs,
+			m.Status, m.Note, markedBy)
+		if err != nil {
+			log.Println("Failed to save attendance:", err)
+			return err
+		}
+	}
+
+	if push {
+		if err = outbox.EnqueueAttendance(ctx, tx, lesson.TenantID, lesson.LessonID); err != nil {
+			log.Println("Failed to enqueue attendance:", err)
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (s *Store) GetLessonAttendance(tenantID, lessonID int) ([]types.AttendanceRecord, error) {
+	rows, err := s.dbpool.Query(context.Background(),
+		`SELECT `+recordColumns+`
+		 FROM attendance a
+		 LEFT JOIN users u ON u.id = a.student_id
+		 WHERE a.tenant_id = $1 AND a.lesson_id = $2
+		 ORDER BY u.last_name, u.first_name, a.student_id`, tenantID, lessonID)
+	if err != nil {
+		return nil, err
+	}
+	return scanRecords(rows)
+}
+
+func (s *Store) GetAttendance(filter types.AttendanceFilter) ([]types.AttendanceRecord, error) {
+	rows, err := s.dbpool.Query(context.Background(),
+		`SELECT `+recordColumns+`
+		 FROM attendance a
+		 LEFT JOIN users u ON u.id = a.student_id
+		 WHERE `+filterCondition+`
+		 ORDER BY a.lesson_date DESC, a.lesson_id DESC, a.student_id`,
+		filterArgs(filter)...)
+	if err != nil {
+		return nil, err
+	}
+	return scanRecords(rows)
+}
+
+func (s *Store) GetAttendanceSummary(filter types.AttendanceFilter) ([]types.AttendanceSummary, error) {
+	rows, err := s.dbpool.Query(context.Background(),
+		`SELECT a.student_id, COALESCE(MAX(u.first_name), ''), COALESCE(MAX(u.last_name), ''),
+		        COUNT(*),
+		        COUNT(*) FILTER (WHERE a.status = 'present'),
+		        COUNT(*) FILTER (WHERE a.status = 'late'),
+		        COUNT(*) FILTER (WHERE a.status = 'absent')
+		 FROM attendance a
+		 LEFT JOIN users u ON u.id = a.student_id
+		 WHERE `+filterCondition+`
+		 GROUP BY a.student_id
+		 ORDER BY COUNT(*) FILTER (WHERE a.status <> 'absent')::float8 / COUNT(*), a.student_id`,
+		filterArgs(filter)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := make([]types.AttendanceSummary, 0)
+	for rows.Next() {
+		var sm types.AttendanceSummary
+		err := rows.Scan(&sm.StudentID, &sm.FirstName, &sm.LastName, &sm.Total, &sm.Present, &sm.Late, &sm.Absent)
+		if err != nil {
+			return nil, err
+		}
+		sm.Rate = Rate(sm)
+		summaries = append(summaries, sm)
+	}
+	return summaries, rows.Err()
+}
+
+func (s *Store) GetStudentAttendance(tenantID, lessonID, studentID int) (string, error) {
+	var status string
+	err := s.dbpool.QueryRow(context.Background(),
+		`SELECT status FROM attendance WHERE tenant_id = $1 AND lesson_id = $2 AND student_id = $3`,
+		tenantID, lessonID, studentID).Scan(&status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	return status, err
+}
+
+// Rate - доля посещенных уроков с точностью до сотых, без отметок - 0.
+func Rate(sm types.AttendanceSummary) float64 {
+	if sm.Total == 0 {
+		return 0
+	}
+	return math.Round(float64(sm.Present+sm.Late)/float64(sm.Total)*100) / 100
+}
+
+func filterArgs(filter types.AttendanceFilter) []any {
+	return []any{filter.TenantID, filter.StudentID, filter.TeacherID, filter.DateFrom, filter.DateTo}
+}
+
+func scanRecords(rows pgx.Rows) ([]types.AttendanceRecord, error) {
+	defer rows.Close()
+
+	records := make([]types.AttendanceRecord, 0)
+	for rows.Next() {
+		var r types.AttendanceRecord
+		var lessonDate time.Time
+		err := rows.Scan(&r.LessonID, &lessonDate, &r.StudentID, &r.FirstName, &r.LastName,
+			&r.Status, &r.Note, &r.MarkedBy, &r.MarkedAt)
+		if err != nil {
+			return nil, err
+		}
+		r.LessonDate = lessonDate.Format("2006-01-02")
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
@@ -1,24 +1,23 @@
 package auth
 
 import (
-	"context"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/prok05/ecom/config"
-	"github.com/prok05/ecom/types"
-	"github.com/prok05/ecom/utils"
 	"log"
 	"net/http"
 	"strconv"
 	"time"
 )
 
-func CreateJWT(secret []byte, userID int, role string) (string, error) {
+// CreateJWT выпускает короткоживущий access-токен, привязанный к сессии sessionID.
+func CreateJWT(secret []byte, userID int, role string, sessionID int) (string, error) {
 	expiration := time.Second * time.Duration(config.Envs.JWTExpirationInSeconds)
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"userID":    strconv.Itoa(userID),
-		"expiredAt": time.Now().Add(expiration).Unix(),
+		"sessionID": strconv.Itoa(sessionID),
+		"exp":       time.Now().Add(expiration).Unix(),
 		"role":      role,
 	})
 
@@ -30,46 +29,8 @@ func CreateJWT(secret []byte, userID int, role string) (string, error) {
 	return tokenString, nil
 }
 
-func WithJWTAuth(handlerFunc http.HandlerFunc, store types.UserStore) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		tokenString := GetTokenFromRequest(r)
-
-		token, err := ValidateToken(tokenString)
-		if err != nil {
-			log.Printf("failed to validate token: %v", err)
-			permissionDenied(w)
-			return
-		}
-
-		if !token.Valid {
-			log.Println("invalid token")
-			permissionDenied(w)
-			return
-		}
-
-		claims := token.Claims.(jwt.MapClaims)
-		str := claims["userID"].(string)
-
-		userID, _ := strconv.Atoi(str)
-
-		u, err := store.FindUserByID(userID)
-		if err != nil {
-			log.Printf("failed to get user by id: %v", err)
-			permissionDenied(w)
-			return
-		}
-
-		ctx := r.Context()
-		ctx = context.WithValue(ctx, "userID", u.ID)
-		ctx = context.WithValue(ctx, "role", u.Role)
-		r = r.WithContext(ctx)
-
-		handlerFunc(w, r)
-	}
-}
-
 func GetTokenFromRequest(r *http.Request) string {
-	cookie, err := r.Cookie("token")
+	cookie, err := r.Cookie(AccessTokenCookie)
 	if err != nil {
 		log.Println(err)
 		return ""
@@ -86,16 +47,25 @@ func ValidateToken(t string) (*jwt.Token, error) {
 	})
 }
 
-func GetUserIDFromContext(ctx context.Context) int {
-	userID := ctx.Value("userID").(int)
-	return userID
-}
+// GetIDsFromClaims достает ID пользователя и ID сессии из claims access-токена.
+func GetIDsFromClaims(claims jwt.MapClaims) (int, int, error) {
+	userIDStr, ok := claims["userID"].(string)
+	if !ok {
+		return 0, 0, fmt.Errorf("missing userID claim")
+	}
+	userID, err := strconv.Atoi(userIDStr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid userID claim: %v", err)
+	}
 
-func GetUserRoleFromContext(ctx context.Context) string {
-	role := ctx.Value("role").(string)
-	return role
-}
+	sessionIDStr, ok := claims["sessionID"].(string)
+	if !ok {
+		return 0, 0, fmt.Errorf("missing sessionID claim")
+	}
+	sessionID, err := strconv.Atoi(sessionIDStr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid sessionID claim: %v", err)
+	}
 
-func permissionDenied(w http.ResponseWriter) {
-	utils.WriteError(w, http.StatusForbidden, fmt.Errorf("permission denied"))
+	return userID, sessionID, nil
 }
@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prok05/ecom/service/tenant"
+	"github.com/prok05/ecom/types"
+	"github.com/prok05/ecom/utils"
+	"log"
+	"net/http"
+)
+
+type contextKey string
+
+const principalKey contextKey = "principal"
+
+// Principal - аутентифицированный пользователь текущего запроса.
+type Principal struct {
+	UserID    int
+	TenantID  int
+	Role      string
+	SessionID int
+	User      *types.UserDTO
+}
+
+func (p *Principal) HasRole(roles ...string) bool {
+	for _, role := range roles {
+		if p.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Principal) Can(permission Permission) bool {
+	return HasPermission(p.Role, permission)
+}
+
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey).(*Principal)
+	return p, ok
+}
+
+func GetUserIDFromContext(ctx context.Context) int {
+	p, _ := PrincipalFromContext(ctx)
+	return p.UserID
+}
+
+func GetUserRoleFromContext(ctx context.Context) string {
+	p, _ := PrincipalFromContext(ctx)
+	return p.Role
+}
+
+func GetTenantIDFromContext(ctx context.Context) int {
+	p, _ := PrincipalFromContext(ctx)
+	return p.TenantID
+}
+
+func GetSessionIDFromContext(ctx context.Context) int {
+	p, _ := PrincipalFromContext(ctx)
+	return p.SessionID
+}
+
+// Authorizer проверяет access-токен и сессию, один раз загружает пользователя
+// и пропускает запрос дальше, только если у пользователя есть нужные роли или права.
+type Authorizer struct {
+	userStore    types.UserStore
+	sessionStore types.SessionStore
+}
+
+func NewAuthorizer(userStore types.UserStore, sessionStore types.SessionStore) *Authorizer {
+	return &Authorizer{
+		userStore:    userStore,
+		sessionStore: sessionStore,
+	}
+}
+
+// Authenticated пропускает любого пользователя с действующей сессией.
+func (a *Authorizer) Authenticated(handlerFunc http.HandlerFunc) http.HandlerFunc {
+	return a.with(handlerFunc, func(p *Principal) bool { return true })
+}
+
+// RequireRoles пропускает пользователя, у которого есть одна из ролей.
+func (a *Authorizer) RequireRoles(handlerFunc http.HandlerFunc, roles ...string) http.HandlerFunc {
+	return a.with(handlerFunc, func(p *Principal) bool { return p.HasRole(roles...) })
+}
+
+// RequirePermissions пропускает пользователя, у которого есть все перечисленные права.
+func (a *Authorizer) RequirePermissions(handlerFunc http.HandlerFunc, permissions ...Permission) http.HandlerFunc {
+	return a.with(handlerFunc, func(p *Principal) bool {
+		for _, permission := range permissions {
+			if !p.Can(permission) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func (a *Authorizer) with(handlerFunc http.HandlerFunc, allowed func(p *Principal) bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, err := a.authenticate(r)
+		if err != nil {
+			log.Printf("authentication failed: %v", err)
+			utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("unauthorized"))
+			return
+		}
+
+		if !allowed(principal) {
+			log.Printf("user %d with role %s is not allowed to %s %s",
+				principal.UserID, principal.Role, r.Method, r.URL.Path)
+			permissionDenied(w)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), principalKey, principal)
+		handlerFunc(w, r.WithContext(ctx))
+	}
+}
+
+func (a *Authorizer) authenticate(r *http.Request) (*Principal, error) {
+	tokenString := GetTokenFromRequest(r)
+	if tokenString == "" {
+		return nil, fmt.Errorf("missing token")
+	}
+
+	token, err := ValidateToken(tokenString)
+	if err != nil {
+		return nil, fmt.Errorf("failed to validate token: %v", err)
+	}
+	if !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("invalid token claims")
+	}
+
+	userID, sessionID, err := GetIDsFromClaims(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	active, err := a.sessionStore.IsSessionActive(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check session %d: %v", sessionID, err)
+	}
+	if !active {
+		return nil, fmt.Errorf("session %d is revoked or expired", sessionID)
+	}
+
+	u, err := a.userStore.FindUserByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user by id: %v", err)
+	}
+	if !u.IsActive {
+		return nil, fmt.Errorf("user %d is deactivated", u.ID)
+	}
+	// токен действует только в своей школе
+	if t := tenant.FromContext(r.Context()); t != nil && t.ID != u.TenantID {
+		return nil, fmt.Errorf("user %d does not belong to tenant %s", u.ID, t.Slug)
+	}
+
+	return &Principal{
+		UserID:    u.ID,
+		TenantID:  u.TenantID,
+		Role:      u.Role,
+		SessionID: sessionID,
+		User:      u,
+	}, nil
+}
+
+func permissionDenied(w http.ResponseWriter) {
+	utils.WriteError(w, http.StatusForbidden, fmt.Errorf("permission denied"))
+}
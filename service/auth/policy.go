@@ -0,0 +1,118 @@
+package auth
+
+import "github.com/prok05/ecom/types"
+
+type Permission string
+
+const (
+	PermChatRead   Permission = "chat:read"
+	PermChatWrite  Permission = "chat:write"
+	PermChatManage Permission = "chat:manage"
+
+	PermHomeworkRead   Permission = "homework:read"
+	PermHomeworkAssign Permission = "homework:assign"
+	PermHomeworkReview Permission = "homework:review"
+	PermHomeworkSubmit Permission = "homework:submit"
+
+	PermLessonRead        Permission = "lesson:read"
+	PermLessonRate        Permission = "lesson:rate"
+	PermLessonRatingsRead Permission = "lesson:ratings:read"
+	PermReportRead        Permission = "report:read"
+	PermLessonSync        Permission = "lesson:sync"
+
+	PermUserRead      Permission = "user:read"
+	PermUserManage    Permission = "user:manage"
+	PermSessionManage Permission = "session:manage"
+	PermLoginManage   Permission = "login:manage"
+	PermOutboxManage  Permission = "outbox:manage"
+
+	PermChildRead    Permission = "child:read"
+	PermParentManage Permission = "parent:manage"
+
+	PermAccountRead      Permission = "account:read"
+	PermNotificationRead Permission = "notification:read"
+	PermStatusRead       Permission = "status:read"
+	PermCalendarRead     Permission = "calendar:read"
+
+	PermAttendanceRead Permission = "attendance:read"
+	PermAttendanceMark Permission = "attendance:mark"
+
+	PermMaterialRead   Permission = "material:read"
+	PermMaterialManage Permission = "material:manage"
+)
+
+// rolePermissions описывает, что разрешено каждой роли.
+// Новая роль добавляется сюда, маршруты менять не нужно.
+var rolePermissions = map[string][]Permission{
+	types.RoleTeacher: {
+		PermChatRead,
+		PermChatWrite,
+		PermHomeworkRead,
+		PermHomeworkAssign,
+		PermHomeworkReview,
+		PermLessonRead,
+		PermUserRead,
+		PermNotificationRead,
+		PermStatusRead,
+		PermCalendarRead,
+		PermAttendanceRead,
+		PermAttendanceMark,
+		PermMaterialRead,
+		PermMaterialManage,
+	},
+	types.RoleStudent: {
+		PermChatRead,
+		PermChatWrite,
+		PermHomeworkRead,
+		PermHomeworkSubmit,
+		PermLessonRead,
+		PermLessonRate,
+		PermUserRead,
+		PermAccountRead,
+		PermNotificationRead,
+		PermStatusRead,
+		PermCalendarRead,
+		PermAttendanceRead,
+		PermMaterialRead,
+	},
+	types.RoleSupervisor: {
+		PermChatRead,
+		PermChatManage,
+		PermHomeworkRead,
+		PermHomeworkReview,
+		PermLessonRead,
+		PermLessonRatingsRead,
+		PermReportRead,
+		PermLessonSync,
+		PermUserRead,
+		PermUserManage,
+		PermSessionManage,
+		PermLoginManage,
+		PermOutboxManage,
+		PermParentManage,
+		PermNotificationRead,
+		PermStatusRead,
+		PermCalendarRead,
+		PermAttendanceRead,
+		PermAttendanceMark,
+		PermMaterialRead,
+		PermMaterialManage,
+	},
+	types.RoleParent: {
+		PermChildRead,
+		PermAccountRead,
+		PermNotificationRead,
+		PermStatusRead,
+		PermCalendarRead,
+		PermAttendanceRead,
+	},
+}
+
+func HasPermission(role string, permission Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/prok05/ecom/config"
+	"net/http"
+	"time"
+)
+
+const (
+	AccessTokenCookie  = "token"
+	RefreshTokenCookie = "refresh_token"
+)
+
+// NewRefreshToken генерирует случайный refresh-токен и его хэш.
+// В базе хранится только хэш, сам токен уходит клиенту в cookie.
+func NewRefreshToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, HashRefreshToken(token), nil
+}
+
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func RefreshTokenExpiration() time.Time {
+	return time.Now().Add(time.Second * time.Duration(config.Envs.JWTRefreshExpirationInSeconds))
+}
+
+func SetTokenCookies(w http.ResponseWriter, accessToken, refreshToken string, refreshExpiresAt time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     AccessTokenCookie,
+		Value:    accessToken,
+		Expires:  time.Now().Add(time.Second * time.Duration(config.Envs.JWTExpirationInSeconds)),
+		HttpOnly: true,
+		Secure:   false,
+		Path:     "/",
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     RefreshTokenCookie,
+		Value:    refreshToken,
+		Expires:  refreshExpiresAt,
+		HttpOnly: true,
+		Secure:   false,
+		Path:     "/",
+	})
+}
+
+func ClearTokenCookies(w http.ResponseWriter) {
+	for _, name := range []string{AccessTokenCookie, RefreshTokenCookie} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			Expires:  time.Now().Add(-1),
+			HttpOnly: true,
+			Secure:   false,
+			Path:     "/",
+		})
+	}
+}
+
+func GetRefreshTokenFromRequest(r *http.Request) string {
+	cookie, err := r.Cookie(RefreshTokenCookie)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
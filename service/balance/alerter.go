@@ -0,0 +1,100 @@
+package balance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/prok05/ecom/service/notification"
+	"github.com/prok05/ecom/service/tenant"
+	"github.com/prok05/ecom/types"
+	"log"
+	"sync"
+	"time"
+)
+
+var ErrCheckInProgress = errors.New("balance check is already running")
+
+// Alerter предупреждает ученика и его родителей, когда оплаченных занятий остается
+// меньше threshold. Повторное уведомление приходит только после пополнения и нового снижения.
+// Остатки берутся из профилей, которые обновляет crmsync.
+type Alerter struct {
+	store     types.BalanceStore
+	tenants   *tenant.Resolver
+	notifier  *notification.Notifier
+	threshold int
+	mu        sync.Mutex
+}
+
+func NewAlerter(store types.BalanceStore, tenants *tenant.Resolver, notifier *notification.Notifier, threshold int) *Alerter {
+	return &Alerter{
+		store:     store,
+		tenants:   tenants,
+		notifier:  notifier,
+		threshold: threshold,
+	}
+}
+
+// Schedule проверяет остатки всех школ сразу и затем каждые interval. Блокирует вызывающего.
+func (a *Alerter) Schedule(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		tenants, err := a.tenants.Tenants()
+		if err != nil {
+			log.Printf("balance check failed: %v", err)
+		}
+		for i := range tenants {
+			if _, err := a.Run(context.Background(), &tenants[i]); err != nil {
+				log.Printf("balance check of tenant %s failed: %v", tenants[i].Slug, err)
+			}
+		}
+		<-ticker.C
+	}
+}
+
+// Run отправляет предупреждения ученикам школы и возвращает их число.
+func (a *Alerter) Run(ctx context.Context, t *types.Tenant) (int, error) {
+	if !a.mu.TryLock() {
+		return 0, ErrCheckInProgress
+	}
+	defer a.mu.Unlock()
+
+	if _, err := a.store.ClearBalanceAlerts(t.ID, a.threshold); err != nil {
+		return 0, err
+	}
+
+	alerts, err := a.store.GetPendingBalanceAlerts(t.ID, a.threshold)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, alert := range alerts {
+		if err := ctx.Err(); err != nil {
+			return sent, err
+		}
+		recipients := append([]int{alert.StudentID}, alert.ParentIDs...)
+		err := a.notifier.Notify(t.ID, recipients, types.Notification{
+			Type:  types.NotificationBalanceLow,
+			Title: "Заканчиваются оплаченные занятия",
+			Body: fmt.Sprintf("%s %s: осталось оплаченных занятий - %d",
+				alert.FirstName, alert.LastName, alert.PaidLessonCount),
+			Data: map[string]any{
+				"student_id":        alert.StudentID,
+				"paid_lesson_count": alert.PaidLessonCount,
+			},
+		})
+		if err != nil {
+			return sent, fmt.Errorf("student %d: %w", alert.StudentID, err)
+		}
+		if err := a.store.SaveBalanceAlert(alert); err != nil {
+			return sent, fmt.Errorf("student %d: %w", alert.StudentID, err)
+		}
+		sent++
+	}
+
+	if sent > 0 {
+		log.Printf("balance check of tenant %s: %d students notified", t.Slug, sent)
+	}
+	return sent, nil
+}
@@ -0,0 +1,118 @@
+package balance
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/prok05/ecom/service/auth"
+	"github.com/prok05/ecom/types"
+	"github.com/prok05/ecom/utils"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// plannedDays - за сколько дней вперед считаются запланированные уроки в сводке
+const plannedDays = 30
+
+type Handler struct {
+	store       types.BalanceStore
+	parentStore types.ParentStore
+	authorizer  *auth.Authorizer
+	threshold   int
+}
+
+func NewHandler(store types.BalanceStore, parentStore types.ParentStore, authorizer *auth.Authorizer, threshold int) *Handler {
+	return &Handler{
+		store:       store,
+		parentStore: parentStore,
+		authorizer:  authorizer,
+		threshold:   threshold,
+	}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/account/summary", h.authorizer.RequirePermissions(h.handleGetSummary, auth.PermAccountRead)).Methods(http.MethodGet)
+	router.HandleFunc("/account/report/balances", h.authorizer.RequirePermissions(h.handleGetLowBalances, auth.PermReportRead)).Methods(http.MethodGet)
+}
+
+// Остаток ученика, для родителя - остатки всех его детей
+func (h *Handler) handleGetSummary(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	studentIDs := []int{principal.UserID}
+	if principal.Role == types.RoleParent {
+		children, err := h.parentStore.GetChildren(principal.UserID)
+		if err != nil {
+			log.Printf("failed to get children of parent %d: %v", principal.UserID, err)
+			utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get children"))
+			return
+		}
+		studentIDs = make([]int, len(children))
+		for i, c := range children {
+			studentIDs[i] = c.StudentID
+		}
+	}
+
+	until := time.Now().AddDate(0, 0, plannedDays)
+	students, err := h.store.GetBalances(studentIDs, until)
+	if err != nil {
+		log.Printf("failed to get balances for user %d: %v", principal.UserID, err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get account summary"))
+		return
+	}
+	for i := range students {
+		students[i].LowBalance = h.low(students[i])
+	}
+
+	utils.WriteJSON(w, http.StatusOK, types.AccountSummary{
+		Students:     students,
+		PlannedUntil: until.Format("2006-01-02"),
+		Threshold:    h.threshold,
+	})
+}
+
+// Ученики с отрицательным или малым балансом или с заканчивающимися занятиями.
+// ?max_balance= (по умолчанию 0), ?paid_lessons_below= (по умолчанию порог уведомлений),
+// ?include_inactive=true - вместе с неактивными
+func (h *Handler) handleGetLowBalances(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := types.LowBalanceFilter{
+		TenantID:         auth.GetTenantIDFromContext(r.Context()),
+		PaidLessonsBelow: h.threshold,
+		IncludeInactive:  query.Get("include_inactive") == "true",
+	}
+	if v := query.Get("max_balance"); v != "" {
+		balance, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid max_balance"))
+			return
+		}
+		filter.MaxBalance = balance
+	}
+	if v := query.Get("paid_lessons_below"); v != "" {
+		count, err := strconv.Atoi(v)
+		if err != nil || count < 0 {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid paid_lessons_below"))
+			return
+		}
+		filter.PaidLessonsBelow = count
+	}
+
+	students, err := h.store.GetLowBalances(filter)
+	if err != nil {
+		log.Printf("failed to get low balances: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get balances"))
+		return
+	}
+	for i := range students {
+		students[i].LowBalance = h.low(students[i])
+	}
+
+	utils.WriteJSON(w, http.StatusOK, students)
+}
+
+// low - баланс отрицательный или оплаченных занятий меньше порога
+func (h *Handler) low(b types.StudentBalance) bool {
+	return (b.Balance != nil && *b.Balance < 0) || (b.PaidLessonCount != nil && *b.PaidLessonCount < h.threshold)
+}
@@ -0,0 +1,123 @@
+package balance
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prok05/ecom/types"
+	"time"
+)
+
+// balanceColumns - колонки users для StudentBalance без числа запланированных уроков
+const balanceColumns = `u.id, u.first_name, u.last_name, u.middle_name, u.phone, u.is_active,
+	u.balance::float8, u.paid_lesson_count, u.crm_synced_at`
+
+type Store struct {
+	dbpool *pgxpool.Pool
+}
+
+func NewStore(dbpool *pgxpool.Pool) *Store {
+	return &Store{
+		dbpool: dbpool,
+	}
+}
+
+// GetBalances берет запланированные уроки из локальной копии расписания.
+func (s *Store) GetBalances(studentIDs []int, lessonsUntil time.Time) ([]types.StudentBalance, error) {
+	rows, err := s.dbpool.Query(context.Background(),
+		`SELECT `+balanceColumns+`,
+		        (SELECT COUNT(*) FROM lesson_customers lc
+		         JOIN lessons l ON l.id = lc.lesson_id
+		         WHERE lc.customer_id = u.id AND l.status = 1
+		           AND l.date BETWEEN CURRENT_DATE AND $2)
+		 FROM users u
+		 WHERE u.id = ANY($1) AND u.user_role = 'student'
+		 ORDER BY u.last_name, u.first_name`, studentIDs, lessonsUntil.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	return scanBalances(rows, true)
+}
+
+func (s *Store) GetLowBalances(filter types.LowBalanceFilter) ([]types.StudentBalance, error) {
+	rows, err := s.dbpool.Query(context.Background(),
+		`SELECT `+balanceColumns+`
+		 FROM users u
+		 WHERE u.tenant_id = $1 AND u.user_role = 'student'
+		   AND (u.balance <= $2 OR u.paid_lesson_count < $3)
+		   AND ($4 OR u.is_active)
+		 ORDER BY u.balance NULLS LAST, u.paid_lesson_count NULLS LAST, u.last_name`,
+		filter.TenantID, filter.MaxBalance, filter.PaidLessonsBelow, filter.IncludeInactive)
+	if err != nil {
+		return nil, err
+	}
+	return scanBalances(rows, false)
+}
+
+func (s *Store) GetPendingBalanceAlerts(tenantID, threshold int) ([]types.BalanceAlert, error) {
+	rows, err := s.dbpool.Query(context.Background(),
+		`SELECT u.tenant_id, u.id, u.first_name, u.last_name, u.paid_lesson_count,
+		        ARRAY(SELECT ps.parent_id FROM parent_students ps
+		              JOIN users p ON p.id = ps.parent_id
+		              WHERE ps.student_id = u.id AND p.is_active ORDER BY ps.parent_id)
+		 FROM users u
+		 WHERE u.tenant_id = $1 AND u.user_role = 'student' AND u.is_active
+		   AND u.paid_lesson_count < $2
+		   AND NOT EXISTS (SELECT 1 FROM balance_alerts a WHERE a.student_id = u.id)
+		 ORDER BY u.id`, tenantID, threshold)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	alerts := make([]types.BalanceAlert, 0)
+	for rows.Next() {
+		var a types.BalanceAlert
+		if err := rows.Scan(&a.TenantID, &a.StudentID, &a.FirstName, &a.LastName, &a.PaidLessonCount, &a.ParentIDs); err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts, rows.Err()
+}
+
+func (s *Store) SaveBalanceAlert(alert types.BalanceAlert) error {
+	_, err := s.dbpool.Exec(context.Background(),
+		`INSERT INTO balance_alerts (student_id, tenant_id, paid_lesson_count)
+		 VALUES ($1, $2, $3)
+		 ON CONFLICT (student_id) DO UPDATE
+		 SET paid_lesson_count = EXCLUDED.paid_lesson_count, notified_at = NOW()`,
+		alert.StudentID, alert.TenantID, alert.PaidLessonCount)
+	return err
+}
+
+func (s *Store) ClearBalanceAlerts(tenantID, threshold int) (int, error) {
+	tag, err := s.dbpool.Exec(context.Background(),
+		`DELETE FROM balance_alerts a
+		 USING users u
+		 WHERE a.tenant_id = $1 AND u.id = a.student_id
+		   AND (u.paid_lesson_count IS NULL OR u.paid_lesson_count >= $2)`, tenantID, threshold)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+func scanBalances(rows pgx.Rows, withLessons bool) ([]types.StudentBalance, error) {
+	defer rows.Close()
+
+	balances := make([]types.StudentBalance, 0)
+	for rows.Next() {
+		var b types.StudentBalance
+		dest := []any{&b.StudentID, &b.FirstName, &b.LastName, &b.MiddleName, &b.Phone, &b.IsActive,
+			&b.Balance, &b.PaidLessonCount, &b.SyncedAt}
+		if withLessons {
+			dest = append(dest, &b.PlannedLessons)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		balances = append(balances, b)
+	}
+	return balances, rows.Err()
+}
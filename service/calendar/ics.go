@@ -0,0 +1,125 @@
+package calendar
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	icsDateTime = "20060102T150405Z"
+	icsDate     = "20060102"
+	// maxLineOctets - длина строки iCalendar без CRLF (RFC 5545, 3.1)
+	maxLineOctets = 75
+)
+
+// event - событие календаря. Start и End в UTC, у урока без времени AllDay.
+type event struct {
+	UID         string
+	Start       time.Time
+	End         time.Time
+	AllDay      bool
+	Summary     string
+	Description string
+	Location    string
+	URL         string
+	Cancelled   bool
+}
+
+// writeCalendar пишет календарь в формате iCalendar (RFC 5545).
+func writeCalendar(w io.Writer, name, timezone string, events []event) error {
+	ics := &icsWriter{w: w}
+	ics.line("BEGIN:VCALENDAR")
+	ics.line("VERSION:2.0")
+	ics.line("PRODID:-//prok05//ecom lessons//RU")
+	ics.line("CALSCALE:GREGORIAN")
+	ics.line("METHOD:PUBLISH")
+	ics.property("X-WR-CALNAME", name)
+	ics.property("X-WR-TIMEZONE", timezone)
+	ics.line("REFRESH-INTERVAL;VALUE=DURATION:PT1H")
+	ics.line("X-PUBLISHED-TTL:PT1H")
+
+	stamp := time.Now().UTC().Format(icsDateTime)
+	for _, e := range events {
+		ics.line("BEGIN:VEVENT")
+		ics.property("UID", e.UID)
+		ics.line("DTSTAMP:" + stamp)
+		if e.AllDay {
+			ics.line("DTSTART;VALUE=DATE:" + e.Start.Format(icsDate))
+			ics.line("DTEND;VALUE=DATE:" + e.Start.AddDate(0, 0, 1).Format(icsDate))
+		} else {
+			ics.line("DTSTART:" + e.Start.UTC().Format(icsDateTime))
+			ics.line("DTEND:" + e.End.UTC().Format(icsDateTime))
+		}
+		ics.property("SUMMARY", e.Summary)
+		if e.Description != "" {
+			ics.property("DESCRIPTION", e.Description)
+		}
+		if e.Location != "" {
+			ics.property("LOCATION", e.Location)
+		}
+		if e.URL != "" {
+			ics.line("URL:" + e.URL)
+		}
+		// SEQUENCE растет при отмене, чтобы календарь заменил ранее полученное событие
+		if e.Cancelled {
+			ics.line("STATUS:CANCELLED")
+			ics.line("SEQUENCE:1")
+		} else {
+			ics.line("STATUS:CONFIRMED")
+			ics.line("SEQUENCE:0")
+		}
+		ics.line("END:VEVENT")
+	}
+	ics.line("END:VCALENDAR")
+	return ics.err
+}
+
+type icsWriter struct {
+	w   io.Writer
+	err error
+}
+
+// property пишет свойство с текстовым значением.
+func (ics *icsWriter) property(name, value string) {
+	ics.line(name + ":" + escapeText(value))
+}
+
+// line пишет строку, перенося ее по 75 октетов без разрыва символов UTF-8.
+func (ics *icsWriter) line(s string) {
+	if ics.err != nil {
+		return
+	}
+	var b strings.Builder
+	limit := maxLineOctets
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		b.WriteString(s[:cut])
+		b.WriteString("\r\n ")
+		s = s[cut:]
+		// продолжение начинается с пробела, он входит в длину строки
+		limit = maxLineOctets - 1
+	}
+	b.WriteString(s)
+	b.WriteString("\r\n")
+	_, ics.err = io.WriteString(ics.w, b.String())
+}
+
+func escapeText(s string) string {
+	s = strings.ReplaceAll(s, "\\", "\\\\")
+	s = strings.ReplaceAll(s, ";", "\\;")
+	s = strings.ReplaceAll(s, ",", "\\,")
+	s = strings.ReplaceAll(s, "\r\n", "\\n")
+	s = strings.ReplaceAll(s, "\n", "\\n")
+	return s
+}
+
+// lessonUID - постоянный идентификатор события урока.
+func lessonUID(lessonID int, tenantSlug string) string {
+	return fmt.Sprintf("lesson-%d@%s", lessonID, tenantSlug)
+}
@@ -0,0 +1,119 @@
+package calendar
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestEscapeText(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"Математика", "Математика"},
+		{"Тема: дроби; проценты, задачи", "Тема: дроби\\; проценты\\, задачи"},
+		{`C:\work`, `C:\\work`},
+		{"Тема\nТрансляция", "Тема\\nТрансляция"},
+		{"Тема\r\nТрансляция", "Тема\\nТрансляция"},
+	}
+	for _, tt := range tests {
+		if got := escapeText(tt.in); got != tt.want {
+			t.Errorf("escapeText(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestLineFolding(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		lines int
+	}{
+		{"short", "SUMMARY:Урок", 1},
+		{"exactly 75 octets", "DESCRIPTION:" + strings.Repeat("a", maxLineOctets-len("DESCRIPTION:")), 1},
+		{"ascii", "DESCRIPTION:" + strings.Repeat("a", 200), 3},
+		// кириллица занимает 2 октета, символ не должен разрываться
+		{"cyrillic", "DESCRIPTION:" + strings.Repeat("я", 100), 3},
+		{"emoji", "SUMMARY:" + strings.Repeat("📚", 40), 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			ics := &icsWriter{w: &b}
+			ics.line(tt.value)
+			if ics.err != nil {
+				t.Fatal(ics.err)
+			}
+
+			out := b.String()
+			if !strings.HasSuffix(out, "\r\n") {
+				t.Fatalf("line does not end with CRLF: %q", out)
+			}
+			lines := strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n")
+			if len(lines) != tt.lines {
+				t.Errorf("%d lines, want %d", len(lines), tt.lines)
+			}
+			for i, l := range lines {
+				if len(l) > maxLineOctets {
+					t.Errorf("line %d is %d octets long", i, len(l))
+				}
+				if !utf8.ValidString(l) {
+					t.Errorf("line %d splits a character: %q", i, l)
+				}
+				if i > 0 && !strings.HasPrefix(l, " ") {
+					t.Errorf("continuation line %d does not start with a space", i)
+				}
+			}
+			if unfolded := strings.ReplaceAll(strings.TrimSuffix(out, "\r\n"), "\r\n ", ""); unfolded != tt.value {
+				t.Errorf("unfolded line %q, want %q", unfolded, tt.value)
+			}
+		})
+	}
+}
+
+func TestWriteCalendar(t *testing.T) {
+	start := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	events := []event{
+		{
+			UID:         lessonUID(1, "school"),
+			Start:       start,
+			End:         start.Add(time.Hour),
+			Summary:     "Аня: Математика",
+			Description: "Тема: дроби, проценты\nТрансляция: https://meet.example/1",
+			Location:    "Кабинет 1; 2 этаж",
+		},
+		{UID: lessonUID(2, "school"), Start: start, AllDay: true, Summary: "Урок", Cancelled: true},
+	}
+
+	var b strings.Builder
+	if err := writeCalendar(&b, "Школа - уроки", "Europe/Moscow", events); err != nil {
+		t.Fatal(err)
+	}
+	out := strings.ReplaceAll(b.String(), "\r\n ", "")
+
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"X-WR-CALNAME:Школа - уроки\r\n",
+		"X-WR-TIMEZONE:Europe/Moscow\r\n",
+		"UID:lesson-1@school\r\n",
+		"DTSTART:20250301T100000Z\r\nDTEND:20250301T110000Z\r\n",
+		"DESCRIPTION:Тема: дроби\\, проценты\\nТрансляция: https://meet.example/1\r\n",
+		"LOCATION:Кабинет 1\\; 2 этаж\r\n",
+		"STATUS:CONFIRMED\r\nSEQUENCE:0\r\n",
+		"DTSTART;VALUE=DATE:20250301\r\nDTEND;VALUE=DATE:20250302\r\n",
+		"STATUS:CANCELLED\r\nSEQUENCE:1\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("calendar does not contain %q", want)
+		}
+	}
+	if n := strings.Count(out, "BEGIN:VEVENT"); n != 2 {
+		t.Errorf("%d events, want 2", n)
+	}
+	if strings.Contains(strings.ReplaceAll(b.String(), "\r\n", ""), "\n") {
+		t.Error("calendar contains a bare LF")
+	}
+}
@@ -0,0 +1,84 @@
+package calendar
+
+import (
+	"github.com/prok05/ecom/types"
+	"strings"
+	"time"
+)
+
+const (
+	crmDateTime = "2006-01-02 15:04:05"
+	// defaultDuration - длительность урока, у которого не указано время окончания
+	defaultDuration = time.Hour
+)
+
+// lessonEvent переводит урок в событие календаря. prefix - имя ребенка в календаре родителя.
+func lessonEvent(l types.GetLessonsResponseItem, tenantSlug string, loc *time.Location, prefix string) (event, bool) {
+	e := event{
+		UID:       lessonUID(l.ID, tenantSlug),
+		Summary:   l.SubjectName,
+		Location:  l.RoomName,
+		URL:       streamingURL(l.Streaming),
+		Cancelled: l.Status == 2,
+	}
+	if e.Summary == "" {
+		e.Summary = "Урок"
+	}
+	if prefix != "" {
+		e.Summary = prefix + ": " + e.Summary
+	}
+
+	description := make([]string, 0, 2)
+	if l.Topic != "" {
+		description = append(description, "Тема: "+l.Topic)
+	}
+	if e.URL != "" {
+		description = append(description, "Трансляция: "+e.URL)
+	}
+	e.Description = strings.Join(description, "\n")
+
+	start, err := time.ParseInLocation(crmDateTime, l.TimeFrom, loc)
+	if err != nil {
+		// время не указано - событие на весь день
+		day, err := time.Parse("2006-01-02", l.Date)
+		if err != nil {
+			return e, false
+		}
+		e.Start, e.AllDay = day, true
+		return e, true
+	}
+	end, err := time.ParseInLocation(crmDateTime, l.TimeTo, loc)
+	if err != nil || !end.After(start) {
+		end = start.Add(defaultDuration)
+	}
+	e.Start, e.End = start, end
+	return e, true
+}
+
+// streamingURL ищет ссылку на трансляцию в поле streaming урока, формат которого AlfaCRM не фиксирует.
+func streamingURL(v any) string {
+	switch s := v.(type) {
+	case string:
+		if strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://") {
+			return s
+		}
+	case map[string]any:
+		for _, key := range []string{"url", "link", "join_url"} {
+			if u := streamingURL(s[key]); u != "" {
+				return u
+			}
+		}
+		for _, item := range s {
+			if u := streamingURL(item); u != "" {
+				return u
+			}
+		}
+	case []any:
+		for _, item := range s {
+			if u := streamingURL(item); u != "" {
+				return u
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,254 @@
+package calendar
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/prok05/ecom/service/alpha"
+	"github.com/prok05/ecom/service/auth"
+	"github.com/prok05/ecom/service/lesson"
+	"github.com/prok05/ecom/service/tenant"
+	"github.com/prok05/ecom/types"
+	"github.com/prok05/ecom/utils"
+	"log"
+	"net/http"
+	"time"
+)
+
+// период календаря в днях от сегодняшнего дня
+const (
+	feedDaysBack  = 30
+	feedDaysAhead = 90
+)
+
+// Handler выдает пользователям секретные ссылки на календарь уроков и отдает по ним ICS.
+// Ссылка открывается приложением календаря без входа, доступ дает только токен в адресе.
+type Handler struct {
+	store       types.CalendarStore
+	lessonStore types.LessonStore
+	parentStore types.ParentStore
+	refs        types.ReferenceStore
+	syncer      *lesson.Syncer
+	tenants     *tenant.Resolver
+	crm         alpha.Provider
+	authorizer  *auth.Authorizer
+	feedRoute   *mux.Route
+}
+
+func NewHandler(store types.CalendarStore, lessonStore types.LessonStore, parentStore types.ParentStore, refs types.ReferenceStore,
+	syncer *lesson.Syncer, tenants *tenant.Resolver, crm alpha.Provider, authorizer *auth.Authorizer) *Handler {
+	return &Handler{
+		store:       store,
+		lessonStore: lessonStore,
+		parentStore: parentStore,
+		refs:        refs,
+		syncer:      syncer,
+		tenants:     tenants,
+		crm:         crm,
+		authorizer:  authorizer,
+	}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/calendar/feed", h.authorizer.RequirePermissions(h.handleGetFeed, auth.PermCalendarRead)).Methods(http.MethodGet)
+	router.HandleFunc("/calendar/feed", h.authorizer.RequirePermissions(h.handleCreateFeed, auth.PermCalendarRead)).Methods(http.MethodPost)
+	router.HandleFunc("/calendar/feed", h.authorizer.RequirePermissions(h.handleDeleteFeed, auth.PermCalendarRead)).Methods(http.MethodDelete)
+
+	h.feedRoute = router.HandleFunc("/calendar/feed/{token}.ics", h.handleGetICS).Methods(http.MethodGet)
+}
+
+// Ссылка на календарь текущего пользователя. Адрес виден только при создании
+func (h *Handler) handleGetFeed(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+	feed, err := h.store.GetFeed(userID)
+	if err != nil {
+		log.Printf("failed to get calendar feed of user %d: %v", userID, err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get calendar feed"))
+		return
+	}
+	if feed == nil {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("calendar feed not found"))
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, feed)
+}
+
+// Новая ссылка на календарь. Если ссылка уже была, прежняя перестает работать
+func (h *Handler) handleCreateFeed(w http.ResponseWriter, r *http.Request) {
+	token, err := newToken()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot create calendar feed"))
+		return
+	}
+
+	userID := auth.GetUserIDFromContext(r.Context())
+	feed, err := h.store.SaveFeed(userID, hashToken(token))
+	if err != nil {
+		log.Printf("failed to save calendar feed of user %d: %v", userID, err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot create calendar feed"))
+		return
+	}
+
+	feed.URL, err = h.feedURL(r, token)
+	if err != nil {
+		log.Printf("failed to build calendar feed url: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot create calendar feed"))
+		return
+	}
+	utils.WriteJSON(w, http.StatusCreated, feed)
+}
+
+func (h *Handler) handleDeleteFeed(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+	deleted, err := h.store.DeleteFeed(userID)
+	if err != nil {
+		log.Printf("failed to delete calendar feed of user %d: %v", userID, err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot delete calendar feed"))
+		return
+	}
+	if !deleted {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("calendar feed not found"))
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"message": "calendar feed deleted"})
+}
+
+// Календарь уроков в формате ICS по секретной ссылке. Приложение календаря не передает
+// X-Tenant, поэтому школа берется из ссылки, а не из запроса
+func (h *Handler) handleGetICS(w http.ResponseWriter, r *http.Request) {
+	feed, err := h.store.FindFeed(hashToken(mux.Vars(r)["token"]))
+	if err != nil {
+		log.Printf("failed to find calendar feed: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get calendar"))
+		return
+	}
+	if feed == nil {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("calendar not found"))
+		return
+	}
+	t, err := h.tenants.ByID(feed.TenantID)
+	if err != nil || !t.IsActive {
+		log.Printf("calendar feed of user %d belongs to unavailable tenant %d: %v", feed.UserID, feed.TenantID, err)
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("calendar not found"))
+		return
+	}
+
+	events, err := h.events(r.Context(), t, feed)
+	if err != nil {
+		log.Printf("failed to get lessons for calendar of user %d: %v", feed.UserID, err)
+		utils.WriteError(w, alpha.HTTPStatus(err), fmt.Errorf("cannot get lessons"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="lessons.ics"`)
+	if err := writeCalendar(w, t.Name+" - уроки", tenant.Timezone(t), events); err != nil {
+		log.Printf("failed to write calendar of user %d: %v", feed.UserID, err)
+	}
+}
+
+// events собирает уроки владельца ссылки: ученика, преподавателя или детей родителя.
+func (h *Handler) events(ctx context.Context, t *types.Tenant, feed *types.CalendarFeed) ([]event, error) {
+	loc := tenant.Location(t)
+	events := make([]event, 0)
+	add := func(filter types.LessonFilter, prefix string) error {
+		lessons, err := h.lessons(ctx, t, filter)
+		if err != nil {
+			return err
+		}
+		for _, l := range lessons {
+			if e, ok := lessonEvent(l, t.Slug, loc, prefix); ok {
+				events = append(events, e)
+			}
+		}
+		return nil
+	}
+
+	switch feed.Role {
+	case types.RoleStudent:
+		return events, add(types.LessonFilter{CustomerID: feed.UserID}, "")
+	case types.RoleTeacher, types.RoleSupervisor:
+		return events, add(types.LessonFilter{TeacherID: feed.UserID}, "")
+	case types.RoleParent:
+		children, err := h.parentStore.GetChildren(feed.UserID)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range children {
+			if err := add(types.LessonFilter{CustomerID: c.StudentID}, c.FirstName); err != nil {
+				return nil, err
+			}
+		}
+		return events, nil
+	}
+	return events, nil
+}
+
+// lessons берет уроки из локальной копии, а пока школа не синхронизирована - из AlfaCRM.
+func (h *Handler) lessons(ctx context.Context, t *types.Tenant, filter types.LessonFilter) ([]types.GetLessonsResponseItem, error) {
+	now := time.Now()
+	filter.TenantID = t.ID
+	filter.DateFrom = now.AddDate(0, 0, -feedDaysBack).Format("2006-01-02")
+	filter.DateTo = now.AddDate(0, 0, feedDaysAhead).Format("2006-01-02")
+
+	state, err := h.lessonStore.GetLessonSyncState(t.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var lessons []types.GetLessonsResponseItem
+	if h.syncer.Mirrored(state, filter.DateFrom) {
+		lessons, err = h.lessonStore.ListLessons(filter)
+	} else {
+		role := types.RoleStudent
+		if filter.TeacherID != 0 {
+			role = types.RoleTeacher
+		}
+		payload := types.GetLessonsPayload{
+			CustomerID: filter.CustomerID,
+			TeacherID:  filter.TeacherID,
+			DateFrom:   filter.DateFrom,
+			DateTo:     filter.DateTo,
+		}
+		lessons, err = lesson.FetchLessons(ctx, h.crm.For(alpha.AccountFor(t)), payload, role, 1, 2, 3)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lesson.EnrichLessons(h.refs, t.ID, lessons); err != nil {
+		log.Printf("error getting lesson reference data: %v", err)
+	}
+	return lessons, nil
+}
+
+// feedURL - полный адрес календаря, по которому его открывает приложение.
+func (h *Handler) feedURL(r *http.Request, token string) (string, error) {
+	path, err := h.feedRoute.URL("token", token)
+	if err != nil {
+		return "", err
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, r.Host, path.Path), nil
+}
+
+// newToken генерирует токен для адреса календаря, в базе хранится только его хэш.
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
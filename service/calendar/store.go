@@ -0,0 +1,70 @@
+package calendar
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prok05/ecom/types"
+)
+
+const feedColumns = `f.user_id, u.tenant_id, u.user_role, f.created_at, f.rotated_at, f.last_fetched_at`
+
+type Store struct {
+	dbpool *pgxpool.Pool
+}
+
+func NewStore(dbpool *pgxpool.Pool) *Store {
+	return &Store{
+		dbpool: dbpool,
+	}
+}
+
+func (s *Store) SaveFeed(userID int, tokenHash string) (*types.CalendarFeed, error) {
+	_, err := s.dbpool.Exec(context.Background(),
+		`INSERT INTO calendar_feeds (user_id, token_hash)
+		 VALUES ($1, $2)
+		 ON CONFLICT (user_id) DO UPDATE
+		 SET token_hash = EXCLUDED.token_hash, rotated_at = NOW(), last_fetched_at = NULL`,
+		userID, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetFeed(userID)
+}
+
+// GetFeed возвращает ссылку пользователя или nil, если ее нет.
+func (s *Store) GetFeed(userID int) (*types.CalendarFeed, error) {
+	return s.getFeed(`SELECT `+feedColumns+`
+		 FROM calendar_feeds f JOIN users u ON u.id = f.user_id
+		 WHERE f.user_id = $1`, userID)
+}
+
+func (s *Store) DeleteFeed(userID int) (bool, error) {
+	tag, err := s.dbpool.Exec(context.Background(), `DELETE FROM calendar_feeds WHERE user_id = $1`, userID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// FindFeed возвращает nil, если ссылки нет или ее владелец отключен.
+func (s *Store) FindFeed(tokenHash string) (*types.CalendarFeed, error) {
+	return s.getFeed(`UPDATE calendar_feeds f SET last_fetched_at = NOW()
+		 FROM users u
+		 WHERE u.id = f.user_id AND f.token_hash = $1 AND u.is_active
+		 RETURNING `+feedColumns, tokenHash)
+}
+
+func (s *Store) getFeed(query string, args ...any) (*types.CalendarFeed, error) {
+	var f types.CalendarFeed
+	err := s.dbpool.QueryRow(context.Background(), query, args...).Scan(
+		&f.UserID, &f.TenantID, &f.Role, &f.CreatedAt, &f.RotatedAt, &f.LastFetchedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
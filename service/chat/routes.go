@@ -2,62 +2,56 @@ package chat
 
 import (
 	"fmt"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/mux"
-	"github.com/prok05/ecom/cache"
+	"github.com/prok05/ecom/service/alpha"
 	"github.com/prok05/ecom/service/auth"
 	"github.com/prok05/ecom/service/lesson"
+	"github.com/prok05/ecom/service/tenant"
 	"github.com/prok05/ecom/types"
 	"github.com/prok05/ecom/utils"
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 type Handler struct {
 	store        types.ChatStore
 	userStore    types.UserStore
 	messageStore types.MessageStore
-	tokenCache   *cache.TokenCache
+	lessonStore  types.LessonStore
+	authorizer   *auth.Authorizer
+	crm          alpha.Provider
 }
 
-func NewHandler(store types.ChatStore, userStore types.UserStore, messageStore types.MessageStore, tokenCache *cache.TokenCache) *Handler {
+func NewHandler(store types.ChatStore, userStore types.UserStore, messageStore types.MessageStore, lessonStore types.LessonStore, authorizer *auth.Authorizer, crm alpha.Provider) *Handler {
 	return &Handler{
 		store:        store,
-		tokenCache:   tokenCache,
+		crm:          crm,
 		userStore:    userStore,
 		messageStore: messageStore,
+		lessonStore:  lessonStore,
+		authorizer:   authorizer,
 	}
 }
 
 func (h *Handler) RegisterRoutes(router *mux.Router) {
-	router.HandleFunc("/chats", h.CreateChat).Methods("POST")
-	router.HandleFunc("/chats", auth.WithJWTAuth(h.GetAllChats, h.userStore)).Methods("GET")
-	router.HandleFunc("/chats/users/{userID}", h.GetAllChatsByUserID).Methods("GET")
-	router.HandleFunc("/chats/{chatID}", h.GetChatByID).Methods("GET")
-	router.HandleFunc("/chats/get/{userID}", h.GetChatByIDs).Methods("GET")
-	router.HandleFunc("/chats/{chatID}", h.DeleteChat).Methods("DELETE")
+	router.HandleFunc("/chats", h.authorizer.RequirePermissions(h.CreateChat, auth.PermChatManage)).Methods("POST")
+	router.HandleFunc("/chats", h.authorizer.RequirePermissions(h.GetAllChats, auth.PermChatRead)).Methods("GET")
+	router.HandleFunc("/chats/users/{userID}", h.authorizer.RequirePermissions(h.GetAllChatsByUserID, auth.PermChatManage)).Methods("GET")
+	router.HandleFunc("/chats/{chatID}", h.authorizer.RequirePermissions(h.GetChatByID, auth.PermChatRead)).Methods("GET")
+	router.HandleFunc("/chats/get/{userID}", h.authorizer.RequirePermissions(h.GetChatByIDs, auth.PermChatWrite)).Methods("GET")
+	router.HandleFunc("/chats/{chatID}", h.authorizer.RequirePermissions(h.DeleteChat, auth.PermChatManage)).Methods("DELETE")
 }
 
 func (h *Handler) CreateChat(w http.ResponseWriter, r *http.Request) {
-	tokenString := auth.GetTokenFromRequest(r)
-	if tokenString == "" {
-		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("missing or invalid JWT token"))
-		return
-	}
-
-	jwtToken, err := auth.ValidateToken(tokenString)
-	if err != nil || !jwtToken.Valid {
-		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("invalid token"))
-		return
-	}
-
 	var chat types.Chat
 	if err := utils.ParseJSON(r, &chat); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
 
+	chat.TenantID = auth.GetTenantIDFromContext(r.Context())
 	parts := []int{1, 2, 3}
 
 	if err := h.store.CreateChat(&chat, parts); err != nil {
@@ -75,16 +69,21 @@ func (h *Handler) GetAllChats(w http.ResponseWriter, r *http.Request) {
 	userID := auth.GetUserIDFromContext(r.Context())
 	role := auth.GetUserRoleFromContext(r.Context())
 
-	if role == "student" {
-		token, err := h.tokenCache.GetToken()
-		if err != nil {
-			log.Println("no alpha token")
-			return
-		}
-
-		teachersIds, err := lesson.GetStudentTeachersIDs(userID, 3, 0, token)
+	if role == types.RoleStudent {
+		crm := h.crm.For(tenant.Account(r.Context()))
+		teachersIds, err := lesson.StudentTeacherIDs(r.Context(), crm, userID)
 		if err != nil {
-			log.Println(err)
+			log.Printf("error getting student teachers: %v", err)
+			// пока CRM недоступна, преподаватели берутся из локальной копии уроков
+			var mirrorErr error
+			teachersIds, mirrorErr = h.lessonStore.GetStudentTeacherIDs(auth.GetTenantIDFromContext(r.Context()), userID,
+				time.Now().AddDate(0, 0, -lesson.TeacherDays))
+			if mirrorErr != nil {
+				log.Printf("error getting student teachers from lessons mirror: %v", mirrorErr)
+				utils.WriteError(w, alpha.HTTPStatus(err), fmt.Errorf("cannot get teachers from CRM"))
+				return
+			}
+			utils.MarkStale(w)
 		}
 
 		teachers, err := h.userStore.FindUsersByIDs(teachersIds)
@@ -95,7 +94,7 @@ func (h *Handler) GetAllChats(w http.ResponseWriter, r *http.Request) {
 		}
 
 		utils.WriteJSON(w, http.StatusOK, teachers)
-	} else if role == "teacher" {
+	} else if role == types.RoleTeacher {
 		chats, err := h.store.GetAllChatsByUserID(userID)
 		if err != nil {
 			log.Println("error getting chats: ", err)
@@ -107,36 +106,6 @@ func (h *Handler) GetAllChats(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) GetAllChatsByUserID(w http.ResponseWriter, r *http.Request) {
-	tokenString := auth.GetTokenFromRequest(r)
-	if tokenString == "" {
-		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("missing or invalid JWT token"))
-		return
-	}
-
-	jwtToken, err := auth.ValidateToken(tokenString)
-	if err != nil || !jwtToken.Valid {
-		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("invalid token"))
-		return
-	}
-
-	claims, ok := jwtToken.Claims.(jwt.MapClaims)
-	if !ok {
-		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("no token"))
-		return
-	}
-
-	role, ok := claims["role"].(string)
-	if !ok {
-		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("invalid token"))
-		return
-	}
-
-	if role != "supervisor" {
-		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("cannot get chats"))
-		log.Println("wrong role for getting chats")
-		return
-	}
-
 	vars := mux.Vars(r)
 	userIDVar := vars["userID"]
 	userIDInt, err := strconv.Atoi(userIDVar)
@@ -145,6 +114,12 @@ func (h *Handler) GetAllChatsByUserID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	user, err := h.userStore.FindUserByID(userIDInt)
+	if err != nil || user.TenantID != auth.GetTenantIDFromContext(r.Context()) {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("user not found"))
+		return
+	}
+
 	chats, err := h.store.GetAllChatsByUserID(userIDInt)
 	if err != nil {
 		log.Println("error getting chats: ", err)
@@ -156,18 +131,6 @@ func (h *Handler) GetAllChatsByUserID(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) GetChatByID(w http.ResponseWriter, r *http.Request) {
-	tokenString := auth.GetTokenFromRequest(r)
-	if tokenString == "" {
-		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("missing or invalid JWT token"))
-		return
-	}
-
-	jwtToken, err := auth.ValidateToken(tokenString)
-	if err != nil || !jwtToken.Valid {
-		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("invalid token"))
-		return
-	}
-
 	vars := mux.Vars(r)
 	chatID := vars["chatID"]
 	chatIDInt, err := strconv.Atoi(chatID)
@@ -177,7 +140,7 @@ func (h *Handler) GetChatByID(w http.ResponseWriter, r *http.Request) {
 	}
 
 	chat, err := h.store.GetChatByID(chatIDInt)
-	if err != nil {
+	if err != nil || chat.TenantID != auth.GetTenantIDFromContext(r.Context()) {
 		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("chat not found"))
 		return
 	}
@@ -189,36 +152,7 @@ func (h *Handler) GetChatByID(w http.ResponseWriter, r *http.Request) {
 // Получение сообщений для студента. Достаем ID студента из токена ID учителя из параметра.
 // Отдаем либо nil, либо список сообщений
 func (h *Handler) GetChatByIDs(w http.ResponseWriter, r *http.Request) {
-	tokenString := auth.GetTokenFromRequest(r)
-	if tokenString == "" {
-		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("missing or invalid JWT token"))
-		return
-	}
-
-	jwtToken, err := auth.ValidateToken(tokenString)
-	if err != nil || !jwtToken.Valid {
-		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("invalid token"))
-		return
-	}
-
-	claims, ok := jwtToken.Claims.(jwt.MapClaims)
-	if !ok {
-		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("no token"))
-		return
-	}
-
-	userIDStr, ok := claims["userID"].(string)
-	if !ok {
-		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("invalid token"))
-		return
-	}
-
-	userID, err := strconv.Atoi(userIDStr)
-	if err != nil {
-		log.Printf("Unable to convert userID: %v", err)
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.GetUserIDFromContext(r.Context())
 
 	vars := mux.Vars(r)
 	userIDParam := vars["userID"]
@@ -249,18 +183,6 @@ func (h *Handler) GetChatByIDs(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) DeleteChat(w http.ResponseWriter, r *http.Request) {
-	tokenString := auth.GetTokenFromRequest(r)
-	if tokenString == "" {
-		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("missing or invalid JWT token"))
-		return
-	}
-
-	jwtToken, err := auth.ValidateToken(tokenString)
-	if err != nil || !jwtToken.Valid {
-		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("invalid token"))
-		return
-	}
-
 	vars := mux.Vars(r)
 	chatID := vars["chatID"]
 	chatIDInt, err := strconv.Atoi(chatID)
@@ -269,6 +191,12 @@ func (h *Handler) DeleteChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	chat, err := h.store.GetChatByID(chatIDInt)
+	if err != nil || chat.TenantID != auth.GetTenantIDFromContext(r.Context()) {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("chat not found"))
+		return
+	}
+
 	if err := h.store.DeleteChat(chatIDInt); err != nil {
 		utils.WriteJSON(w, http.StatusBadRequest, err)
 		return
@@ -21,8 +21,8 @@ func NewStore(pool *pgxpool.Pool) *Store {
 
 func (s *Store) CreateChat(chat *types.Chat, members []int) error {
 	err := s.pool.QueryRow(context.Background(),
-		"INSERT INTO chats (chat_type, name) VALUES ($1, $2) RETURNING id",
-		chat.ChatType, chat.Name).Scan(&chat.ID)
+		"INSERT INTO chats (chat_type, name, tenant_id) VALUES ($1, $2, $3) RETURNING id",
+		chat.ChatType, chat.Name, chat.TenantID).Scan(&chat.ID)
 	if err != nil {
 		log.Println(err)
 		return err
@@ -102,8 +102,8 @@ func (s *Store) GetChatParticipants(chatID int) ([]types.Participant, error) {
 
 func (s *Store) GetChatByID(chatID int) (*types.Chat, error) {
 	var chat types.Chat
-	query := "SELECT id, chat_type, name, created_at FROM chats WHERE id = $1"
-	err := s.pool.QueryRow(context.Background(), query, chatID).Scan(&chatID, &chat.ChatType, &chat.Name, &chat.CreatedAt)
+	query := "SELECT id, chat_type, name, tenant_id, created_at FROM chats WHERE id = $1"
+	err := s.pool.QueryRow(context.Background(), query, chatID).Scan(&chat.ID, &chat.ChatType, &chat.Name, &chat.TenantID, &chat.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,78 @@
+package crmsync
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/prok05/ecom/cache"
+	"github.com/prok05/ecom/service/auth"
+	"github.com/prok05/ecom/service/tenant"
+	"github.com/prok05/ecom/utils"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+type Handler struct {
+	syncer     *Syncer
+	store      *Store
+	tokenCache *cache.TokenCache
+	authorizer *auth.Authorizer
+}
+
+func NewHandler(syncer *Syncer, store *Store, tokenCache *cache.TokenCache, authorizer *auth.Authorizer) *Handler {
+	return &Handler{
+		syncer:     syncer,
+		store:      store,
+		tokenCache: tokenCache,
+		authorizer: authorizer,
+	}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/admin/crm/sync", h.authorizer.RequirePermissions(h.handleRunSync, auth.PermUserManage)).Methods(http.MethodPost)
+	router.HandleFunc("/admin/crm/sync/runs", h.authorizer.RequirePermissions(h.handleGetSyncRuns, auth.PermUserManage)).Methods(http.MethodGet)
+	router.HandleFunc("/admin/crm/token", h.authorizer.RequirePermissions(h.handleGetTokenStatus, auth.PermUserManage)).Methods(http.MethodGet)
+}
+
+// Запуск синхронизации вне расписания, в ответе отчет
+func (h *Handler) handleRunSync(w http.ResponseWriter, r *http.Request) {
+	report, err := h.syncer.Run(r.Context(), tenant.FromContext(r.Context()))
+	if err != nil {
+		if errors.Is(err, ErrSyncInProgress) {
+			utils.WriteError(w, http.StatusConflict, err)
+			return
+		}
+		log.Printf("crm sync failed: %v", err)
+		utils.WriteError(w, http.StatusBadGateway, fmt.Errorf("crm sync failed"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, report)
+}
+
+func (h *Handler) handleGetSyncRuns(w http.ResponseWriter, r *http.Request) {
+	limit := 20
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 100 {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid limit"))
+			return
+		}
+		limit = n
+	}
+
+	reports, err := h.store.GetSyncReports(auth.GetTenantIDFromContext(r.Context()), limit)
+	if err != nil {
+		log.Printf("failed to get sync reports: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get sync reports"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, reports)
+}
+
+// Состояние токена AlfaCRM школы: когда получен, когда истекает, ошибки входа
+func (h *Handler) handleGetTokenStatus(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSON(w, http.StatusOK, h.tokenCache.Status(tenant.Account(r.Context())))
+}
@@ -0,0 +1,171 @@
+package crmsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prok05/ecom/types"
+	"log"
+)
+
+const crmUserColumns = `id, tenant_id, phone, first_name, last_name, middle_name, user_role,
+		balance::float8, paid_lesson_count, is_active, COALESCE(deactivation_reason, '')`
+
+type Store struct {
+	dbpool *pgxpool.Pool
+}
+
+func NewStore(dbpool *pgxpool.Pool) *Store {
+	return &Store{
+		dbpool: dbpool,
+	}
+}
+
+// GetLocalUsers возвращает всех пользователей школы с ID из AlfaCRM.
+func (s *Store) GetLocalUsers(tenant *types.Tenant) (map[int]types.CRMUser, error) {
+	rows, err := s.dbpool.Query(context.Background(),
+		`SELECT `+crmUserColumns+`
+		 FROM users
+		 WHERE tenant_id = $1 AND id < $2`, tenant.ID, tenant.IDOffset+types.LocalUserIDStart)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make(map[int]types.CRMUser)
+	for rows.Next() {
+		u, err := scanCRMUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users[u.ID] = *u
+	}
+	return users, rows.Err()
+}
+
+// GetLocalUser возвращает пользователя школы с ID из AlfaCRM или nil, если его нет.
+func (s *Store) GetLocalUser(tenant *types.Tenant, userID int) (*types.CRMUser, error) {
+	row := s.dbpool.QueryRow(context.Background(),
+		`SELECT `+crmUserColumns+`
+		 FROM users
+		 WHERE tenant_id = $1 AND id = $2 AND id < $3`, tenant.ID, userID, tenant.IDOffset+types.LocalUserIDStart)
+	u, err := scanCRMUser(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return u, err
+}
+
+// UpsertCRMUser создает пользователя без пароля или обновляет профиль существующего.
+// Роль и статус существующего пользователя не меняются. Пользователя другой роли
+// с тем же ID не обновляет и возвращает ErrRoleConflict.
+func (s *Store) UpsertCRMUser(u types.CRMUser) error {
+	tag, err := s.dbpool.Exec(context.Background(),
+		`INSERT INTO users (id, tenant_id, phone, password, first_name, last_name, middle_name, user_role,
+		                    balance, paid_lesson_count, crm_synced_at)
+		 VALUES ($1, $2, $3, '', $4, $5, $6, $7, $8, $9, NOW())
+		 ON CONFLICT (id) DO UPDATE SET
+		     phone = EXCLUDED.phone,
+		     first_name = EXCLUDED.first_name,
+		     last_name = EXCLUDED.last_name,
+		     middle_name = EXCLUDED.middle_name,
+		     balance = EXCLUDED.balance,
+		     paid_lesson_count = EXCLUDED.paid_lesson_count,
+		     crm_synced_at = NOW()
+		 WHERE users.user_role = EXCLUDED.user_role
+		    OR (users.user_role IN ('teacher', 'supervisor') AND EXCLUDED.user_role IN ('teacher', 'supervisor'))`,
+		u.ID, u.TenantID, u.Phone, u.FirstName, u.LastName, u.MiddleName, u.Role, u.Balance, u.PaidLessonCount)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrRoleConflict
+	}
+	return nil
+}
+
+func (s *Store) SetCRMUserActive(userID int, active bool) error {
+	_, err := s.dbpool.Exec(context.Background(),
+		`UPDATE users SET is_active = $1,
+			deactivated_at = CASE WHEN $1 THEN NULL ELSE NOW() END,
+			deactivation_reason = CASE WHEN $1 THEN NULL ELSE $3 END
+		 WHERE id = $2`,
+		active, userID, types.DeactivatedByCRM)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (s *Store) SaveSyncReport(report *types.CRMSyncReport) error {
+	errorsJSON, err := json.Marshal(report.Errors)
+	if err != nil {
+		return err
+	}
+	changesJSON, err := json.Marshal(report.Changes)
+	if err != nil {
+		return err
+	}
+
+	return s.dbpool.QueryRow(context.Background(),
+		`INSERT INTO crm_sync_runs (tenant_id, started_at, finished_at, created, updated, deactivated, reactivated, skipped, errors, changes)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id`,
+		report.TenantID, report.StartedAt, report.FinishedAt, report.Created, report.Updated, report.Deactivated,
+		report.Reactivated, report.Skipped, errorsJSON, changesJSON).Scan(&report.ID)
+}
+
+func (s *Store) GetSyncReports(tenantID, limit int) ([]types.CRMSyncReport, error) {
+	rows, err := s.dbpool.Query(context.Background(),
+		`SELECT id, tenant_id, started_at, finished_at, created, updated, deactivated, reactivated, skipped, errors, changes
+		 FROM crm_sync_runs
+		 WHERE tenant_id = $1
+		 ORDER BY started_at DESC
+		 LIMIT $2`, tenantID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := make([]types.CRMSyncReport, 0)
+	for rows.Next() {
+		var r types.CRMSyncReport
+		var errorsJSON, changesJSON []byte
+		err := rows.Scan(&r.ID, &r.TenantID, &r.StartedAt, &r.FinishedAt, &r.Created, &r.Updated, &r.Deactivated,
+			&r.Reactivated, &r.Skipped, &errorsJSON, &changesJSON)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(errorsJSON, &r.Errors); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(changesJSON, &r.Changes); err != nil {
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+	return reports, rows.Err()
+}
+
+func scanCRMUser(row pgx.Row) (*types.CRMUser, error) {
+	var u types.CRMUser
+	err := row.Scan(
+		&u.ID,
+		&u.TenantID,
+		&u.Phone,
+		&u.FirstName,
+		&u.LastName,
+		&u.MiddleName,
+		&u.Role,
+		&u.Balance,
+		&u.PaidLessonCount,
+		&u.IsActive,
+		&u.DeactivationReason,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
@@ -0,0 +1,303 @@
+package crmsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/prok05/ecom/service/alpha"
+	"github.com/prok05/ecom/service/tenant"
+	"github.com/prok05/ecom/service/ws"
+	"github.com/prok05/ecom/types"
+	"github.com/prok05/ecom/utils"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	ActionCreated     = "created"
+	ActionUpdated     = "updated"
+	ActionDeactivated = "deactivated"
+	ActionReactivated = "reactivated"
+	ActionSkipped     = "skipped"
+)
+
+var (
+	ErrSyncInProgress = errors.New("crm sync is already running")
+	// ErrRoleConflict - пользователь с этим ID уже есть с ролью из другого справочника AlfaCRM
+	ErrRoleConflict = errors.New("user with this id has another role")
+)
+
+// Syncer переносит преподавателей и учеников из AlfaCRM в таблицу users.
+// Новые пользователи создаются без пароля и завершают регистрацию сами,
+// у существующих обновляются ФИО, телефон, баланс и число оплаченных уроков.
+// Пользователи, которых больше нет среди активных в CRM, блокируются.
+type Syncer struct {
+	store   types.CRMSyncStore
+	tenants *tenant.Resolver
+	crm     alpha.Provider
+	hub     *ws.Hub
+	mu      sync.Mutex
+}
+
+func NewSyncer(store types.CRMSyncStore, tenants *tenant.Resolver, crm alpha.Provider, hub *ws.Hub) *Syncer {
+	return &Syncer{
+		store:   store,
+		tenants: tenants,
+		crm:     crm,
+		hub:     hub,
+	}
+}
+
+// Schedule запускает синхронизацию всех школ сразу и затем каждые interval. Блокирует вызывающего.
+func (s *Syncer) Schedule(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		tenants, err := s.tenants.Tenants()
+		if err != nil {
+			log.Printf("crm sync failed: %v", err)
+		}
+		for i := range tenants {
+			if _, err := s.Run(context.Background(), &tenants[i]); err != nil {
+				log.Printf("crm sync of tenant %s failed: %v", tenants[i].Slug, err)
+			}
+		}
+		<-ticker.C
+	}
+}
+
+// Run выполняет одну синхронизацию школы и сохраняет отчет.
+// Одновременно выполняется только один запуск, остальные получают ErrSyncInProgress.
+func (s *Syncer) Run(ctx context.Context, t *types.Tenant) (*types.CRMSyncReport, error) {
+	if !s.mu.TryLock() {
+		return nil, ErrSyncInProgress
+	}
+	defer s.mu.Unlock()
+
+	report := &types.CRMSyncReport{
+		TenantID:  t.ID,
+		StartedAt: time.Now(),
+		Errors:    make([]string, 0),
+		Changes:   make([]types.CRMSyncChange, 0),
+	}
+
+	crm := s.crm.For(alpha.AccountFor(t))
+	local, err := s.store.GetLocalUsers(t)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load local users: %v", err)
+	}
+
+	for _, role := range []string{types.RoleTeacher, types.RoleStudent} {
+		s.syncRole(ctx, crm, t.ID, role, local, report)
+	}
+
+	report.FinishedAt = time.Now()
+	if err := s.store.SaveSyncReport(report); err != nil {
+		return report, fmt.Errorf("failed to save sync report: %v", err)
+	}
+
+	log.Printf("crm sync finished: created=%d updated=%d deactivated=%d reactivated=%d skipped=%d errors=%d",
+		report.Created, report.Updated, report.Deactivated, report.Reactivated, report.Skipped, len(report.Errors))
+	return report, nil
+}
+
+// SyncUser обновляет одного пользователя по данным AlfaCRM, например по вебхуку.
+// Ученик, которого нет среди активных клиентов CRM, блокируется.
+// Возвращает изменение или nil, если пользователь не изменился.
+func (s *Syncer) SyncUser(ctx context.Context, t *types.Tenant, role string, userID int) (*types.CRMSyncChange, error) {
+	existing, err := s.store.GetLocalUser(t, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load local user: %v", err)
+	}
+	if existing != nil && !SameCRMRole(existing.Role, role) {
+		// ID клиента совпал с ID преподавателя или наоборот: это другой человек
+		log.Printf("crm %s %d collides with local %s, skipped", role, userID, existing.Role)
+		return nil, nil
+	}
+	local := make(map[int]types.CRMUser)
+	if existing != nil {
+		local[userID] = *existing
+	}
+
+	item, err := s.crm.For(alpha.AccountFor(t)).GetUser(ctx, role, userID)
+	removed := errors.Is(err, alpha.ErrNotFound) || (err == nil && role == types.RoleStudent && item.IsStudy != 1)
+	if removed {
+		if existing == nil || !existing.IsActive {
+			return nil, nil
+		}
+		if err := s.store.SetCRMUserActive(userID, false); err != nil {
+			return nil, err
+		}
+		s.hub.DisconnectUser(userID)
+		return &types.CRMSyncChange{UserID: userID, Role: existing.Role, Action: ActionDeactivated}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	report := &types.CRMSyncReport{Errors: make([]string, 0), Changes: make([]types.CRMSyncChange, 0)}
+	s.upsert(t.ID, role, *item, local, report)
+	if len(report.Errors) > 0 {
+		return nil, errors.New(report.Errors[0])
+	}
+	if len(report.Changes) == 0 {
+		return nil, nil
+	}
+	return &report.Changes[len(report.Changes)-1], nil
+}
+
+func (s *Syncer) syncRole(ctx context.Context, crm alpha.API, tenantID int, role string, local map[int]types.CRMUser, report *types.CRMSyncReport) {
+	items, err := crm.ListUsers(ctx, role)
+	if err != nil {
+		// без полного списка нельзя понять, кто удален из CRM, поэтому никого не блокируем
+		report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", role, err))
+		return
+	}
+
+	seen := make(map[int]bool)
+	for _, item := range items {
+		// лиды в AlfaCRM тоже клиенты, но учениками не считаются
+		if role == types.RoleStudent && item.IsStudy != 1 {
+			continue
+		}
+		seen[item.ID] = true
+		s.upsert(tenantID, role, item, local, report)
+	}
+
+	if len(seen) == 0 {
+		report.Errors = append(report.Errors, fmt.Sprintf("%s: CRM returned no active users, deactivation skipped", role))
+		return
+	}
+
+	for id, u := range local {
+		if u.Role != role || !u.IsActive || seen[id] {
+			continue
+		}
+		if err := s.store.SetCRMUserActive(id, false); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("user %d: %v", id, err))
+			continue
+		}
+		s.hub.DisconnectUser(id)
+		report.Deactivated++
+		report.Changes = append(report.Changes, types.CRMSyncChange{UserID: id, Role: role, Action: ActionDeactivated})
+	}
+}
+
+func (s *Syncer) upsert(tenantID int, role string, item types.GetUserResponseItem, local map[int]types.CRMUser, report *types.CRMSyncReport) {
+	lastName, firstName, middleName := alpha.SplitName(item.Name)
+	u := types.CRMUser{
+		ID:              item.ID,
+		TenantID:        tenantID,
+		FirstName:       firstName,
+		LastName:        lastName,
+		MiddleName:      middleName,
+		Role:            role,
+		PaidLessonCount: &item.PaidLessonCount,
+	}
+	if balance, err := strconv.ParseFloat(item.Balance, 64); err == nil {
+		u.Balance = &balance
+	}
+	if len(item.Phone) > 0 {
+		u.Phone = utils.NormalizePhone(item.Phone[0])
+	}
+
+	existing, exists := local[item.ID]
+	if exists && !SameCRMRole(existing.Role, role) {
+		// преподаватели и клиенты в AlfaCRM нумеруются отдельно, и ID может совпасть
+		// с пользователем другой роли. Его профиль и телефон не трогаем
+		log.Printf("crm %s %d collides with local %s, skipped", role, item.ID, existing.Role)
+		report.Skipped++
+		report.Changes = append(report.Changes, types.CRMSyncChange{UserID: u.ID, Role: role, Action: ActionSkipped, Fields: []string{"role"}})
+		return
+	}
+	var changed []string
+	if exists {
+		u.Role = existing.Role
+		// телефон, под которым пользователь входит, меняется только если номер действительно другой
+		if u.Phone == "" || u.Phone == utils.NormalizePhone(existing.Phone) {
+			u.Phone = existing.Phone
+		}
+		changed = diff(existing, u)
+	} else if u.Phone == "" {
+		report.Skipped++
+		report.Changes = append(report.Changes, types.CRMSyncChange{UserID: u.ID, Role: role, Action: ActionSkipped, Fields: []string{"phone"}})
+		return
+	}
+
+	if err := s.store.UpsertCRMUser(u); err != nil {
+		if errors.Is(err, ErrRoleConflict) {
+			// пользователь другой роли появился после загрузки local
+			report.Skipped++
+			report.Changes = append(report.Changes, types.CRMSyncChange{UserID: u.ID, Role: role, Action: ActionSkipped, Fields: []string{"role"}})
+			return
+		}
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			// телефон уже занят другим пользователем, например братом или сестрой
+			report.Skipped++
+			report.Changes = append(report.Changes, types.CRMSyncChange{UserID: u.ID, Role: role, Action: ActionSkipped, Fields: []string{"phone"}})
+			return
+		}
+		report.Errors = append(report.Errors, fmt.Sprintf("user %d: %v", u.ID, err))
+		return
+	}
+
+	switch {
+	case !exists:
+		report.Created++
+		report.Changes = append(report.Changes, types.CRMSyncChange{UserID: u.ID, Role: role, Action: ActionCreated})
+	case len(changed) > 0:
+		report.Updated++
+		report.Changes = append(report.Changes, types.CRMSyncChange{UserID: u.ID, Role: u.Role, Action: ActionUpdated, Fields: changed})
+	}
+
+	// заблокированных супервизором синхронизация не разблокирует
+	if exists && !existing.IsActive && existing.DeactivationReason == types.DeactivatedByCRM {
+		if err := s.store.SetCRMUserActive(u.ID, true); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("user %d: %v", u.ID, err))
+			return
+		}
+		report.Reactivated++
+		report.Changes = append(report.Changes, types.CRMSyncChange{UserID: u.ID, Role: u.Role, Action: ActionReactivated})
+	}
+}
+
+// SameCRMRole сообщает, относится ли локальная роль к тому же справочнику AlfaCRM, что role:
+// преподаватели и супервизоры - к преподавателям, ученики - к клиентам.
+func SameCRMRole(localRole, role string) bool {
+	return crmRole(localRole) == crmRole(role)
+}
+
+func crmRole(role string) string {
+	if role == types.RoleSupervisor {
+		return types.RoleTeacher
+	}
+	return role
+}
+
+func diff(old, new types.CRMUser) []string {
+	changed := make([]string, 0)
+	if old.FirstName != new.FirstName || old.LastName != new.LastName || old.MiddleName != new.MiddleName {
+		changed = append(changed, "name")
+	}
+	if old.Phone != new.Phone {
+		changed = append(changed, "phone")
+	}
+	if !equalPtr(old.Balance, new.Balance) {
+		changed = append(changed, "balance")
+	}
+	if !equalPtr(old.PaidLessonCount, new.PaidLessonCount) {
+		changed = append(changed, "paid_lesson_count")
+	}
+	return changed
+}
+
+func equalPtr[T comparable](a, b *T) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -0,0 +1,174 @@
+package homework
+
+import (
+	"errors"
+	"fmt"
+	"github.com/prok05/ecom/service/alpha"
+	"github.com/prok05/ecom/service/auth"
+	"github.com/prok05/ecom/service/lesson"
+	"github.com/prok05/ecom/service/tenant"
+	"github.com/prok05/ecom/types"
+	"github.com/prok05/ecom/utils"
+	"log"
+	"net/http"
+)
+
+// Проверки владения: к домашнему заданию, решению и файлам имеют доступ
+// назначивший его преподаватель, ученики, которым оно выдано, и супервизор его школы.
+// Каждая функция сама пишет ответ с ошибкой и возвращает false, если доступ запрещен.
+
+func (h *Handler) authorizeHomeworkRead(w http.ResponseWriter, p *auth.Principal, homeworkID int) bool {
+	access, err := h.store.GetHomeworkAccess(homeworkID)
+	if !checkAccessLookup(w, access, err) {
+		return false
+	}
+	return h.authorizeRead(w, p, access)
+}
+
+func (h *Handler) authorizeHomeworkReview(w http.ResponseWriter, p *auth.Principal, homeworkID int) bool {
+	access, err := h.store.GetHomeworkAccess(homeworkID)
+	if !checkAccessLookup(w, access, err) {
+		return false
+	}
+	return authorizeReview(w, p, access)
+}
+
+func (h *Handler) authorizeSolutionReview(w http.ResponseWriter, p *auth.Principal, solutionID int) bool {
+	access, err := h.store.GetSolutionAccess(solutionID)
+	if !checkAccessLookup(w, access, err) {
+		return false
+	}
+	return authorizeReview(w, p, access)
+}
+
+// authorizeSolutionOwner пропускает только ученика, которому принадлежит решение
+func (h *Handler) authorizeSolutionOwner(w http.ResponseWriter, p *auth.Principal, solutionID, homeworkID int) bool {
+	access, err := h.store.GetSolutionAccess(solutionID)
+	if !checkAccessLookup(w, access, err) {
+		return false
+	}
+	if access.StudentID != p.UserID || access.HomeworkID != homeworkID {
+		return forbidden(w, p, "solution", solutionID)
+	}
+	return true
+}
+
+func (h *Handler) authorizeStudentFileRead(w http.ResponseWriter, p *auth.Principal, fileID int) bool {
+	access, err := h.store.GetHomeworkFileAccess(fileID)
+	if !checkAccessLookup(w, access, err) {
+		return false
+	}
+	if p.Role == types.RoleStudent {
+		if access.StudentID != p.UserID {
+			return forbidden(w, p, "homework file", fileID)
+		}
+		return true
+	}
+	return authorizeReview(w, p, access)
+}
+
+func (h *Handler) authorizeStudentFileOwner(w http.ResponseWriter, p *auth.Principal, fileID int) bool {
+	access, err := h.store.GetHomeworkFileAccess(fileID)
+	if !checkAccessLookup(w, access, err) {
+		return false
+	}
+	if access.StudentID != p.UserID {
+		return forbidden(w, p, "homework file", fileID)
+	}
+	return true
+}
+
+func (h *Handler) authorizeTeacherFileRead(w http.ResponseWriter, p *auth.Principal, fileID int) bool {
+	access, err := h.store.GetHomeworkTeacherFileAccess(fileID)
+	if !checkAccessLookup(w, access, err) {
+		return false
+	}
+	return h.authorizeRead(w, p, access)
+}
+
+func (h *Handler) authorizeRead(w http.ResponseWriter, p *auth.Principal, access *types.HomeworkAccess) bool {
+	switch p.Role {
+	case types.RoleSupervisor:
+		if access.TenantID == p.TenantID {
+			return true
+		}
+	case types.RoleTeacher:
+		if access.TeacherID == p.UserID {
+			return true
+		}
+	case types.RoleStudent:
+		assigned, err := h.store.IsStudentAssignedToHomework(access.HomeworkID, p.UserID)
+		if err != nil {
+			log.Printf("failed to check homework assignment: %v", err)
+			utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to check access"))
+			return false
+		}
+		if assigned {
+			return true
+		}
+	}
+	return forbidden(w, p, "homework", access.HomeworkID)
+}
+
+func authorizeReview(w http.ResponseWriter, p *auth.Principal, access *types.HomeworkAccess) bool {
+	if (p.Role == types.RoleSupervisor && access.TenantID == p.TenantID) ||
+		(p.Role == types.RoleTeacher && access.TeacherID == p.UserID) {
+		return true
+	}
+	return forbidden(w, p, "homework", access.HomeworkID)
+}
+
+func checkAccessLookup(w http.ResponseWriter, access *types.HomeworkAccess, err error) bool {
+	if err != nil {
+		log.Printf("failed to load homework access: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to check access"))
+		return false
+	}
+	if access == nil {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("not found"))
+		return false
+	}
+	return true
+}
+
+func forbidden(w http.ResponseWriter, p *auth.Principal, resource string, id int) bool {
+	log.Printf("user %d (%s) has no access to %s %d", p.UserID, p.Role, resource, id)
+	utils.WriteError(w, http.StatusForbidden, fmt.Errorf("permission denied"))
+	return false
+}
+
+// authorizeLessonAssign пропускает преподавателя урока (администратора - любой урок школы)
+// и только учеников этого урока. Урок ищется в локальной копии, затем в AlfaCRM.
+func (h *Handler) authorizeLessonAssign(w http.ResponseWriter, r *http.Request, p *auth.Principal, lessonID int, studentIDs []int) bool {
+	item, err := lesson.FindLesson(r.Context(), h.lessonStore, h.crm.For(tenant.Account(r.Context())), p.TenantID, lessonID)
+	if errors.Is(err, alpha.ErrNotFound) {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("lesson not found"))
+		return false
+	}
+	if err != nil {
+		log.Printf("failed to get lesson %d: %v", lessonID, err)
+		utils.WriteError(w, alpha.HTTPStatus(err), fmt.Errorf("cannot verify lesson"))
+		return false
+	}
+
+	if p.Role != types.RoleSupervisor && !containsID(item.TeacherIDs, p.UserID) {
+		return forbidden(w, p, "lesson", lessonID)
+	}
+	for _, studentID := range studentIDs {
+		if !containsID(item.CustomerIDs, studentID) {
+			log.Printf("student %d is not in lesson %d", studentID, lessonID)
+			utils.WriteError(w, http.StatusForbidden, fmt.Errorf("student %d is not in the lesson", studentID))
+			return false
+		}
+	}
+	return true
+}
+
+func containsID(ids []int, id int) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
@@ -3,6 +3,7 @@ package homework
 import (
 	"fmt"
 	"github.com/gorilla/mux"
+	"github.com/prok05/ecom/service/alpha"
 	"github.com/prok05/ecom/service/auth"
 	"github.com/prok05/ecom/types"
 	"github.com/prok05/ecom/utils"
@@ -18,40 +19,45 @@ import (
 )
 
 type Handler struct {
-	store     types.HomeworkStore
-	userStore types.UserStore
+	store       types.HomeworkStore
+	userStore   types.UserStore
+	refs        types.ReferenceStore
+	lessonStore types.LessonStore
+	authorizer  *auth.Authorizer
+	crm         alpha.Provider
 }
 
-func NewHandler(store types.HomeworkStore, userStore types.UserStore) *Handler {
-	return &Handler{store: store, userStore: userStore}
+func NewHandler(store types.HomeworkStore, userStore types.UserStore, refs types.ReferenceStore, lessonStore types.LessonStore, authorizer *auth.Authorizer, crm alpha.Provider) *Handler {
+	return &Handler{store: store, userStore: userStore, refs: refs, lessonStore: lessonStore, authorizer: authorizer, crm: crm}
 }
 
 func (h *Handler) RegisterRoutes(router *mux.Router) {
-	router.HandleFunc("/upload/homework", h.handleUploadHomework).Methods(http.MethodPost)
-	router.HandleFunc("/upload/homework-add", h.handleAddHomework).Methods(http.MethodPost)
+	router.HandleFunc("/upload/homework", h.authorizer.RequirePermissions(h.handleUploadHomework, auth.PermHomeworkAssign)).Methods(http.MethodPost)
+	router.HandleFunc("/upload/homework-add", h.authorizer.RequirePermissions(h.handleAddHomework, auth.PermHomeworkSubmit)).Methods(http.MethodPost)
 	//router.HandleFunc("/homework/teacher", h.GetHomeworkTeacher).Methods(http.MethodPost)
 
 	// Преподаватель
-	router.HandleFunc("/homework/teacher", h.handleAssignHomework).Methods(http.MethodPost)
-	router.HandleFunc("/homework/teacher/files/{homeworkID}", h.handleGetHomeworkTeacherFiles).Methods(http.MethodGet)
-	router.HandleFunc("/homework/teacher", auth.WithJWTAuth(h.handleGetTeacherHomework, h.userStore)).Methods(http.MethodGet)
-	router.HandleFunc("/homework/teacher/solutions/{homeworkID}", auth.WithJWTAuth(h.handleGetTeacherHomeworkSolutions, h.userStore)).Methods(http.MethodGet)
-	router.HandleFunc("/homework/solution/{solutionID}", auth.WithJWTAuth(h.handleUpdateSolutionStatus, h.userStore)).Methods(http.MethodPatch)
-	router.HandleFunc("/homework/teacher/file/{fileID}/download", h.handleDownloadTeacherHomeworkFile).Methods(http.MethodGet)
+	router.HandleFunc("/homework/teacher", h.authorizer.RequirePermissions(h.handleAssignHomework, auth.PermHomeworkAssign)).Methods(http.MethodPost)
+	router.HandleFunc("/homework/teacher/files/{homeworkID}", h.authorizer.RequirePermissions(h.handleGetHomeworkTeacherFiles, auth.PermHomeworkRead)).Methods(http.MethodGet)
+	router.HandleFunc("/homework/teacher", h.authorizer.RequirePermissions(h.handleGetTeacherHomework, auth.PermHomeworkAssign)).Methods(http.MethodGet)
+	router.HandleFunc("/homework/teacher/solutions/{homeworkID}", h.authorizer.RequirePermissions(h.handleGetTeacherHomeworkSolutions, auth.PermHomeworkReview)).Methods(http.MethodGet)
+	router.HandleFunc("/homework/solution/{solutionID}", h.authorizer.RequirePermissions(h.handleUpdateSolutionStatus, auth.PermHomeworkReview)).Methods(http.MethodPatch)
+	router.HandleFunc("/homework/teacher/file/{fileID}/download", h.authorizer.RequirePermissions(h.handleDownloadTeacherHomeworkFile, auth.PermHomeworkRead)).Methods(http.MethodGet)
 
 	// Ученик
 	// Получение ДЗ ученика
-	router.HandleFunc("/homework/student", auth.WithJWTAuth(h.handleGetStudentHomework, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/homework/student", h.authorizer.RequirePermissions(h.handleGetStudentHomework, auth.PermHomeworkSubmit)).Methods(http.MethodGet)
 	// Отправка решения
-	router.HandleFunc("/homework/student/solution", auth.WithJWTAuth(h.handleAssignSolution, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/homework/student/solution", h.authorizer.RequirePermissions(h.handleAssignSolution, auth.PermHomeworkSubmit)).Methods(http.MethodPost)
 	// Получение решения
-	router.HandleFunc("/homework/student/solution/{homeworkID}", auth.WithJWTAuth(h.handleGetStudentSolution, h.userStore)).Methods(http.MethodGet)
-
-	router.HandleFunc("/homework/{lessonID}", h.handleGetHomework).Methods(http.MethodGet)
-	router.HandleFunc("/homework/teacher/count", h.CountHomeworkWithStatus).Methods(http.MethodPost)
-	router.HandleFunc("/homework/files/{homeworkID}", h.handleGetHomeworkFiles).Methods(http.MethodGet)
-	router.HandleFunc("/homework/files/{fileID}", h.handleDeleteHomeworkFiles).Methods(http.MethodDelete)
-	router.HandleFunc("/homework/file/{fileID}/download", h.handleDownloadHomeworkFile).Methods(http.MethodGet)
+	router.HandleFunc("/homework/student/solution/{homeworkID}", h.authorizer.RequirePermissions(h.handleGetStudentSolution, auth.PermHomeworkSubmit)).Methods(http.MethodGet)
+
+	router.HandleFunc("/homework/{lessonID}", h.authorizer.RequirePermissions(h.handleGetHomework, auth.PermHomeworkRead)).Methods(http.MethodGet)
+	router.HandleFunc("/homework/teacher/count", h.authorizer.RequirePermissions(h.CountHomeworkWithStatus, auth.PermHomeworkReview)).Methods(http.MethodPost)
+	router.HandleFunc("/homework/report/subjects", h.authorizer.RequirePermissions(h.handleGetSubjectReport, auth.PermReportRead)).Methods(http.MethodGet)
+	router.HandleFunc("/homework/files/{homeworkID}", h.authorizer.RequirePermissions(h.handleGetHomeworkFiles, auth.PermHomeworkRead)).Methods(http.MethodGet)
+	router.HandleFunc("/homework/files/{fileID}", h.authorizer.RequirePermissions(h.handleDeleteHomeworkFiles, auth.PermHomeworkSubmit)).Methods(http.MethodDelete)
+	router.HandleFunc("/homework/file/{fileID}/download", h.authorizer.RequirePermissions(h.handleDownloadHomeworkFile, auth.PermHomeworkRead)).Methods(http.MethodGet)
 }
 
 func (h *Handler) handleUploadHomework(w http.ResponseWriter, r *http.Request) {
@@ -63,17 +69,18 @@ func (h *Handler) handleUploadHomework(w http.ResponseWriter, r *http.Request) {
 		fmt.Println("wrong lesson_id")
 		return
 	}
-	teacherID, err := strconv.Atoi(r.FormValue("teacher_id"))
-	if err != nil {
-		fmt.Println("wrong teacher_id")
-		return
-	}
+	teacherID := auth.GetUserIDFromContext(r.Context())
 	studentID, err := strconv.Atoi(r.FormValue("student_id"))
 	if err != nil {
 		fmt.Println("wrong student_id")
 		return
 	}
 
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if !h.authorizeLessonAssign(w, r, principal, lessonID, []int{studentID}) {
+		return
+	}
+
 	file, header, err := r.FormFile("file")
 	if err != nil {
 		fmt.Println(err)
@@ -110,7 +117,7 @@ func (h *Handler) handleUploadHomework(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.store.SaveHomeworkFile(homeworkID, tempFile.Name())
+	err = h.store.SaveHomeworkFile(homeworkID, studentID, header.Filename, tempFile.Name())
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot save homework file"))
 		log.Println(err)
@@ -130,6 +137,22 @@ func (h *Handler) handleAddHomework(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// файл прикладывается к решению самого ученика
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	solution, err := h.store.GetHomeworkSolutionByStudent(homeworkID, principal.UserID)
+	if err != nil {
+		log.Printf("failed to get solution of homework %d: %v", homeworkID, err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to check access"))
+		return
+	}
+	if solution == nil {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("solution not found"))
+		return
+	}
+	if !h.authorizeSolutionOwner(w, principal, solution.ID, homeworkID) {
+		return
+	}
+
 	file, header, err := r.FormFile("file")
 	if err != nil {
 		fmt.Println(err)
@@ -159,14 +182,19 @@ func (h *Handler) handleAddHomework(w http.ResponseWriter, r *http.Request) {
 
 	tempFile.Write(fileBytes)
 
-	err = h.store.SaveHomeworkFile(homeworkID, tempFile.Name())
+	err = h.store.SaveHomeworkFile(homeworkID, principal.UserID, header.Filename, tempFile.Name())
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot save homework file"))
 		log.Println(err)
 		return
 	}
 
-	err = h.store.UpdateSolutionStatus(homeworkID, 2)
+	err = h.store.UpdateSolutionStatus(solution.ID, 2)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot update homework"))
+		log.Println("cannot update solution status:", err)
+		return
+	}
 
 	utils.WriteJSON(w, http.StatusCreated, "ok")
 }
@@ -194,6 +222,11 @@ func (h *Handler) handleUpdateSolutionStatus(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if !h.authorizeSolutionReview(w, principal, solutionID) {
+		return
+	}
+
 	err = h.store.UpdateSolutionStatus(solutionID, payload.Status)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot update homework"))
@@ -201,6 +234,15 @@ func (h *Handler) handleUpdateSolutionStatus(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	// комментарий преподавателя к решению, его видят ученик и родители
+	if payload.ReviewNotes != nil {
+		if err := h.store.UpdateSolutionReviewNotes(solutionID, *payload.ReviewNotes); err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot update homework"))
+			log.Println("cannot update review notes:", err)
+			return
+		}
+	}
+
 	utils.WriteJSON(w, http.StatusOK, "ok")
 }
 
@@ -218,6 +260,11 @@ func (h *Handler) handleGetHomeworkFiles(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if !h.authorizeHomeworkReview(w, principal, homeworkID) {
+		return
+	}
+
 	homeworkFiles, err := h.store.GetHomeworkFilesByHomeworkID(homeworkID)
 	if err != nil {
 		utils.WriteJSON(w, http.StatusInternalServerError, fmt.Errorf("error getting homework files"))
@@ -243,6 +290,11 @@ func (h *Handler) handleDeleteHomeworkFiles(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if !h.authorizeStudentFileOwner(w, principal, fileID) {
+		return
+	}
+
 	filepath, err := h.store.GetHomeworkFilePathByID(fileID)
 	if err != nil {
 		fmt.Println("error getting path")
@@ -278,6 +330,11 @@ func (h *Handler) handleDownloadHomeworkFile(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if !h.authorizeStudentFileRead(w, principal, fileID) {
+		return
+	}
+
 	filepath, err := h.store.GetHomeworkFilePathByID(fileID)
 	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
@@ -334,6 +391,11 @@ func (h *Handler) handleDownloadTeacherHomeworkFile(w http.ResponseWriter, r *ht
 		return
 	}
 
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if !h.authorizeTeacherFileRead(w, principal, fileID) {
+		return
+	}
+
 	filepath, err := h.store.GetHomeworkTeacherFilePathByID(fileID)
 	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
@@ -398,11 +460,8 @@ func (h *Handler) handleAssignHomework(w http.ResponseWriter, r *http.Request) {
 		fmt.Println("wrong lesson_id")
 		return
 	}
-	teacherID, err := strconv.Atoi(r.FormValue("teacher_id"))
-	if err != nil {
-		fmt.Println("wrong teacher_id")
-		return
-	}
+	// преподаватель назначает ДЗ только от своего имени
+	teacherID := auth.GetUserIDFromContext(r.Context())
 
 	students := r.PostForm["student_ids"]
 	studentIDs, err := utils.StringsToInts(students)
@@ -412,10 +471,36 @@ func (h *Handler) handleAssignHomework(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if !h.authorizeLessonAssign(w, r, principal, lessonID, studentIDs) {
+		return
+	}
+
 	description := r.FormValue("description")
 
 	subjectTitle := r.FormValue("subject_title")
 
+	// предмет из справочника, subject_title тогда можно не передавать
+	var subjectID *int
+	if v := r.FormValue("subject_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid subject_id"))
+			return
+		}
+		ok, err := h.subjectExists(auth.GetTenantIDFromContext(r.Context()), id)
+		if err != nil {
+			log.Println("error while checking subject:", err)
+			utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot check subject"))
+			return
+		}
+		if !ok {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("unknown subject"))
+			return
+		}
+		subjectID = &id
+	}
+
 	lessonDate := r.FormValue("lesson_date")
 	lessonDateTime, err := time.Parse(time.RFC3339, lessonDate)
 	if err != nil {
@@ -432,6 +517,7 @@ func (h *Handler) handleAssignHomework(w http.ResponseWriter, r *http.Request) {
 		TeacherID:    teacherID,
 		LessonID:     lessonID,
 		StudentIDs:   studentIDs,
+		SubjectID:    subjectID,
 		SubjectTitle: subjectTitle,
 		LessonTopic:  lessonTopic,
 		Description:  description,
@@ -463,6 +549,11 @@ func (h *Handler) handleGetHomeworkTeacherFiles(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if !h.authorizeHomeworkRead(w, principal, homeworkID) {
+		return
+	}
+
 	files, err := h.store.GetHomeworkTeacherFiles(homeworkID)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("unable to get homework teacher files"))
@@ -488,6 +579,11 @@ func (h *Handler) handleAssignSolution(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if !h.authorizeSolutionOwner(w, principal, solutionID, homeworkID) {
+		return
+	}
+
 	solution := r.FormValue("solution")
 
 	files := r.MultipartForm.File["files"]
@@ -513,7 +609,17 @@ func (h *Handler) handleAssignSolution(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) handleGetTeacherHomework(w http.ResponseWriter, r *http.Request) {
 	teacherID := auth.GetUserIDFromContext(r.Context())
 
-	homeworks, err := h.store.GetHomeworksByTeacherID(teacherID)
+	subjectID := 0
+	if v := r.URL.Query().Get("subject_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid subject_id"))
+			return
+		}
+		subjectID = id
+	}
+
+	homeworks, err := h.store.GetHomeworksByTeacherID(teacherID, subjectID)
 	if err != nil {
 		log.Printf("failed to retrieve homeworks for teacher: %v", err)
 		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to retrieve homeworks"))
@@ -576,6 +682,11 @@ func (h *Handler) handleGetTeacherHomeworkSolutions(w http.ResponseWriter, r *ht
 		return
 	}
 
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if !h.authorizeHomeworkReview(w, principal, homeworkID) {
+		return
+	}
+
 	solutions, err := h.store.GetHomeworkSolutions(homeworkID)
 	if err != nil {
 		log.Printf("unable to get homework solutions: %v", err)
@@ -606,6 +717,13 @@ func (h *Handler) handleGetHomework(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if homework != nil {
+		principal, _ := auth.PrincipalFromContext(r.Context())
+		if !h.authorizeHomeworkRead(w, principal, homework.ID) {
+			return
+		}
+	}
+
 	utils.WriteJSON(w, http.StatusOK, homework)
 }
 
@@ -613,6 +731,12 @@ func (h *Handler) CountHomeworkWithStatus(w http.ResponseWriter, r *http.Request
 	var payload types.HomeworkPayload
 	if err := utils.ParseJSON(r, &payload); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if principal.Role == types.RoleTeacher {
+		payload.TeacherID = principal.UserID
 	}
 
 	count, err := h.store.CountHomeworksWithStatus(payload.LessonID, payload.TeacherID, payload.Status)
@@ -622,3 +746,40 @@ func (h *Handler) CountHomeworkWithStatus(w http.ResponseWriter, r *http.Request
 
 	utils.WriteJSON(w, http.StatusOK, map[string]int{"count": count})
 }
+
+// Сводка по ДЗ школы в разрезе предметов, период по дате урока: ?date_from=&date_to= (YYYY-MM-DD)
+func (h *Handler) handleGetSubjectReport(w http.ResponseWriter, r *http.Request) {
+	dateFrom := r.URL.Query().Get("date_from")
+	dateTo := r.URL.Query().Get("date_to")
+	for _, d := range []string{dateFrom, dateTo} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", d); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid date, expected YYYY-MM-DD"))
+			return
+		}
+	}
+
+	report, err := h.store.GetSubjectReport(auth.GetTenantIDFromContext(r.Context()), dateFrom, dateTo)
+	if err != nil {
+		log.Printf("failed to get subject report: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get report"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, report)
+}
+
+func (h *Handler) subjectExists(tenantID, subjectID int) (bool, error) {
+	subjects, err := h.refs.GetSubjects(tenantID)
+	if err != nil {
+		return false, err
+	}
+	for _, s := range subjects {
+		if s.ID == subjectID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
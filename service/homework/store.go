@@ -8,6 +8,7 @@ import (
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prok05/ecom/service/outbox"
 	"github.com/prok05/ecom/types"
 	"github.com/prok05/ecom/utils"
 	"log"
@@ -40,9 +41,13 @@ func (s *Store) AssignHomework(data types.HomeworkAssignment) (int, error) {
 
 	var homeworkID int
 	err = tx.QueryRow(context.Background(),
-		`INSERT INTO homeworks (lesson_id, lesson_date, lesson_topic, teacher_id, subject_title, description) 
-		 VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
-		data.LessonID, data.LessonDate, data.LessonTopic, data.TeacherID, data.SubjectTitle, data.Description).Scan(&homeworkID)
+		`INSERT INTO homeworks (lesson_id, lesson_date, lesson_topic, teacher_id, subject_id, subject_title, description, tenant_id)
+		 SELECT $1, $2, $3, u.id, $5, COALESCE(NULLIF($6, ''), s.name, ''), $7, u.tenant_id
+		 FROM users u
+		 LEFT JOIN subjects s ON s.tenant_id = u.tenant_id AND s.id = $5
+		 WHERE u.id = $4
+		 RETURNING id`,
+		data.LessonID, data.LessonDate, data.LessonTopic, data.TeacherID, data.SubjectID, data.SubjectTitle, data.Description).Scan(&homeworkID)
 	if err != nil {
 		log.Println("Failed to insert into homeworks:", err)
 		return 0, err
@@ -75,6 +80,12 @@ func (s *Store) AssignHomework(data types.HomeworkAssignment) (int, error) {
 		}
 	}
 
+	err = outbox.EnqueueHomeworkStatus(context.Background(), tx, homeworkID)
+	if err != nil {
+		log.Println("Failed to enqueue homework status:", err)
+		return 0, err
+	}
+
 	err = tx.Commit(context.Background())
 	if err != nil {
 		log.Println("Failed to commit transaction:", err)
@@ -117,7 +128,8 @@ func (s *Store) AssignSolution(data types.SolutionAssignment) error {
 		}
 	}()
 
-	_, err = tx.Exec(context.Background(), `UPDATE homework_solutions SET status=$1, solution=$2 WHERE id=$3`,
+	_, err = tx.Exec(context.Background(),
+		`UPDATE homework_solutions SET status=$1, solution=$2, submitted_at=NOW(), reviewed_at=NULL, updated_at=NOW() WHERE id=$3`,
 		2, data.Solution, data.SolutionID)
 	if err != nil {
 		log.Println("Failed to insert into homeworks:", err)
@@ -140,6 +152,12 @@ func (s *Store) AssignSolution(data types.SolutionAssignment) error {
 		}
 	}
 
+	err = outbox.EnqueueHomeworkStatus(context.Background(), tx, data.HomeworkID)
+	if err != nil {
+		log.Println("Failed to enqueue homework status:", err)
+		return err
+	}
+
 	err = tx.Commit(context.Background())
 	if err != nil {
 		log.Println("Failed to commit transaction:", err)
@@ -152,7 +170,8 @@ func (s *Store) AssignSolution(data types.SolutionAssignment) error {
 func (s *Store) SaveHomework(lessonID, studentID, teacherID int) (int, error) {
 	var homeworkID int
 	err := s.dbpool.QueryRow(context.Background(),
-		"INSERT INTO homeworks (lesson_id, student_id, teacher_id, status) VALUES ($1, $2, $3, $4) RETURNING id",
+		`INSERT INTO homeworks (lesson_id, student_id, teacher_id, status, tenant_id)
+		 VALUES ($1, $2, $3, $4, (SELECT tenant_id FROM users WHERE id = $3)) RETURNING id`,
 		lessonID, studentID, teacherID, 2).Scan(&homeworkID)
 	if err != nil {
 		log.Println(err)
@@ -170,8 +189,41 @@ func (s *Store) DeleteHomework(homeworkID int) error {
 	return nil
 }
 
+// UpdateSolutionStatus меняет статус решения и ставит в очередь отправку статуса ДЗ урока в AlfaCRM.
+// Статус 2 отмечает сдачу решения, 1 и 4 - его проверку.
 func (s *Store) UpdateSolutionStatus(solutionID, status int) error {
-	_, err := s.dbpool.Exec(context.Background(), `UPDATE homework_solutions SET status = $1 WHERE id = $2`, status, solutionID)
+	ctx := context.Background()
+	tx, err := s.dbpool.Begin(ctx)
+	if err != nil {
+		log.Println("Failed to start transaction: ", err)
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var homeworkID int
+	err = tx.QueryRow(ctx,
+		`UPDATE homework_solutions
+		 SET status = $1,
+		     submitted_at = CASE WHEN $1 = 2 THEN NOW() ELSE submitted_at END,
+		     reviewed_at = CASE WHEN $1 IN (1, 4) THEN NOW() WHEN $1 = 2 THEN NULL ELSE reviewed_at END,
+		     updated_at = NOW()
+		 WHERE id = $2
+		 RETURNING homework_id`,
+		status, solutionID).Scan(&homeworkID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if err := outbox.EnqueueHomeworkStatus(ctx, tx, homeworkID); err != nil {
+		log.Println("Failed to enqueue homework status:", err)
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (s *Store) UpdateSolutionReviewNotes(solutionID int, notes string) error {
+	_, err := s.dbpool.Exec(context.Background(), `UPDATE homework_solutions SET review_notes = $1 WHERE id = $2`, notes, solutionID)
 	if err != nil {
 		log.Println(err)
 		return err
@@ -191,10 +243,10 @@ func (s *Store) CountHomeworksWithStatus(lessonID, teacherID, status int) (int,
 	return count, nil
 }
 
-func (s *Store) SaveHomeworkFile(homeworkID int, filepath string) error {
+func (s *Store) SaveHomeworkFile(homeworkID, studentID int, filename, filepath string) error {
 	_, err := s.dbpool.Exec(context.Background(),
-		"INSERT INTO homework_files (homework_id, filepath) VALUES ($1, $2)",
-		homeworkID, filepath)
+		"INSERT INTO homework_files (homework_id, student_id, filename, filepath) VALUES ($1, $2, $3, $4)",
+		homeworkID, studentID, filename, filepath)
 
 	if err != nil {
 		log.Println(err)
@@ -350,12 +402,15 @@ func (s *Store) GetHomeworksByTeacherAndLessonID(lessonID, teacherID int, studen
 
 func (s *Store) GetHomeworkByLessonID(lessonID int) (*types.Homework, error) {
 	var homework types.Homework
-	err := s.dbpool.QueryRow(context.Background(), `SELECT * from homeworks WHERE lesson_id = $1`, lessonID).Scan(
+	err := s.dbpool.QueryRow(context.Background(),
+		`SELECT id, lesson_id, lesson_date, lesson_topic, teacher_id, subject_id, subject_title, description, created_at
+		 FROM homeworks WHERE lesson_id = $1`, lessonID).Scan(
 		&homework.ID,
 		&homework.LessonID,
 		&homework.LessonDate,
 		&homework.LessonTopic,
 		&homework.TeacherID,
+		&homework.SubjectID,
 		&homework.SubjectTitle,
 		&homework.Description,
 		&homework.CreatedAt)
@@ -374,13 +429,14 @@ func (s *Store) GetHomeworkByLessonID(lessonID int) (*types.Homework, error) {
 }
 
 // ОК
-func (s *Store) GetHomeworksByTeacherID(teacherID int) ([]types.Homework, error) {
+func (s *Store) GetHomeworksByTeacherID(teacherID, subjectID int) ([]types.Homework, error) {
 	query := `SELECT 
 		    h.id, 
 		    h.lesson_id, 
 		    h.lesson_date, 
 		    h.lesson_topic, 
 		    h.teacher_id, 
+		    h.subject_id, 
 		    h.subject_title, 
 		    h.description, 
 		    h.created_at, 
@@ -390,10 +446,10 @@ func (s *Store) GetHomeworksByTeacherID(teacherID int) ([]types.Homework, error)
 		LEFT JOIN 
 		    homework_solutions hs ON h.id = hs.homework_id
 		WHERE 
-		    h.teacher_id = $1
+		    h.teacher_id = $1 AND ($2 = 0 OR h.subject_id = $2)
 		GROUP BY 
 		    h.id`
-	rows, err := s.dbpool.Query(context.Background(), query, teacherID)
+	rows, err := s.dbpool.Query(context.Background(), query, teacherID, subjectID)
 	if err != nil {
 		log.Printf("failed to get homeworks for teacher: %v", err)
 		return nil, err
@@ -744,3 +800,111 @@ func (s *Store) GetHomeworkSolutionByStudent(homeworkID int, studentID int) (*ty
 
 	return solution, nil
 }
+
+// GetHomeworkAccess возвращает nil, если домашнего задания не существует
+func (s *Store) GetHomeworkAccess(homeworkID int) (*types.HomeworkAccess, error) {
+	var access types.HomeworkAccess
+	err := s.dbpool.QueryRow(context.Background(),
+		`SELECT id, tenant_id, teacher_id FROM homeworks WHERE id = $1`, homeworkID).Scan(&access.HomeworkID, &access.TenantID, &access.TeacherID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &access, nil
+}
+
+func (s *Store) IsStudentAssignedToHomework(homeworkID, studentID int) (bool, error) {
+	var exists bool
+	err := s.dbpool.QueryRow(context.Background(),
+		`SELECT EXISTS (SELECT 1 FROM homework_solutions WHERE homework_id = $1 AND student_id = $2)`,
+		homeworkID, studentID).Scan(&exists)
+	return exists, err
+}
+
+func (s *Store) GetSolutionAccess(solutionID int) (*types.HomeworkAccess, error) {
+	var access types.HomeworkAccess
+	err := s.dbpool.QueryRow(context.Background(),
+		`SELECT h.id, h.tenant_id, h.teacher_id, hs.student_id
+		 FROM homework_solutions hs
+		 JOIN homeworks h ON h.id = hs.homework_id
+		 WHERE hs.id = $1`, solutionID).Scan(&access.HomeworkID, &access.TenantID, &access.TeacherID, &access.StudentID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &access, nil
+}
+
+func (s *Store) GetHomeworkFileAccess(fileID int) (*types.HomeworkAccess, error) {
+	var access types.HomeworkAccess
+	err := s.dbpool.QueryRow(context.Background(),
+		`SELECT h.id, h.tenant_id, h.teacher_id, hf.student_id
+		 FROM homework_files hf
+		 JOIN homeworks h ON h.id = hf.homework_id
+		 WHERE hf.id = $1`, fileID).Scan(&access.HomeworkID, &access.TenantID, &access.TeacherID, &access.StudentID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &access, nil
+}
+
+func (s *Store) GetHomeworkTeacherFileAccess(fileID int) (*types.HomeworkAccess, error) {
+	var access types.HomeworkAccess
+	err := s.dbpool.QueryRow(context.Background(),
+		`SELECT h.id, h.tenant_id, h.teacher_id
+		 FROM homework_teacher_files tf
+		 JOIN homeworks h ON h.id = tf.homework_id
+		 WHERE tf.id = $1`, fileID).Scan(&access.HomeworkID, &access.TenantID, &access.TeacherID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &access, nil
+}
+
+// GetSubjectReport считает ДЗ и решения школы по предметам за период по дате урока.
+// ДЗ без предмета из справочника группируются по введенному названию.
+func (s *Store) GetSubjectReport(tenantID int, dateFrom, dateTo string) ([]types.SubjectReport, error) {
+	rows, err := s.dbpool.Query(context.Background(),
+		`SELECT h.subject_id,
+		        COALESCE(sub.name, h.subject_title),
+		        COUNT(DISTINCT h.id),
+		        COUNT(hs.id),
+		        COUNT(hs.id) FILTER (WHERE hs.status = 1),
+		        COUNT(hs.id) FILTER (WHERE hs.status = 2),
+		        COUNT(hs.id) FILTER (WHERE hs.status = 3),
+		        COUNT(hs.id) FILTER (WHERE hs.status = 4)
+		 FROM homeworks h
+		 LEFT JOIN subjects sub ON sub.tenant_id = h.tenant_id AND sub.id = h.subject_id
+		 LEFT JOIN homework_solutions hs ON hs.homework_id = h.id
+		 WHERE h.tenant_id = $1
+		   AND ($2 = '' OR h.lesson_date >= $2::date)
+		   AND ($3 = '' OR h.lesson_date < $3::date + 1)
+		 GROUP BY h.subject_id, COALESCE(sub.name, h.subject_title)
+		 ORDER BY 2`, tenantID, dateFrom, dateTo)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := make([]types.SubjectReport, 0)
+	for rows.Next() {
+		var r types.SubjectReport
+		err := rows.Scan(&r.SubjectID, &r.SubjectName, &r.Homeworks, &r.Solutions,
+			&r.Accepted, &r.UnderReview, &r.NotSubmitted, &r.Rejected)
+		if err != nil {
+			return nil, err
+		}
+		report = append(report, r)
+	}
+	return report, rows.Err()
+}
@@ -0,0 +1,110 @@
+package homework
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"os"
+	"testing"
+	"time"
+)
+
+// testPool подключается к базе TEST_DATABASE_URL с примененными миграциями.
+// Без нее тесты хранилища пропускаются.
+func testPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	pool, err := pgxpool.New(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func TestUpdateSolutionStatusStampsSolution(t *testing.T) {
+	pool := testPool(t)
+	ctx := context.Background()
+	const teacherID, lessonID = 990000001, 990000001
+
+	cleanup := func() {
+		pool.Exec(ctx, `DELETE FROM crm_outbox WHERE lesson_id = $1`, lessonID)
+		pool.Exec(ctx, `DELETE FROM homework_solutions WHERE homework_id IN (SELECT id FROM homeworks WHERE teacher_id = $1)`, teacherID)
+		pool.Exec(ctx, `DELETE FROM homeworks WHERE teacher_id = $1`, teacherID)
+		pool.Exec(ctx, `DELETE FROM users WHERE id = $1`, teacherID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	_, err := pool.Exec(ctx,
+		`INSERT INTO users (id, phone, password, first_name, last_name, middle_name, user_role)
+		 VALUES ($1, '+70000000001', '', 'Test', 'Teacher', '', 'teacher')`, teacherID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var homeworkID int
+	err = pool.QueryRow(ctx,
+		`INSERT INTO homeworks (lesson_id, lesson_date, teacher_id, subject_title, description)
+		 VALUES ($1, NOW(), $2, 'Математика', '') RETURNING id`, lessonID, teacherID).Scan(&homeworkID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// оба решения уже проверены
+	reviewedAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	solutions := make([]int, 2)
+	for i := range solutions {
+		err = pool.QueryRow(ctx,
+			`INSERT INTO homework_solutions (homework_id, student_id, status, reviewed_at)
+			 VALUES ($1, $2, 1, $3) RETURNING id`, homeworkID, 990000010+i, reviewedAt).Scan(&solutions[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type stamps struct {
+		status      int
+		submittedAt *time.Time
+		reviewedAt  *time.Time
+	}
+	get := func(solutionID int) stamps {
+		t.Helper()
+		var s stamps
+		err := pool.QueryRow(ctx, `SELECT status, submitted_at, reviewed_at FROM homework_solutions WHERE id = $1`,
+			solutionID).Scan(&s.status, &s.submittedAt, &s.reviewedAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	store := NewStore(pool)
+	if err := store.UpdateSolutionStatus(solutions[1], 2); err != nil {
+		t.Fatalf("UpdateSolutionStatus: %v", err)
+	}
+	submitted := get(solutions[1])
+	if submitted.status != 2 || submitted.submittedAt == nil || submitted.reviewedAt != nil {
+		t.Errorf("submitted solution: %+v", submitted)
+	}
+	other := get(solutions[0])
+	if other.status != 1 || other.submittedAt != nil || other.reviewedAt == nil || !other.reviewedAt.Equal(reviewedAt) {
+		t.Errorf("other solution was changed: %+v", other)
+	}
+
+	if err := store.UpdateSolutionStatus(solutions[1], 4); err != nil {
+		t.Fatalf("UpdateSolutionStatus: %v", err)
+	}
+	reviewed := get(solutions[1])
+	if reviewed.status != 4 || reviewed.submittedAt == nil || !reviewed.submittedAt.Equal(*submitted.submittedAt) || reviewed.reviewedAt == nil {
+		t.Errorf("reviewed solution: %+v", reviewed)
+	}
+
+	var queued int
+	if err := pool.QueryRow(ctx, `SELECT COUNT(*) FROM crm_outbox WHERE lesson_id = $1`, lessonID).Scan(&queued); err != nil {
+		t.Fatal(err)
+	}
+	if queued != 2 {
+		t.Errorf("%d outbox messages for the lesson, want 2", queued)
+	}
+}
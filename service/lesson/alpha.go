@@ -1,237 +1,102 @@
 package lesson
 
 import (
-	"bytes"
-	"encoding/json"
-	"fmt"
+	"context"
 	"github.com/prok05/ecom/service/alpha"
 	"github.com/prok05/ecom/types"
-	"io"
-	"log"
-	"net/http"
+	"sort"
 	"sync"
 	"time"
 )
 
-func GetAlphaLessons(id, status, page int, alphaToken, dateFrom, dateTo string, wg *sync.WaitGroup, ch chan []types.GetLessonsResponseItem, role string) {
-	defer wg.Done()
-	var allLessons []types.GetLessonsResponseItem
-	p := page
-
-	for {
-		var requestBody types.GetLessonsPayload
-
-		if role == "student" {
-			requestBody = types.GetLessonsPayload{
-				CustomerID: id,
-				Status:     status,
-				Page:       p,
-				DateTo:     dateTo,
-				DateFrom:   dateFrom,
-			}
-		} else if role == "teacher" {
-			requestBody = types.GetLessonsPayload{
-				TeacherID: id,
-				Status:    status,
-				Page:      p,
-				DateTo:    dateTo,
-				DateFrom:  dateFrom,
-			}
-		}
-		// Формируем тело запроса
-
-		// парсинг тела запроса в json
-		reqBodyJSON, err := json.Marshal(requestBody)
-		if err != nil {
-			log.Printf("error marshalling request body: %v", err)
-			//return nil, fmt.Errorf("error marshalling request body: %v", err)
-		}
-
-		// создание запроса
-		req, err := http.NewRequest("POST",
-			"https://centriym.s20.online/v2api/1/lesson/index",
-			bytes.NewBuffer(reqBodyJSON))
-		if err != nil {
-			log.Printf("error creating reques: %v", err)
-			//return nil, fmt.Errorf("error creating request: %v", err)
-		}
-
-		// установка залоговков
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("X-ALFACRM-TOKEN", alphaToken)
-
-		//  создание клиента и отправка post запроса
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			log.Printf("error sending request: %v", err)
-			//return nil, fmt.Errorf("error sending request: %v", err)
-		}
-		defer resp.Body.Close()
-
-		// чтение тела запроса
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			log.Printf("error reading response: %v", err)
-			//return nil, fmt.Errorf("error reading response: %v", err)
-		}
-
-		// создание структуры ответа от сервера и распарсинг ответа
-		var lessonsResponse types.GetLessonsResponse
-		if err := json.Unmarshal(body, &lessonsResponse); err != nil {
-			log.Printf("error parsing response: %v", err)
-			//return nil, fmt.Errorf("error parsing response: %v", err)
-		}
-
-		// добавление уроков во все уроки путем распаковки lessonsResponse.Items
-		allLessons = append(allLessons, lessonsResponse.Items...)
-
-		// условие, чтобы остановить цикл
-		if len(lessonsResponse.Items) == 0 || lessonsResponse.Count == 0 {
-			break
-		}
-		p++
+// FindLesson ищет урок в локальной копии, затем в AlfaCRM, например если урок создан
+// после последней синхронизации. Если урока нет, возвращает alpha.ErrNotFound.
+func FindLesson(ctx context.Context, store types.LessonStore, crm alpha.API, tenantID, lessonID int) (*types.GetLessonsResponseItem, error) {
+	lesson, err := store.GetLesson(tenantID, lessonID)
+	if err != nil || lesson != nil {
+		return lesson, err
 	}
-	ch <- allLessons
+	return crm.GetLesson(ctx, lessonID)
 }
 
-func GetLessonByIDAlpha(lessonID int) (*types.GetLessonsResponseItem, error) {
-	token, err := alpha.GetAlphaToken()
-	if err != nil {
-		return nil, fmt.Errorf("error getting alha token")
-	}
-
-	data := map[string]any{
-		"id": lessonID,
-	}
-
-	var lesson types.GetLessonsResponseItem
-
-	reqBodyJSON, err := json.Marshal(data)
-	if err != nil {
-		log.Printf("error marshalling request body: %v", err)
-		return nil, fmt.Errorf("error marshalling request body: %v", err)
-	}
-
-	req, err := http.NewRequest("POST",
-		"https://centriym.s20.online/v2api/1/lesson/index",
-		bytes.NewBuffer(reqBodyJSON))
-	if err != nil {
-		log.Printf("error creating reques: %v", err)
-		return nil, fmt.Errorf("error creating request: %v", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-ALFACRM-TOKEN", token)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("error sending request: %v", err)
-		//return nil, fmt.Errorf("error sending request: %v", err)
+// FetchLessons запрашивает в AlfaCRM уроки пользователя с перечисленными статусами параллельно.
+// role определяет, ищутся уроки ученика (customer_id) или преподавателя (teacher_id).
+// Уроки упорядочены так же, как в локальной копии: по дате, времени начала и ID.
+func FetchLessons(ctx context.Context, crm alpha.API, payload types.GetLessonsPayload, role string, statuses ...int) ([]types.GetLessonsResponseItem, error) {
+	query := types.AlphaLessonQuery{
+		Page:     payload.Page,
+		DateFrom: payload.DateFrom,
+		DateTo:   payload.DateTo,
 	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("error reading response: %v", err)
-		//return nil, fmt.Errorf("error reading response: %v", err)
+	if role == types.RoleTeacher {
+		query.TeacherID = payload.TeacherID
+	} else {
+		query.CustomerID = payload.CustomerID
 	}
 
-	if err := json.Unmarshal(body, &lesson); err != nil {
-		log.Printf("error parsing response: %v", err)
-		return nil, fmt.Errorf("error parsing response: %v", err)
+	results := make([][]types.GetLessonsResponseItem, len(statuses))
+	errs := make([]error, len(statuses))
+	wg := sync.WaitGroup{}
+	wg.Add(len(statuses))
+	for i, status := range statuses {
+		go func(i int, q types.AlphaLessonQuery) {
+			defer wg.Done()
+			results[i], errs[i] = crm.ListLessons(ctx, q)
+		}(i, withStatus(query, status))
 	}
+	wg.Wait()
 
-	return &lesson, nil
-}
-
-func GetStudentTeachersIDs(userID, status, page int, alphaToken string) ([]int, error) {
-	p := page
-
-	now := time.Now().UTC()
-	end := now.Add(30 * (-24 * time.Hour))
-	nowstr := now.Format("2006-01-02")
-	endstr := end.Format("2006-01-02")
-
-	var allLessons []types.GetLessonsResponseItem
-	for {
-		//requestBody := types.GetLessonsPayload{
-		//	CustomerID: userID,
-		//	Status:     status,
-		//	Page:       p,
-		//	DateTo:     nowstr,
-		//	DateFrom:   endstr,
-		//}
-
-		bodyMap := map[string]any{
-			"customer_id": userID,
-			"status":      status,
-			"page":        p,
-			"date_from":   endstr,
-			"date_to":     nowstr,
-		}
-		// парсинг тела запроса в json
-		reqBodyJSON, err := json.Marshal(bodyMap)
-		if err != nil {
-			log.Printf("error marshalling request body: %v", err)
-			//return nil, fmt.Errorf("error marshalling request body: %v", err)
-		}
-
-		// создание запроса
-		req, err := http.NewRequest("POST",
-			"https://centriym.s20.online/v2api/1/lesson/index",
-			bytes.NewBuffer(reqBodyJSON))
-		if err != nil {
-			log.Printf("error creating reques: %v", err)
-			//return nil, fmt.Errorf("error creating request: %v", err)
-		}
-
-		// установка залоговков
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("X-ALFACRM-TOKEN", alphaToken)
-
-		//  создание клиента и отправка post запроса
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			log.Printf("error sending request: %v", err)
-			//return nil, fmt.Errorf("error sending request: %v", err)
+	lessons := make([]types.GetLessonsResponseItem, 0)
+	for i := range statuses {
+		if errs[i] != nil {
+			return nil, errs[i]
 		}
-		defer resp.Body.Close()
-
-		// чтение тела запроса
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			log.Printf("error reading response: %v", err)
-			//return nil, fmt.Errorf("error reading response: %v", err)
+		lessons = append(lessons, results[i]...)
+	}
+	sort.Slice(lessons, func(i, j int) bool {
+		a, b := lessons[i], lessons[j]
+		if a.Date != b.Date {
+			return a.Date < b.Date
 		}
-
-		// создание структуры ответа от сервера и распарсинг ответа
-		var lessonsResponse types.GetLessonsResponse
-		if err := json.Unmarshal(body, &lessonsResponse); err != nil {
-			log.Printf("error parsing response: %v", err)
-			//return nil, fmt.Errorf("error parsing response: %v", err)
+		if a.TimeFrom != b.TimeFrom {
+			// урок без времени начала идет последним в своем дне
+			return b.TimeFrom == "" || (a.TimeFrom != "" && a.TimeFrom < b.TimeFrom)
 		}
+		return a.ID < b.ID
+	})
+	return lessons, nil
+}
 
-		// добавление уроков во все уроки путем распаковки lessonsResponse.Items
-		allLessons = append(allLessons, lessonsResponse.Items...)
+// TeacherDays - за сколько последних дней уроки ученика определяют его преподавателей
+const TeacherDays = 30
 
-		// условие, чтобы остановить цикл
-		if len(lessonsResponse.Items) == 0 || lessonsResponse.Count == 0 {
-			break
-		}
-		p++
-	}
-	teachersMap := make(map[int]struct{})
-	for _, v := range allLessons {
-		teachersMap[v.TeacherIDs[0]] = struct{}{}
+// StudentTeacherIDs возвращает преподавателей, которые провели уроки ученику за последние TeacherDays дней.
+func StudentTeacherIDs(ctx context.Context, crm alpha.API, studentID int) ([]int, error) {
+	now := time.Now().UTC()
+	lessons, err := crm.ListLessons(ctx, types.AlphaLessonQuery{
+		CustomerID: studentID,
+		Status:     3,
+		DateFrom:   now.AddDate(0, 0, -TeacherDays).Format("2006-01-02"),
+		DateTo:     now.Format("2006-01-02"),
+	})
+	if err != nil {
+		return nil, err
 	}
 
+	seen := make(map[int]bool)
 	teachersIds := make([]int, 0)
-	for id, _ := range teachersMap {
-		teachersIds = append(teachersIds, id)
+	for _, v := range lessons {
+		for _, id := range v.TeacherIDs {
+			if !seen[id] {
+				seen[id] = true
+				teachersIds = append(teachersIds, id)
+			}
+		}
 	}
-
 	return teachersIds, nil
 }
+
+func withStatus(query types.AlphaLessonQuery, status int) types.AlphaLessonQuery {
+	query.Status = status
+	return query
+}
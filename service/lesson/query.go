@@ -0,0 +1,161 @@
+package lesson
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/prok05/ecom/types"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// lessonFields - поля урока, которые можно запросить через fields, по json-тегам GetLessonsResponseItem
+var lessonFields = jsonFields(reflect.TypeOf(types.GetLessonsResponseItem{}))
+
+// lessonQuery - разобранные параметры GET /lessons.
+type lessonQuery struct {
+	filter    types.LessonFilter
+	studentID int
+	teacherID int
+	fields    []string
+}
+
+// parseLessonQuery разбирает фильтры, сортировку, курсор и поля из параметров запроса.
+func parseLessonQuery(values url.Values) (*lessonQuery, error) {
+	q := &lessonQuery{filter: types.LessonFilter{Limit: defaultPageSize}}
+
+	if v := values.Get("status"); v != "" {
+		for _, s := range strings.Split(v, ",") {
+			status, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil || status < 1 || status > 3 {
+				return nil, fmt.Errorf("invalid status %q, expected 1, 2 or 3", s)
+			}
+			q.filter.Statuses = append(q.filter.Statuses, status)
+		}
+	}
+
+	q.filter.DateFrom = values.Get("date_from")
+	q.filter.DateTo = values.Get("date_to")
+	if !validDate(q.filter.DateFrom) || !validDate(q.filter.DateTo) {
+		return nil, fmt.Errorf("invalid date, expected YYYY-MM-DD")
+	}
+
+	for name, dest := range map[string]*int{
+		"subject_id": &q.filter.SubjectID,
+		"student_id": &q.studentID,
+		"teacher_id": &q.teacherID,
+	} {
+		if v := values.Get(name); v != "" {
+			id, err := strconv.Atoi(v)
+			if err != nil || id <= 0 {
+				return nil, fmt.Errorf("invalid %s", name)
+			}
+			*dest = id
+		}
+	}
+
+	switch values.Get("sort") {
+	case "", "date":
+	case "-date":
+		q.filter.Desc = true
+	default:
+		return nil, fmt.Errorf("invalid sort, expected date or -date")
+	}
+
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxPageSize {
+			return nil, fmt.Errorf("invalid limit, expected 1..%d", maxPageSize)
+		}
+		q.filter.Limit = limit
+	}
+
+	if v := values.Get("cursor"); v != "" {
+		cursor, err := decodeCursor(v)
+		if err != nil || cursor.Desc != q.filter.Desc {
+			return nil, fmt.Errorf("invalid cursor")
+		}
+		q.filter.After = cursor
+	}
+
+	if v := values.Get("fields"); v != "" {
+		for _, f := range strings.Split(v, ",") {
+			f = strings.TrimSpace(f)
+			if !lessonFields[f] {
+				return nil, fmt.Errorf("unknown field %q", f)
+			}
+			q.fields = append(q.fields, f)
+		}
+	}
+
+	return q, nil
+}
+
+func encodeCursor(lesson types.GetLessonsResponseItem, desc bool) string {
+	data, _ := json.Marshal(types.LessonCursor{
+		Date:     lesson.Date,
+		TimeFrom: lesson.TimeFrom,
+		ID:       lesson.ID,
+		Desc:     desc,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(value string) (*types.LessonCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	var cursor types.LessonCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	if !validDate(cursor.Date) || cursor.ID == 0 {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	if cursor.TimeFrom != "" {
+		if _, err := time.Parse("2006-01-02 15:04:05", cursor.TimeFrom); err != nil {
+			return nil, fmt.Errorf("invalid cursor")
+		}
+	}
+	return &cursor, nil
+}
+
+// selectFields оставляет в уроке только запрошенные поля, ID урока возвращается всегда.
+func selectFields(lesson types.GetLessonsResponseItem, fields []string) (map[string]any, error) {
+	data, err := json.Marshal(lesson)
+	if err != nil {
+		return nil, err
+	}
+	var all map[string]any
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+
+	selected := map[string]any{"id": lesson.ID}
+	for _, f := range fields {
+		if v, ok := all[f]; ok {
+			selected[f] = v
+		}
+	}
+	return selected, nil
+}
+
+func jsonFields(t reflect.Type) map[string]bool {
+	fields := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}
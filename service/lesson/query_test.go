@@ -0,0 +1,121 @@
+package lesson
+
+import (
+	"encoding/base64"
+	"github.com/prok05/ecom/types"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestParseLessonQuery(t *testing.T) {
+	cursor := encodeCursor(types.GetLessonsResponseItem{ID: 7, Date: "2025-03-01", TimeFrom: "2025-03-01 10:00:00"}, false)
+	descCursor := encodeCursor(types.GetLessonsResponseItem{ID: 7, Date: "2025-03-01"}, true)
+
+	tests := []struct {
+		name    string
+		query   string
+		want    *lessonQuery
+		wantErr bool
+	}{
+		{name: "defaults", query: "", want: &lessonQuery{filter: types.LessonFilter{Limit: defaultPageSize}}},
+		{
+			name:  "all filters",
+			query: "status=1,3&date_from=2025-03-01&date_to=2025-03-31&subject_id=4&student_id=5&teacher_id=6&sort=-date&limit=10&fields=date,topic",
+			want: &lessonQuery{
+				filter: types.LessonFilter{
+					Statuses: []int{1, 3}, DateFrom: "2025-03-01", DateTo: "2025-03-31",
+					SubjectID: 4, Desc: true, Limit: 10,
+				},
+				studentID: 5,
+				teacherID: 6,
+				fields:    []string{"date", "topic"},
+			},
+		},
+		{
+			name:  "cursor",
+			query: "cursor=" + cursor,
+			want: &lessonQuery{filter: types.LessonFilter{
+				Limit: defaultPageSize,
+				After: &types.LessonCursor{Date: "2025-03-01", TimeFrom: "2025-03-01 10:00:00", ID: 7},
+			}},
+		},
+		{name: "unknown status", query: "status=4", wantErr: true},
+		{name: "invalid status", query: "status=1,x", wantErr: true},
+		{name: "invalid date", query: "date_from=01.03.2025", wantErr: true},
+		{name: "invalid id", query: "student_id=0", wantErr: true},
+		{name: "invalid sort", query: "sort=topic", wantErr: true},
+		{name: "limit too large", query: "limit=201", wantErr: true},
+		{name: "zero limit", query: "limit=0", wantErr: true},
+		{name: "unknown field", query: "fields=date,secret", wantErr: true},
+		{name: "broken cursor", query: "cursor=abc", wantErr: true},
+		// курсор выдан для другого направления сортировки
+		{name: "cursor of other sort", query: "cursor=" + descCursor, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := parseLessonQuery(values)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseLessonQuery: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCursor(t *testing.T) {
+	tests := []struct {
+		name   string
+		lesson types.GetLessonsResponseItem
+		desc   bool
+	}{
+		{"with time", types.GetLessonsResponseItem{ID: 1, Date: "2025-03-01", TimeFrom: "2025-03-01 09:30:00"}, false},
+		{"without time", types.GetLessonsResponseItem{ID: 2, Date: "2025-03-02"}, false},
+		{"descending", types.GetLessonsResponseItem{ID: 3, Date: "2025-03-03"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor, err := decodeCursor(encodeCursor(tt.lesson, tt.desc))
+			if err != nil {
+				t.Fatalf("decodeCursor: %v", err)
+			}
+			want := types.LessonCursor{Date: tt.lesson.Date, TimeFrom: tt.lesson.TimeFrom, ID: tt.lesson.ID, Desc: tt.desc}
+			if *cursor != want {
+				t.Errorf("got %+v, want %+v", *cursor, want)
+			}
+		})
+	}
+}
+
+func TestDecodeInvalidCursor(t *testing.T) {
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"not base64", "!!!"},
+		{"not json", encode("lesson 1")},
+		{"no id", encode(`{"d":"2025-03-01"}`)},
+		{"invalid date", encode(`{"d":"March","id":1}`)},
+		{"invalid time", encode(`{"d":"2025-03-01","t":"10:00","id":1}`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if cursor, err := decodeCursor(tt.value); err == nil {
+				t.Errorf("decoded invalid cursor %+v", cursor)
+			}
+		})
+	}
+}
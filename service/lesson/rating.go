@@ -0,0 +1,146 @@
+package lesson
+
+import (
+	"fmt"
+	"github.com/prok05/ecom/service/tenant"
+	"github.com/prok05/ecom/types"
+	"github.com/prok05/ecom/utils"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Сводка оценок школы: распределение, средние по категориям и динамика.
+// ?group_by=teacher|subject|week|month (по умолчанию teacher), фильтры как у GET /lessons/rating.
+// Для преподавателей и предметов динамика считается к предыдущему периоду той же длины,
+// поэтому нужны date_from и date_to, для недель и месяцев - к предыдущей группе
+func (h *Handler) handleGetLessonRateStats(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseRateFilter(r.URL.Query())
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	filter.TenantID = tenant.FromContext(r.Context()).ID
+
+	groupBy := r.URL.Query().Get("group_by")
+	switch groupBy {
+	case "":
+		groupBy = types.LessonRateGroupByTeacher
+	case types.LessonRateGroupByTeacher, types.LessonRateGroupBySubject, types.LessonRateGroupByWeek, types.LessonRateGroupByMonth:
+	default:
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid group_by, expected teacher, subject, week or month"))
+		return
+	}
+
+	groups, err := h.lessonStore.GetLessonRateStats(filter, groupBy)
+	if err != nil {
+		log.Printf("error getting rate stats: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get lesson rate stats"))
+		return
+	}
+
+	switch {
+	case groupBy == types.LessonRateGroupByWeek || groupBy == types.LessonRateGroupByMonth:
+		for i := 1; i < len(groups); i++ {
+			groups[i].Trend = trend(groups[i].Average, groups[i-1].Average)
+		}
+	case filter.DateFrom != nil && filter.DateTo != nil:
+		previous := filter
+		days := int(filter.DateTo.Sub(*filter.DateFrom).Hours()/24) + 1
+		to := filter.DateFrom.AddDate(0, 0, -1)
+		from := to.AddDate(0, 0, 1-days)
+		previous.DateFrom, previous.DateTo = &from, &to
+
+		before, err := h.lessonStore.GetLessonRateStats(previous, groupBy)
+		if err != nil {
+			log.Printf("error getting previous rate stats: %v", err)
+			utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get lesson rate stats"))
+			return
+		}
+		averages := make(map[string]float64, len(before))
+		for _, g := range before {
+			averages[g.Key] = g.Average
+		}
+		for i := range groups {
+			if average, ok := averages[groups[i].Key]; ok {
+				groups[i].Trend = trend(groups[i].Average, average)
+			}
+		}
+	}
+
+	for i := range groups {
+		groups[i].Average = round(groups[i].Average)
+		for category, average := range groups[i].Categories {
+			groups[i].Categories[category] = round(average)
+		}
+	}
+
+	stats := types.LessonRateStats{GroupBy: groupBy, Groups: groups}
+	if filter.DateFrom != nil {
+		stats.DateFrom = filter.DateFrom.Format("2006-01-02")
+	}
+	if filter.DateTo != nil {
+		stats.DateTo = filter.DateTo.Format("2006-01-02")
+	}
+	utils.WriteJSON(w, http.StatusOK, stats)
+}
+
+func parseRateFilter(values url.Values) (types.LessonRateFilter, error) {
+	var filter types.LessonRateFilter
+	for name, dest := range map[string]*int{
+		"teacher_id": &filter.TeacherID,
+		"subject_id": &filter.SubjectID,
+	} {
+		if v := values.Get(name); v != "" {
+			id, err := strconv.Atoi(v)
+			if err != nil || id <= 0 {
+				return filter, fmt.Errorf("invalid %s", name)
+			}
+			*dest = id
+		}
+	}
+	for name, dest := range map[string]**time.Time{
+		"date_from": &filter.DateFrom,
+		"date_to":   &filter.DateTo,
+	} {
+		if v := values.Get(name); v != "" {
+			date, err := time.Parse("2006-01-02", v)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s, expected YYYY-MM-DD", name)
+			}
+			*dest = &date
+		}
+	}
+	if filter.DateFrom != nil && filter.DateTo != nil && filter.DateTo.Before(*filter.DateFrom) {
+		return filter, fmt.Errorf("date_to is before date_from")
+	}
+	return filter, nil
+}
+
+// lessonPeriod - день урока и время его окончания в часовом поясе школы.
+// Урок без времени окончания считается закончившимся в конце дня.
+func lessonPeriod(lesson *types.GetLessonsResponseItem, loc *time.Location) (date, end time.Time, err error) {
+	date, err = time.ParseInLocation("2006-01-02", lesson.Date, loc)
+	if err != nil {
+		return date, end, err
+	}
+	if lesson.TimeTo != "" {
+		if end, err := time.ParseInLocation("2006-01-02 15:04:05", lesson.TimeTo, loc); err == nil {
+			return date, end, nil
+		}
+	}
+	return date, date.AddDate(0, 0, 1), nil
+}
+
+func trend(average, previous float64) *float64 {
+	delta := round(average - previous)
+	return &delta
+}
+
+// round - до сотых
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
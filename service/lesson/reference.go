@@ -0,0 +1,49 @@
+package lesson
+
+import (
+	"github.com/prok05/ecom/types"
+)
+
+// EnrichLessons подставляет в уроки названия предмета, аудитории и групп из справочников школы.
+func EnrichLessons(refs types.ReferenceStore, tenantID int, lessons []types.GetLessonsResponseItem) error {
+	subjects, err := refs.GetSubjects(tenantID)
+	if err != nil {
+		return err
+	}
+	rooms, err := refs.GetRooms(tenantID)
+	if err != nil {
+		return err
+	}
+	groups, err := refs.GetGroups(tenantID)
+	if err != nil {
+		return err
+	}
+
+	subjectNames := names(subjects)
+	roomNames := names(rooms)
+	groupItems := make(map[int]types.ReferenceItem, len(groups))
+	for _, g := range groups {
+		groupItems[g.ID] = g.ReferenceItem
+	}
+
+	for i := range lessons {
+		l := &lessons[i]
+		l.SubjectName = subjectNames[l.SubjectID]
+		l.RoomName = roomNames[l.RoomID]
+		l.Groups = make([]types.ReferenceItem, 0, len(l.GroupIDs))
+		for _, id := range l.GroupIDs {
+			if g, ok := groupItems[id]; ok {
+				l.Groups = append(l.Groups, g)
+			}
+		}
+	}
+	return nil
+}
+
+func names(items []types.ReferenceItem) map[int]string {
+	m := make(map[int]string, len(items))
+	for _, item := range items {
+		m[item.ID] = item.Name
+	}
+	return m
+}
@@ -1,210 +1,171 @@
 package lesson
 
 import (
+	"errors"
 	"fmt"
+	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
-	"github.com/prok05/ecom/cache"
 	"github.com/prok05/ecom/service/alpha"
+	"github.com/prok05/ecom/service/auth"
+	"github.com/prok05/ecom/service/tenant"
 	"github.com/prok05/ecom/types"
 	"github.com/prok05/ecom/utils"
 	"log"
 	"net/http"
-	"sync"
+	"strings"
+	"time"
 )
 
 type Handler struct {
-	lessonStore   types.LessonStore
-	homeworkStore types.HomeworkStore
-	chatStore     types.LessonStore
-	tokenCache    *cache.TokenCache
+	lessonStore     types.LessonStore
+	homeworkStore   types.HomeworkStore
+	chatStore       types.LessonStore
+	attendanceStore types.AttendanceStore
+	materialStore   types.MaterialStore
+	authorizer      *auth.Authorizer
+	crm             alpha.Provider
+	syncer          *Syncer
+	refs            types.ReferenceStore
+	// ratingWindow - сколько после окончания урока его можно оценить
+	ratingWindow time.Duration
 }
 
-func NewHandler(homeworkStore types.HomeworkStore, lessonStore types.LessonStore, tokenCache *cache.TokenCache) *Handler {
+func NewHandler(homeworkStore types.HomeworkStore, lessonStore types.LessonStore, attendanceStore types.AttendanceStore, materialStore types.MaterialStore, authorizer *auth.Authorizer, crm alpha.Provider, syncer *Syncer, refs types.ReferenceStore, ratingWindow time.Duration) *Handler {
 	return &Handler{
-		lessonStore:   lessonStore,
-		homeworkStore: homeworkStore,
-		tokenCache:    tokenCache,
+		lessonStore:     lessonStore,
+		homeworkStore:   homeworkStore,
+		attendanceStore: attendanceStore,
+		materialStore:   materialStore,
+		authorizer:      authorizer,
+		crm:             crm,
+		syncer:          syncer,
+		refs:            refs,
+		ratingWindow:    ratingWindow,
 	}
 }
 
 func (h *Handler) RegisterRoutes(router *mux.Router) {
-	router.HandleFunc("/lessons/student", h.handleGetAllLessonsStudent).Methods(http.MethodPost)
-	router.HandleFunc("/lessons/homework/student", h.handleGetLessonsHomeworkStudent).Methods(http.MethodPost)
-	router.HandleFunc("/lessons/teacher", h.handleGetAllLessonsTeacher).Methods(http.MethodPost)
-	router.HandleFunc("/lessons/homework/teacher", h.handleGetLessonsHomeworkTeacher).Methods(http.MethodPost)
-
-	router.HandleFunc("/lessons/rating", h.handleGetLessonRates).Methods(http.MethodGet)
-	router.HandleFunc("/lessons/rating", h.handleRateLesson).Methods(http.MethodPost)
+	router.HandleFunc("/lessons", h.authorizer.RequirePermissions(h.handleGetLessons, auth.PermLessonRead)).Methods(http.MethodGet)
+	router.HandleFunc("/lessons/student", h.authorizer.RequirePermissions(h.handleGetAllLessonsStudent, auth.PermLessonRead)).Methods(http.MethodPost)
+	router.HandleFunc("/lessons/homework/student", h.authorizer.RequirePermissions(h.handleGetLessonsHomeworkStudent, auth.PermLessonRead)).Methods(http.MethodPost)
+	router.HandleFunc("/lessons/teacher", h.authorizer.RequirePermissions(h.handleGetAllLessonsTeacher, auth.PermLessonRead)).Methods(http.MethodPost)
+	router.HandleFunc("/lessons/homework/teacher", h.authorizer.RequirePermissions(h.handleGetLessonsHomeworkTeacher, auth.PermLessonRead)).Methods(http.MethodPost)
+
+	router.HandleFunc("/lessons/rating", h.authorizer.RequirePermissions(h.handleGetLessonRates, auth.PermLessonRatingsRead)).Methods(http.MethodGet)
+	router.HandleFunc("/lessons/rating", h.authorizer.RequirePermissions(h.handleRateLesson, auth.PermLessonRate)).Methods(http.MethodPost)
+	router.HandleFunc("/lessons/rating/stats", h.authorizer.RequirePermissions(h.handleGetLessonRateStats, auth.PermLessonRatingsRead)).Methods(http.MethodGet)
+
+	router.HandleFunc("/lessons/sync", h.authorizer.RequirePermissions(h.handleGetSyncState, auth.PermLessonSync)).Methods(http.MethodGet)
+	router.HandleFunc("/lessons/sync", h.authorizer.RequirePermissions(h.handleRunSync, auth.PermLessonSync)).Methods(http.MethodPost)
 }
 
-func (h *Handler) handleGetAllLessonsStudent(w http.ResponseWriter, r *http.Request) {
-	var payload types.GetLessonsPayload
-	if err := utils.ParseJSON(r, &payload); err != nil {
+// Уроки из локальной копии с фильтрами, сортировкой по дате и выдачей страницами по курсору.
+// Ученик получает только свои уроки, преподаватель - только те, которые ведет.
+// Параметры: status=1,3, date_from, date_to, subject_id, teacher_id, student_id,
+// sort=date|-date, limit, cursor (next_cursor предыдущей страницы), fields=id,date,topic
+func (h *Handler) handleGetLessons(w http.ResponseWriter, r *http.Request) {
+	q, err := parseLessonQuery(r.URL.Query())
+	if err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
+		return
 	}
 
-	// получение токена alpha CRM
-	//alphaToken, err := alpha.GetAlphaToken()
-	//if err != nil {
-	//	log.Printf("error getting alpha token: %v\n", err)
-	//	utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("error getting alpha token"))
-	//	return
-	//}
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	q.filter.TenantID = principal.TenantID
+	q.filter.CustomerID = q.studentID
+	q.filter.TeacherID = q.teacherID
+	switch principal.Role {
+	case types.RoleStudent:
+		if q.studentID != 0 && q.studentID != principal.UserID {
+			utils.WriteError(w, http.StatusForbidden, fmt.Errorf("permission denied"))
+			return
+		}
+		q.filter.CustomerID = principal.UserID
+	case types.RoleTeacher:
+		if q.teacherID != 0 && q.teacherID != principal.UserID {
+			utils.WriteError(w, http.StatusForbidden, fmt.Errorf("permission denied"))
+			return
+		}
+		q.filter.TeacherID = principal.UserID
+	}
 
-	alphaToken, err := h.tokenCache.GetToken()
+	state, err := h.lessonStore.GetLessonSyncState(principal.TenantID)
 	if err != nil {
-		log.Printf("error getting alpha token: %v\n", err)
-		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("error getting alpha token"))
+		log.Printf("error getting lesson sync state: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get lessons"))
 		return
 	}
-
-	lessons := []types.GetLessonsResponseItem{}
-	lessonsCh := make(chan []types.GetLessonsResponseItem, 3)
-	wg := sync.WaitGroup{}
-	wg.Add(3)
-
-	go GetAlphaLessons(
-		payload.CustomerID,
-		1,
-		payload.Page,
-		alphaToken,
-		payload.DateFrom,
-		payload.DateTo,
-		&wg,
-		lessonsCh,
-		"student")
-	go GetAlphaLessons(
-		payload.CustomerID,
-		2,
-		payload.Page,
-		alphaToken,
-		payload.DateFrom,
-		payload.DateTo,
-		&wg,
-		lessonsCh,
-		"student")
-	go GetAlphaLessons(
-		payload.CustomerID,
-		3,
-		payload.Page,
-		alphaToken,
-		payload.DateFrom,
-		payload.DateTo,
-		&wg,
-		lessonsCh,
-		"student")
-
-	wg.Wait()
-	close(lessonsCh)
-	for v := range lessonsCh {
-		lessons = append(lessons, v...)
+	if state == nil || state.SyncedAt == nil {
+		utils.WriteError(w, http.StatusServiceUnavailable, fmt.Errorf("lessons are not synced yet"))
+		return
 	}
-
-	// ответ запроса
-	utils.WriteJSON(w, http.StatusOK, types.AllFutureLessonsResponse{
-		Count: len(lessons),
-		Items: lessons,
-	})
-}
-
-func (h *Handler) handleGetAllLessonsTeacher(w http.ResponseWriter, r *http.Request) {
-	var payload types.GetLessonsPayload
-	if err := utils.ParseJSON(r, &payload); err != nil {
-		utils.WriteError(w, http.StatusBadRequest, err)
+	if !h.syncer.Mirrored(state, q.filter.DateFrom) {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("date_from is before the synced period"))
+		return
 	}
 
-	// получение токена alpha CRM
-	alphaToken, err := h.tokenCache.GetToken()
+	// лишний урок показывает, есть ли следующая страница
+	limit := q.filter.Limit
+	q.filter.Limit = limit + 1
+	lessons, err := h.lessonStore.ListLessons(q.filter)
 	if err != nil {
-		log.Printf("error getting alpha token: %v\n", err)
-		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("error getting alpha token"))
+		log.Printf("error getting lessons: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get lessons"))
 		return
 	}
 
-	lessons := []types.GetLessonsResponseItem{}
-	lessonsCh := make(chan []types.GetLessonsResponseItem, 3)
-	wg := sync.WaitGroup{}
-	wg.Add(3)
-
-	go GetAlphaLessons(
-		payload.TeacherID,
-		1,
-		payload.Page,
-		alphaToken,
-		payload.DateFrom,
-		payload.DateTo,
-		&wg,
-		lessonsCh,
-		"teacher")
-	go GetAlphaLessons(
-		payload.TeacherID,
-		2,
-		payload.Page,
-		alphaToken,
-		payload.DateFrom,
-		payload.DateTo,
-		&wg,
-		lessonsCh,
-		"teacher")
-	go GetAlphaLessons(
-		payload.TeacherID,
-		3,
-		payload.Page,
-		alphaToken,
-		payload.DateFrom,
-		payload.DateTo,
-		&wg,
-		lessonsCh,
-		"teacher")
-
-	wg.Wait()
-	close(lessonsCh)
-	for v := range lessonsCh {
-		lessons = append(lessons, v...)
+	page := types.LessonPage{SyncedAt: state.SyncedAt, Stale: h.syncer.Stale(state)}
+	if len(lessons) > limit {
+		lessons = lessons[:limit]
+		page.NextCursor = encodeCursor(lessons[limit-1], q.filter.Desc)
 	}
+	h.enrich(principal.TenantID, lessons)
 
-	// ответ запроса
-	utils.WriteJSON(w, http.StatusOK, types.AllFutureLessonsResponse{
-		Count: len(lessons),
-		Items: lessons,
-	})
+	page.Items = make([]any, len(lessons))
+	for i, lesson := range lessons {
+		if len(q.fields) == 0 {
+			page.Items[i] = lesson
+			continue
+		}
+		item, err := selectFields(lesson, q.fields)
+		if err != nil {
+			log.Printf("error selecting lesson fields: %v", err)
+			utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get lessons"))
+			return
+		}
+		page.Items[i] = item
+	}
+	page.Count = len(page.Items)
+
+	utils.WriteJSON(w, http.StatusOK, page)
 }
 
-func (h *Handler) handleGetLessonsHomeworkStudent(w http.ResponseWriter, r *http.Request) {
-	var payload types.GetLessonsPayload
-	if err := utils.ParseJSON(r, &payload); err != nil {
-		utils.WriteError(w, http.StatusBadRequest, err)
+func (h *Handler) handleGetAllLessonsStudent(w http.ResponseWriter, r *http.Request) {
+	resp, _, ok := h.listLessons(w, r, types.RoleStudent, 1, 2, 3)
+	if !ok {
 		return
 	}
 
-	// получение токена alpha CRM
-	alphaToken, err := alpha.GetAlphaToken()
-	if err != nil {
-		log.Printf("error getting alpha token: %v\n", err)
-		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("error getting alpha token"))
+	// ответ запроса
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+func (h *Handler) handleGetAllLessonsTeacher(w http.ResponseWriter, r *http.Request) {
+	resp, _, ok := h.listLessons(w, r, types.RoleTeacher, 1, 2, 3)
+	if !ok {
 		return
 	}
-	lessons := []types.GetLessonsResponseItem{}
-	lessonsCh := make(chan []types.GetLessonsResponseItem, 3)
-	wg := sync.WaitGroup{}
-	wg.Add(1)
 
-	go GetAlphaLessons(
-		payload.CustomerID,
-		3,
-		payload.Page,
-		alphaToken,
-		payload.DateFrom,
-		payload.DateTo,
-		&wg,
-		lessonsCh,
-		"student")
+	// ответ запроса
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
 
-	wg.Wait()
-	close(lessonsCh)
-	for v := range lessonsCh {
-		lessons = append(lessons, v...)
+func (h *Handler) handleGetLessonsHomeworkStudent(w http.ResponseWriter, r *http.Request) {
+	resp, payload, ok := h.listLessons(w, r, types.RoleStudent, 3)
+	if !ok {
+		return
 	}
+	lessons := resp.Items
 
 	lessonIDs := make([]int, len(lessons))
 	for i, lesson := range lessons {
@@ -230,56 +191,112 @@ func (h *Handler) handleGetLessonsHomeworkStudent(w http.ResponseWriter, r *http
 	}
 
 	// ответ запроса
-	utils.WriteJSON(w, http.StatusOK, types.AllFutureLessonsResponse{
-		Count: len(lessons),
-		Items: lessons,
-	})
+	utils.WriteJSON(w, http.StatusOK, resp)
 }
 
 func (h *Handler) handleGetLessonsHomeworkTeacher(w http.ResponseWriter, r *http.Request) {
+	resp, _, ok := h.listLessons(w, r, types.RoleTeacher, 3)
+	if !ok {
+		return
+	}
+
+	// ответ запроса
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// listLessons разбирает фильтр из тела запроса и получает уроки из локальной копии.
+// Пока школа не синхронизирована или период старше копии, уроки берутся из AlfaCRM.
+// При ошибке сам пишет ответ и возвращает false.
+func (h *Handler) listLessons(w http.ResponseWriter, r *http.Request, role string, statuses ...int) (*types.AllFutureLessonsResponse, *types.GetLessonsPayload, bool) {
 	var payload types.GetLessonsPayload
 	if err := utils.ParseJSON(r, &payload); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
-		return
+		return nil, nil, false
+	}
+	if !validDate(payload.DateFrom) || !validDate(payload.DateTo) {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid date, expected YYYY-MM-DD"))
+		return nil, nil, false
+	}
+
+	if !ownLessonsOnly(w, r, &payload, role) {
+		return nil, nil, false
 	}
 
-	// получение токена alpha CRM
-	alphaToken, err := alpha.GetAlphaToken()
+	t := tenant.FromContext(r.Context())
+	state, err := h.lessonStore.GetLessonSyncState(t.ID)
 	if err != nil {
-		log.Printf("error getting alpha token: %v\n", err)
-		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("error getting alpha token"))
-		return
+		log.Printf("error getting lesson sync state: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get lessons"))
+		return nil, nil, false
 	}
 
-	lessons := []types.GetLessonsResponseItem{}
-	lessonsCh := make(chan []types.GetLessonsResponseItem, 3)
-	wg := sync.WaitGroup{}
-	wg.Add(1)
+	if h.syncer.Mirrored(state, payload.DateFrom) {
+		return h.mirroredLessons(w, t.ID, state, &payload, role, h.syncer.Stale(state), statuses...)
+	}
 
-	go GetAlphaLessons(
-		payload.TeacherID,
-		3,
-		payload.Page,
-		alphaToken,
-		payload.DateFrom,
-		payload.DateTo,
-		&wg,
-		lessonsCh,
-		"teacher")
+	crm := h.crm.For(tenant.Account(r.Context()))
+	lessons, err := FetchLessons(r.Context(), crm, payload, role, statuses...)
+	if err != nil {
+		log.Printf("error getting lessons from alpha: %v", err)
+		// период старше копии, но лучше отдать то, что в ней есть, чем ничего
+		if state != nil && state.SyncedAt != nil {
+			return h.mirroredLessons(w, t.ID, state, &payload, role, true, statuses...)
+		}
+		utils.WriteError(w, alpha.HTTPStatus(err), fmt.Errorf("cannot get lessons from CRM"))
+		return nil, nil, false
+	}
+	h.enrich(t.ID, lessons)
+	return &types.AllFutureLessonsResponse{Count: len(lessons), Items: lessons}, &payload, true
+}
 
-	wg.Wait()
-	close(lessonsCh)
-	for v := range lessonsCh {
-		lessons = append(lessons, v...)
+// mirroredLessons отдает уроки из локальной копии.
+func (h *Handler) mirroredLessons(w http.ResponseWriter, tenantID int, state *types.LessonSyncState, payload *types.GetLessonsPayload, role string, stale bool, statuses ...int) (*types.AllFutureLessonsResponse, *types.GetLessonsPayload, bool) {
+	filter := types.LessonFilter{
+		TenantID: tenantID,
+		Statuses: statuses,
+		DateFrom: payload.DateFrom,
+		DateTo:   payload.DateTo,
+	}
+	if role == types.RoleTeacher {
+		filter.TeacherID = payload.TeacherID
+	} else {
+		filter.CustomerID = payload.CustomerID
+	}
+	lessons, err := h.lessonStore.ListLessons(filter)
+	if err != nil {
+		log.Printf("error getting lessons: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get lessons"))
+		return nil, nil, false
 	}
+	h.enrich(tenantID, lessons)
+	return &types.AllFutureLessonsResponse{Count: len(lessons), Items: lessons, SyncedAt: state.SyncedAt, Stale: stale}, payload, true
+}
 
-	// ответ запроса
-	utils.WriteJSON(w, http.StatusOK, types.AllFutureLessonsResponse{
-		Count: len(lessons),
-		Items: lessons,
-	})
+// enrich добавляет названия из справочников и число материалов урока.
+// Без них уроки все равно отдаются.
+func (h *Handler) enrich(tenantID int, lessons []types.GetLessonsResponseItem) {
+	if err := EnrichLessons(h.refs, tenantID, lessons); err != nil {
+		log.Printf("error getting lesson reference data: %v", err)
+	}
+	if len(lessons) == 0 {
+		return
+	}
+	lessonIDs := make([]int, len(lessons))
+	for i, lesson := range lessons {
+		lessonIDs[i] = lesson.ID
+	}
+	counts, err := h.materialStore.CountLessonMaterials(tenantID, lessonIDs)
+	if err != nil {
+		log.Printf("error counting lesson materials: %v", err)
+		return
+	}
+	for i := range lessons {
+		lessons[i].MaterialsCount = counts[lessons[i].ID]
+	}
 }
 
+// Оценка урока учеником: урок проведен, ученик на нем был и с окончания урока
+// прошло не больше ratingWindow. Можно добавить отзыв и оценки по категориям
 func (h *Handler) handleRateLesson(w http.ResponseWriter, r *http.Request) {
 	var payload types.RateLessonPayload
 	if err := utils.ParseJSON(r, &payload); err != nil {
@@ -287,37 +304,190 @@ func (h *Handler) handleRateLesson(w http.ResponseWriter, r *http.Request) {
 		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
 
-	rateExists, err := h.lessonStore.CheckRateExists(payload.StudentID, payload.TeacherID, payload.LessonID)
+	// ученик оценивает урок только от своего имени и только урок,
+	// в котором он участвовал вместе с этим преподавателем
+	payload.StudentID = auth.GetUserIDFromContext(r.Context())
+	tenantID := auth.GetTenantIDFromContext(r.Context())
+
+	lesson, err := h.lessonStore.GetLesson(tenantID, payload.LessonID)
 	if err != nil {
 		log.Println("handleRateLesson:", err)
-		utils.WriteError(w, http.StatusBadRequest, err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot verify lesson"))
+		return
+	}
+	if lesson == nil {
+		// урока еще нет в копии, например он создан после последней синхронизации
+		crm := h.crm.For(tenant.Account(r.Context()))
+		lesson, err = crm.GetLesson(r.Context(), payload.LessonID)
+	}
+	if errors.Is(err, alpha.ErrNotFound) {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("lesson not found"))
+		return
+	}
+	if err != nil {
+		log.Println("handleRateLesson:", err)
+		utils.WriteError(w, alpha.HTTPStatus(err), fmt.Errorf("cannot verify lesson"))
+		return
+	}
+
+	if !containsID(lesson.CustomerIDs, payload.StudentID) || !containsID(lesson.TeacherIDs, payload.TeacherID) {
+		utils.WriteError(w, http.StatusForbidden, fmt.Errorf("permission denied"))
+		return
+	}
+	if lesson.Status != 3 {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("lesson is not completed"))
+		return
+	}
+
+	// без отметки посещаемости участником урока считается ученик из состава урока в CRM
+	attendance, err := h.attendanceStore.GetStudentAttendance(tenantID, lesson.ID, payload.StudentID)
+	if err != nil {
+		log.Println("handleRateLesson:", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot verify attendance"))
+		return
+	}
+	if attendance == types.AttendanceAbsent {
+		utils.WriteError(w, http.StatusForbidden, fmt.Errorf("student did not attend the lesson"))
 		return
 	}
 
+	loc := tenant.Location(tenant.FromContext(r.Context()))
+	date, end, err := lessonPeriod(lesson, loc)
+	if err != nil {
+		log.Printf("handleRateLesson: invalid date of lesson %d: %v", lesson.ID, err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot verify lesson"))
+		return
+	}
+	if time.Since(end) > h.ratingWindow {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("rating period for this lesson is over"))
+		return
+	}
+
+	rateExists, err := h.lessonStore.CheckRateExists(payload.StudentID, payload.TeacherID, payload.LessonID)
+	if err != nil {
+		log.Println("handleRateLesson:", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot verify lesson rate"))
+		return
+	}
 	if rateExists {
 		utils.WriteError(w, http.StatusConflict, fmt.Errorf("lesson rate already exists"))
 		return
-	} else {
-		if err := h.lessonStore.SaveLessonRate(payload.StudentID,
-			payload.TeacherID,
-			payload.LessonID,
-			payload.LessonDate,
-			payload.Rate); err != nil {
-			log.Println("handleRateLesson:", err)
-			utils.WriteError(w, http.StatusInternalServerError, err)
-		}
-		utils.WriteJSON(w, http.StatusCreated, nil)
 	}
+
+	err = h.lessonStore.SaveLessonRate(types.NewLessonRate{
+		TenantID:   tenantID,
+		StudentID:  payload.StudentID,
+		TeacherID:  payload.TeacherID,
+		LessonID:   lesson.ID,
+		SubjectID:  lesson.SubjectID,
+		LessonDate: date,
+		Rate:       payload.Rate,
+		Comment:    strings.TrimSpace(payload.Comment),
+		Scores:     payload.Scores,
+	})
+	if errors.Is(err, ErrRateExists) {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("lesson rate already exists"))
+		return
+	}
+	if err != nil {
+		log.Println("handleRateLesson:", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot save lesson rate"))
+		return
+	}
+	utils.WriteJSON(w, http.StatusCreated, nil)
 }
 
+// Оценки школы с отзывами, ?teacher_id=&subject_id=&date_from=&date_to= (YYYY-MM-DD)
 func (h *Handler) handleGetLessonRates(w http.ResponseWriter, r *http.Request) {
-	rates, err := h.lessonStore.GetLessonRates()
+	filter, err := parseRateFilter(r.URL.Query())
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	filter.TenantID = tenant.FromContext(r.Context()).ID
+
+	rates, err := h.lessonStore.GetLessonRates(filter)
 	if err != nil {
 		log.Printf("error getting rates: %v", err)
-		utils.WriteError(w, http.StatusInternalServerError, err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get lesson rates"))
 		return
 	}
 
 	utils.WriteJSON(w, http.StatusOK, rates)
 }
+
+// Состояние синхронизации уроков школы с AlfaCRM
+func (h *Handler) handleGetSyncState(w http.ResponseWriter, r *http.Request) {
+	tenantID := auth.GetTenantIDFromContext(r.Context())
+	state, err := h.lessonStore.GetLessonSyncState(tenantID)
+	if err != nil {
+		log.Printf("error getting lesson sync state: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get lesson sync state"))
+		return
+	}
+	if state == nil {
+		state = &types.LessonSyncState{TenantID: tenantID}
+	}
+
+	utils.WriteJSON(w, http.StatusOK, state)
+}
+
+// Синхронизация уроков вне расписания. С ?full=true перечитывается весь период полной синхронизации.
+func (h *Handler) handleRunSync(w http.ResponseWriter, r *http.Request) {
+	full := r.URL.Query().Get("full") == "true"
+	state, err := h.syncer.Run(r.Context(), tenant.FromContext(r.Context()), full)
+	if err != nil {
+		if errors.Is(err, ErrSyncInProgress) {
+			utils.WriteError(w, http.StatusConflict, err)
+			return
+		}
+		log.Printf("lesson sync failed: %v", err)
+		utils.WriteError(w, http.StatusBadGateway, fmt.Errorf("lesson sync failed"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, state)
+}
+
+// ownLessonsOnly ограничивает выборку уроков текущим пользователем: ученик и преподаватель
+// получают только свои уроки, независимо от ID в теле запроса. Супервизор может запросить любые.
+func ownLessonsOnly(w http.ResponseWriter, r *http.Request, payload *types.GetLessonsPayload, role string) bool {
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	switch {
+	case principal.Role == types.RoleSupervisor:
+		return true
+	case principal.Role != role:
+		utils.WriteError(w, http.StatusForbidden, fmt.Errorf("permission denied"))
+		return false
+	case role == types.RoleStudent:
+		payload.CustomerID = principal.UserID
+	case role == types.RoleTeacher:
+		payload.TeacherID = principal.UserID
+	}
+	return true
+}
+
+// validDate - пустая строка или дата в формате YYYY-MM-DD.
+func validDate(date string) bool {
+	if date == "" {
+		return true
+	}
+	_, err := time.Parse("2006-01-02", date)
+	return err == nil
+}
+
+func containsID(ids []int, id int) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
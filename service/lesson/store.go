@@ -2,12 +2,27 @@ package lesson
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/prok05/ecom/types"
 	"log"
 	"time"
 )
 
+// lessonColumns - поля урока в формате ответа AlfaCRM, ожидают псевдоним l у таблицы lessons
+const lessonColumns = `
+	l.id, l.status, to_char(l.date, 'YYYY-MM-DD'),
+	COALESCE(to_char(l.time_from, 'YYYY-MM-DD HH24:MI:SS'), ''),
+	COALESCE(to_char(l.time_to, 'YYYY-MM-DD HH24:MI:SS'), ''),
+	COALESCE(l.subject_id, 0), COALESCE(l.room_id, 0), l.topic, l.note, l.streaming, l.homework,
+	ARRAY(SELECT teacher_id FROM lesson_teachers WHERE lesson_id = l.id ORDER BY teacher_id),
+	ARRAY(SELECT customer_id FROM lesson_customers WHERE lesson_id = l.id ORDER BY customer_id),
+	l.group_ids`
+
 type Store struct {
 	pool *pgxpool.Pool
 }
@@ -18,16 +33,59 @@ func NewStore(pool *pgxpool.Pool) *Store {
 	}
 }
 
-func (s *Store) SaveLessonRate(studentID, teacherID, lessonID int, lessonDate time.Time, rate int8) error {
-	query := `INSERT INTO lesson_rates (student_id, teacher_id, lesson_id, lesson_date, rate) VALUES ($1, $2, $3, $4, $5)`
-	_, err := s.pool.Exec(context.Background(),
-		query,
-		studentID, teacherID, lessonID, lessonDate, rate)
+// ErrRateExists - ученик уже оценил урок этого преподавателя.
+var ErrRateExists = errors.New("lesson rate already exists")
+
+// rateCondition - условие по types.LessonRateFilter, параметры $1..$5
+const rateCondition = `lr.tenant_id = $1
+	AND ($2 = 0 OR lr.teacher_id = $2)
+	AND ($3 = 0 OR lr.subject_id = $3)
+	AND ($4::date IS NULL OR lr.lesson_date::date >= $4)
+	AND ($5::date IS NULL OR lr.lesson_date::date <= $5)`
+
+// rateGroupKeys - ключ и название группы для GetLessonRateStats
+var rateGroupKeys = map[string][2]string{
+	types.LessonRateGroupByTeacher: {`lr.teacher_id::text`, `MAX(concat_ws(' ', t.last_name, t.first_name))`},
+	types.LessonRateGroupBySubject: {`COALESCE(lr.subject_id, 0)::text`, `COALESCE(MAX(sb.name), '')`},
+	types.LessonRateGroupByWeek:    {`to_char(date_trunc('week', lr.lesson_date::date), 'YYYY-MM-DD')`, `''`},
+	types.LessonRateGroupByMonth:   {`to_char(date_trunc('month', lr.lesson_date::date), 'YYYY-MM-DD')`, `''`},
+}
+
+func (s *Store) SaveLessonRate(rate types.NewLessonRate) error {
+	ctx := context.Background()
+	tx, err := s.pool.Begin(ctx)
 	if err != nil {
-		log.Println(err)
+		log.Println("Failed to start transaction: ", err)
 		return err
 	}
-	return nil
+	defer tx.Rollback(ctx)
+
+	var rateID int
+	err = tx.QueryRow(ctx,
+		`INSERT INTO lesson_rates (tenant_id, student_id, teacher_id, lesson_id, subject_id, lesson_date, rate, comment)
+		 VALUES ($1, $2, $3, $4, NULLIF($5, 0), $6, $7, $8)
+		 RETURNING id`,
+		rate.TenantID, rate.StudentID, rate.TeacherID, rate.LessonID, rate.SubjectID, rate.LessonDate, rate.Rate, rate.Comment).Scan(&rateID)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return ErrRateExists
+		}
+		log.Println("Failed to insert lesson rate:", err)
+		return err
+	}
+
+	for category, score := range rate.Scores {
+		_, err = tx.Exec(ctx,
+			`INSERT INTO lesson_rate_scores (rate_id, category, score) VALUES ($1, $2, $3)`,
+			rateID, category, score)
+		if err != nil {
+			log.Println("Failed to insert lesson rate score:", err)
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
 }
 
 func (s *Store) CheckRateExists(studentID, teacherID, lessonID int) (bool, error) {
@@ -46,7 +104,7 @@ func (s *Store) CheckRateExists(studentID, teacherID, lessonID int) (bool, error
 	return exists, nil
 }
 
-func (s *Store) GetLessonRates() ([]types.LessonRate, error) {
+func (s *Store) GetLessonRates(filter types.LessonRateFilter) ([]types.LessonRate, error) {
 	query := `
 	SELECT 
         lr.id,
@@ -58,19 +116,25 @@ func (s *Store) GetLessonRates() ([]types.LessonRate, error) {
         t.first_name AS teacher_first_name,
         t.last_name AS teacher_last_name,
         t.middle_name AS teacher_middle_name,
-        lr.lesson_id,
+        lr.lesson_id::text,
         lr.lesson_date,
-        lr.rate
+        lr.rate,
+        lr.subject_id,
+        lr.comment,
+        COALESCE((SELECT jsonb_object_agg(sc.category, sc.score) FROM lesson_rate_scores sc WHERE sc.rate_id = lr.id), '{}'),
+        lr.created_at
     FROM 
         lesson_rates lr
     JOIN 
         users s ON lr.student_id = s.id
     JOIN 
-        users t ON lr.teacher_id = t.id;
-	`
-	rows, err := s.pool.Query(context.Background(), query)
+        users t ON lr.teacher_id = t.id
+    WHERE ` + rateCondition + `
+    ORDER BY lr.lesson_date DESC, lr.id DESC`
+	rows, err := s.pool.Query(context.Background(), query, rateFilterArgs(filter)...)
 	if err != nil {
-		log.Fatalf("Error fetching data: %v", err)
+		log.Printf("Error fetching lesson rates: %v", err)
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -78,6 +142,7 @@ func (s *Store) GetLessonRates() ([]types.LessonRate, error) {
 
 	for rows.Next() {
 		var rate types.LessonRate
+		var scores []byte
 		err = rows.Scan(
 			&rate.ID,
 			&rate.StudentID,
@@ -91,12 +156,377 @@ func (s *Store) GetLessonRates() ([]types.LessonRate, error) {
 			&rate.LessonID,
 			&rate.LessonDate,
 			&rate.Rate,
+			&rate.SubjectID,
+			&rate.Comment,
+			&scores,
+			&rate.CreatedAt,
 		)
 		if err != nil {
-			log.Printf("Error scanning row: %v", err)
-			continue
+			log.Printf("Error scanning lesson rate: %v", err)
+			return nil, err
+		}
+		if err := json.Unmarshal(scores, &rate.Scores); err != nil {
+			return nil, err
 		}
 		rates = append(rates, rate)
 	}
-	return rates, nil
+	return rates, rows.Err()
+}
+
+func (s *Store) GetLessonRateStats(filter types.LessonRateFilter, groupBy string) ([]types.LessonRateGroup, error) {
+	key, ok := rateGroupKeys[groupBy]
+	if !ok {
+		return nil, fmt.Errorf("unknown rate grouping %q", groupBy)
+	}
+	ctx := context.Background()
+	args := rateFilterArgs(filter)
+
+	rows, err := s.pool.Query(ctx,
+		`SELECT `+key[0]+`, `+key[1]+`, COUNT(*), AVG(lr.rate)::float8,
+		        COUNT(*) FILTER (WHERE lr.rate = 1), COUNT(*) FILTER (WHERE lr.rate = 2),
+		        COUNT(*) FILTER (WHERE lr.rate = 3), COUNT(*) FILTER (WHERE lr.rate = 4),
+		        COUNT(*) FILTER (WHERE lr.rate = 5),
+		        COUNT(*) FILTER (WHERE lr.comment <> '')
+		 FROM lesson_rates lr
+		 JOIN users t ON t.id = lr.teacher_id
+		 LEFT JOIN subjects sb ON sb.tenant_id = lr.tenant_id AND sb.id = lr.subject_id
+		 WHERE `+rateCondition+`
+		 GROUP BY 1
+		 ORDER BY 1`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := make([]types.LessonRateGroup, 0)
+	index := make(map[string]int)
+	for rows.Next() {
+		g := types.LessonRateGroup{Categories: make(map[string]float64)}
+		d := &g.Distribution
+		err := rows.Scan(&g.Key, &g.Name, &g.Count, &g.Average, &d[0], &d[1], &d[2], &d[3], &d[4], &g.Comments)
+		if err != nil {
+			return nil, err
+		}
+		index[g.Key] = len(groups)
+		groups = append(groups, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.pool.Query(ctx,
+		`SELECT `+key[0]+`, sc.category, AVG(sc.score)::float8
+		 FROM lesson_rates lr
+		 JOIN lesson_rate_scores sc ON sc.rate_id = lr.id
+		 WHERE `+rateCondition+`
+		 GROUP BY 1, 2`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var groupKey, category string
+		var average float64
+		if err := rows.Scan(&groupKey, &category, &average); err != nil {
+			return nil, err
+		}
+		if i, ok := index[groupKey]; ok {
+			groups[i].Categories[category] = average
+		}
+	}
+	return groups, rows.Err()
+}
+
+func rateFilterArgs(filter types.LessonRateFilter) []any {
+	return []any{filter.TenantID, filter.TeacherID, filter.SubjectID, filter.DateFrom, filter.DateTo}
+}
+
+// lessonOrder - ключ сортировки уроков. Урок без времени начала идет последним в своем дне
+const lessonOrder = `(l.date, COALESCE(l.time_from, 'infinity'::timestamp), l.id)`
+
+// ListLessons возвращает уроки из локальной копии по времени начала.
+func (s *Store) ListLessons(filter types.LessonFilter) ([]types.GetLessonsResponseItem, error) {
+	query := `SELECT ` + lessonColumns + ` FROM lessons l WHERE l.tenant_id = $1`
+	args := []any{filter.TenantID}
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if len(filter.Statuses) > 0 {
+		query += ` AND l.status = ANY(` + arg(filter.Statuses) + `)`
+	}
+	if filter.CustomerID != 0 {
+		query += ` AND EXISTS (SELECT 1 FROM lesson_customers WHERE lesson_id = l.id AND customer_id = ` + arg(filter.CustomerID) + `)`
+	}
+	if filter.TeacherID != 0 {
+		query += ` AND EXISTS (SELECT 1 FROM lesson_teachers WHERE lesson_id = l.id AND teacher_id = ` + arg(filter.TeacherID) + `)`
+	}
+	if filter.DateFrom != "" {
+		query += ` AND l.date >= ` + arg(filter.DateFrom) + `::text::date`
+	}
+	if filter.DateTo != "" {
+		query += ` AND l.date <= ` + arg(filter.DateTo) + `::text::date`
+	}
+	if filter.SubjectID != 0 {
+		query += ` AND l.subject_id = ` + arg(filter.SubjectID)
+	}
+
+	direction, compare := "ASC", ">"
+	if filter.Desc {
+		direction, compare = "DESC", "<"
+	}
+	if c := filter.After; c != nil {
+		timeFrom := "infinity"
+		if c.TimeFrom != "" {
+			timeFrom = c.TimeFrom
+		}
+		query += fmt.Sprintf(` AND %s %s (%s::text::date, %s::text::timestamp, %s)`,
+			lessonOrder, compare, arg(c.Date), arg(timeFrom), arg(c.ID))
+	}
+	query += fmt.Sprintf(` ORDER BY l.date %[1]s, COALESCE(l.time_from, 'infinity'::timestamp) %[1]s, l.id %[1]s`, direction)
+	if filter.Limit > 0 {
+		query += ` LIMIT ` + arg(filter.Limit)
+	}
+
+	rows, err := s.pool.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lessons := make([]types.GetLessonsResponseItem, 0)
+	for rows.Next() {
+		lesson, err := scanLesson(rows)
+		if err != nil {
+			return nil, err
+		}
+		lessons = append(lessons, *lesson)
+	}
+	return lessons, rows.Err()
+}
+
+// GetLesson возвращает урок из локальной копии или nil, если его там нет.
+func (s *Store) GetLesson(tenantID, lessonID int) (*types.GetLessonsResponseItem, error) {
+	row := s.pool.QueryRow(context.Background(),
+		`SELECT `+lessonColumns+` FROM lessons l WHERE l.tenant_id = $1 AND l.id = $2`, tenantID, lessonID)
+	lesson, err := scanLesson(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return lesson, err
+}
+
+func (s *Store) ReplaceLessons(tenantID int, from, to time.Time, lessons []types.GetLessonsResponseItem) (int, int, error) {
+	ctx := context.Background()
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	ids := make([]int, 0, len(lessons))
+	for _, l := range lessons {
+		if err := saveLesson(ctx, tx, tenantID, l); err != nil {
+			return 0, 0, err
+		}
+		ids = append(ids, l.ID)
+	}
+
+	// урок, которого больше нет в CRM за этот период, удален там или перенесен за его пределы
+	tag, err := tx.Exec(ctx,
+		`DELETE FROM lessons WHERE tenant_id = $1 AND date BETWEEN $2 AND $3 AND NOT (id = ANY($4))`,
+		tenantID, from, to, ids)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, 0, err
+	}
+	return len(ids), int(tag.RowsAffected()), nil
+}
+
+// SaveLesson сохраняет один урок в локальную копию, например по вебхуку AlfaCRM.
+func (s *Store) SaveLesson(tenantID int, lesson types.GetLessonsResponseItem) error {
+	ctx := context.Background()
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := saveLesson(ctx, tx, tenantID, lesson); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (s *Store) DeleteLesson(tenantID, lessonID int) error {
+	_, err := s.pool.Exec(context.Background(),
+		`DELETE FROM lessons WHERE tenant_id = $1 AND id = $2`, tenantID, lessonID)
+	return err
+}
+
+// saveLesson обновляет урок и заменяет списки его преподавателей и учеников.
+func saveLesson(ctx context.Context, tx pgx.Tx, tenantID int, l types.GetLessonsResponseItem) error {
+	streaming, err := jsonValue(l.Streaming)
+	if err != nil {
+		return err
+	}
+	homework, err := jsonValue(l.Homework)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO lessons (id, tenant_id, status, date, time_from, time_to, subject_id, room_id,
+		                      topic, note, streaming, homework, group_ids, synced_at)
+		 VALUES ($1, $2, $3, $4::text::date, NULLIF($5::text, '')::timestamp, NULLIF($6::text, '')::timestamp,
+		         NULLIF($7, 0), NULLIF($8, 0), $9, $10, $11, $12, COALESCE($13::int[], '{}'), NOW())
+		 ON CONFLICT (id) DO UPDATE SET
+		     status = EXCLUDED.status,
+		     date = EXCLUDED.date,
+		     time_from = EXCLUDED.time_from,
+		     time_to = EXCLUDED.time_to,
+		     subject_id = EXCLUDED.subject_id,
+		     room_id = EXCLUDED.room_id,
+		     topic = EXCLUDED.topic,
+		     note = EXCLUDED.note,
+		     streaming = EXCLUDED.streaming,
+		     homework = EXCLUDED.homework,
+		     group_ids = EXCLUDED.group_ids,
+		     synced_at = NOW()`,
+		l.ID, tenantID, l.Status, l.Date, l.TimeFrom, l.TimeTo, l.SubjectID, l.RoomID,
+		l.Topic, l.Note, streaming, homework, l.GroupIDs)
+	if err != nil {
+		return fmt.Errorf("lesson %d: %v", l.ID, err)
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM lesson_teachers WHERE lesson_id = $1`, l.ID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx,
+		`INSERT INTO lesson_teachers (lesson_id, teacher_id) SELECT $1, unnest($2::bigint[]) ON CONFLICT DO NOTHING`,
+		l.ID, l.TeacherIDs)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `DELETE FROM lesson_customers WHERE lesson_id = $1`, l.ID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx,
+		`INSERT INTO lesson_customers (lesson_id, customer_id) SELECT $1, unnest($2::bigint[]) ON CONFLICT DO NOTHING`,
+		l.ID, l.CustomerIDs)
+	if err != nil {
+		return err
+	}
+	// доступ к материалам урока проверяется по составу, сохраненному при их прикреплении,
+	// поэтому состав обновляется вместе с уроком
+	_, err = tx.Exec(ctx,
+		`UPDATE lesson_materials
+		 SET lesson_date = $3::text::date, subject_id = NULLIF($4, 0), teacher_ids = $5, customer_ids = $6
+		 WHERE tenant_id = $1 AND lesson_id = $2`,
+		tenantID, l.ID, l.Date, l.SubjectID, l.TeacherIDs, l.CustomerIDs)
+	if err != nil {
+		return fmt.Errorf("materials of lesson %d: %v", l.ID, err)
+	}
+	return nil
+}
+
+// GetLessonSyncState возвращает состояние синхронизации уроков школы или nil, если ее еще не было.
+func (s *Store) GetLessonSyncState(tenantID int) (*types.LessonSyncState, error) {
+	state := types.LessonSyncState{TenantID: tenantID}
+	var lastError *string
+	err := s.pool.QueryRow(context.Background(),
+		`SELECT synced_at, window_from, window_to, upserted, deleted, last_error, last_error_at
+		 FROM lesson_sync_state WHERE tenant_id = $1`, tenantID).Scan(
+		&state.SyncedAt,
+		&state.WindowFrom,
+		&state.WindowTo,
+		&state.Upserted,
+		&state.Deleted,
+		&lastError,
+		&state.LastErrorAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if lastError != nil {
+		state.LastError = *lastError
+	}
+	return &state, nil
+}
+
+func (s *Store) SaveLessonSyncState(state *types.LessonSyncState) error {
+	var lastError *string
+	if state.LastError != "" {
+		lastError = &state.LastError
+	}
+	_, err := s.pool.Exec(context.Background(),
+		`INSERT INTO lesson_sync_state (tenant_id, synced_at, window_from, window_to, upserted, deleted, last_error, last_error_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		 ON CONFLICT (tenant_id) DO UPDATE SET
+		     synced_at = EXCLUDED.synced_at,
+		     window_from = EXCLUDED.window_from,
+		     window_to = EXCLUDED.window_to,
+		     upserted = EXCLUDED.upserted,
+		     deleted = EXCLUDED.deleted,
+		     last_error = EXCLUDED.last_error,
+		     last_error_at = EXCLUDED.last_error_at`,
+		state.TenantID, state.SyncedAt, state.WindowFrom, state.WindowTo,
+		state.Upserted, state.Deleted, lastError, state.LastErrorAt)
+	return err
+}
+
+func (s *Store) GetStudentTeacherIDs(tenantID, studentID int, since time.Time) ([]int, error) {
+	rows, err := s.pool.Query(context.Background(),
+		`SELECT DISTINCT lt.teacher_id
+		 FROM lessons l
+		 JOIN lesson_customers lc ON lc.lesson_id = l.id
+		 JOIN lesson_teachers lt ON lt.lesson_id = l.id
+		 WHERE l.tenant_id = $1 AND lc.customer_id = $2 AND l.status = 3 AND l.date >= $3
+		 ORDER BY lt.teacher_id`, tenantID, studentID, since)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[int])
+}
+
+func scanLesson(row pgx.Row) (*types.GetLessonsResponseItem, error) {
+	var l types.GetLessonsResponseItem
+	err := row.Scan(
+		&l.ID,
+		&l.Status,
+		&l.Date,
+		&l.TimeFrom,
+		&l.TimeTo,
+		&l.SubjectID,
+		&l.RoomID,
+		&l.Topic,
+		&l.Note,
+		&l.Streaming,
+		&l.Homework,
+		&l.TeacherIDs,
+		&l.CustomerIDs,
+		&l.GroupIDs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// jsonValue готовит поле AlfaCRM произвольного вида для колонки JSONB.
+func jsonValue(v any) ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return json.Marshal(v)
 }
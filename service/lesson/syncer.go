@@ -0,0 +1,178 @@
+package lesson
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/prok05/ecom/service/alpha"
+	"github.com/prok05/ecom/service/tenant"
+	"github.com/prok05/ecom/types"
+	"log"
+	"sync"
+	"time"
+)
+
+var ErrSyncInProgress = errors.New("lesson sync is already running")
+
+// SyncWindow - период, который синхронизация берет из AlfaCRM, в днях от сегодняшнего дня.
+// Обычный запуск обновляет DaysBack..DaysAhead, полный - FullDaysBack..DaysAhead.
+type SyncWindow struct {
+	DaysBack     int
+	DaysAhead    int
+	FullDaysBack int
+}
+
+// Syncer копирует уроки из AlfaCRM в таблицу lessons. Каждый запуск полностью
+// перечитывает уроки за скользящий период, поэтому изменения и удаления в CRM
+// попадают в копию при следующем запуске. Первый запуск для школы - полный.
+type Syncer struct {
+	store   types.LessonStore
+	tenants *tenant.Resolver
+	crm     alpha.Provider
+	window  SyncWindow
+
+	mu      sync.Mutex
+	running map[int]bool
+}
+
+func NewSyncer(store types.LessonStore, tenants *tenant.Resolver, crm alpha.Provider, window SyncWindow) *Syncer {
+	return &Syncer{
+		store:   store,
+		tenants: tenants,
+		crm:     crm,
+		window:  window,
+		running: make(map[int]bool),
+	}
+}
+
+// Schedule синхронизирует уроки всех школ сразу и затем каждые interval. Блокирует вызывающего.
+func (s *Syncer) Schedule(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		tenants, err := s.tenants.Tenants()
+		if err != nil {
+			log.Printf("lesson sync failed: %v", err)
+		}
+		for i := range tenants {
+			state, err := s.store.GetLessonSyncState(tenants[i].ID)
+			if err != nil {
+				log.Printf("lesson sync of tenant %s failed: %v", tenants[i].Slug, err)
+				continue
+			}
+			full := state == nil || state.SyncedAt == nil
+			if _, err := s.Run(context.Background(), &tenants[i], full); err != nil {
+				log.Printf("lesson sync of tenant %s failed: %v", tenants[i].Slug, err)
+			}
+		}
+		<-ticker.C
+	}
+}
+
+// Run синхронизирует уроки школы и сохраняет состояние. Для одной школы одновременно
+// выполняется только один запуск, остальные получают ErrSyncInProgress.
+func (s *Syncer) Run(ctx context.Context, t *types.Tenant, full bool) (*types.LessonSyncState, error) {
+	if !s.lock(t.ID) {
+		return nil, ErrSyncInProgress
+	}
+	defer s.unlock(t.ID)
+
+	state, err := s.store.GetLessonSyncState(t.ID)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		state = &types.LessonSyncState{TenantID: t.ID}
+	}
+
+	from, to := s.period(full)
+	lessons, err := s.fetch(ctx, s.crm.For(alpha.AccountFor(t)), from, to)
+	if err == nil {
+		state.Upserted, state.Deleted, err = s.store.ReplaceLessons(t.ID, from, to, lessons)
+	}
+
+	now := time.Now()
+	if err != nil {
+		// прежняя копия остается, о сбое видно по last_error
+		state.LastError = err.Error()
+		state.LastErrorAt = &now
+	} else {
+		state.SyncedAt = &now
+		state.WindowFrom = &from
+		state.WindowTo = &to
+		state.LastError = ""
+		state.LastErrorAt = nil
+	}
+	if saveErr := s.store.SaveLessonSyncState(state); saveErr != nil {
+		return state, fmt.Errorf("failed to save lesson sync state: %v", saveErr)
+	}
+	if err != nil {
+		return state, err
+	}
+
+	log.Printf("lesson sync of tenant %s finished: %s..%s upserted=%d deleted=%d",
+		t.Slug, from.Format("2006-01-02"), to.Format("2006-01-02"), state.Upserted, state.Deleted)
+	return state, nil
+}
+
+// Mirrored сообщает, можно ли отдать уроки с dateFrom из локальной копии:
+// школа уже синхронизирована и период не начинается раньше полной синхронизации.
+func (s *Syncer) Mirrored(state *types.LessonSyncState, dateFrom string) bool {
+	if state == nil || state.SyncedAt == nil {
+		return false
+	}
+	from, _ := s.period(true)
+	return dateFrom == "" || dateFrom >= from.Format("2006-01-02")
+}
+
+// Stale сообщает, что последняя синхронизация не удалась и копия может отставать от CRM.
+func (s *Syncer) Stale(state *types.LessonSyncState) bool {
+	if state == nil || state.LastErrorAt == nil {
+		return false
+	}
+	return state.SyncedAt == nil || state.LastErrorAt.After(*state.SyncedAt)
+}
+
+// fetch получает уроки всех статусов за период. Ошибка по любому статусу
+// прерывает синхронизацию, иначе уроки этого статуса были бы удалены из копии.
+func (s *Syncer) fetch(ctx context.Context, crm alpha.API, from, to time.Time) ([]types.GetLessonsResponseItem, error) {
+	lessons := make([]types.GetLessonsResponseItem, 0)
+	for _, status := range []int{1, 2, 3} {
+		items, err := crm.ListLessons(ctx, types.AlphaLessonQuery{
+			Status:   status,
+			DateFrom: from.Format("2006-01-02"),
+			DateTo:   to.Format("2006-01-02"),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("status %d: %w", status, err)
+		}
+		lessons = append(lessons, items...)
+	}
+	return lessons, nil
+}
+
+func (s *Syncer) period(full bool) (time.Time, time.Time) {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	back := s.window.DaysBack
+	if full {
+		back = s.window.FullDaysBack
+	}
+	return today.AddDate(0, 0, -back), today.AddDate(0, 0, s.window.DaysAhead)
+}
+
+func (s *Syncer) lock(tenantID int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running[tenantID] {
+		return false
+	}
+	s.running[tenantID] = true
+	return true
+}
+
+func (s *Syncer) unlock(tenantID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.running, tenantID)
+}
@@ -0,0 +1,754 @@
+package material
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/prok05/ecom/service/alpha"
+	"github.com/prok05/ecom/service/auth"
+	"github.com/prok05/ecom/service/lesson"
+	"github.com/prok05/ecom/service/tenant"
+	"github.com/prok05/ecom/types"
+	"github.com/prok05/ecom/utils"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// formOverhead - запас на остальные поля формы сверх размера файла
+const formOverhead = 1 << 20
+
+type Handler struct {
+	store       types.MaterialStore
+	lessonStore types.LessonStore
+	refs        types.ReferenceStore
+	authorizer  *auth.Authorizer
+	crm         alpha.Provider
+	// maxFileSize - максимальный размер загружаемого файла в байтах
+	maxFileSize int64
+}
+
+func NewHandler(store types.MaterialStore, lessonStore types.LessonStore, refs types.ReferenceStore, authorizer *auth.Authorizer, crm alpha.Provider, maxFileSize int64) *Handler {
+	return &Handler{
+		store:       store,
+		lessonStore: lessonStore,
+		refs:        refs,
+		authorizer:  authorizer,
+		crm:         crm,
+		maxFileSize: maxFileSize,
+	}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	// папки и выборки регистрируются раньше /materials/{materialID}
+	router.HandleFunc("/materials/folders", h.authorizer.RequirePermissions(h.handleGetFolders, auth.PermMaterialManage)).Methods(http.MethodGet)
+	router.HandleFunc("/materials/folders", h.authorizer.RequirePermissions(h.handleCreateFolder, auth.PermMaterialManage)).Methods(http.MethodPost)
+	router.HandleFunc("/materials/folders/{folderID}", h.authorizer.RequirePermissions(h.handleRenameFolder, auth.PermMaterialManage)).Methods(http.MethodPatch)
+	router.HandleFunc("/materials/folders/{folderID}", h.authorizer.RequirePermissions(h.handleDeleteFolder, auth.PermMaterialManage)).Methods(http.MethodDelete)
+	router.HandleFunc("/materials/lessons", h.authorizer.RequirePermissions(h.handleGetMyLessonMaterials, auth.PermMaterialRead)).Methods(http.MethodGet)
+
+	router.HandleFunc("/materials", h.authorizer.RequirePermissions(h.handleGetMaterials, auth.PermMaterialManage)).Methods(http.MethodGet)
+	router.HandleFunc("/materials", h.authorizer.RequirePermissions(h.handleCreateMaterial, auth.PermMaterialManage)).Methods(http.MethodPost)
+	router.HandleFunc("/materials/{materialID}", h.authorizer.RequirePermissions(h.handleUpdateMaterial, auth.PermMaterialManage)).Methods(http.MethodPatch)
+	router.HandleFunc("/materials/{materialID}", h.authorizer.RequirePermissions(h.handleDeleteMaterial, auth.PermMaterialManage)).Methods(http.MethodDelete)
+	router.HandleFunc("/materials/{materialID}/download", h.authorizer.RequirePermissions(h.handleDownloadMaterial, auth.PermMaterialRead)).Methods(http.MethodGet)
+
+	router.HandleFunc("/lessons/{lessonID}/materials", h.authorizer.RequirePermissions(h.handleGetLessonMaterials, auth.PermMaterialRead)).Methods(http.MethodGet)
+	router.HandleFunc("/lessons/{lessonID}/materials", h.authorizer.RequirePermissions(h.handleAttachMaterial, auth.PermMaterialManage)).Methods(http.MethodPost)
+	router.HandleFunc("/lessons/{lessonID}/materials/{materialID}", h.authorizer.RequirePermissions(h.handleDetachMaterial, auth.PermMaterialManage)).Methods(http.MethodDelete)
+}
+
+// Вложенные папки ?parent_id=, без него - папки корня библиотеки
+func (h *Handler) handleGetFolders(w http.ResponseWriter, r *http.Request) {
+	var parentID *int
+	if v := r.URL.Query().Get("parent_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id <= 0 {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid parent_id"))
+			return
+		}
+		parentID = &id
+	}
+
+	folders, err := h.store.GetFolders(auth.GetTenantIDFromContext(r.Context()), parentID)
+	if err != nil {
+		log.Printf("failed to get material folders: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get folders"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, folders)
+}
+
+func (h *Handler) handleCreateFolder(w http.ResponseWriter, r *http.Request) {
+	var payload types.CreateFolderPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if payload.ParentID != nil && !h.folderExists(w, principal.TenantID, *payload.ParentID) {
+		return
+	}
+
+	folder, err := h.store.CreateFolder(types.MaterialFolder{
+		TenantID: principal.TenantID,
+		ParentID: payload.ParentID,
+		Name:     strings.TrimSpace(payload.Name),
+		OwnerID:  principal.UserID,
+	})
+	if err != nil {
+		log.Printf("failed to create material folder: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot create folder"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, folder)
+}
+
+// Переименовать папку может ее автор или администратор
+func (h *Handler) handleRenameFolder(w http.ResponseWriter, r *http.Request) {
+	var payload types.RenameFolderPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	folder, ok := h.folderFromPath(w, r)
+	if !ok {
+		return
+	}
+	if !canEdit(r, folder.OwnerID) {
+		utils.WriteError(w, http.StatusForbidden, fmt.Errorf("permission denied"))
+		return
+	}
+
+	folder.Name = strings.TrimSpace(payload.Name)
+	if err := h.store.RenameFolder(folder.TenantID, folder.ID, folder.Name); err != nil {
+		log.Printf("failed to rename material folder %d: %v", folder.ID, err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot rename folder"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, folder)
+}
+
+// Удаляется только пустая папка
+func (h *Handler) handleDeleteFolder(w http.ResponseWriter, r *http.Request) {
+	folder, ok := h.folderFromPath(w, r)
+	if !ok {
+		return
+	}
+	if !canEdit(r, folder.OwnerID) {
+		utils.WriteError(w, http.StatusForbidden, fmt.Errorf("permission denied"))
+		return
+	}
+
+	deleted, err := h.store.DeleteFolder(folder.TenantID, folder.ID)
+	if err != nil {
+		log.Printf("failed to delete material folder %d: %v", folder.ID, err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot delete folder"))
+		return
+	}
+	if !deleted {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("folder is not empty"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Материалы библиотеки. ?folder_id= (0 - корень), ?subject_id=, ?owner_id=, ?q= - поиск
+// по названию и описанию. Без folder_id ищет во всех папках
+func (h *Handler) handleGetMaterials(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := types.MaterialFilter{
+		TenantID: auth.GetTenantIDFromContext(r.Context()),
+		Query:    strings.TrimSpace(query.Get("q")),
+	}
+	if v := query.Get("folder_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id < 0 {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid folder_id"))
+			return
+		}
+		filter.FolderID = &id
+	}
+	for name, dest := range map[string]*int{
+		"subject_id": &filter.SubjectID,
+		"owner_id":   &filter.OwnerID,
+	} {
+		if v := query.Get(name); v != "" {
+			id, err := strconv.Atoi(v)
+			if err != nil || id <= 0 {
+				utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid %s", name))
+				return
+			}
+			*dest = id
+		}
+	}
+
+	materials, err := h.store.GetMaterials(filter)
+	if err != nil {
+		log.Printf("failed to get materials: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get materials"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, materials)
+}
+
+// Загрузка материала формой multipart: файл file или ссылка url, title (по умолчанию имя файла),
+// description, folder_id, subject_id. С lesson_id материал сразу открывается ученикам урока
+func (h *Handler) handleCreateMaterial(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxFileSize+formOverhead)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			utils.WriteError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("file is larger than %d MB", h.maxFileSize>>20))
+			return
+		}
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid form"))
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	material := types.Material{
+		TenantID:    principal.TenantID,
+		Title:       strings.TrimSpace(r.FormValue("title")),
+		Description: strings.TrimSpace(r.FormValue("description")),
+		OwnerID:     principal.UserID,
+	}
+	var lessonID *int
+	for name, dest := range map[string]**int{
+		"folder_id":  &material.FolderID,
+		"subject_id": &material.SubjectID,
+		"lesson_id":  &lessonID,
+	} {
+		if v := r.FormValue(name); v != "" {
+			id, err := strconv.Atoi(v)
+			if err != nil || id <= 0 {
+				utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid %s", name))
+				return
+			}
+			*dest = &id
+		}
+	}
+	if len(material.Title) > 500 || len(material.Description) > 5000 {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("title or description is too long"))
+		return
+	}
+
+	link := strings.TrimSpace(r.FormValue("url"))
+	file, header, err := r.FormFile("file")
+	switch {
+	case err == nil:
+		file.Close()
+		if link != "" {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("expected either file or url"))
+			return
+		}
+		if header.Size > h.maxFileSize {
+			utils.WriteError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("file is larger than %d MB", h.maxFileSize>>20))
+			return
+		}
+		material.Kind = types.MaterialKindFile
+		material.Filename = header.Filename
+		material.Size = header.Size
+	case errors.Is(err, http.ErrMissingFile) && link != "":
+		if !validLink(link) {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid url, expected http or https link"))
+			return
+		}
+		material.Kind = types.MaterialKindLink
+		material.URL = link
+	case errors.Is(err, http.ErrMissingFile):
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("expected either file or url"))
+		return
+	default:
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid file"))
+		return
+	}
+	if material.Title == "" {
+		material.Title = material.Filename
+		if material.Title == "" {
+			material.Title = material.URL
+		}
+	}
+
+	if material.FolderID != nil && !h.folderExists(w, principal.TenantID, *material.FolderID) {
+		return
+	}
+	if material.SubjectID != nil && !h.subjectExists(w, principal.TenantID, *material.SubjectID) {
+		return
+	}
+	// урок проверяется до сохранения файла, чтобы не оставлять материал без урока
+	var lessonItem *types.GetLessonsResponseItem
+	if lessonID != nil {
+		var ok bool
+		if lessonItem, ok = h.findLesson(w, r, *lessonID); !ok {
+			return
+		}
+		if principal.Role == types.RoleTeacher && !containsID(lessonItem.TeacherIDs, principal.UserID) {
+			utils.WriteError(w, http.StatusForbidden, fmt.Errorf("permission denied"))
+			return
+		}
+	}
+
+	if material.Kind == types.MaterialKindFile {
+		material.Filepath, err = utils.SaveUpload(header, "material")
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot save file"))
+			return
+		}
+	}
+	created, err := h.store.CreateMaterial(material)
+	if err != nil {
+		log.Printf("failed to create material: %v", err)
+		removeFile(material.Filepath)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot save material"))
+		return
+	}
+
+	if lessonItem != nil && !h.attach(w, principal, lessonItem, created.ID) {
+		// материал без урока не нужен тому, кто его загружал
+		if err := h.store.DeleteMaterial(principal.TenantID, created.ID); err != nil {
+			log.Printf("failed to delete unattached material %d: %v", created.ID, err)
+			return
+		}
+		removeFile(created.Filepath)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, created)
+}
+
+// Изменить материал может его автор или администратор
+func (h *Handler) handleUpdateMaterial(w http.ResponseWriter, r *http.Request) {
+	var payload types.UpdateMaterialPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	material, ok := h.materialFromPath(w, r)
+	if !ok {
+		return
+	}
+	if !canEdit(r, material.OwnerID) {
+		utils.WriteError(w, http.StatusForbidden, fmt.Errorf("permission denied"))
+		return
+	}
+
+	if payload.Title != nil {
+		material.Title = strings.TrimSpace(*payload.Title)
+		if material.Title == "" {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("title is empty"))
+			return
+		}
+	}
+	if payload.Description != nil {
+		material.Description = strings.TrimSpace(*payload.Description)
+	}
+	if payload.FolderID != nil {
+		material.FolderID = nil
+		if *payload.FolderID != 0 {
+			if !h.folderExists(w, material.TenantID, *payload.FolderID) {
+				return
+			}
+			material.FolderID = payload.FolderID
+		}
+	}
+	if payload.SubjectID != nil {
+		material.SubjectID = nil
+		if *payload.SubjectID != 0 {
+			if !h.subjectExists(w, material.TenantID, *payload.SubjectID) {
+				return
+			}
+			material.SubjectID = payload.SubjectID
+		}
+	}
+
+	if err := h.store.UpdateMaterial(*material); err != nil {
+		log.Printf("failed to update material %d: %v", material.ID, err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot update material"))
+		return
+	}
+	material.UpdatedAt = time.Now()
+
+	utils.WriteJSON(w, http.StatusOK, material)
+}
+
+// Удаление материала закрывает его и на всех уроках
+func (h *Handler) handleDeleteMaterial(w http.ResponseWriter, r *http.Request) {
+	material, ok := h.materialFromPath(w, r)
+	if !ok {
+		return
+	}
+	if !canEdit(r, material.OwnerID) {
+		utils.WriteError(w, http.StatusForbidden, fmt.Errorf("permission denied"))
+		return
+	}
+
+	if err := h.store.DeleteMaterial(material.TenantID, material.ID); err != nil {
+		log.Printf("failed to delete material %d: %v", material.ID, err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot delete material"))
+		return
+	}
+	removeFile(material.Filepath)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Скачивание файла или переход по ссылке. Ученик получает только материалы своих уроков
+func (h *Handler) handleDownloadMaterial(w http.ResponseWriter, r *http.Request) {
+	material, ok := h.materialFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if principal.Role == types.RoleStudent {
+		shared, err := h.store.IsSharedWith(principal.TenantID, material.ID, principal.UserID)
+		if err != nil {
+			log.Printf("failed to check access to material %d: %v", material.ID, err)
+			utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get material"))
+			return
+		}
+		if !shared {
+			utils.WriteError(w, http.StatusForbidden, fmt.Errorf("permission denied"))
+			return
+		}
+	}
+
+	if material.Kind == types.MaterialKindLink {
+		http.Redirect(w, r, material.URL, http.StatusFound)
+		return
+	}
+
+	file, err := os.Open(material.Filepath)
+	if os.IsNotExist(err) {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("file not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("error opening file of material %d: %v", material.ID, err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("error opening file"))
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": material.Filename}))
+	http.ServeContent(w, r, material.Filename, material.UpdatedAt, file)
+}
+
+// Материалы прошедших уроков: ученику - его уроков, преподавателю - уроков, которые он ведет,
+// администратору - всех уроков школы. ?date_from= (YYYY-MM-DD) ограничивает начало периода
+func (h *Handler) handleGetMyLessonMaterials(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	loc := tenant.Location(tenant.FromContext(r.Context()))
+	now := time.Now().In(loc)
+	filter := types.LessonMaterialFilter{
+		TenantID: principal.TenantID,
+		Until:    time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC),
+	}
+	if v := r.URL.Query().Get("date_from"); v != "" {
+		date, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid date_from, expected YYYY-MM-DD"))
+			return
+		}
+		filter.DateFrom = &date
+	}
+	switch principal.Role {
+	case types.RoleStudent:
+		filter.StudentID = principal.UserID
+	case types.RoleTeacher:
+		filter.TeacherID = principal.UserID
+	}
+
+	lessons, err := h.store.GetLessonMaterialsFor(filter)
+	if err != nil {
+		log.Printf("failed to get lesson materials of user %d: %v", principal.UserID, err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get materials"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, lessons)
+}
+
+// Материалы урока. Ученик видит только уроки, в которых участвует, преподаватель - свои уроки
+func (h *Handler) handleGetLessonMaterials(w http.ResponseWriter, r *http.Request) {
+	lessonID, err := strconv.Atoi(mux.Vars(r)["lessonID"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid lesson ID"))
+		return
+	}
+	lessonItem, ok := h.findLesson(w, r, lessonID)
+	if !ok {
+		return
+	}
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if (principal.Role == types.RoleStudent && !containsID(lessonItem.CustomerIDs, principal.UserID)) ||
+		(principal.Role == types.RoleTeacher && !containsID(lessonItem.TeacherIDs, principal.UserID)) {
+		utils.WriteError(w, http.StatusForbidden, fmt.Errorf("permission denied"))
+		return
+	}
+
+	materials, err := h.store.GetLessonMaterials(principal.TenantID, lessonID)
+	if err != nil {
+		log.Printf("failed to get materials of lesson %d: %v", lessonID, err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get materials"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, materials)
+}
+
+// Открыть материал библиотеки ученикам урока. Преподаватель прикрепляет только к своим урокам
+func (h *Handler) handleAttachMaterial(w http.ResponseWriter, r *http.Request) {
+	var payload types.AttachMaterialPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+	lessonID, err := strconv.Atoi(mux.Vars(r)["lessonID"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid lesson ID"))
+		return
+	}
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	material, err := h.store.GetMaterial(principal.TenantID, payload.MaterialID)
+	if err != nil {
+		log.Printf("failed to get material %d: %v", payload.MaterialID, err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get material"))
+		return
+	}
+	if material == nil {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("material not found"))
+		return
+	}
+
+	lessonItem, ok := h.findLesson(w, r, lessonID)
+	if !ok {
+		return
+	}
+	if principal.Role == types.RoleTeacher && !containsID(lessonItem.TeacherIDs, principal.UserID) {
+		utils.WriteError(w, http.StatusForbidden, fmt.Errorf("permission denied"))
+		return
+	}
+	if !h.attach(w, principal, lessonItem, material.ID) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) handleDetachMaterial(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	lessonID, err := strconv.Atoi(vars["lessonID"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid lesson ID"))
+		return
+	}
+	materialID, err := strconv.Atoi(vars["materialID"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid material ID"))
+		return
+	}
+
+	lessonItem, ok := h.findLesson(w, r, lessonID)
+	if !ok {
+		return
+	}
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if principal.Role == types.RoleTeacher && !containsID(lessonItem.TeacherIDs, principal.UserID) {
+		utils.WriteError(w, http.StatusForbidden, fmt.Errorf("permission denied"))
+		return
+	}
+
+	detached, err := h.store.DetachMaterial(principal.TenantID, lessonID, materialID)
+	if err != nil {
+		log.Printf("failed to detach material %d from lesson %d: %v", materialID, lessonID, err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot detach material"))
+		return
+	}
+	if !detached {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("material is not attached to the lesson"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// attach сохраняет прикрепление с участниками урока. При ошибке сам пишет ответ и возвращает false.
+func (h *Handler) attach(w http.ResponseWriter, principal *auth.Principal, lessonItem *types.GetLessonsResponseItem, materialID int) bool {
+	date, err := time.Parse("2006-01-02", lessonItem.Date)
+	if err != nil {
+		log.Printf("invalid date of lesson %d: %v", lessonItem.ID, err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot verify lesson"))
+		return false
+	}
+
+	err = h.store.AttachMaterial(types.LessonMaterialLink{
+		TenantID:    principal.TenantID,
+		LessonID:    lessonItem.ID,
+		MaterialID:  materialID,
+		LessonDate:  date,
+		SubjectID:   lessonItem.SubjectID,
+		TeacherIDs:  lessonItem.TeacherIDs,
+		CustomerIDs: lessonItem.CustomerIDs,
+		SharedBy:    principal.UserID,
+	})
+	if err != nil {
+		log.Printf("failed to attach material %d to lesson %d: %v", materialID, lessonItem.ID, err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot attach material"))
+		return false
+	}
+	return true
+}
+
+// findLesson находит урок в локальной копии или в AlfaCRM. При ошибке сам пишет ответ и возвращает false.
+func (h *Handler) findLesson(w http.ResponseWriter, r *http.Request, lessonID int) (*types.GetLessonsResponseItem, bool) {
+	lessonItem, err := lesson.FindLesson(r.Context(), h.lessonStore, h.crm.For(tenant.Account(r.Context())),
+		auth.GetTenantIDFromContext(r.Context()), lessonID)
+	if errors.Is(err, alpha.ErrNotFound) {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("lesson not found"))
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("failed to get lesson %d: %v", lessonID, err)
+		utils.WriteError(w, alpha.HTTPStatus(err), fmt.Errorf("cannot verify lesson"))
+		return nil, false
+	}
+	return lessonItem, true
+}
+
+func (h *Handler) folderFromPath(w http.ResponseWriter, r *http.Request) (*types.MaterialFolder, bool) {
+	folderID, err := strconv.Atoi(mux.Vars(r)["folderID"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid folder ID"))
+		return nil, false
+	}
+	folder, err := h.store.GetFolder(auth.GetTenantIDFromContext(r.Context()), folderID)
+	if err != nil {
+		log.Printf("failed to get material folder %d: %v", folderID, err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get folder"))
+		return nil, false
+	}
+	if folder == nil {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("folder not found"))
+		return nil, false
+	}
+	return folder, true
+}
+
+// folderExists проверяет, что папка есть в школе. При ошибке сам пишет ответ и возвращает false.
+func (h *Handler) folderExists(w http.ResponseWriter, tenantID, folderID int) bool {
+	folder, err := h.store.GetFolder(tenantID, folderID)
+	if err != nil {
+		log.Printf("failed to get material folder %d: %v", folderID, err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get folder"))
+		return false
+	}
+	if folder == nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("folder %d not found", folderID))
+		return false
+	}
+	return true
+}
+
+// subjectExists проверяет, что предмет есть в справочнике школы. При ошибке сам пишет ответ и возвращает false.
+func (h *Handler) subjectExists(w http.ResponseWriter, tenantID, subjectID int) bool {
+	subjects, err := h.refs.GetSubjects(tenantID)
+	if err != nil {
+		log.Printf("failed to get subjects: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot check subject"))
+		return false
+	}
+	for _, s := range subjects {
+		if s.ID == subjectID {
+			return true
+		}
+	}
+	utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("unknown subject"))
+	return false
+}
+
+func (h *Handler) materialFromPath(w http.ResponseWriter, r *http.Request) (*types.Material, bool) {
+	materialID, err := strconv.Atoi(mux.Vars(r)["materialID"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid material ID"))
+		return nil, false
+	}
+	material, err := h.store.GetMaterial(auth.GetTenantIDFromContext(r.Context()), materialID)
+	if err != nil {
+		log.Printf("failed to get material %d: %v", materialID, err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get material"))
+		return nil, false
+	}
+	if material == nil {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("material not found"))
+		return nil, false
+	}
+	return material, true
+}
+
+// canEdit - менять папку или материал может автор или администратор.
+func canEdit(r *http.Request, ownerID int) bool {
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	return principal.Role == types.RoleSupervisor || principal.UserID == ownerID
+}
+
+func validLink(link string) bool {
+	u, err := url.ParseRequestURI(link)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func removeFile(path string) {
+	if path == "" {
+		return
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Printf("failed to remove file %s: %v", path, err)
+	}
+}
+
+func containsID(ids []int, id int) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,286 @@
+package material
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prok05/ecom/types"
+	"time"
+)
+
+// materialColumns ожидают псевдоним m у таблицы materials
+const materialColumns = `m.id, m.tenant_id, m.folder_id, m.subject_id, m.kind, m.title, m.description,
+	COALESCE(m.filename, ''), COALESCE(m.size, 0), COALESCE(m.url, ''), COALESCE(m.filepath, ''),
+	m.owner_id, m.created_at, m.updated_at`
+
+const folderColumns = `id, tenant_id, parent_id, name, owner_id, created_at`
+
+type Store struct {
+	dbpool *pgxpool.Pool
+}
+
+func NewStore(dbpool *pgxpool.Pool) *Store {
+	return &Store{
+		dbpool: dbpool,
+	}
+}
+
+func (s *Store) CreateFolder(folder types.MaterialFolder) (*types.MaterialFolder, error) {
+	row := s.dbpool.QueryRow(context.Background(),
+		`INSERT INTO material_folders (tenant_id, parent_id, name, owner_id)
+		 VALUES ($1, $2, $3, $4)
+		 RETURNING `+folderColumns,
+		folder.TenantID, folder.ParentID, folder.Name, folder.OwnerID)
+	return scanFolder(row)
+}
+
+func (s *Store) GetFolder(tenantID, folderID int) (*types.MaterialFolder, error) {
+	row := s.dbpool.QueryRow(context.Background(),
+		`SELECT `+folderColumns+` FROM material_folders WHERE tenant_id = $1 AND id = $2`, tenantID, folderID)
+	folder, err := scanFolder(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return folder, err
+}
+
+func (s *Store) GetFolders(tenantID int, parentID *int) ([]types.MaterialFolder, error) {
+	rows, err := s.dbpool.Query(context.Background(),
+		`SELECT `+folderColumns+`
+		 FROM material_folders
+		 WHERE tenant_id = $1 AND parent_id IS NOT DISTINCT FROM $2
+		 ORDER BY name, id`, tenantID, parentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	folders := make([]types.MaterialFolder, 0)
+	for rows.Next() {
+		folder, err := scanFolder(rows)
+		if err != nil {
+			return nil, err
+		}
+		folders = append(folders, *folder)
+	}
+	return folders, rows.Err()
+}
+
+func (s *Store) RenameFolder(tenantID, folderID int, name string) error {
+	_, err := s.dbpool.Exec(context.Background(),
+		`UPDATE material_folders SET name = $3 WHERE tenant_id = $1 AND id = $2`, tenantID, folderID, name)
+	return err
+}
+
+func (s *Store) DeleteFolder(tenantID, folderID int) (bool, error) {
+	tag, err := s.dbpool.Exec(context.Background(),
+		`DELETE FROM material_folders f
+		 WHERE f.tenant_id = $1 AND f.id = $2
+		   AND NOT EXISTS (SELECT 1 FROM material_folders c WHERE c.parent_id = f.id)
+		   AND NOT EXISTS (SELECT 1 FROM materials m WHERE m.folder_id = f.id)`, tenantID, folderID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (s *Store) CreateMaterial(material types.Material) (*types.Material, error) {
+	var id int
+	err := s.dbpool.QueryRow(context.Background(),
+		`INSERT INTO materials (tenant_id, folder_id, subject_id, kind, title, description, filepath, filename, size, url, owner_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), NULLIF($8, ''), NULLIF($9, 0), NULLIF($10, ''), $11)
+		 RETURNING id`,
+		material.TenantID, material.FolderID, material.SubjectID, material.Kind, material.Title, material.Description,
+		material.Filepath, material.Filename, material.Size, material.URL, material.OwnerID).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetMaterial(material.TenantID, id)
+}
+
+func (s *Store) GetMaterial(tenantID, materialID int) (*types.Material, error) {
+	row := s.dbpool.QueryRow(context.Background(),
+		`SELECT `+materialColumns+` FROM materials m WHERE m.tenant_id = $1 AND m.id = $2`, tenantID, materialID)
+	material, err := scanMaterial(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return material, err
+}
+
+func (s *Store) GetMaterials(filter types.MaterialFilter) ([]types.Material, error) {
+	rows, err := s.dbpool.Query(context.Background(),
+		`SELECT `+materialColumns+`
+		 FROM materials m
+		 WHERE m.tenant_id = $1
+		   AND ($2::int IS NULL OR m.folder_id IS NOT DISTINCT FROM NULLIF($2, 0))
+		   AND ($3 = 0 OR m.subject_id = $3)
+		   AND ($4 = 0 OR m.owner_id = $4)
+		   AND ($5 = '' OR m.title ILIKE '%' || $5 || '%' OR m.description ILIKE '%' || $5 || '%')
+		 ORDER BY m.title, m.id`,
+		filter.TenantID, filter.FolderID, filter.SubjectID, filter.OwnerID, filter.Query)
+	if err != nil {
+		return nil, err
+	}
+	return scanMaterials(rows)
+}
+
+func (s *Store) UpdateMaterial(material types.Material) error {
+	_, err := s.dbpool.Exec(context.Background(),
+		`UPDATE materials
+		 SET folder_id = $3, subject_id = $4, title = $5, description = $6, updated_at = NOW()
+		 WHERE tenant_id = $1 AND id = $2`,
+		material.TenantID, material.ID, material.FolderID, material.SubjectID, material.Title, material.Description)
+	return err
+}
+
+func (s *Store) DeleteMaterial(tenantID, materialID int) error {
+	_, err := s.dbpool.Exec(context.Background(),
+		`DELETE FROM materials WHERE tenant_id = $1 AND id = $2`, tenantID, materialID)
+	return err
+}
+
+func (s *Store) AttachMaterial(link types.LessonMaterialLink) error {
+	_, err := s.dbpool.Exec(context.Background(),
+		`INSERT INTO lesson_materials (lesson_id, material_id, tenant_id, lesson_date, subject_id, teacher_ids, customer_ids, shared_by)
+		 VALUES ($1, $2, $3, $4, NULLIF($5, 0), $6, $7, $8)
+		 ON CONFLICT (lesson_id, material_id) DO UPDATE
+		 SET lesson_date = EXCLUDED.lesson_date, subject_id = EXCLUDED.subject_id,
+		     teacher_ids = EXCLUDED.teacher_ids, customer_ids = EXCLUDED.customer_ids`,
+		link.LessonID, link.MaterialID, link.TenantID, link.LessonDate.Format("2006-01-02"), link.SubjectID,
+		link.TeacherIDs, link.CustomerIDs, link.SharedBy)
+	return err
+}
+
+func (s *Store) DetachMaterial(tenantID, lessonID, materialID int) (bool, error) {
+	tag, err := s.dbpool.Exec(context.Background(),
+		`DELETE FROM lesson_materials WHERE tenant_id = $1 AND lesson_id = $2 AND material_id = $3`,
+		tenantID, lessonID, materialID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (s *Store) GetLessonMaterials(tenantID, lessonID int) ([]types.Material, error) {
+	rows, err := s.dbpool.Query(context.Background(),
+		`SELECT `+materialColumns+`
+		 FROM lesson_materials lm
+		 JOIN materials m ON m.id = lm.material_id
+		 WHERE lm.tenant_id = $1 AND lm.lesson_id = $2
+		 ORDER BY lm.shared_at, m.id`, tenantID, lessonID)
+	if err != nil {
+		return nil, err
+	}
+	return scanMaterials(rows)
+}
+
+func (s *Store) GetLessonMaterialsFor(filter types.LessonMaterialFilter) ([]types.LessonMaterials, error) {
+	rows, err := s.dbpool.Query(context.Background(),
+		`SELECT lm.lesson_id, lm.lesson_date, lm.subject_id, `+materialColumns+`
+		 FROM lesson_materials lm
+		 JOIN materials m ON m.id = lm.material_id
+		 WHERE lm.tenant_id = $1
+		   AND ($2 = 0 OR lm.customer_ids @> ARRAY[$2]::bigint[])
+		   AND ($3 = 0 OR lm.teacher_ids @> ARRAY[$3]::bigint[])
+		   AND ($4::date IS NULL OR lm.lesson_date >= $4)
+		   AND lm.lesson_date <= $5
+		 ORDER BY lm.lesson_date DESC, lm.lesson_id DESC, lm.shared_at, m.id`,
+		filter.TenantID, filter.StudentID, filter.TeacherID, filter.DateFrom, filter.Until.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lessons := make([]types.LessonMaterials, 0)
+	for rows.Next() {
+		var lessonID int
+		var lessonDate time.Time
+		var subjectID *int
+		var m types.Material
+		err := rows.Scan(&lessonID, &lessonDate, &subjectID,
+			&m.ID, &m.TenantID, &m.FolderID, &m.SubjectID, &m.Kind, &m.Title, &m.Description,
+			&m.Filename, &m.Size, &m.URL, &m.Filepath, &m.OwnerID, &m.CreatedAt, &m.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if n := len(lessons); n == 0 || lessons[n-1].LessonID != lessonID {
+			lessons = append(lessons, types.LessonMaterials{
+				LessonID:   lessonID,
+				LessonDate: lessonDate.Format("2006-01-02"),
+				SubjectID:  subjectID,
+				Materials:  make([]types.Material, 0, 1),
+			})
+		}
+		last := &lessons[len(lessons)-1]
+		last.Materials = append(last.Materials, m)
+	}
+	return lessons, rows.Err()
+}
+
+func (s *Store) CountLessonMaterials(tenantID int, lessonIDs []int) (map[int]int, error) {
+	rows, err := s.dbpool.Query(context.Background(),
+		`SELECT lesson_id, COUNT(*)
+		 FROM lesson_materials
+		 WHERE tenant_id = $1 AND lesson_id = ANY($2)
+		 GROUP BY lesson_id`, tenantID, lessonIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[int]int)
+	for rows.Next() {
+		var lessonID, count int
+		if err := rows.Scan(&lessonID, &count); err != nil {
+			return nil, err
+		}
+		counts[lessonID] = count
+	}
+	return counts, rows.Err()
+}
+
+func (s *Store) IsSharedWith(tenantID, materialID, userID int) (bool, error) {
+	var shared bool
+	err := s.dbpool.QueryRow(context.Background(),
+		`SELECT EXISTS(
+		     SELECT 1 FROM lesson_materials
+		     WHERE tenant_id = $1 AND material_id = $2
+		       AND (customer_ids @> ARRAY[$3]::bigint[] OR teacher_ids @> ARRAY[$3]::bigint[])
+		 )`, tenantID, materialID, userID).Scan(&shared)
+	return shared, err
+}
+
+func scanFolder(row pgx.Row) (*types.MaterialFolder, error) {
+	var f types.MaterialFolder
+	err := row.Scan(&f.ID, &f.TenantID, &f.ParentID, &f.Name, &f.OwnerID, &f.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func scanMaterial(row pgx.Row) (*types.Material, error) {
+	var m types.Material
+	err := row.Scan(&m.ID, &m.TenantID, &m.FolderID, &m.SubjectID, &m.Kind, &m.Title, &m.Description,
+		&m.Filename, &m.Size, &m.URL, &m.Filepath, &m.OwnerID, &m.CreatedAt, &m.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func scanMaterials(rows pgx.Rows) ([]types.Material, error) {
+	defer rows.Close()
+
+	materials := make([]types.Material, 0)
+	for rows.Next() {
+		m, err := scanMaterial(rows)
+		if err != nil {
+			return nil, err
+		}
+		materials = append(materials, *m)
+	}
+	return materials, rows.Err()
+}
@@ -2,7 +2,6 @@ package message
 
 import (
 	"fmt"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/mux"
 	"github.com/prok05/ecom/cache"
 	"github.com/prok05/ecom/service/auth"
@@ -16,59 +15,28 @@ import (
 type Handler struct {
 	store      types.MessageStore
 	chatStore  types.ChatStore
+	authorizer *auth.Authorizer
 	tokenCache *cache.TokenCache
 }
 
-func NewHandler(store types.MessageStore, chatStore types.ChatStore, tokenCache *cache.TokenCache) *Handler {
+func NewHandler(store types.MessageStore, chatStore types.ChatStore, authorizer *auth.Authorizer, tokenCache *cache.TokenCache) *Handler {
 	return &Handler{
 		store:      store,
 		chatStore:  chatStore,
+		authorizer: authorizer,
 		tokenCache: tokenCache,
 	}
 }
 
 func (h *Handler) RegisterRoutes(router *mux.Router) {
-	router.HandleFunc("/messages", h.SendMessage).Methods("POST")
-	router.HandleFunc("/chats/{chatID}/messages", h.GetMessages).Methods("GET")
+	router.HandleFunc("/messages", h.authorizer.RequirePermissions(h.SendMessage, auth.PermChatWrite)).Methods("POST")
+	router.HandleFunc("/chats/{chatID}/messages", h.authorizer.RequirePermissions(h.GetMessages, auth.PermChatRead)).Methods("GET")
 }
 
 func (h *Handler) SendMessage(w http.ResponseWriter, r *http.Request) {
-	tokenString := auth.GetTokenFromRequest(r)
-	if tokenString == "" {
-		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("missing or invalid JWT token"))
-		return
-	}
-
-	jwtToken, err := auth.ValidateToken(tokenString)
-	if err != nil || !jwtToken.Valid {
-		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("invalid token"))
-		return
-	}
-
-	claims, ok := jwtToken.Claims.(jwt.MapClaims)
-	if !ok {
-		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("no token"))
-		return
-	}
-
-	userIDStr, ok := claims["userID"].(string)
-	if !ok {
-		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("invalid token"))
-		return
-	}
-
-	userID, err := strconv.Atoi(userIDStr)
-	if err != nil {
-		log.Printf("Unable to convert userID: %v", err)
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	role, ok := claims["role"].(string)
-	if !ok {
-		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("invalid token"))
-		return
-	}
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	userID := principal.UserID
+	role := principal.Role
 
 	var payload types.MessagePayload
 	if err := utils.ParseJSON(r, &payload); err != nil {
@@ -76,14 +44,14 @@ func (h *Handler) SendMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if role == "teacher" {
+	if role == types.RoleTeacher {
 		_, err := h.store.SaveMessage(&payload.Message)
 		if err != nil {
 			utils.WriteError(w, http.StatusInternalServerError, err)
 			return
 		}
 		utils.WriteJSON(w, http.StatusCreated, nil)
-	} else if role == "student" {
+	} else if role == types.RoleStudent {
 		chat, err := h.chatStore.GetChatByUserIDs(userID, payload.UserID)
 		if err != nil {
 			utils.WriteError(w, http.StatusInternalServerError, err)
@@ -94,6 +62,7 @@ func (h *Handler) SendMessage(w http.ResponseWriter, r *http.Request) {
 			chat := types.Chat{
 				ChatType: "",
 				Name:     "",
+				TenantID: auth.GetTenantIDFromContext(r.Context()),
 			}
 			err = h.chatStore.CreateChat(&chat, []int{userID, payload.UserID})
 			if err != nil {
@@ -116,18 +85,6 @@ func (h *Handler) SendMessage(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) GetMessages(w http.ResponseWriter, r *http.Request) {
-	tokenString := auth.GetTokenFromRequest(r)
-	if tokenString == "" {
-		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("missing or invalid JWT token"))
-		return
-	}
-
-	jwtToken, err := auth.ValidateToken(tokenString)
-	if err != nil || !jwtToken.Valid {
-		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("invalid token"))
-		return
-	}
-
 	vars := mux.Vars(r)
 	chatID := vars["chatID"]
 	chatIDInt, err := strconv.Atoi(chatID)
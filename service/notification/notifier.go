@@ -0,0 +1,40 @@
+package notification
+
+import (
+	"github.com/prok05/ecom/service/ws"
+	"github.com/prok05/ecom/types"
+)
+
+// EventCreated - событие WebSocket о новом уведомлении
+const EventCreated = "notification.created"
+
+// Notifier сохраняет уведомления и сразу отправляет их подключенным получателям.
+type Notifier struct {
+	store types.NotificationStore
+	hub   *ws.Hub
+}
+
+func NewNotifier(store types.NotificationStore, hub *ws.Hub) *Notifier {
+	return &Notifier{
+		store: store,
+		hub:   hub,
+	}
+}
+
+// Notify создает копию уведомления n для каждого из userIDs школы tenantID.
+func (n *Notifier) Notify(tenantID int, userIDs []int, note types.Notification) error {
+	for _, userID := range userIDs {
+		item := note
+		item.TenantID = tenantID
+		item.UserID = userID
+		if err := n.store.CreateNotification(&item); err != nil {
+			return err
+		}
+		n.hub.PublishPrivate(tenantID, []int{userID}, types.WSEvent{
+			Type:     EventCreated,
+			EntityID: item.ID,
+			Data:     item,
+		})
+	}
+	return nil
+}
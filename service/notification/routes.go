@@ -0,0 +1,88 @@
+package notification
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/prok05/ecom/service/auth"
+	"github.com/prok05/ecom/types"
+	"github.com/prok05/ecom/utils"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+type Handler struct {
+	store      types.NotificationStore
+	authorizer *auth.Authorizer
+}
+
+func NewHandler(store types.NotificationStore, authorizer *auth.Authorizer) *Handler {
+	return &Handler{
+		store:      store,
+		authorizer: authorizer,
+	}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/notifications", h.authorizer.RequirePermissions(h.handleGetNotifications, auth.PermNotificationRead)).Methods(http.MethodGet)
+	router.HandleFunc("/notifications/read", h.authorizer.RequirePermissions(h.handleMarkAllRead, auth.PermNotificationRead)).Methods(http.MethodPost)
+	router.HandleFunc("/notifications/{notificationID}/read", h.authorizer.RequirePermissions(h.handleMarkRead, auth.PermNotificationRead)).Methods(http.MethodPost)
+}
+
+// Последние уведомления пользователя, ?unread=true - только непрочитанные
+func (h *Handler) handleGetNotifications(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 200 {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid limit"))
+			return
+		}
+		limit = n
+	}
+	unreadOnly := r.URL.Query().Get("unread") == "true"
+
+	userID := auth.GetUserIDFromContext(r.Context())
+	notifications, unread, err := h.store.GetNotifications(userID, unreadOnly, limit)
+	if err != nil {
+		log.Printf("failed to get notifications of user %d: %v", userID, err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get notifications"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, types.NotificationsResponse{Items: notifications, Unread: unread})
+}
+
+func (h *Handler) handleMarkRead(w http.ResponseWriter, r *http.Request) {
+	notificationID, err := strconv.Atoi(mux.Vars(r)["notificationID"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid notification ID"))
+		return
+	}
+
+	userID := auth.GetUserIDFromContext(r.Context())
+	ok, err := h.store.MarkNotificationRead(userID, notificationID)
+	if err != nil {
+		log.Printf("failed to mark notification %d as read: %v", notificationID, err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot update notification"))
+		return
+	}
+	if !ok {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("notification not found"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"message": "notification read"})
+}
+
+func (h *Handler) handleMarkAllRead(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+	count, err := h.store.MarkAllNotificationsRead(userID)
+	if err != nil {
+		log.Printf("failed to mark notifications of user %d as read: %v", userID, err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot update notifications"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]int{"count": count})
+}
@@ -0,0 +1,77 @@
+package notification
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prok05/ecom/types"
+)
+
+type Store struct {
+	dbpool *pgxpool.Pool
+}
+
+func NewStore(dbpool *pgxpool.Pool) *Store {
+	return &Store{
+		dbpool: dbpool,
+	}
+}
+
+func (s *Store) CreateNotification(n *types.Notification) error {
+	return s.dbpool.QueryRow(context.Background(),
+		`INSERT INTO notifications (tenant_id, user_id, type, title, body, data)
+		 VALUES ($1, $2, $3, $4, $5, $6)
+		 RETURNING id, created_at`,
+		n.TenantID, n.UserID, n.Type, n.Title, n.Body, n.Data).Scan(&n.ID, &n.CreatedAt)
+}
+
+func (s *Store) GetNotifications(userID int, unreadOnly bool, limit int) ([]types.Notification, int, error) {
+	rows, err := s.dbpool.Query(context.Background(),
+		`SELECT id, tenant_id, user_id, type, title, body, data, created_at, read_at
+		 FROM notifications
+		 WHERE user_id = $1 AND (NOT $2 OR read_at IS NULL)
+		 ORDER BY created_at DESC, id DESC
+		 LIMIT $3`, userID, unreadOnly, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	notifications := make([]types.Notification, 0)
+	for rows.Next() {
+		var n types.Notification
+		err := rows.Scan(&n.ID, &n.TenantID, &n.UserID, &n.Type, &n.Title, &n.Body, &n.Data, &n.CreatedAt, &n.ReadAt)
+		if err != nil {
+			return nil, 0, err
+		}
+		notifications = append(notifications, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var unread int
+	err = s.dbpool.QueryRow(context.Background(),
+		`SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL`, userID).Scan(&unread)
+	if err != nil {
+		return nil, 0, err
+	}
+	return notifications, unread, nil
+}
+
+func (s *Store) MarkNotificationRead(userID, id int) (bool, error) {
+	tag, err := s.dbpool.Exec(context.Background(),
+		`UPDATE notifications SET read_at = COALESCE(read_at, NOW()) WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (s *Store) MarkAllNotificationsRead(userID int) (int, error) {
+	tag, err := s.dbpool.Exec(context.Background(),
+		`UPDATE notifications SET read_at = NOW() WHERE user_id = $1 AND read_at IS NULL`, userID)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
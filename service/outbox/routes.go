@@ -0,0 +1,81 @@
+package outbox
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/prok05/ecom/service/auth"
+	"github.com/prok05/ecom/types"
+	"github.com/prok05/ecom/utils"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+type Handler struct {
+	store      types.OutboxStore
+	authorizer *auth.Authorizer
+}
+
+func NewHandler(store types.OutboxStore, authorizer *auth.Authorizer) *Handler {
+	return &Handler{
+		store:      store,
+		authorizer: authorizer,
+	}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/admin/crm/outbox", h.authorizer.RequirePermissions(h.handleGetMessages, auth.PermOutboxManage)).Methods(http.MethodGet)
+	router.HandleFunc("/admin/crm/outbox/{messageID}/replay", h.authorizer.RequirePermissions(h.handleReplay, auth.PermOutboxManage)).Methods(http.MethodPost)
+}
+
+// Сообщения очереди школы, ?status=dead - только недоставленные
+func (h *Handler) handleGetMessages(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", StatusPending, StatusDelivered, StatusDead:
+	default:
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid status"))
+		return
+	}
+
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 500 {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid limit"))
+			return
+		}
+		limit = n
+	}
+
+	messages, err := h.store.GetMessages(auth.GetTenantIDFromContext(r.Context()), status, limit)
+	if err != nil {
+		log.Printf("failed to get outbox messages: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get outbox messages"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, messages)
+}
+
+// Повторная отправка недоставленного сообщения
+func (h *Handler) handleReplay(w http.ResponseWriter, r *http.Request) {
+	messageID, err := strconv.Atoi(mux.Vars(r)["messageID"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid message ID"))
+		return
+	}
+
+	ok, err := h.store.Replay(auth.GetTenantIDFromContext(r.Context()), messageID)
+	if err != nil {
+		log.Printf("failed to replay outbox message %d: %v", messageID, err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot replay message"))
+		return
+	}
+	if !ok {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("message not found or already delivered"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, nil)
+}
@@ -0,0 +1,179 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prok05/ecom/types"
+	"time"
+)
+
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusDead      = "dead"
+
+	// KindLessonUpdate - изменение полей урока через lesson/update, payload - поля урока
+	KindLessonUpdate = "lesson.update"
+	// KindLessonAttendance - посещаемость урока, payload - {"details": [types.AlphaAttendance]}
+	KindLessonAttendance = "lesson.attendance"
+)
+
+const messageColumns = `id, tenant_id, kind, lesson_id, payload, status, attempts, next_attempt_at,
+	COALESCE(last_error, ''), created_at, delivered_at`
+
+type Store struct {
+	dbpool *pgxpool.Pool
+}
+
+func NewStore(dbpool *pgxpool.Pool) *Store {
+	return &Store{
+		dbpool: dbpool,
+	}
+}
+
+// homeworkStatusPayload - payload KindLessonUpdate со сводным статусом ДЗ урока %s.
+// Есть решения на проверке - 2, иначе отклоненные - 4, иначе несданные - 3, все приняты - 1.
+const homeworkStatusPayload = `jsonb_build_object('custom_homework_status', (
+	     SELECT CASE
+	         WHEN COUNT(*) = 0 THEN 3
+	         WHEN bool_or(hs.status = 2) THEN 2
+	         WHEN bool_or(hs.status = 4) THEN 4
+	         WHEN bool_or(hs.status = 3) THEN 3
+	         ELSE 1
+	     END
+	     FROM homework_solutions hs
+	     JOIN homeworks h2 ON h2.id = hs.homework_id
+	     WHERE h2.lesson_id = %s
+	 )::text)`
+
+// attendancePayload - payload KindLessonAttendance со всеми отметками урока %[2]s школы %[1]s,
+// без отметок подзапрос не возвращает строк. Опоздание передается как посещение.
+const attendancePayload = `(
+	     SELECT jsonb_build_object('details', jsonb_agg(jsonb_strip_nulls(jsonb_build_object(
+	         'customer_id', a.student_id,
+	         'is_attend', CASE WHEN a.status = 'absent' THEN 0 ELSE 1 END,
+	         'note', NULLIF(a.note, '')
+	     )) ORDER BY a.student_id))
+	     FROM attendance a
+	     WHERE a.tenant_id = %[1]s AND a.lesson_id = %[2]s
+	     HAVING COUNT(*) > 0
+	 )`
+
+// EnqueueHomeworkStatus ставит в очередь отправку в AlfaCRM статуса ДЗ урока, к которому
+// относится homeworkID. Вызывается в транзакции, изменившей решения.
+func EnqueueHomeworkStatus(ctx context.Context, tx pgx.Tx, homeworkID int) error {
+	_, err := tx.Exec(ctx,
+		`INSERT INTO crm_outbox (tenant_id, kind, lesson_id, payload)
+		 SELECT h.tenant_id, $2, h.lesson_id, `+fmt.Sprintf(homeworkStatusPayload, "h.lesson_id")+`
+		 FROM homeworks h
+		 WHERE h.id = $1`, homeworkID, KindLessonUpdate)
+	return err
+}
+
+// EnqueueAttendance ставит в очередь отправку в AlfaCRM всех отметок посещаемости урока.
+// Вызывается в транзакции, изменившей отметки.
+func EnqueueAttendance(ctx context.Context, tx pgx.Tx, tenantID, lessonID int) error {
+	_, err := tx.Exec(ctx,
+		`INSERT INTO crm_outbox (tenant_id, kind, lesson_id, payload)
+		 SELECT $1, $3, $2, payload
+		 FROM `+fmt.Sprintf(attendancePayload, "$1", "$2")+` AS p(payload)`, tenantID, lessonID, KindLessonAttendance)
+	return err
+}
+
+// ClaimDue выбирает сообщения, срок отправки которых наступил. Сообщение не выдается,
+// пока не доставлено более раннее сообщение для того же урока, чтобы старое значение
+// не перезаписало новое.
+func (s *Store) ClaimDue(limit int, lease time.Duration) ([]types.OutboxMessage, error) {
+	rows, err := s.dbpool.Query(context.Background(),
+		`UPDATE crm_outbox SET next_attempt_at = NOW() + $2 * INTERVAL '1 second'
+		 WHERE id IN (
+		     SELECT o.id FROM crm_outbox o
+		     WHERE o.status = 'pending' AND o.next_attempt_at <= NOW()
+		       AND NOT EXISTS (
+		           SELECT 1 FROM crm_outbox prev
+		           WHERE prev.lesson_id = o.lesson_id AND prev.kind = o.kind
+		             AND prev.status = 'pending' AND prev.id < o.id
+		       )
+		     ORDER BY o.id
+		     LIMIT $1
+		     FOR UPDATE SKIP LOCKED
+		 )
+		 RETURNING `+messageColumns, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows)
+}
+
+func (s *Store) MarkDelivered(id int) error {
+	_, err := s.dbpool.Exec(context.Background(),
+		`UPDATE crm_outbox SET status = 'delivered', delivered_at = NOW(), last_error = NULL WHERE id = $1`, id)
+	return err
+}
+
+func (s *Store) MarkFailed(id, attempts int, nextAttemptAt time.Time, lastError string, dead bool) error {
+	status := StatusPending
+	if dead {
+		status = StatusDead
+	}
+	_, err := s.dbpool.Exec(context.Background(),
+		`UPDATE crm_outbox SET status = $2, attempts = $3, next_attempt_at = $4, last_error = $5 WHERE id = $1`,
+		id, status, attempts, nextAttemptAt, lastError)
+	return err
+}
+
+// GetMessages возвращает последние сообщения школы, status пустой - с любым статусом.
+func (s *Store) GetMessages(tenantID int, status string, limit int) ([]types.OutboxMessage, error) {
+	rows, err := s.dbpool.Query(context.Background(),
+		`SELECT `+messageColumns+`
+		 FROM crm_outbox
+		 WHERE tenant_id = $1 AND ($2 = '' OR status = $2)
+		 ORDER BY id DESC
+		 LIMIT $3`, tenantID, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows)
+}
+
+// Replay возвращает недоставленное сообщение в очередь с обнуленным числом попыток.
+// Payload собирается заново из текущих данных, чтобы повтор не перезаписал в CRM
+// более новое значение. Возвращает false, если у школы нет такого сообщения или оно уже доставлено.
+func (s *Store) Replay(tenantID, id int) (bool, error) {
+	tag, err := s.dbpool.Exec(context.Background(),
+		`UPDATE crm_outbox o SET status = 'pending', attempts = 0, next_attempt_at = NOW(),
+		     payload = CASE o.kind
+		         WHEN $3 THEN `+fmt.Sprintf(homeworkStatusPayload, "o.lesson_id")+`
+		         WHEN $4 THEN COALESCE(`+fmt.Sprintf(attendancePayload, "o.tenant_id", "o.lesson_id")+`, o.payload)
+		         ELSE o.payload
+		     END
+		 WHERE o.id = $1 AND o.tenant_id = $2 AND o.status <> 'delivered'`,
+		id, tenantID, KindLessonUpdate, KindLessonAttendance)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func scanMessages(rows pgx.Rows) ([]types.OutboxMessage, error) {
+	defer rows.Close()
+
+	messages := make([]types.OutboxMessage, 0)
+	for rows.Next() {
+		var m types.OutboxMessage
+		var payload []byte
+		err := rows.Scan(&m.ID, &m.TenantID, &m.Kind, &m.LessonID, &payload, &m.Status, &m.Attempts,
+			&m.NextAttemptAt, &m.LastError, &m.CreatedAt, &m.DeliveredAt)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(payload, &m.Payload); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
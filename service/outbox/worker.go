@@ -0,0 +1,166 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/prok05/ecom/service/alpha"
+	"github.com/prok05/ecom/service/tenant"
+	"github.com/prok05/ecom/types"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	batchSize = 50
+	// lease - сколько сообщение не выдается повторно, пока идет его отправка
+	lease = 5 * time.Minute
+)
+
+var (
+	errUnknownKind    = errors.New("unknown outbox message kind")
+	errInvalidPayload = errors.New("invalid outbox message payload")
+)
+
+// Retry - политика повторов: задержка удваивается от Base до Max,
+// после MaxAttempts неудачных попыток сообщение помечается dead.
+type Retry struct {
+	Base        time.Duration
+	Max         time.Duration
+	MaxAttempts int
+}
+
+// Worker отправляет сообщения из crm_outbox в AlfaCRM.
+type Worker struct {
+	store   types.OutboxStore
+	tenants *tenant.Resolver
+	crm     alpha.Provider
+	retry   Retry
+}
+
+func NewWorker(store types.OutboxStore, tenants *tenant.Resolver, crm alpha.Provider, retry Retry) *Worker {
+	return &Worker{
+		store:   store,
+		tenants: tenants,
+		crm:     crm,
+		retry:   retry,
+	}
+}
+
+// Schedule проверяет очередь каждые interval. Блокирует вызывающего.
+func (wk *Worker) Schedule(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := wk.Run(context.Background()); err != nil {
+			log.Printf("outbox delivery failed: %v", err)
+		}
+		<-ticker.C
+	}
+}
+
+// Run отправляет все сообщения, срок которых наступил.
+func (wk *Worker) Run(ctx context.Context) error {
+	for {
+		messages, err := wk.store.ClaimDue(batchSize, lease)
+		if err != nil {
+			return err
+		}
+		for _, m := range messages {
+			wk.deliver(ctx, m)
+		}
+		if len(messages) < batchSize {
+			return nil
+		}
+	}
+}
+
+func (wk *Worker) deliver(ctx context.Context, m types.OutboxMessage) {
+	err := wk.send(ctx, m)
+	if err == nil {
+		if err := wk.store.MarkDelivered(m.ID); err != nil {
+			log.Printf("outbox message %d delivered but not marked: %v", m.ID, err)
+		}
+		return
+	}
+
+	attempts := m.Attempts + 1
+	if errors.Is(err, alpha.ErrUnavailable) {
+		// запрос не отправлялся, пока CRM недоступна, попытка не засчитывается
+		attempts = m.Attempts
+	}
+	dead := permanent(err) || attempts >= wk.retry.MaxAttempts
+	next := time.Now().Add(wk.backoff(attempts))
+	if dead {
+		log.Printf("outbox message %d (%s lesson %d) is dead after %d attempts: %v", m.ID, m.Kind, m.LessonID, attempts, err)
+	} else {
+		log.Printf("outbox message %d (%s lesson %d) failed, retry at %s: %v", m.ID, m.Kind, m.LessonID, next.Format(time.RFC3339), err)
+	}
+	if err := wk.store.MarkFailed(m.ID, attempts, next, err.Error(), dead); err != nil {
+		log.Printf("failed to update outbox message %d: %v", m.ID, err)
+	}
+}
+
+func (wk *Worker) send(ctx context.Context, m types.OutboxMessage) error {
+	t, err := wk.tenants.ByID(m.TenantID)
+	if err != nil {
+		return err
+	}
+	crm := wk.crm.For(alpha.AccountFor(t))
+
+	switch m.Kind {
+	case KindLessonUpdate:
+		return crm.UpdateLesson(ctx, m.LessonID, m.Payload)
+	case KindLessonAttendance:
+		details, err := attendanceDetails(m.Payload)
+		if err != nil {
+			return err
+		}
+		return crm.UpdateLessonAttendance(ctx, m.LessonID, details)
+	}
+	return fmt.Errorf("%w: %s", errUnknownKind, m.Kind)
+}
+
+// attendanceDetails разбирает payload сообщения KindLessonAttendance.
+// Испорченный payload не исправится повтором, поэтому ошибка постоянная.
+func attendanceDetails(payload map[string]any) ([]types.AlphaAttendance, error) {
+	var details struct {
+		Details []types.AlphaAttendance `json:"details"`
+	}
+	raw, err := json.Marshal(payload)
+	if err == nil {
+		err = json.Unmarshal(raw, &details)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidPayload, err)
+	}
+	return details.Details, nil
+}
+
+// backoff - задержка перед попыткой attempts+1.
+func (wk *Worker) backoff(attempts int) time.Duration {
+	delay := wk.retry.Base
+	for i := 1; i < attempts && delay < wk.retry.Max; i++ {
+		delay *= 2
+	}
+	if delay > wk.retry.Max {
+		delay = wk.retry.Max
+	}
+	return delay
+}
+
+// permanent - ошибки, которые повтор не исправит: урок удален в CRM, CRM отклонила
+// запрос как некорректный, тип сообщения неизвестен или payload не разбирается.
+func permanent(err error) bool {
+	if errors.Is(err, alpha.ErrNotFound) || errors.Is(err, errUnknownKind) || errors.Is(err, errInvalidPayload) {
+		return true
+	}
+	var apiErr *alpha.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= 400 && apiErr.StatusCode < 500 &&
+			apiErr.StatusCode != http.StatusTooManyRequests && !alpha.IsUnauthorized(err)
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package outbox
+
+import (
+	"errors"
+	"fmt"
+	"github.com/prok05/ecom/service/alpha"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	wk := NewWorker(nil, nil, nil, Retry{Base: time.Minute, Max: 10 * time.Minute, MaxAttempts: 8})
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, time.Minute},
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{4, 8 * time.Minute},
+		{5, 10 * time.Minute},
+		{100, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.attempts), func(t *testing.T) {
+			if got := wk.backoff(tt.attempts); got != tt.want {
+				t.Errorf("backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPermanent(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"lesson deleted", fmt.Errorf("lesson 1: %w", alpha.ErrNotFound), true},
+		{"unknown kind", fmt.Errorf("%w: x", errUnknownKind), true},
+		{"invalid payload", fmt.Errorf("%w: x", errInvalidPayload), true},
+		{"bad request", &alpha.APIError{StatusCode: http.StatusBadRequest}, true},
+		{"unauthorized", &alpha.APIError{StatusCode: http.StatusUnauthorized}, false},
+		{"forbidden", &alpha.APIError{StatusCode: http.StatusForbidden}, false},
+		{"too many requests", &alpha.APIError{StatusCode: http.StatusTooManyRequests}, false},
+		{"server error", &alpha.APIError{StatusCode: http.StatusInternalServerError}, false},
+		{"crm unavailable", alpha.ErrUnavailable, false},
+		{"transport error", errors.New("connection refused"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := permanent(tt.err); got != tt.want {
+				t.Errorf("permanent(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAttendanceDetails(t *testing.T) {
+	details, err := attendanceDetails(map[string]any{
+		"details": []any{map[string]any{"customer_id": 10, "is_attend": 1}},
+	})
+	if err != nil || len(details) != 1 || details[0].CustomerID != 10 {
+		t.Errorf("got %+v, %v", details, err)
+	}
+
+	if _, err := attendanceDetails(map[string]any{"details": "x"}); !errors.Is(err, errInvalidPayload) {
+		t.Errorf("invalid payload: %v, want errInvalidPayload", err)
+	}
+}
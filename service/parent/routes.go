@@ -0,0 +1,302 @@
+package parent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/prok05/ecom/service/alpha"
+	"github.com/prok05/ecom/service/auth"
+	"github.com/prok05/ecom/service/lesson"
+	"github.com/prok05/ecom/service/tenant"
+	"github.com/prok05/ecom/types"
+	"github.com/prok05/ecom/utils"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// Handler - привязка родителей к ученикам (для супервизора) и доступ родителя
+// к урокам, домашним заданиям и оценкам своих детей только на чтение.
+type Handler struct {
+	store       types.ParentStore
+	userStore   types.UserStore
+	lessonStore types.LessonStore
+	authorizer  *auth.Authorizer
+	crm         alpha.Provider
+}
+
+func NewHandler(store types.ParentStore, userStore types.UserStore, lessonStore types.LessonStore, authorizer *auth.Authorizer, crm alpha.Provider) *Handler {
+	return &Handler{
+		store:       store,
+		userStore:   userStore,
+		lessonStore: lessonStore,
+		authorizer:  authorizer,
+		crm:         crm,
+	}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/parents/{parentID}/children", h.authorizer.RequirePermissions(h.handleGetParentChildren, auth.PermParentManage)).Methods(http.MethodGet)
+	router.HandleFunc("/parents/{parentID}/children", h.authorizer.RequirePermissions(h.handleLinkChild, auth.PermParentManage)).Methods(http.MethodPost)
+	router.HandleFunc("/parents/{parentID}/children/sync", h.authorizer.RequirePermissions(h.handleSyncChildren, auth.PermParentManage)).Methods(http.MethodPost)
+	router.HandleFunc("/parents/{parentID}/children/{studentID}", h.authorizer.RequirePermissions(h.handleUnlinkChild, auth.PermParentManage)).Methods(http.MethodDelete)
+
+	router.HandleFunc("/parent/children", h.authorizer.RequirePermissions(h.handleGetOwnChildren, auth.PermChildRead)).Methods(http.MethodGet)
+	router.HandleFunc("/parent/children/{studentID}/lessons", h.authorizer.RequirePermissions(h.handleGetChildLessons, auth.PermChildRead)).Methods(http.MethodGet)
+	router.HandleFunc("/parent/children/{studentID}/homework", h.authorizer.RequirePermissions(h.handleGetChildHomework, auth.PermChildRead)).Methods(http.MethodGet)
+	router.HandleFunc("/parent/children/{studentID}/ratings", h.authorizer.RequirePermissions(h.handleGetChildRatings, auth.PermChildRead)).Methods(http.MethodGet)
+}
+
+// LinkChildrenFromAlpha привязывает к родителю всех клиентов AlfaCRM с его номером телефона.
+// Возвращает ID привязанных учеников.
+func LinkChildrenFromAlpha(ctx context.Context, store types.ParentStore, crm alpha.API, parentID int, phone string, createdBy *int) ([]int, error) {
+	customers, err := crm.FindCustomersByPhone(ctx, phone)
+	if err != nil {
+		return nil, err
+	}
+
+	linked := make([]int, 0, len(customers))
+	for _, customer := range customers {
+		if err := store.LinkStudent(parentID, customer.ID, types.ParentLinkAlpha, createdBy); err != nil {
+			return linked, err
+		}
+		linked = append(linked, customer.ID)
+	}
+	return linked, nil
+}
+
+func (h *Handler) handleGetParentChildren(w http.ResponseWriter, r *http.Request) {
+	parent, ok := h.parentFromPath(w, r)
+	if !ok {
+		return
+	}
+	h.writeChildren(w, parent.ID)
+}
+
+func (h *Handler) handleLinkChild(w http.ResponseWriter, r *http.Request) {
+	parent, ok := h.parentFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var payload types.LinkChildPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	// ученик может быть еще не зарегистрирован, поэтому проверяем его по AlfaCRM
+	crm := h.crm.For(tenant.Account(r.Context()))
+	if _, err := crm.GetUser(r.Context(), types.RoleStudent, payload.StudentID); err != nil {
+		if errors.Is(err, alpha.ErrNotFound) {
+			utils.WriteError(w, http.StatusNotFound, fmt.Errorf("student not found"))
+			return
+		}
+		log.Printf("handleLinkChild: %v", err)
+		utils.WriteError(w, alpha.HTTPStatus(err), fmt.Errorf("cannot check student in CRM"))
+		return
+	}
+
+	supervisorID := auth.GetUserIDFromContext(r.Context())
+	if err := h.store.LinkStudent(parent.ID, payload.StudentID, types.ParentLinkManual, &supervisorID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot link student"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, map[string]string{"message": "student linked"})
+}
+
+// Привязка детей по данным AlfaCRM: все клиенты с телефоном родителя
+func (h *Handler) handleSyncChildren(w http.ResponseWriter, r *http.Request) {
+	parent, ok := h.parentFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	crm := h.crm.For(tenant.Account(r.Context()))
+	supervisorID := auth.GetUserIDFromContext(r.Context())
+	if _, err := LinkChildrenFromAlpha(r.Context(), h.store, crm, parent.ID, parent.Phone, &supervisorID); err != nil {
+		log.Printf("handleSyncChildren: %v", err)
+		utils.WriteError(w, alpha.HTTPStatus(err), fmt.Errorf("cannot link students from CRM"))
+		return
+	}
+
+	h.writeChildren(w, parent.ID)
+}
+
+func (h *Handler) handleUnlinkChild(w http.ResponseWriter, r *http.Request) {
+	parent, ok := h.parentFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	studentID, err := strconv.Atoi(mux.Vars(r)["studentID"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid student ID"))
+		return
+	}
+
+	removed, err := h.store.UnlinkStudent(parent.ID, studentID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot unlink student"))
+		return
+	}
+	if !removed {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("student is not linked"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"message": "student unlinked"})
+}
+
+func (h *Handler) handleGetOwnChildren(w http.ResponseWriter, r *http.Request) {
+	h.writeChildren(w, auth.GetUserIDFromContext(r.Context()))
+}
+
+// Уроки ребенка: запланированные, отмененные и проведенные
+func (h *Handler) handleGetChildLessons(w http.ResponseWriter, r *http.Request) {
+	studentID, ok := h.childFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	payload := types.GetLessonsPayload{
+		CustomerID: studentID,
+		DateFrom:   r.URL.Query().Get("date_from"),
+		DateTo:     r.URL.Query().Get("date_to"),
+	}
+	crm := h.crm.For(tenant.Account(r.Context()))
+	lessons, err := lesson.FetchLessons(r.Context(), crm, payload, types.RoleStudent, 1, 2, 3)
+	if err != nil {
+		log.Printf("error getting child lessons: %v", err)
+		h.writeMirroredChildLessons(w, r, payload, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, types.AllFutureLessonsResponse{
+		Count: len(lessons),
+		Items: lessons,
+	})
+}
+
+// writeMirroredChildLessons отдает уроки ребенка из локальной копии, когда CRM не ответила.
+func (h *Handler) writeMirroredChildLessons(w http.ResponseWriter, r *http.Request, payload types.GetLessonsPayload, crmErr error) {
+	tenantID := auth.GetTenantIDFromContext(r.Context())
+	state, err := h.lessonStore.GetLessonSyncState(tenantID)
+	if err != nil || state == nil || state.SyncedAt == nil {
+		utils.WriteError(w, alpha.HTTPStatus(crmErr), fmt.Errorf("cannot get lessons from CRM"))
+		return
+	}
+
+	lessons, err := h.lessonStore.ListLessons(types.LessonFilter{
+		TenantID:   tenantID,
+		CustomerID: payload.CustomerID,
+		Statuses:   []int{1, 2, 3},
+		DateFrom:   payload.DateFrom,
+		DateTo:     payload.DateTo,
+	})
+	if err != nil {
+		log.Printf("error getting child lessons from mirror: %v", err)
+		utils.WriteError(w, alpha.HTTPStatus(crmErr), fmt.Errorf("cannot get lessons from CRM"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, types.AllFutureLessonsResponse{
+		Count:    len(lessons),
+		Items:    lessons,
+		SyncedAt: state.SyncedAt,
+		Stale:    true,
+	})
+}
+
+// Статусы домашних заданий ребенка с комментариями преподавателей
+func (h *Handler) handleGetChildHomework(w http.ResponseWriter, r *http.Request) {
+	studentID, ok := h.childFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	homeworks, err := h.store.GetChildHomeworks(studentID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get homeworks"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, homeworks)
+}
+
+// Оценки, которые ребенок поставил урокам
+func (h *Handler) handleGetChildRatings(w http.ResponseWriter, r *http.Request) {
+	studentID, ok := h.childFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	rates, err := h.store.GetChildLessonRates(studentID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get lesson rates"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, rates)
+}
+
+func (h *Handler) writeChildren(w http.ResponseWriter, parentID int) {
+	children, err := h.store.GetChildren(parentID)
+	if err != nil {
+		log.Printf("failed to get children of parent %d: %v", parentID, err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get children"))
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, children)
+}
+
+// parentFromPath загружает родителя из {parentID} и проверяет, что у пользователя роль parent.
+func (h *Handler) parentFromPath(w http.ResponseWriter, r *http.Request) (*types.UserDTO, bool) {
+	parentID, err := strconv.Atoi(mux.Vars(r)["parentID"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid parent ID"))
+		return nil, false
+	}
+
+	u, err := h.userStore.FindUserByID(parentID)
+	if err != nil || u.TenantID != auth.GetTenantIDFromContext(r.Context()) {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("user not found"))
+		return nil, false
+	}
+	if u.Role != types.RoleParent {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("user %d is not a parent", parentID))
+		return nil, false
+	}
+	return u, true
+}
+
+// childFromPath возвращает {studentID}, если ученик привязан к текущему родителю.
+func (h *Handler) childFromPath(w http.ResponseWriter, r *http.Request) (int, bool) {
+	studentID, err := strconv.Atoi(mux.Vars(r)["studentID"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid student ID"))
+		return 0, false
+	}
+
+	parentID := auth.GetUserIDFromContext(r.Context())
+	linked, err := h.store.IsParentOf(parentID, studentID)
+	if err != nil {
+		log.Printf("failed to check parent link: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to check access"))
+		return 0, false
+	}
+	if !linked {
+		log.Printf("parent %d has no access to student %d", parentID, studentID)
+		utils.WriteError(w, http.StatusForbidden, fmt.Errorf("permission denied"))
+		return 0, false
+	}
+	return studentID, true
+}
@@ -0,0 +1,152 @@
+package parent
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prok05/ecom/types"
+	"log"
+)
+
+type Store struct {
+	dbpool *pgxpool.Pool
+}
+
+func NewStore(dbpool *pgxpool.Pool) *Store {
+	return &Store{
+		dbpool: dbpool,
+	}
+}
+
+// LinkStudent привязывает ученика к родителю. Повторная привязка ничего не меняет.
+func (s *Store) LinkStudent(parentID, studentID int, source string, createdBy *int) error {
+	_, err := s.dbpool.Exec(context.Background(),
+		`INSERT INTO parent_students (parent_id, student_id, source, created_by)
+		 VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (parent_id, student_id) DO NOTHING`,
+		parentID, studentID, source, createdBy)
+	if err != nil {
+		log.Println("failed to link student:", err)
+		return err
+	}
+	return nil
+}
+
+func (s *Store) UnlinkStudent(parentID, studentID int) (bool, error) {
+	tag, err := s.dbpool.Exec(context.Background(),
+		`DELETE FROM parent_students WHERE parent_id = $1 AND student_id = $2`, parentID, studentID)
+	if err != nil {
+		log.Println("failed to unlink student:", err)
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (s *Store) GetChildren(parentID int) ([]types.ParentChild, error) {
+	rows, err := s.dbpool.Query(context.Background(),
+		`SELECT ps.student_id,
+		        COALESCE(u.first_name, ''), COALESCE(u.last_name, ''), COALESCE(u.middle_name, ''),
+		        u.id IS NOT NULL,
+		        ps.source, ps.created_at
+		 FROM parent_students ps
+		 LEFT JOIN users u ON u.id = ps.student_id
+		 WHERE ps.parent_id = $1
+		 ORDER BY ps.created_at`, parentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	children := make([]types.ParentChild, 0)
+	for rows.Next() {
+		var c types.ParentChild
+		if err := rows.Scan(&c.StudentID, &c.FirstName, &c.LastName, &c.MiddleName, &c.Registered, &c.Source, &c.LinkedAt); err != nil {
+			return nil, err
+		}
+		children = append(children, c)
+	}
+	return children, rows.Err()
+}
+
+func (s *Store) IsParentOf(parentID, studentID int) (bool, error) {
+	var exists bool
+	err := s.dbpool.QueryRow(context.Background(),
+		`SELECT EXISTS(SELECT 1 FROM parent_students WHERE parent_id = $1 AND student_id = $2)`,
+		parentID, studentID).Scan(&exists)
+	return exists, err
+}
+
+func (s *Store) GetChildHomeworks(studentID int) ([]types.ChildHomework, error) {
+	rows, err := s.dbpool.Query(context.Background(),
+		`SELECT h.id, h.lesson_id, h.lesson_date, COALESCE(h.lesson_topic, ''), h.subject_title,
+		        h.teacher_id, t.first_name, t.last_name,
+		        hs.status, COALESCE(hs.review_notes, ''), hs.updated_at
+		 FROM homework_solutions hs
+		 JOIN homeworks h ON h.id = hs.homework_id
+		 JOIN users t ON t.id = h.teacher_id
+		 WHERE hs.student_id = $1
+		 ORDER BY h.lesson_date DESC`, studentID)
+	if err != nil {
+		log.Printf("failed to get homeworks for child: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	homeworks := make([]types.ChildHomework, 0)
+	for rows.Next() {
+		var hw types.ChildHomework
+		err := rows.Scan(
+			&hw.HomeworkID,
+			&hw.LessonID,
+			&hw.LessonDate,
+			&hw.LessonTopic,
+			&hw.SubjectTitle,
+			&hw.TeacherID,
+			&hw.TeacherFirstName,
+			&hw.TeacherLastName,
+			&hw.Status,
+			&hw.ReviewNotes,
+			&hw.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		homeworks = append(homeworks, hw)
+	}
+	return homeworks, rows.Err()
+}
+
+func (s *Store) GetChildLessonRates(studentID int) ([]types.LessonRate, error) {
+	rows, err := s.dbpool.Query(context.Background(),
+		`SELECT lr.id, lr.student_id, lr.teacher_id, t.first_name, t.last_name, t.middle_name,
+		        lr.lesson_id::text, lr.lesson_date, lr.rate, lr.comment
+		 FROM lesson_rates lr
+		 JOIN users t ON t.id = lr.teacher_id
+		 WHERE lr.student_id = $1
+		 ORDER BY lr.lesson_date DESC`, studentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := make([]types.LessonRate, 0)
+	for rows.Next() {
+		var lr types.LessonRate
+		err := rows.Scan(
+			&lr.ID,
+			&lr.StudentID,
+			&lr.TeacherID,
+			&lr.TeacherFirstName,
+			&lr.TeacherLastName,
+			&lr.TeacherMiddleName,
+			&lr.LessonID,
+			&lr.LessonDate,
+			&lr.Rate,
+			&lr.Comment,
+		)
+		if err != nil {
+			return nil, err
+		}
+		rates = append(rates, lr)
+	}
+	return rates, rows.Err()
+}
@@ -0,0 +1,103 @@
+package reference
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/prok05/ecom/service/auth"
+	"github.com/prok05/ecom/service/tenant"
+	"github.com/prok05/ecom/types"
+	"github.com/prok05/ecom/utils"
+	"log"
+	"net/http"
+)
+
+type Handler struct {
+	store      types.ReferenceStore
+	syncer     *Syncer
+	authorizer *auth.Authorizer
+}
+
+func NewHandler(store types.ReferenceStore, syncer *Syncer, authorizer *auth.Authorizer) *Handler {
+	return &Handler{
+		store:      store,
+		syncer:     syncer,
+		authorizer: authorizer,
+	}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/reference/subjects", h.authorizer.RequirePermissions(h.handleGetSubjects, auth.PermLessonRead)).Methods(http.MethodGet)
+	router.HandleFunc("/reference/rooms", h.authorizer.RequirePermissions(h.handleGetRooms, auth.PermLessonRead)).Methods(http.MethodGet)
+	router.HandleFunc("/reference/groups", h.authorizer.RequirePermissions(h.handleGetGroups, auth.PermLessonRead)).Methods(http.MethodGet)
+
+	router.HandleFunc("/admin/crm/reference/sync", h.authorizer.RequirePermissions(h.handleRunSync, auth.PermUserManage)).Methods(http.MethodPost)
+}
+
+func (h *Handler) handleGetSubjects(w http.ResponseWriter, r *http.Request) {
+	subjects, err := h.store.GetSubjects(auth.GetTenantIDFromContext(r.Context()))
+	if err != nil {
+		log.Printf("failed to get subjects: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get subjects"))
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, subjects)
+}
+
+func (h *Handler) handleGetRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := h.store.GetRooms(auth.GetTenantIDFromContext(r.Context()))
+	if err != nil {
+		log.Printf("failed to get rooms: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get rooms"))
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, rooms)
+}
+
+// Группы школы. Ученик видит только свои группы, преподаватель - группы, которые ведет
+func (h *Handler) handleGetGroups(w http.ResponseWriter, r *http.Request) {
+	groups, err := h.store.GetGroups(auth.GetTenantIDFromContext(r.Context()))
+	if err != nil {
+		log.Printf("failed to get groups: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get groups"))
+		return
+	}
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if principal.Role != types.RoleSupervisor {
+		visible := make([]types.LearningGroup, 0)
+		for _, g := range groups {
+			if containsID(g.CustomerIDs, principal.UserID) || containsID(g.TeacherIDs, principal.UserID) {
+				visible = append(visible, g)
+			}
+		}
+		groups = visible
+	}
+
+	utils.WriteJSON(w, http.StatusOK, groups)
+}
+
+// Синхронизация справочников вне расписания, в ответе отчет
+func (h *Handler) handleRunSync(w http.ResponseWriter, r *http.Request) {
+	report, err := h.syncer.Run(r.Context(), tenant.FromContext(r.Context()))
+	if err != nil {
+		if errors.Is(err, ErrSyncInProgress) {
+			utils.WriteError(w, http.StatusConflict, err)
+			return
+		}
+		log.Printf("reference sync failed: %v", err)
+		utils.WriteError(w, http.StatusBadGateway, fmt.Errorf("reference sync failed"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, report)
+}
+
+func containsID(ids []int, id int) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
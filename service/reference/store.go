@@ -0,0 +1,163 @@
+package reference
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prok05/ecom/types"
+)
+
+type Store struct {
+	dbpool *pgxpool.Pool
+}
+
+func NewStore(dbpool *pgxpool.Pool) *Store {
+	return &Store{
+		dbpool: dbpool,
+	}
+}
+
+func (s *Store) GetSubjects(tenantID int) ([]types.ReferenceItem, error) {
+	return s.getItems(`SELECT id, name, is_active FROM subjects WHERE tenant_id = $1 ORDER BY name`, tenantID)
+}
+
+func (s *Store) GetRooms(tenantID int) ([]types.ReferenceItem, error) {
+	return s.getItems(`SELECT id, name, is_active FROM rooms WHERE tenant_id = $1 ORDER BY name`, tenantID)
+}
+
+func (s *Store) GetGroups(tenantID int) ([]types.LearningGroup, error) {
+	rows, err := s.dbpool.Query(context.Background(),
+		`SELECT g.id, g.name, g.is_active, g.teacher_ids,
+		        ARRAY(SELECT customer_id FROM learning_group_customers
+		              WHERE tenant_id = g.tenant_id AND group_id = g.id ORDER BY customer_id),
+		        to_char(g.b_date, 'YYYY-MM-DD'), to_char(g.e_date, 'YYYY-MM-DD')
+		 FROM learning_groups g
+		 WHERE g.tenant_id = $1
+		 ORDER BY g.name`, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := make([]types.LearningGroup, 0)
+	for rows.Next() {
+		var g types.LearningGroup
+		err := rows.Scan(&g.ID, &g.Name, &g.IsActive, &g.TeacherIDs, &g.CustomerIDs, &g.BDate, &g.EDate)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
+
+func (s *Store) ReplaceSubjects(tenantID int, items []types.ReferenceItem) error {
+	return s.replaceItems("subjects", tenantID, items)
+}
+
+func (s *Store) ReplaceRooms(tenantID int, items []types.ReferenceItem) error {
+	return s.replaceItems("rooms", tenantID, items)
+}
+
+// ReplaceGroups сохраняет группы и заменяет их состав.
+func (s *Store) ReplaceGroups(tenantID int, groups []types.LearningGroup) error {
+	ctx := context.Background()
+	tx, err := s.dbpool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	ids := make([]int, 0, len(groups))
+	for _, g := range groups {
+		_, err := tx.Exec(ctx,
+			`INSERT INTO learning_groups (tenant_id, id, name, teacher_ids, b_date, e_date, is_active, synced_at)
+			 VALUES ($1, $2, $3, COALESCE($4::bigint[], '{}'), $5::text::date, $6::text::date, $7, NOW())
+			 ON CONFLICT (tenant_id, id) DO UPDATE SET
+			     name = EXCLUDED.name,
+			     teacher_ids = EXCLUDED.teacher_ids,
+			     b_date = EXCLUDED.b_date,
+			     e_date = EXCLUDED.e_date,
+			     is_active = EXCLUDED.is_active,
+			     synced_at = NOW()`,
+			tenantID, g.ID, g.Name, g.TeacherIDs, g.BDate, g.EDate, g.IsActive)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, `DELETE FROM learning_group_customers WHERE tenant_id = $1 AND group_id = $2`, tenantID, g.ID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx,
+			`INSERT INTO learning_group_customers (tenant_id, group_id, customer_id)
+			 SELECT $1, $2, unnest($3::bigint[]) ON CONFLICT DO NOTHING`,
+			tenantID, g.ID, g.CustomerIDs)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, g.ID)
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE learning_groups SET is_active = FALSE WHERE tenant_id = $1 AND NOT (id = ANY($2))`, tenantID, ids)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// LinkHomeworkSubjects находит предмет ДЗ по названию, которое ввел преподаватель.
+func (s *Store) LinkHomeworkSubjects(tenantID int) (int, error) {
+	tag, err := s.dbpool.Exec(context.Background(),
+		`UPDATE homeworks h SET subject_id = s.id
+		 FROM subjects s
+		 WHERE h.tenant_id = $1 AND s.tenant_id = h.tenant_id AND h.subject_id IS NULL
+		   AND lower(trim(h.subject_title)) = lower(s.name)`, tenantID)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+func (s *Store) getItems(query string, tenantID int) ([]types.ReferenceItem, error) {
+	rows, err := s.dbpool.Query(context.Background(), query, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByName[types.ReferenceItem])
+}
+
+// replaceItems сохраняет записи справочника table, остальные записи школы становятся неактивными.
+// table - имя таблицы из кода, не из запроса.
+func (s *Store) replaceItems(table string, tenantID int, items []types.ReferenceItem) error {
+	ctx := context.Background()
+	tx, err := s.dbpool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	ids := make([]int, 0, len(items))
+	for _, item := range items {
+		_, err := tx.Exec(ctx,
+			`INSERT INTO `+table+` (tenant_id, id, name, is_active, synced_at)
+			 VALUES ($1, $2, $3, $4, NOW())
+			 ON CONFLICT (tenant_id, id) DO UPDATE SET
+			     name = EXCLUDED.name,
+			     is_active = EXCLUDED.is_active,
+			     synced_at = NOW()`,
+			tenantID, item.ID, item.Name, item.IsActive)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, item.ID)
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE `+table+` SET is_active = FALSE WHERE tenant_id = $1 AND NOT (id = ANY($2))`, tenantID, ids)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,156 @@
+package reference
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/prok05/ecom/service/alpha"
+	"github.com/prok05/ecom/service/tenant"
+	"github.com/prok05/ecom/types"
+	"log"
+	"sync"
+	"time"
+)
+
+var ErrSyncInProgress = errors.New("reference sync is already running")
+
+// Syncer переносит предметы, аудитории и группы из AlfaCRM. Справочник, который
+// не удалось получить, не меняется, остальные сохраняются.
+type Syncer struct {
+	store   types.ReferenceStore
+	tenants *tenant.Resolver
+	crm     alpha.Provider
+	mu      sync.Mutex
+}
+
+func NewSyncer(store types.ReferenceStore, tenants *tenant.Resolver, crm alpha.Provider) *Syncer {
+	return &Syncer{
+		store:   store,
+		tenants: tenants,
+		crm:     crm,
+	}
+}
+
+// Schedule синхронизирует справочники всех школ сразу и затем каждые interval. Блокирует вызывающего.
+func (s *Syncer) Schedule(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		tenants, err := s.tenants.Tenants()
+		if err != nil {
+			log.Printf("reference sync failed: %v", err)
+		}
+		for i := range tenants {
+			if _, err := s.Run(context.Background(), &tenants[i]); err != nil {
+				log.Printf("reference sync of tenant %s failed: %v", tenants[i].Slug, err)
+			}
+		}
+		<-ticker.C
+	}
+}
+
+// Run синхронизирует справочники школы. Одновременно выполняется только один запуск.
+func (s *Syncer) Run(ctx context.Context, t *types.Tenant) (*types.ReferenceSyncReport, error) {
+	if !s.mu.TryLock() {
+		return nil, ErrSyncInProgress
+	}
+	defer s.mu.Unlock()
+
+	report := &types.ReferenceSyncReport{
+		TenantID:  t.ID,
+		StartedAt: time.Now(),
+		Errors:    make([]string, 0),
+	}
+	fail := func(name string, err error) {
+		report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", name, err))
+	}
+	crm := s.crm.For(alpha.AccountFor(t))
+
+	if subjects, err := crm.ListSubjects(ctx); err != nil {
+		fail("subjects", err)
+	} else {
+		items := make([]types.ReferenceItem, len(subjects))
+		for i, v := range subjects {
+			items[i] = types.ReferenceItem{ID: v.ID, Name: v.Name, IsActive: true}
+		}
+		if err := s.store.ReplaceSubjects(t.ID, items); err != nil {
+			fail("subjects", err)
+		} else {
+			report.Subjects = len(items)
+		}
+	}
+
+	if rooms, err := crm.ListRooms(ctx); err != nil {
+		fail("rooms", err)
+	} else {
+		items := make([]types.ReferenceItem, len(rooms))
+		for i, v := range rooms {
+			items[i] = types.ReferenceItem{ID: v.ID, Name: v.Name, IsActive: v.IsEnabled == 1}
+		}
+		if err := s.store.ReplaceRooms(t.ID, items); err != nil {
+			fail("rooms", err)
+		} else {
+			report.Rooms = len(items)
+		}
+	}
+
+	if groups, err := s.groups(ctx, crm); err != nil {
+		fail("groups", err)
+	} else if err := s.store.ReplaceGroups(t.ID, groups); err != nil {
+		fail("groups", err)
+	} else {
+		report.Groups = len(groups)
+	}
+
+	linked, err := s.store.LinkHomeworkSubjects(t.ID)
+	if err != nil {
+		fail("homeworks", err)
+	}
+	report.HomeworksLinked = linked
+
+	report.FinishedAt = time.Now()
+	log.Printf("reference sync of tenant %s finished: subjects=%d rooms=%d groups=%d homeworks_linked=%d errors=%d",
+		t.Slug, report.Subjects, report.Rooms, report.Groups, report.HomeworksLinked, len(report.Errors))
+	return report, nil
+}
+
+// groups получает группы с учениками, которые занимаются в них сейчас.
+func (s *Syncer) groups(ctx context.Context, crm alpha.API) ([]types.LearningGroup, error) {
+	alphaGroups, err := crm.ListGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	today := time.Now().Format("2006-01-02")
+	groups := make([]types.LearningGroup, 0, len(alphaGroups))
+	for _, g := range alphaGroups {
+		members, err := crm.ListGroupCustomers(ctx, g.ID)
+		if err != nil {
+			return nil, fmt.Errorf("group %d: %w", g.ID, err)
+		}
+
+		group := types.LearningGroup{
+			ReferenceItem: types.ReferenceItem{ID: g.ID, Name: g.Name, IsActive: g.EDate == "" || g.EDate >= today},
+			TeacherIDs:    g.TeacherIDs,
+			CustomerIDs:   make([]int, 0, len(members)),
+			BDate:         date(g.BDate),
+			EDate:         date(g.EDate),
+		}
+		for _, m := range members {
+			if m.EDate == "" || m.EDate >= today {
+				group.CustomerIDs = append(group.CustomerIDs, m.CustomerID)
+			}
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+// date берет дату из значения AlfaCRM, которое может содержать и время.
+func date(value string) *string {
+	if len(value) < len("2006-01-02") {
+		return nil
+	}
+	d := value[:len("2006-01-02")]
+	return &d
+}
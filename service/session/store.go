@@ -0,0 +1,166 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prok05/ecom/types"
+	"log"
+	"time"
+)
+
+type Store struct {
+	pool *pgxpool.Pool
+}
+
+func NewStore(pool *pgxpool.Pool) *Store {
+	return &Store{
+		pool: pool,
+	}
+}
+
+const sessionColumns = `id, user_id, refresh_token_hash, COALESCE(user_agent, ''), COALESCE(ip, ''),
+	created_at, last_used_at, expires_at, revoked_at`
+
+func (s *Store) CreateSession(session *types.Session) error {
+	err := s.pool.QueryRow(context.Background(),
+		`INSERT INTO sessions (user_id, refresh_token_hash, user_agent, ip, expires_at)
+		 VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at, last_used_at`,
+		session.UserID, session.RefreshTokenHash, session.UserAgent, session.IP, session.ExpiresAt).
+		Scan(&session.ID, &session.CreatedAt, &session.LastUsedAt)
+	if err != nil {
+		log.Println("failed to create session:", err)
+		return err
+	}
+	return nil
+}
+
+func (s *Store) GetSessionByID(sessionID int) (*types.Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions WHERE id = $1`
+	return s.getSession(query, sessionID)
+}
+
+func (s *Store) GetSessionByTokenHash(tokenHash string) (*types.Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions WHERE refresh_token_hash = $1`
+	return s.getSession(query, tokenHash)
+}
+
+// GetSessionByPreviousTokenHash ищет сессию по уже ротированному refresh-токену.
+// Используется для обнаружения повторного использования украденного токена.
+func (s *Store) GetSessionByPreviousTokenHash(tokenHash string) (*types.Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions WHERE previous_token_hash = $1`
+	return s.getSession(query, tokenHash)
+}
+
+func (s *Store) GetActiveSessionsByUserID(userID int) ([]types.Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_used_at DESC`
+
+	rows, err := s.pool.Query(context.Background(), query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := make([]types.Session, 0)
+	for rows.Next() {
+		session, err := scanRowIntoSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+func (s *Store) IsSessionActive(sessionID int) (bool, error) {
+	var active bool
+	query := `SELECT EXISTS (
+		SELECT 1 FROM sessions WHERE id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+	)`
+	err := s.pool.QueryRow(context.Background(), query, sessionID).Scan(&active)
+	return active, err
+}
+
+// RotateRefreshToken заменяет refresh-токен сессии. Обновление происходит только если
+// текущий хэш совпадает с oldHash, поэтому два параллельных refresh не пройдут оба.
+func (s *Store) RotateRefreshToken(sessionID int, oldHash, newHash string, expiresAt time.Time) error {
+	tag, err := s.pool.Exec(context.Background(),
+		`UPDATE sessions
+		 SET refresh_token_hash = $1, previous_token_hash = $2, expires_at = $3, last_used_at = NOW()
+		 WHERE id = $4 AND refresh_token_hash = $2 AND revoked_at IS NULL`,
+		newHash, oldHash, expiresAt, sessionID)
+	if err != nil {
+		log.Println("failed to rotate refresh token:", err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("session %d not found or already rotated", sessionID)
+	}
+	return nil
+}
+
+func (s *Store) RevokeSession(sessionID int) error {
+	_, err := s.pool.Exec(context.Background(),
+		`UPDATE sessions SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`, sessionID)
+	if err != nil {
+		log.Println("failed to revoke session:", err)
+		return err
+	}
+	return nil
+}
+
+func (s *Store) RevokeAllUserSessions(userID int) error {
+	_, err := s.pool.Exec(context.Background(),
+		`UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+	if err != nil {
+		log.Println("failed to revoke user sessions:", err)
+		return err
+	}
+	return nil
+}
+
+func (s *Store) getSession(query string, arg any) (*types.Session, error) {
+	rows, err := s.pool.Query(context.Background(), query, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	return scanRowIntoSession(rows)
+}
+
+func scanRowIntoSession(rows pgx.Rows) (*types.Session, error) {
+	session := new(types.Session)
+
+	err := rows.Scan(
+		&session.ID,
+		&session.UserID,
+		&session.RefreshTokenHash,
+		&session.UserAgent,
+		&session.IP,
+		&session.CreatedAt,
+		&session.LastUsedAt,
+		&session.ExpiresAt,
+		&session.RevokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
@@ -0,0 +1,59 @@
+package sms
+
+import (
+	"fmt"
+	"github.com/prok05/ecom/config"
+	"github.com/prok05/ecom/types"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// NewSender возвращает отправщика SMS в зависимости от SMS_PROVIDER.
+// Реального провайдера пока нет, для локальной работы есть console и file.
+func NewSender() (types.SMSSender, error) {
+	switch config.Envs.SMSProvider {
+	case "console":
+		return &ConsoleSender{}, nil
+	case "file":
+		return NewFileSender(config.Envs.SMSFilePath), nil
+	default:
+		return nil, fmt.Errorf("unknown sms provider: %s", config.Envs.SMSProvider)
+	}
+}
+
+// ConsoleSender пишет сообщения в лог вместо отправки.
+type ConsoleSender struct{}
+
+func (s *ConsoleSender) Send(phone, text string) error {
+	log.Printf("SMS to %s: %s", phone, text)
+	return nil
+}
+
+// FileSender дописывает сообщения в файл, удобно для ручного тестирования.
+type FileSender struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileSender(path string) *FileSender {
+	return &FileSender{path: path}
+}
+
+func (s *FileSender) Send(phone, text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open sms file: %v", err)
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "%s\t%s\t%s\n", time.Now().Format(time.RFC3339), phone, text)
+	if err != nil {
+		return fmt.Errorf("failed to write sms: %v", err)
+	}
+	return nil
+}
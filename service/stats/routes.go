@@ -0,0 +1,191 @@
+package stats
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/prok05/ecom/service/auth"
+	"github.com/prok05/ecom/types"
+	"github.com/prok05/ecom/utils"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// defaultDays - период по умолчанию, заканчивается сегодня
+	defaultDays = 30
+	maxDays     = 366
+)
+
+// rankings - показатели, по которым строится сводка: значение и лучше ли большее.
+// Преподаватели без значения идут последними.
+var rankings = map[string]func(t types.TeacherStats) (*float64, bool){
+	"rating": func(t types.TeacherStats) (*float64, bool) {
+		return t.Ratings.Average, true
+	},
+	"review_time": func(t types.TeacherStats) (*float64, bool) {
+		return t.Homework.MedianReviewHours, false
+	},
+	"response_time": func(t types.TeacherStats) (*float64, bool) {
+		return t.Chat.MedianResponseMinutes, false
+	},
+	"reviewed": func(t types.TeacherStats) (*float64, bool) {
+		return floatPtr(float64(t.Homework.Reviewed)), true
+	},
+	"lessons": func(t types.TeacherStats) (*float64, bool) {
+		return floatPtr(float64(t.LessonsHeld)), true
+	},
+}
+
+type Handler struct {
+	store      types.TeacherStatsStore
+	authorizer *auth.Authorizer
+}
+
+func NewHandler(store types.TeacherStatsStore, authorizer *auth.Authorizer) *Handler {
+	return &Handler{
+		store:      store,
+		authorizer: authorizer,
+	}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/admin/teachers/stats", h.authorizer.RequirePermissions(h.handleGetOverview, auth.PermReportRead)).Methods(http.MethodGet)
+	router.HandleFunc("/admin/teachers/{teacherID}/stats", h.authorizer.RequirePermissions(h.handleGetTeacherStats, auth.PermReportRead)).Methods(http.MethodGet)
+}
+
+// Показатели преподавателя за период ?date_from=&date_to= (YYYY-MM-DD, по умолчанию
+// последние 30 дней) и за предыдущий период той же длины
+func (h *Handler) handleGetTeacherStats(w http.ResponseWriter, r *http.Request) {
+	teacherID, err := strconv.Atoi(mux.Vars(r)["teacherID"])
+	if err != nil || teacherID <= 0 {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid teacher ID"))
+		return
+	}
+	period, err := parsePeriod(r.URL.Query())
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	reports, err := h.reports(auth.GetTenantIDFromContext(r.Context()), teacherID, period)
+	if err != nil {
+		log.Printf("failed to get stats of teacher %d: %v", teacherID, err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get teacher stats"))
+		return
+	}
+	if len(reports) == 0 {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("teacher not found"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, reports[0])
+}
+
+// Сводка по активным преподавателям школы, упорядоченная по показателю
+// ?sort=rating|review_time|response_time|reviewed|lessons (по умолчанию rating), период как у статистики преподавателя
+func (h *Handler) handleGetOverview(w http.ResponseWriter, r *http.Request) {
+	period, err := parsePeriod(r.URL.Query())
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	sortBy := r.URL.Query().Get("sort")
+	if sortBy == "" {
+		sortBy = "rating"
+	}
+	metric, ok := rankings[sortBy]
+	if !ok {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid sort, expected rating, review_time, response_time, reviewed or lessons"))
+		return
+	}
+
+	reports, err := h.reports(auth.GetTenantIDFromContext(r.Context()), 0, period)
+	if err != nil {
+		log.Printf("failed to get teacher stats: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get teacher stats"))
+		return
+	}
+
+	sort.SliceStable(reports, func(i, j int) bool {
+		a, higher := metric(reports[i].Current)
+		b, _ := metric(reports[j].Current)
+		if a == nil || b == nil {
+			return a != nil
+		}
+		if higher {
+			return *a > *b
+		}
+		return *a < *b
+	})
+	ranking := make([]types.TeacherRanking, len(reports))
+	for i, report := range reports {
+		ranking[i] = types.TeacherRanking{Rank: i + 1, TeacherStatsReport: report}
+	}
+
+	utils.WriteJSON(w, http.StatusOK, ranking)
+}
+
+// reports считает показатели за период и за предыдущий период той же длины.
+func (h *Handler) reports(tenantID, teacherID int, period types.StatsPeriod) ([]types.TeacherStatsReport, error) {
+	current, err := h.store.GetTeacherStats(tenantID, teacherID, period)
+	if err != nil || len(current) == 0 {
+		return nil, err
+	}
+	previous, err := h.store.GetTeacherStats(tenantID, teacherID, previousPeriod(period))
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int]types.TeacherStats, len(previous))
+	for _, t := range previous {
+		byID[t.TeacherID] = t
+	}
+
+	reports := make([]types.TeacherStatsReport, len(current))
+	for i, t := range current {
+		reports[i] = types.TeacherStatsReport{Current: t, Previous: byID[t.TeacherID]}
+	}
+	return reports, nil
+}
+
+func parsePeriod(values url.Values) (types.StatsPeriod, error) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	period := types.StatsPeriod{From: today.AddDate(0, 0, 1-defaultDays), To: today}
+	for name, dest := range map[string]*time.Time{
+		"date_from": &period.From,
+		"date_to":   &period.To,
+	} {
+		if v := values.Get(name); v != "" {
+			date, err := time.Parse("2006-01-02", v)
+			if err != nil {
+				return period, fmt.Errorf("invalid %s, expected YYYY-MM-DD", name)
+			}
+			*dest = date
+		}
+	}
+	if period.To.Before(period.From) {
+		return period, fmt.Errorf("date_to is before date_from")
+	}
+	if days(period) > maxDays {
+		return period, fmt.Errorf("period is longer than %d days", maxDays)
+	}
+	return period, nil
+}
+
+// previousPeriod - период той же длины, заканчивающийся накануне period.From.
+func previousPeriod(period types.StatsPeriod) types.StatsPeriod {
+	to := period.From.AddDate(0, 0, -1)
+	return types.StatsPeriod{From: to.AddDate(0, 0, 1-days(period)), To: to}
+}
+
+// days - число дней в периоде, включая обе даты
+func days(period types.StatsPeriod) int {
+	return int(period.To.Sub(period.From).Hours()/24) + 1
+}
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
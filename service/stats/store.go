@@ -0,0 +1,225 @@
+package stats
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prok05/ecom/types"
+	"math"
+)
+
+// Параметры запросов: $1 - школа, $2 - преподаватель (0 - все), $3..$4 - период.
+const (
+	teachersQuery = `
+		SELECT id, first_name, last_name, middle_name
+		FROM users
+		WHERE tenant_id = $1 AND user_role = 'teacher' AND (($2 = 0 AND is_active) OR id = $2)
+		ORDER BY last_name, first_name, id`
+
+	lessonsQuery = `
+		SELECT lt.teacher_id, COUNT(*)
+		FROM lessons l
+		JOIN lesson_teachers lt ON lt.lesson_id = l.id
+		WHERE l.tenant_id = $1 AND ($2 = 0 OR lt.teacher_id = $2) AND l.status = 3
+		  AND l.date BETWEEN $3 AND $4
+		GROUP BY 1`
+
+	ratingsQuery = `
+		SELECT teacher_id, COUNT(*), AVG(rate)::float8,
+		       COUNT(*) FILTER (WHERE rate = 1), COUNT(*) FILTER (WHERE rate = 2),
+		       COUNT(*) FILTER (WHERE rate = 3), COUNT(*) FILTER (WHERE rate = 4),
+		       COUNT(*) FILTER (WHERE rate = 5),
+		       COUNT(*) FILTER (WHERE comment <> '')
+		FROM lesson_rates
+		WHERE tenant_id = $1 AND ($2 = 0 OR teacher_id = $2)
+		  AND lesson_date::date BETWEEN $3 AND $4
+		GROUP BY 1`
+
+	// ДЗ по дате урока и текущие статусы их решений
+	homeworkQuery = `
+		SELECT h.teacher_id, COUNT(DISTINCT h.id), COUNT(hs.id),
+		       COUNT(hs.id) FILTER (WHERE hs.status = 3), COUNT(hs.id) FILTER (WHERE hs.status = 2),
+		       COUNT(hs.id) FILTER (WHERE hs.status = 1), COUNT(hs.id) FILTER (WHERE hs.status = 4)
+		FROM homeworks h
+		LEFT JOIN homework_solutions hs ON hs.homework_id = h.id
+		WHERE h.tenant_id = $1 AND ($2 = 0 OR h.teacher_id = $2)
+		  AND h.lesson_date::date BETWEEN $3 AND $4
+		GROUP BY 1`
+
+	// проверки, сделанные за период, и время от сдачи до проверки в часах
+	reviewsQuery = `
+		SELECT h.teacher_id, COUNT(*),
+		       AVG(EXTRACT(EPOCH FROM hs.reviewed_at - hs.submitted_at))::float8 / 3600,
+		       percentile_cont(0.5) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM hs.reviewed_at - hs.submitted_at)) / 3600
+		FROM homework_solutions hs
+		JOIN homeworks h ON h.id = hs.homework_id
+		WHERE h.tenant_id = $1 AND ($2 = 0 OR h.teacher_id = $2)
+		  AND hs.submitted_at IS NOT NULL AND hs.reviewed_at::date BETWEEN $3 AND $4
+		GROUP BY 1`
+
+	// вопрос - сообщение ученика или родителя в начале чата или сразу после сообщения
+	// преподавателя, ответ - следующее сообщение преподавателя в этом чате
+	chatQuery = `
+		WITH ordered AS (
+		    SELECT cm.user_id AS teacher_id, m.chat_id, m.sender_id, m.created_at,
+		           LAG(m.sender_id) OVER (PARTITION BY cm.user_id, m.chat_id ORDER BY m.created_at, m.id) AS prev_sender
+		    FROM chat_members cm
+		    JOIN users t ON t.id = cm.user_id
+		    JOIN messages m ON m.chat_id = cm.chat_id
+		    WHERE t.tenant_id = $1 AND t.user_role = 'teacher' AND ($2 = 0 OR t.id = $2)
+		),
+		questions AS (
+		    SELECT o.teacher_id, o.created_at AS asked_at,
+		           (SELECT MIN(a.created_at) FROM messages a
+		            WHERE a.chat_id = o.chat_id AND a.sender_id = o.teacher_id AND a.created_at > o.created_at) AS answered_at
+		    FROM ordered o
+		    JOIN users u ON u.id = o.sender_id
+		    WHERE u.user_role IN ('student', 'parent')
+		      AND (o.prev_sender IS NULL OR o.prev_sender = o.teacher_id)
+		      AND o.created_at::date BETWEEN $3 AND $4
+		)
+		SELECT teacher_id, COUNT(*), COUNT(answered_at),
+		       AVG(EXTRACT(EPOCH FROM answered_at - asked_at))::float8 / 60,
+		       percentile_cont(0.5) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM answered_at - asked_at)) / 60
+		FROM questions
+		GROUP BY 1`
+)
+
+type Store struct {
+	dbpool *pgxpool.Pool
+}
+
+func NewStore(dbpool *pgxpool.Pool) *Store {
+	return &Store{
+		dbpool: dbpool,
+	}
+}
+
+// GetTeacherStats собирает показатели отдельными запросами и сводит их по преподавателю.
+func (s *Store) GetTeacherStats(tenantID, teacherID int, period types.StatsPeriod) ([]types.TeacherStats, error) {
+	ctx := context.Background()
+	args := []any{tenantID, teacherID, period.From.Format("2006-01-02"), period.To.Format("2006-01-02")}
+
+	teachers := make([]types.TeacherStats, 0)
+	err := s.forEach(ctx, teachersQuery, args[:2], func(rows pgx.Rows) error {
+		t := types.TeacherStats{
+			DateFrom: period.From.Format("2006-01-02"),
+			DateTo:   period.To.Format("2006-01-02"),
+		}
+		if err := rows.Scan(&t.TeacherID, &t.FirstName, &t.LastName, &t.MiddleName); err != nil {
+			return err
+		}
+		teachers = append(teachers, t)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int]*types.TeacherStats, len(teachers))
+	for i := range teachers {
+		byID[teachers[i].TeacherID] = &teachers[i]
+	}
+	// other - строка преподавателя не из списка, например неактивного
+	var other types.TeacherStats
+	teacher := func(id int) *types.TeacherStats {
+		if t, ok := byID[id]; ok {
+			return t
+		}
+		return &other
+	}
+
+	err = s.forEach(ctx, lessonsQuery, args, func(rows pgx.Rows) error {
+		var id, count int
+		if err := rows.Scan(&id, &count); err != nil {
+			return err
+		}
+		teacher(id).LessonsHeld = count
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.forEach(ctx, ratingsQuery, args, func(rows pgx.Rows) error {
+		var id int
+		var r types.TeacherRatingStats
+		d := &r.Distribution
+		if err := rows.Scan(&id, &r.Count, &r.Average, &d[0], &d[1], &d[2], &d[3], &d[4], &r.Comments); err != nil {
+			return err
+		}
+		r.Average = round(r.Average)
+		teacher(id).Ratings = r
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.forEach(ctx, homeworkQuery, args, func(rows pgx.Rows) error {
+		var id int
+		var h types.TeacherHomeworkStats
+		if err := rows.Scan(&id, &h.Assigned, &h.Solutions, &h.NotSubmitted, &h.UnderReview, &h.Accepted, &h.Rejected); err != nil {
+			return err
+		}
+		teacher(id).Homework = h
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// проверки дополняют Homework, поэтому идут после homeworkQuery
+	err = s.forEach(ctx, reviewsQuery, args, func(rows pgx.Rows) error {
+		var id, reviewed int
+		var avg, median *float64
+		if err := rows.Scan(&id, &reviewed, &avg, &median); err != nil {
+			return err
+		}
+		h := &teacher(id).Homework
+		h.Reviewed, h.AvgReviewHours, h.MedianReviewHours = reviewed, round(avg), round(median)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.forEach(ctx, chatQuery, args, func(rows pgx.Rows) error {
+		var id int
+		var c types.TeacherChatStats
+		if err := rows.Scan(&id, &c.Questions, &c.Answered, &c.AvgResponseMinutes, &c.MedianResponseMinutes); err != nil {
+			return err
+		}
+		c.AvgResponseMinutes, c.MedianResponseMinutes = round(c.AvgResponseMinutes), round(c.MedianResponseMinutes)
+		teacher(id).Chat = c
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return teachers, nil
+}
+
+func (s *Store) forEach(ctx context.Context, query string, args []any, scan func(rows pgx.Rows) error) error {
+	rows, err := s.dbpool.Query(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// round - до сотых, nil остается nil
+func round(v *float64) *float64 {
+	if v == nil {
+		return nil
+	}
+	r := math.Round(*v*100) / 100
+	return &r
+}
@@ -0,0 +1,74 @@
+package status
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/prok05/ecom/cache"
+	"github.com/prok05/ecom/service/alpha"
+	"github.com/prok05/ecom/service/auth"
+	"github.com/prok05/ecom/service/tenant"
+	"github.com/prok05/ecom/types"
+	"github.com/prok05/ecom/utils"
+	"log"
+	"net/http"
+	"time"
+)
+
+// CRMStatus - связь школы с AlfaCRM. Пока Available false, уроки и преподаватели
+// отдаются из локальной копии с пометкой stale.
+type CRMStatus struct {
+	Available       bool                `json:"available"`
+	Breaker         alpha.BreakerStatus `json:"breaker"`
+	Token           *cache.TokenStatus  `json:"token,omitempty"`
+	LessonsSyncedAt *time.Time          `json:"lessons_synced_at"`
+	LessonSyncError string              `json:"lesson_sync_error,omitempty"`
+}
+
+type Handler struct {
+	crm         *alpha.ClientProvider
+	tokenCache  *cache.TokenCache
+	lessonStore types.LessonStore
+	authorizer  *auth.Authorizer
+}
+
+func NewHandler(crm *alpha.ClientProvider, tokenCache *cache.TokenCache, lessonStore types.LessonStore, authorizer *auth.Authorizer) *Handler {
+	return &Handler{
+		crm:         crm,
+		tokenCache:  tokenCache,
+		lessonStore: lessonStore,
+		authorizer:  authorizer,
+	}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/status/crm", h.authorizer.RequirePermissions(h.handleGetCRMStatus, auth.PermStatusRead)).Methods(http.MethodGet)
+}
+
+// Состояние связи с AlfaCRM. Подробности сбоев и токена видит только супервизор
+func (h *Handler) handleGetCRMStatus(w http.ResponseWriter, r *http.Request) {
+	account := tenant.Account(r.Context())
+	status := CRMStatus{Breaker: h.crm.BreakerStatus(account)}
+	status.Available = status.Breaker.State != alpha.BreakerOpen
+
+	state, err := h.lessonStore.GetLessonSyncState(auth.GetTenantIDFromContext(r.Context()))
+	if err != nil {
+		log.Printf("failed to get lesson sync state: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get CRM status"))
+		return
+	}
+	if state != nil {
+		status.LessonsSyncedAt = state.SyncedAt
+		status.LessonSyncError = state.LastError
+	}
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if principal.Role == types.RoleSupervisor {
+		token := h.tokenCache.Status(account)
+		status.Token = &token
+	} else {
+		status.Breaker.LastError = ""
+		status.LessonSyncError = ""
+	}
+
+	utils.WriteJSON(w, http.StatusOK, status)
+}
@@ -0,0 +1,191 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"github.com/prok05/ecom/config"
+	"github.com/prok05/ecom/service/alpha"
+	"github.com/prok05/ecom/types"
+	"github.com/prok05/ecom/utils"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Header - заголовок, в котором клиент может явно указать школу.
+const Header = "X-Tenant"
+
+const reloadInterval = time.Minute
+
+type contextKey string
+
+const tenantKey contextKey = "tenant"
+
+// Resolver определяет школу запроса: по заголовку X-Tenant, затем по Host,
+// иначе берется школа по умолчанию. Список школ кешируется и перечитывается раз в минуту.
+type Resolver struct {
+	store    types.TenantStore
+	mu       sync.RWMutex
+	bySlug   map[string]*types.Tenant
+	byHost   map[string]*types.Tenant
+	tenants  []types.Tenant
+	loadedAt time.Time
+}
+
+func NewResolver(store types.TenantStore) *Resolver {
+	return &Resolver{
+		store: store,
+	}
+}
+
+func FromContext(ctx context.Context) *types.Tenant {
+	t, _ := ctx.Value(tenantKey).(*types.Tenant)
+	return t
+}
+
+func WithTenant(ctx context.Context, t *types.Tenant) context.Context {
+	return context.WithValue(ctx, tenantKey, t)
+}
+
+// Account - подключение к AlfaCRM школы текущего запроса.
+func Account(ctx context.Context) alpha.Account {
+	return alpha.AccountFor(FromContext(ctx))
+}
+
+func (res *Resolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t, err := res.Resolve(r)
+		if err != nil {
+			log.Printf("tenant resolution failed: %v", err)
+			utils.WriteError(w, http.StatusNotFound, fmt.Errorf("unknown school"))
+			return
+		}
+		if !t.IsActive {
+			utils.WriteError(w, http.StatusForbidden, fmt.Errorf("school is disabled"))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithTenant(r.Context(), t)))
+	})
+}
+
+func (res *Resolver) Resolve(r *http.Request) (*types.Tenant, error) {
+	if err := res.reloadIfStale(); err != nil {
+		return nil, err
+	}
+
+	res.mu.RLock()
+	defer res.mu.RUnlock()
+
+	if slug := r.Header.Get(Header); slug != "" {
+		if t, ok := res.bySlug[slug]; ok {
+			return t, nil
+		}
+		return nil, fmt.Errorf("unknown tenant %q", slug)
+	}
+
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if t, ok := res.byHost[strings.ToLower(host)]; ok {
+		return t, nil
+	}
+
+	if t, ok := res.bySlug[config.Envs.DefaultTenant]; ok {
+		return t, nil
+	}
+	return nil, fmt.Errorf("no tenant for host %q", host)
+}
+
+// BySlug возвращает школу по slug, например из адреса вебхука.
+func (res *Resolver) BySlug(slug string) (*types.Tenant, error) {
+	if err := res.reloadIfStale(); err != nil {
+		return nil, err
+	}
+
+	res.mu.RLock()
+	defer res.mu.RUnlock()
+
+	if t, ok := res.bySlug[slug]; ok {
+		return t, nil
+	}
+	return nil, fmt.Errorf("unknown tenant %q", slug)
+}
+
+// ByID возвращает школу по ID, например для фоновых задач.
+func (res *Resolver) ByID(id int) (*types.Tenant, error) {
+	if err := res.reloadIfStale(); err != nil {
+		return nil, err
+	}
+
+	res.mu.RLock()
+	defer res.mu.RUnlock()
+
+	for i := range res.tenants {
+		if res.tenants[i].ID == id {
+			return &res.tenants[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown tenant %d", id)
+}
+
+// Tenants возвращает все активные школы, например для фоновых задач.
+func (res *Resolver) Tenants() ([]types.Tenant, error) {
+	if err := res.reloadIfStale(); err != nil {
+		return nil, err
+	}
+
+	res.mu.RLock()
+	defer res.mu.RUnlock()
+
+	active := make([]types.Tenant, 0, len(res.tenants))
+	for _, t := range res.tenants {
+		if t.IsActive {
+			active = append(active, t)
+		}
+	}
+	return active, nil
+}
+
+func (res *Resolver) reloadIfStale() error {
+	res.mu.RLock()
+	fresh := time.Since(res.loadedAt) < reloadInterval
+	res.mu.RUnlock()
+	if fresh {
+		return nil
+	}
+
+	tenants, err := res.store.GetTenants()
+	if err != nil {
+		res.mu.RLock()
+		loaded := res.bySlug != nil
+		res.mu.RUnlock()
+		// при недоступной БД продолжаем работать со старым списком
+		if loaded {
+			log.Printf("failed to reload tenants: %v", err)
+			return nil
+		}
+		return err
+	}
+
+	bySlug := make(map[string]*types.Tenant, len(tenants))
+	byHost := make(map[string]*types.Tenant, len(tenants))
+	for i := range tenants {
+		t := &tenants[i]
+		bySlug[t.Slug] = t
+		if t.Host != "" {
+			byHost[strings.ToLower(t.Host)] = t
+		}
+	}
+
+	res.mu.Lock()
+	res.tenants = tenants
+	res.bySlug = bySlug
+	res.byHost = byHost
+	res.loadedAt = time.Now()
+	res.mu.Unlock()
+	return nil
+}
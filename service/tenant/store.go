@@ -0,0 +1,42 @@
+package tenant
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prok05/ecom/types"
+)
+
+type Store struct {
+	pool *pgxpool.Pool
+}
+
+func NewStore(pool *pgxpool.Pool) *Store {
+	return &Store{
+		pool: pool,
+	}
+}
+
+func (s *Store) GetTenants() ([]types.Tenant, error) {
+	rows, err := s.pool.Query(context.Background(),
+		`SELECT id, slug, name, COALESCE(host, ''), COALESCE(crm_host, ''), COALESCE(crm_branch_id, 0),
+		        COALESCE(crm_email, ''), COALESCE(crm_api_key, ''), COALESCE(crm_app_key, ''),
+		        id_offset, is_active, COALESCE(webhook_secret, ''), COALESCE(timezone, '')
+		 FROM tenants
+		 ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tenants := make([]types.Tenant, 0)
+	for rows.Next() {
+		var t types.Tenant
+		err := rows.Scan(&t.ID, &t.Slug, &t.Name, &t.Host, &t.CRMHost, &t.CRMBranchID,
+			&t.CRMEmail, &t.CRMAPIKey, &t.CRMAppKey, &t.IDOffset, &t.IsActive, &t.WebhookSecret, &t.Timezone)
+		if err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, t)
+	}
+	return tenants, rows.Err()
+}
@@ -0,0 +1,28 @@
+package tenant
+
+import (
+	"github.com/prok05/ecom/config"
+	"github.com/prok05/ecom/types"
+	"log"
+	"time"
+	// база часовых поясов встроена, чтобы не зависеть от tzdata в контейнере
+	_ "time/tzdata"
+)
+
+// Timezone - часовой пояс школы, по умолчанию config.Envs.DefaultTimezone.
+func Timezone(t *types.Tenant) string {
+	if t.Timezone != "" {
+		return t.Timezone
+	}
+	return config.Envs.DefaultTimezone
+}
+
+// Location - часовой пояс, в котором AlfaCRM школы указывает время уроков.
+func Location(t *types.Tenant) *time.Location {
+	loc, err := time.LoadLocation(Timezone(t))
+	if err != nil {
+		log.Printf("unknown timezone %q of tenant %s, using UTC: %v", Timezone(t), t.Slug, err)
+		return time.UTC
+	}
+	return loc
+}
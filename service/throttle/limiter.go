@@ -0,0 +1,146 @@
+package throttle
+
+import (
+	"fmt"
+	"github.com/prok05/ecom/config"
+	"github.com/prok05/ecom/types"
+	"log"
+	"time"
+)
+
+const (
+	ReasonUnknownPhone    = "unknown_phone"
+	ReasonInvalidPassword = "invalid_password"
+	ReasonInactive        = "inactive"
+)
+
+// ThrottledError - вход временно запрещен. RetryAfter - через сколько можно повторить.
+type ThrottledError struct {
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+func (e *ThrottledError) Error() string {
+	if e.Locked {
+		return "too many failed login attempts, account is temporarily locked"
+	}
+	return "too many failed login attempts, try again later"
+}
+
+// Limiter ограничивает перебор паролей. Неудачные попытки считаются в скользящем окне
+// отдельно по телефону и по IP, в каждой школе свои. После нескольких бесплатных попыток для телефона
+// вводится растущая задержка, а при превышении порога телефон или IP блокируется.
+// Все состояние хранится в Postgres и переживает перезапуск.
+type Limiter struct {
+	store types.LoginThrottleStore
+}
+
+func NewLimiter(store types.LoginThrottleStore) *Limiter {
+	return &Limiter{
+		store: store,
+	}
+}
+
+// Check возвращает *ThrottledError, если попытку входа нужно отклонить, не проверяя пароль.
+func (l *Limiter) Check(tenantID int, phone, ip string) error {
+	for _, k := range []struct{ scope, key string }{
+		{types.LockoutScopePhone, phone},
+		{types.LockoutScopeIP, ip},
+	} {
+		lockout, err := l.store.GetActiveLockout(tenantID, k.scope, k.key)
+		if err != nil {
+			return err
+		}
+		if lockout != nil {
+			return &ThrottledError{RetryAfter: time.Until(lockout.LockedUntil), Locked: true}
+		}
+	}
+
+	failures, last, err := l.store.CountLoginFailures(tenantID, types.LockoutScopePhone, phone, windowStart())
+	if err != nil {
+		return err
+	}
+	if last != nil {
+		if wait := delay(failures) - time.Since(*last); wait > 0 {
+			return &ThrottledError{RetryAfter: wait}
+		}
+	}
+	return nil
+}
+
+// RecordFailure сохраняет неудачную попытку и блокирует телефон или IP при превышении порога.
+func (l *Limiter) RecordFailure(attempt types.LoginAttempt) error {
+	attempt.Success = false
+	if err := l.store.RecordLoginAttempt(&attempt); err != nil {
+		return err
+	}
+
+	if err := l.lockIfExceeded(attempt.TenantID, types.LockoutScopePhone, attempt.Phone, config.Envs.LoginMaxFailuresPerPhone); err != nil {
+		return err
+	}
+	return l.lockIfExceeded(attempt.TenantID, types.LockoutScopeIP, attempt.IP, config.Envs.LoginMaxFailuresPerIP)
+}
+
+// RecordSuccess сохраняет успешный вход, что заодно сбрасывает счетчик неудач телефона.
+func (l *Limiter) RecordSuccess(attempt types.LoginAttempt) error {
+	attempt.Success = true
+	return l.store.RecordLoginAttempt(&attempt)
+}
+
+// Unlock снимает блокировку телефона в школе. Возвращает false, если блокировки не было.
+func (l *Limiter) Unlock(tenantID int, phone string, unlockedBy int) (bool, error) {
+	released, err := l.store.ReleaseLockouts(tenantID, types.LockoutScopePhone, phone, unlockedBy)
+	if err != nil {
+		return false, err
+	}
+	return released > 0, nil
+}
+
+func (l *Limiter) History(tenantID, userID, limit int) ([]types.LoginAttempt, error) {
+	return l.store.GetLoginAttemptsByUserID(tenantID, userID, limit)
+}
+
+func (l *Limiter) lockIfExceeded(tenantID int, scope, key string, max int64) error {
+	failures, _, err := l.store.CountLoginFailures(tenantID, scope, key, windowStart())
+	if err != nil {
+		return err
+	}
+	if int64(failures) < max {
+		return nil
+	}
+
+	lockout := types.LoginLockout{
+		TenantID:    tenantID,
+		Scope:       scope,
+		Key:         key,
+		LockedUntil: time.Now().Add(time.Second * time.Duration(config.Envs.LoginLockoutSeconds)),
+		Reason:      fmt.Sprintf("%d failed attempts", failures),
+	}
+	if err := l.store.CreateLockout(&lockout); err != nil {
+		return err
+	}
+	log.Printf("login locked for %s %s in tenant %d until %s", scope, key, tenantID, lockout.LockedUntil.Format(time.RFC3339))
+	return nil
+}
+
+func windowStart() time.Time {
+	return time.Now().Add(-time.Second * time.Duration(config.Envs.LoginWindowSeconds))
+}
+
+// delay - пауза после failures неудач подряд: 0 для первых бесплатных попыток,
+// дальше base, 2*base, 4*base... но не больше max.
+func delay(failures int) time.Duration {
+	extra := int64(failures) - config.Envs.LoginFreeAttempts
+	if extra < 0 {
+		return 0
+	}
+	maxDelay := time.Second * time.Duration(config.Envs.LoginDelayMaxSeconds)
+	d := time.Second * time.Duration(config.Envs.LoginDelayBaseSeconds)
+	for i := int64(0); i < extra; i++ {
+		d *= 2
+		if d >= maxDelay {
+			return maxDelay
+		}
+	}
+	return d
+}
@@ -0,0 +1,140 @@
+package throttle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prok05/ecom/types"
+	"log"
+	"time"
+)
+
+type Store struct {
+	pool *pgxpool.Pool
+}
+
+func NewStore(pool *pgxpool.Pool) *Store {
+	return &Store{
+		pool: pool,
+	}
+}
+
+func (s *Store) RecordLoginAttempt(attempt *types.LoginAttempt) error {
+	err := s.pool.QueryRow(context.Background(),
+		`INSERT INTO login_attempts (tenant_id, phone, user_id, ip, user_agent, success, reason)
+		 VALUES ($7, $1, $2, $3, $4, $5, NULLIF($6, '')) RETURNING id, created_at`,
+		attempt.Phone, attempt.UserID, attempt.IP, attempt.UserAgent, attempt.Success, attempt.Reason, attempt.TenantID).
+		Scan(&attempt.ID, &attempt.CreatedAt)
+	if err != nil {
+		log.Println("failed to record login attempt:", err)
+		return err
+	}
+	return nil
+}
+
+func (s *Store) GetLoginAttemptsByUserID(tenantID, userID, limit int) ([]types.LoginAttempt, error) {
+	rows, err := s.pool.Query(context.Background(),
+		`SELECT id, tenant_id, phone, user_id, ip, COALESCE(user_agent, ''), success, COALESCE(reason, ''), created_at
+		 FROM login_attempts
+		 WHERE tenant_id = $1 AND user_id = $2
+		 ORDER BY created_at DESC
+		 LIMIT $3`, tenantID, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := make([]types.LoginAttempt, 0)
+	for rows.Next() {
+		var a types.LoginAttempt
+		if err := rows.Scan(&a.ID, &a.TenantID, &a.Phone, &a.UserID, &a.IP, &a.UserAgent, &a.Success, &a.Reason, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
+
+// CountLoginFailures считает неудачные попытки входа в школу с момента since и возвращает время последней.
+// Счетчик сбрасывается блокировкой и ее снятием, а для телефона еще и успешным входом.
+// Для IP успешный вход счетчик не сбрасывает, иначе его можно обнулять входом в свой аккаунт.
+func (s *Store) CountLoginFailures(tenantID int, scope, key string, since time.Time) (int, *time.Time, error) {
+	var column, successReset string
+	switch scope {
+	case types.LockoutScopePhone:
+		column = "phone"
+		successReset = `AND created_at > COALESCE(
+			(SELECT MAX(created_at) FROM login_attempts WHERE tenant_id = $4 AND phone = $1 AND success), '-infinity')`
+	case types.LockoutScopeIP:
+		column = "ip"
+	default:
+		return 0, nil, fmt.Errorf("unknown lockout scope %q", scope)
+	}
+
+	query := `SELECT COUNT(*), MAX(created_at) FROM login_attempts
+		WHERE tenant_id = $4 AND ` + column + ` = $1 AND NOT success AND created_at > $2
+		AND created_at > COALESCE(
+			(SELECT MAX(COALESCE(unlocked_at, created_at)) FROM login_lockouts
+			 WHERE tenant_id = $4 AND scope = $3 AND key = $1), '-infinity')
+		` + successReset
+
+	var count int
+	var last *time.Time
+	if err := s.pool.QueryRow(context.Background(), query, key, since, scope, tenantID).Scan(&count, &last); err != nil {
+		return 0, nil, err
+	}
+	return count, last, nil
+}
+
+func (s *Store) GetActiveLockout(tenantID int, scope, key string) (*types.LoginLockout, error) {
+	var l types.LoginLockout
+	err := s.pool.QueryRow(context.Background(),
+		`SELECT id, tenant_id, scope, key, locked_until, COALESCE(reason, ''), unlocked_at, unlocked_by, created_at
+		 FROM login_lockouts
+		 WHERE tenant_id = $1 AND scope = $2 AND key = $3 AND unlocked_at IS NULL AND locked_until > NOW()
+		 ORDER BY locked_until DESC
+		 LIMIT 1`, tenantID, scope, key).Scan(
+		&l.ID,
+		&l.TenantID,
+		&l.Scope,
+		&l.Key,
+		&l.LockedUntil,
+		&l.Reason,
+		&l.UnlockedAt,
+		&l.UnlockedBy,
+		&l.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &l, nil
+}
+
+func (s *Store) CreateLockout(lockout *types.LoginLockout) error {
+	err := s.pool.QueryRow(context.Background(),
+		`INSERT INTO login_lockouts (tenant_id, scope, key, locked_until, reason)
+		 VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`,
+		lockout.TenantID, lockout.Scope, lockout.Key, lockout.LockedUntil, lockout.Reason).Scan(&lockout.ID, &lockout.CreatedAt)
+	if err != nil {
+		log.Println("failed to create lockout:", err)
+		return err
+	}
+	return nil
+}
+
+// ReleaseLockouts снимает все действующие блокировки в школе и возвращает их количество.
+func (s *Store) ReleaseLockouts(tenantID int, scope, key string, unlockedBy int) (int, error) {
+	tag, err := s.pool.Exec(context.Background(),
+		`UPDATE login_lockouts SET unlocked_at = NOW(), unlocked_by = $4
+		 WHERE tenant_id = $1 AND scope = $2 AND key = $3 AND unlocked_at IS NULL AND locked_until > NOW()`,
+		tenantID, scope, key, unlockedBy)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
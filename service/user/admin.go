@@ -0,0 +1,277 @@
+package user
+
+import (
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/prok05/ecom/service/auth"
+	"github.com/prok05/ecom/service/tenant"
+	"github.com/prok05/ecom/types"
+	"github.com/prok05/ecom/utils"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Управление пользователями для супервизора
+func (h *Handler) registerAdminRoutes(router *mux.Router) {
+	router.HandleFunc("/admin/users", h.authorizer.RequirePermissions(h.handleAdminListUsers, auth.PermUserManage)).Methods(http.MethodGet)
+	router.HandleFunc("/admin/users", h.authorizer.RequirePermissions(h.handleAdminCreateUser, auth.PermUserManage)).Methods(http.MethodPost)
+	router.HandleFunc("/admin/users/{userID}", h.authorizer.RequirePermissions(h.handleAdminGetUser, auth.PermUserManage)).Methods(http.MethodGet)
+	router.HandleFunc("/admin/users/{userID}/role", h.authorizer.RequirePermissions(h.handleAdminUpdateRole, auth.PermUserManage)).Methods(http.MethodPatch)
+	router.HandleFunc("/admin/users/{userID}/password", h.authorizer.RequirePermissions(h.handleAdminSetPassword, auth.PermUserManage)).Methods(http.MethodPost)
+	router.HandleFunc("/admin/users/{userID}/deactivate", h.authorizer.RequirePermissions(h.handleAdminDeactivate, auth.PermUserManage)).Methods(http.MethodPost)
+	router.HandleFunc("/admin/users/{userID}/activate", h.authorizer.RequirePermissions(h.handleAdminActivate, auth.PermUserManage)).Methods(http.MethodPost)
+}
+
+// Список пользователей: ?role=&q=&active=&page=&limit=
+func (h *Handler) handleAdminListUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := types.UserFilter{
+		TenantID: auth.GetTenantIDFromContext(r.Context()),
+		Role:     query.Get("role"),
+		Query:    strings.TrimSpace(query.Get("q")),
+		Page:     1,
+		Limit:    50,
+	}
+
+	if v := query.Get("active"); v != "" {
+		active, err := strconv.ParseBool(v)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid active"))
+			return
+		}
+		filter.Active = &active
+	}
+	if v := query.Get("page"); v != "" {
+		page, err := strconv.Atoi(v)
+		if err != nil || page < 1 {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid page"))
+			return
+		}
+		filter.Page = page
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > 200 {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid limit"))
+			return
+		}
+		filter.Limit = limit
+	}
+
+	users, total, err := h.store.ListUsers(filter)
+	if err != nil {
+		log.Printf("failed to list users: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get users"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, types.UserListResponse{
+		Total: total,
+		Page:  filter.Page,
+		Limit: filter.Limit,
+		Items: users,
+	})
+}
+
+// Создание пользователя без карточки в AlfaCRM, например супервизора. ID выдается локальный.
+func (h *Handler) handleAdminCreateUser(w http.ResponseWriter, r *http.Request) {
+	var payload types.CreateUserPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	t := tenant.FromContext(r.Context())
+	if _, err := h.store.FindUserByPhone(t.ID, payload.Phone); err == nil {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("user with phone %s already exists", payload.Phone))
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(payload.Password)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	userID, err := h.store.NextLocalUserID(t.IDOffset)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot create user"))
+		return
+	}
+
+	err = h.store.CreateUser(types.User{
+		ID:         userID,
+		TenantID:   t.ID,
+		Phone:      payload.Phone,
+		Password:   hashedPassword,
+		FirstName:  payload.FirstName,
+		LastName:   payload.LastName,
+		MiddleName: payload.MiddleName,
+		Role:       payload.Role,
+	})
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot create user"))
+		return
+	}
+
+	h.writeAdminUser(w, http.StatusCreated, userID)
+}
+
+func (h *Handler) handleAdminGetUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.adminUserIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	h.writeAdminUser(w, http.StatusOK, userID)
+}
+
+// Смена роли. Роль читается из БД при каждом HTTP-запросе, поэтому сессии не отзываются;
+// WebSocket-соединения закрываются, так как роль клиента фиксируется при подключении.
+func (h *Handler) handleAdminUpdateRole(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.adminTarget(w, r)
+	if !ok {
+		return
+	}
+
+	var payload types.UpdateUserRolePayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	if err := h.store.UpdateUserRole(userID, payload.Role); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot update role"))
+		return
+	}
+	h.hub.DisconnectUser(userID)
+
+	h.writeAdminUser(w, http.StatusOK, userID)
+}
+
+// Установка нового пароля. Все сессии пользователя отзываются.
+func (h *Handler) handleAdminSetPassword(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.adminUserIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	if _, err := h.store.FindUserByID(userID); err != nil {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("user not found"))
+		return
+	}
+
+	var payload types.SetPasswordPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(payload.Password)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := h.store.UpdatePassword(userID, hashedPassword); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot update password"))
+		return
+	}
+	if err := h.sessionStore.RevokeAllUserSessions(userID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot revoke sessions"))
+		return
+	}
+	h.hub.DisconnectUser(userID)
+
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"message": "password updated"})
+}
+
+// Блокировка пользователя: вход и WebSocket-подключения запрещаются, сессии отзываются.
+func (h *Handler) handleAdminDeactivate(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.adminTarget(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.store.SetUserActive(userID, false); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot deactivate user"))
+		return
+	}
+	if err := h.sessionStore.RevokeAllUserSessions(userID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot revoke sessions"))
+		return
+	}
+	h.hub.DisconnectUser(userID)
+
+	h.writeAdminUser(w, http.StatusOK, userID)
+}
+
+func (h *Handler) handleAdminActivate(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.adminUserIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	if _, err := h.store.FindUserByID(userID); err != nil {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("user not found"))
+		return
+	}
+
+	if err := h.store.SetUserActive(userID, true); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot activate user"))
+		return
+	}
+
+	h.writeAdminUser(w, http.StatusOK, userID)
+}
+
+func (h *Handler) writeAdminUser(w http.ResponseWriter, status, userID int) {
+	u, err := h.store.GetAdminUserByID(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("user not found"))
+		return
+	}
+	utils.WriteJSON(w, status, u)
+}
+
+// adminTarget - ID пользователя из пути для действий, которые супервизор
+// не может выполнить над собой, чтобы случайно не потерять доступ.
+func (h *Handler) adminTarget(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, ok := h.adminUserIDFromPath(w, r)
+	if !ok {
+		return 0, false
+	}
+	if userID == auth.GetUserIDFromContext(r.Context()) {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("cannot change own account"))
+		return 0, false
+	}
+	return userID, true
+}
+
+// adminUserIDFromPath - ID пользователя из пути, если он из школы текущего запроса.
+func (h *Handler) adminUserIDFromPath(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, err := strconv.Atoi(mux.Vars(r)["userID"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid user ID"))
+		return 0, false
+	}
+	if _, err := h.findTenantUser(r, userID); err != nil {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("user not found"))
+		return 0, false
+	}
+	return userID, true
+}
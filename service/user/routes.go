@@ -1,40 +1,68 @@
 package user
 
 import (
+	"errors"
 	"fmt"
 	"github.com/go-playground/validator/v10"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/mux"
 	"github.com/prok05/ecom/config"
 	"github.com/prok05/ecom/service/alpha"
 	"github.com/prok05/ecom/service/auth"
+	"github.com/prok05/ecom/service/crmsync"
+	"github.com/prok05/ecom/service/parent"
+	"github.com/prok05/ecom/service/tenant"
+	"github.com/prok05/ecom/service/throttle"
+	"github.com/prok05/ecom/service/verification"
+	"github.com/prok05/ecom/service/ws"
 	"github.com/prok05/ecom/types"
 	"github.com/prok05/ecom/utils"
 	"log"
+	"math"
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
 )
 
 type Handler struct {
-	store types.UserStore
+	store        types.UserStore
+	sessionStore types.SessionStore
+	parentStore  types.ParentStore
+	verification *verification.Service
+	limiter      *throttle.Limiter
+	authorizer   *auth.Authorizer
+	crm          alpha.Provider
+	hub          *ws.Hub
 }
 
-func NewHandler(store types.UserStore) *Handler {
-	return &Handler{store: store}
+func NewHandler(store types.UserStore, sessionStore types.SessionStore, parentStore types.ParentStore, verification *verification.Service, limiter *throttle.Limiter, authorizer *auth.Authorizer, crm alpha.Provider, hub *ws.Hub) *Handler {
+	return &Handler{store: store, sessionStore: sessionStore, parentStore: parentStore, verification: verification, limiter: limiter, authorizer: authorizer, crm: crm, hub: hub}
 }
 
 func (h *Handler) RegisterRoutes(router *mux.Router) {
 	router.HandleFunc("/login", h.handleLogin).Methods(http.MethodPost)
+	router.HandleFunc("/register/code", h.handleRegisterCode).Methods(http.MethodPost)
 	router.HandleFunc("/register", h.handleRegister).Methods(http.MethodPost)
+	router.HandleFunc("/refresh", h.handleRefresh).Methods(http.MethodPost)
+	router.HandleFunc("/password/forgot", h.handleForgotPassword).Methods(http.MethodPost)
+	router.HandleFunc("/password/reset", h.handleResetPassword).Methods(http.MethodPost)
 	router.HandleFunc("/logout", h.handleLogout).Methods(http.MethodPost)
+	router.HandleFunc("/logout/all", h.authorizer.Authenticated(h.handleLogoutAll)).Methods(http.MethodPost)
 
-	router.HandleFunc("/users/teachers", h.handleGetAllTeachers).Methods(http.MethodGet)
-	router.HandleFunc("/users/students", h.handleGetAllStudents).Methods(http.MethodGet)
-	router.HandleFunc("/users/{userID}", h.handleGetUser).Methods(http.MethodGet)
+	router.HandleFunc("/sessions", h.authorizer.Authenticated(h.handleGetSessions)).Methods(http.MethodGet)
+	router.HandleFunc("/sessions/{sessionID}", h.authorizer.Authenticated(h.handleRevokeSession)).Methods(http.MethodDelete)
+	router.HandleFunc("/users/{userID}/sessions", h.authorizer.RequirePermissions(h.handleRevokeUserSessions, auth.PermSessionManage)).Methods(http.MethodDelete)
 
-	router.HandleFunc("/alpha/users/{userID}", h.handleAlphaGetUser).Methods(http.MethodGet)
+	router.HandleFunc("/login/history", h.authorizer.Authenticated(h.handleGetOwnLoginHistory)).Methods(http.MethodGet)
+	router.HandleFunc("/users/{userID}/login/history", h.authorizer.RequirePermissions(h.handleGetLoginHistory, auth.PermLoginManage)).Methods(http.MethodGet)
+	router.HandleFunc("/users/{userID}/unlock", h.authorizer.RequirePermissions(h.handleUnlockUser, auth.PermLoginManage)).Methods(http.MethodPost)
+
+	router.HandleFunc("/users/teachers", h.authorizer.RequirePermissions(h.handleGetAllTeachers, auth.PermUserRead)).Methods(http.MethodGet)
+	router.HandleFunc("/users/students", h.authorizer.RequirePermissions(h.handleGetAllStudents, auth.PermUserRead)).Methods(http.MethodGet)
+	router.HandleFunc("/users/{userID}", h.authorizer.RequirePermissions(h.handleGetUser, auth.PermUserRead)).Methods(http.MethodGet)
+
+	h.registerAdminRoutes(router)
+
+	router.HandleFunc("/alpha/users/{userID}", h.authorizer.RequirePermissions(h.handleAlphaGetUser, auth.PermUserRead)).Methods(http.MethodGet)
 }
 
 // Логин пользователя
@@ -43,6 +71,7 @@ func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
 	var payload types.LoginUserPayload
 	if err := utils.ParseJSON(r, &payload); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
+		return
 	}
 
 	// валидация payload
@@ -52,43 +81,189 @@ func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	u, err := h.store.FindUserByPhone(payload.Phone)
+	tenantID := tenant.FromContext(r.Context()).ID
+	attempt := types.LoginAttempt{
+		TenantID:  tenantID,
+		Phone:     payload.Phone,
+		IP:        utils.ClientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+
+	// защита от перебора: проверка блокировок до сверки пароля
+	if err := h.limiter.Check(tenantID, attempt.Phone, attempt.IP); err != nil {
+		writeThrottleError(w, err)
+		return
+	}
+
+	u, err := h.store.FindUserByPhone(tenantID, payload.Phone)
 	if err != nil {
-		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("user not found"))
+		attempt.Reason = throttle.ReasonUnknownPhone
+		h.failLogin(w, attempt, http.StatusNotFound, fmt.Errorf("user not found"))
 		return
 	}
+	attempt.UserID = &u.ID
 
 	if !auth.ComparePasswords(u.Password, []byte(payload.Password)) {
-		utils.WriteError(w, http.StatusForbidden, fmt.Errorf("invalid phone or password"))
+		attempt.Reason = throttle.ReasonInvalidPassword
+		h.failLogin(w, attempt, http.StatusForbidden, fmt.Errorf("invalid phone or password"))
+		return
+	}
+
+	if !u.IsActive {
+		attempt.Reason = throttle.ReasonInactive
+		h.failLogin(w, attempt, http.StatusForbidden, fmt.Errorf("user is deactivated"))
+		return
+	}
+
+	if err := h.limiter.RecordSuccess(attempt); err != nil {
+		writeThrottleError(w, err)
+		return
+	}
+
+	refreshToken, refreshHash, err := auth.NewRefreshToken()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	session := types.Session{
+		UserID:           u.ID,
+		RefreshTokenHash: refreshHash,
+		UserAgent:        r.UserAgent(),
+		IP:               utils.ClientIP(r),
+		ExpiresAt:        auth.RefreshTokenExpiration(),
+	}
+	if err := h.sessionStore.CreateSession(&session); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot create session"))
 		return
 	}
 
 	secret := []byte(config.Envs.JWTSecret)
-	token, err := auth.CreateJWT(secret, u.ID, u.Role)
+	token, err := auth.CreateJWT(secret, u.ID, u.Role, session.ID)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "token",
-		Value:    token,
-		Expires:  time.Now().Add(24 * time.Hour * 30),
-		HttpOnly: true,
-		Secure:   false,
-		Path:     "/",
-	})
+	auth.SetTokenCookies(w, token, refreshToken, session.ExpiresAt)
 
-	//utils.WriteJSON(w, http.StatusOK, map[string]string{"token": token})
 	utils.WriteJSON(w, http.StatusOK, map[string]string{"message": "login successful"})
 }
 
-// Регистрация пользователя
+// Обновление пары токенов по refresh-токену. Refresh-токен одноразовый:
+// при каждом обновлении выдается новый, а предъявление старого отзывает сессию.
+func (h *Handler) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	refreshToken := auth.GetRefreshTokenFromRequest(r)
+	if refreshToken == "" {
+		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("missing refresh token"))
+		return
+	}
+
+	tokenHash := auth.HashRefreshToken(refreshToken)
+
+	session, err := h.sessionStore.GetSessionByTokenHash(tokenHash)
+	if err != nil {
+		log.Println("handleRefresh:", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get session"))
+		return
+	}
+
+	if session == nil {
+		reused, err := h.sessionStore.GetSessionByPreviousTokenHash(tokenHash)
+		if err == nil && reused != nil {
+			log.Printf("refresh token reuse detected for session %d, revoking", reused.ID)
+			h.sessionStore.RevokeSession(reused.ID)
+			h.hub.DisconnectSession(reused.ID)
+		}
+		auth.ClearTokenCookies(w)
+		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("invalid refresh token"))
+		return
+	}
+
+	if session.RevokedAt != nil || session.ExpiresAt.Before(time.Now()) {
+		auth.ClearTokenCookies(w)
+		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("session expired"))
+		return
+	}
+
+	u, err := h.store.FindUserByID(session.UserID)
+	if err != nil {
+		h.sessionStore.RevokeSession(session.ID)
+		h.hub.DisconnectSession(session.ID)
+		auth.ClearTokenCookies(w)
+		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("user not found"))
+		return
+	}
+
+	newRefreshToken, newHash, err := auth.NewRefreshToken()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	expiresAt := auth.RefreshTokenExpiration()
+	if err := h.sessionStore.RotateRefreshToken(session.ID, tokenHash, newHash, expiresAt); err != nil {
+		log.Println("handleRefresh:", err)
+		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("invalid refresh token"))
+		return
+	}
+
+	secret := []byte(config.Envs.JWTSecret)
+	token, err := auth.CreateJWT(secret, u.ID, u.Role, session.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	auth.SetTokenCookies(w, token, newRefreshToken, expiresAt)
+
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"message": "token refreshed"})
+}
+
+// Регистрация, шаг 1: отправка кода подтверждения на номер телефона.
+// Код отправляется только если номер есть в AlphaCRM и еще не зарегистрирован.
+func (h *Handler) handleRegisterCode(w http.ResponseWriter, r *http.Request) {
+	var payload types.RegisterCodePayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	// пользователь без пароля создан синхронизацией с AlfaCRM и еще не зарегистрирован
+	existing, err := h.store.FindUserByPhone(tenant.FromContext(r.Context()).ID, payload.Phone)
+	if err == nil && existing.Password != "" {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("user with phone %s "+
+			"already exists", payload.Phone))
+		return
+	}
+
+	crm := h.crm.For(tenant.Account(r.Context()))
+	if _, err := crm.FindUserByPhone(r.Context(), payload.Role, payload.Phone); err != nil {
+		writeCRMUserError(w, err, fmt.Errorf("Пользователь с таким номером телефона не найден: %s", payload.Phone))
+		return
+	}
+
+	if err := h.verification.SendCode(tenant.FromContext(r.Context()).ID, payload.Phone, verification.PurposeRegister); err != nil {
+		writeVerificationError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"message": "code sent"})
+}
+
+// Регистрация, шаг 2: проверка кода и создание пользователя
 func (h *Handler) handleRegister(w http.ResponseWriter, r *http.Request) {
 	// парсинг payload
 	var payload types.RegisterUserPayload
 	if err := utils.ParseJSON(r, &payload); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
+		return
 	}
 
 	// валидация payload
@@ -99,35 +274,70 @@ func (h *Handler) handleRegister(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// проверка существует ли пользователь
-	_, err := h.store.FindUserByPhone(payload.Phone)
-	if err == nil {
+	// пользователь без пароля создан синхронизацией с AlfaCRM и еще не зарегистрирован
+	existing, err := h.store.FindUserByPhone(tenant.FromContext(r.Context()).ID, payload.Phone)
+	if err == nil && existing.Password != "" {
 		utils.WriteError(w, http.StatusConflict, fmt.Errorf("user with phone %s "+
 			"already exists", payload.Phone))
 		return
 	}
 
-	// получения токена AlphaCRM
-	token, err := alpha.GetAlphaToken()
-	if err != nil {
-		fmt.Printf("Error getting alpha token: %v\n", err)
+	// проверка кода из SMS
+	if err := h.verification.CheckCode(tenant.FromContext(r.Context()).ID, payload.Phone, verification.PurposeRegister, payload.Code); err != nil {
+		writeVerificationError(w, err)
 		return
 	}
 
 	// получение пользователя из Alpha
-	alphaUser, err := alpha.GetUserIDByPhone(payload.Phone, payload.Role, token)
+	t := tenant.FromContext(r.Context())
+	crm := h.crm.For(alpha.AccountFor(t))
+	alphaUser, err := crm.FindUserByPhone(r.Context(), payload.Role, payload.Phone)
 	if err != nil {
-		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("Пользователь с таким номером телефона не найден: %s", payload.Phone))
+		// пока CRM недоступна, зарегистрироваться можно по профилю, загруженному синхронизацией.
+		// Родителю нужна карточка ребенка из CRM, поэтому он ждет восстановления связи
+		if errors.Is(err, alpha.ErrUnavailable) && existing != nil && existing.IsActive &&
+			existing.Role == payload.Role && payload.Role != types.RoleParent {
+			h.claimUser(w, existing.ID, payload)
+			return
+		}
+		writeCRMUserError(w, err, fmt.Errorf("Пользователь с таким номером телефона не найден: %s", payload.Phone))
 		return
 	}
 
-	fullName := strings.Split(alphaUser.Name, " ")
-	firstName := fullName[1]
-	lastName := fullName[0]
-	var middleName string
-	if len(fullName) == 3 {
-		middleName = fullName[2]
-	} else {
-		middleName = ""
+	userID := alphaUser.ID
+	name := alphaUser.Name
+
+	// у родителя нет своей карточки в AlfaCRM: имя берется из поля
+	// "законный представитель" карточки ребенка, а ID выдается локальный
+	if payload.Role == types.RoleParent {
+		if alphaUser.LegalName == "" {
+			utils.WriteError(w, http.StatusNotFound, fmt.Errorf("В карточке ученика не указан законный представитель: %s", payload.Phone))
+			return
+		}
+		name = alphaUser.LegalName
+		userID, err = h.store.NextLocalUserID(t.IDOffset)
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot create user"))
+			return
+		}
+	}
+
+	lastName, firstName, middleName := alpha.SplitName(name)
+	if lastName == "" {
+		utils.WriteError(w, http.StatusUnprocessableEntity, fmt.Errorf("empty user name in CRM"))
+		return
+	}
+
+	// профиль уже загружен синхронизацией с AlfaCRM, остается установить пароль.
+	// ID клиента и преподавателя в AlfaCRM могут совпадать, чужой профиль не занимаем
+	if synced, err := h.store.FindUserByID(userID); err == nil {
+		if !crmsync.SameCRMRole(synced.Role, payload.Role) {
+			log.Printf("register: %s %d collides with local %s", payload.Role, userID, synced.Role)
+			utils.WriteError(w, http.StatusConflict, fmt.Errorf("user already registered"))
+			return
+		}
+		h.claimUser(w, userID, payload)
+		return
 	}
 
 	hashedPassword, err := auth.HashPassword(payload.Password)
@@ -137,7 +347,8 @@ func (h *Handler) handleRegister(w http.ResponseWriter, r *http.Request) {
 	}
 
 	err = h.store.CreateUser(types.User{
-		ID:         alphaUser.ID,
+		ID:         userID,
+		TenantID:   t.ID,
 		Phone:      payload.Phone,
 		FirstName:  firstName,
 		LastName:   lastName,
@@ -150,131 +361,377 @@ func (h *Handler) handleRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if payload.Role == types.RoleParent {
+		if _, err := parent.LinkChildrenFromAlpha(r.Context(), h.parentStore, crm, userID, payload.Phone, nil); err != nil {
+			log.Printf("failed to link children of parent %d: %v", userID, err)
+		}
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, nil)
+}
+
+// claimUser устанавливает пароль профилю, созданному синхронизацией с AlfaCRM.
+func (h *Handler) claimUser(w http.ResponseWriter, userID int, payload types.RegisterUserPayload) {
+	hashedPassword, err := auth.HashPassword(payload.Password)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	claimed, err := h.store.ClaimUser(userID, payload.Phone, hashedPassword)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot create user"))
+		return
+	}
+	if !claimed {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("user already registered"))
+		return
+	}
 	utils.WriteJSON(w, http.StatusCreated, nil)
 }
 
+// Выход с текущего устройства. Access-токен к этому моменту может быть уже просрочен,
+// поэтому сессия ищется по refresh-токену.
 func (h *Handler) handleLogout(w http.ResponseWriter, r *http.Request) {
-	http.SetCookie(w, &http.Cookie{
-		Name:     "token",
-		Value:    "",
-		Expires:  time.Now().Add(-1),
-		HttpOnly: true,
-		Secure:   false,
-		Path:     "/",
-	})
+	if refreshToken := auth.GetRefreshTokenFromRequest(r); refreshToken != "" {
+		session, err := h.sessionStore.GetSessionByTokenHash(auth.HashRefreshToken(refreshToken))
+		if err != nil {
+			log.Println("handleLogout:", err)
+		} else if session != nil {
+			if err := h.sessionStore.RevokeSession(session.ID); err != nil {
+				utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot revoke session"))
+				return
+			}
+			h.hub.DisconnectSession(session.ID)
+		}
+	}
+
+	auth.ClearTokenCookies(w)
 
 	utils.WriteJSON(w, http.StatusOK, map[string]string{"message": "logout successful"})
+}
+
+// Выход со всех устройств
+func (h *Handler) handleLogoutAll(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
 
+	if err := h.sessionStore.RevokeAllUserSessions(userID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot revoke sessions"))
+		return
+	}
+	h.hub.DisconnectUser(userID)
+
+	auth.ClearTokenCookies(w)
+
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"message": "logout successful"})
 }
 
-func (h *Handler) handleGetUser(w http.ResponseWriter, r *http.Request) {
-	//tokenString := auth.GetTokenFromRequest(r)
-	//if tokenString == "" {
-	//	utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("missing or invalid JWT token"))
-	//	return
-	//}
-	//
-	//jwtToken, err := auth.ValidateToken(tokenString)
-	//if err != nil || !jwtToken.Valid {
-	//	utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("invalid token"))
-	//	return
-	//}
+func (h *Handler) handleGetSessions(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+	currentSessionID := auth.GetSessionIDFromContext(r.Context())
+
+	sessions, err := h.sessionStore.GetActiveSessionsByUserID(userID)
+	if err != nil {
+		log.Printf("failed to get sessions: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get sessions"))
+		return
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentSessionID
+	}
+
+	utils.WriteJSON(w, http.StatusOK, sessions)
+}
+
+func (h *Handler) handleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
 
 	vars := mux.Vars(r)
-	str, ok := vars["userID"]
-	if !ok {
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("missing user ID"))
+	sessionID, err := strconv.Atoi(vars["sessionID"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid session ID"))
 		return
 	}
 
-	userID, err := strconv.Atoi(str)
+	session, err := h.sessionStore.GetSessionByID(sessionID)
 	if err != nil {
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid user ID"))
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get session"))
+		return
+	}
+	if session == nil || session.UserID != userID {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("session not found"))
 		return
 	}
 
-	var user *types.UserDTO
+	if err := h.sessionStore.RevokeSession(sessionID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot revoke session"))
+		return
+	}
+	h.hub.DisconnectSession(sessionID)
+
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"message": "session revoked"})
+}
 
-	user, err = h.store.FindUserByID(userID)
+// Принудительный выход пользователя со всех устройств
+func (h *Handler) handleRevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := strconv.Atoi(vars["userID"])
 	if err != nil {
-		utils.WriteError(w, http.StatusNotFound, err)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid user ID"))
+		return
+	}
+	if _, err := h.findTenantUser(r, userID); err != nil {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("user not found"))
 		return
 	}
 
-	utils.WriteJSON(w, 200, user)
+	if err := h.sessionStore.RevokeAllUserSessions(userID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot revoke sessions"))
+		return
+	}
+	h.hub.DisconnectUser(userID)
+
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"message": "sessions revoked"})
 }
 
-func (h *Handler) handleGetAllTeachers(w http.ResponseWriter, r *http.Request) {
-	tokenString := auth.GetTokenFromRequest(r)
-	if tokenString == "" {
-		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("missing or invalid JWT token"))
+// Восстановление пароля, шаг 1: отправка кода на телефон.
+// Ответ не зависит от того, зарегистрирован ли номер, чтобы по нему нельзя было перебирать пользователей.
+func (h *Handler) handleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var payload types.ForgotPasswordPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	if _, err := h.store.FindUserByPhone(tenant.FromContext(r.Context()).ID, payload.Phone); err == nil {
+		if err := h.verification.SendCode(tenant.FromContext(r.Context()).ID, payload.Phone, verification.PurposePasswordReset); err != nil {
+			writeVerificationError(w, err)
+			return
+		}
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"message": "code sent"})
+}
+
+// Восстановление пароля, шаг 2: проверка кода и установка нового пароля.
+// После смены пароля все сессии пользователя отзываются.
+func (h *Handler) handleResetPassword(w http.ResponseWriter, r *http.Request) {
+	var payload types.ResetPasswordPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	jwtToken, err := auth.ValidateToken(tokenString)
-	if err != nil || !jwtToken.Valid {
-		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("invalid token"))
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
 		return
 	}
 
-	var teachers []*types.UserDTO
+	if err := h.verification.CheckCode(tenant.FromContext(r.Context()).ID, payload.Phone, verification.PurposePasswordReset, payload.Code); err != nil {
+		writeVerificationError(w, err)
+		return
+	}
 
-	teachers, err = h.store.GetAllTeachers()
+	u, err := h.store.FindUserByPhone(tenant.FromContext(r.Context()).ID, payload.Phone)
 	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("error getting teachers"))
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("user not found"))
+		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, teachers)
+	hashedPassword, err := auth.HashPassword(payload.Password)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := h.store.UpdatePassword(u.ID, hashedPassword); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot update password"))
+		return
+	}
+
+	if err := h.sessionStore.RevokeAllUserSessions(u.ID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot revoke sessions"))
+		return
+	}
+	h.hub.DisconnectUser(u.ID)
+
+	auth.ClearTokenCookies(w)
+
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"message": "password updated"})
 }
 
-func (h *Handler) handleGetAllStudents(w http.ResponseWriter, r *http.Request) {
-	tokenString := auth.GetTokenFromRequest(r)
-	if tokenString == "" {
-		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("missing or invalid JWT token"))
+// История входов текущего пользователя
+func (h *Handler) handleGetOwnLoginHistory(w http.ResponseWriter, r *http.Request) {
+	h.writeLoginHistory(w, r, auth.GetUserIDFromContext(r.Context()))
+}
+
+// История входов любого пользователя, для супервизора
+func (h *Handler) handleGetLoginHistory(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["userID"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid user ID"))
 		return
 	}
+	if _, err := h.findTenantUser(r, userID); err != nil {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("user not found"))
+		return
+	}
+	h.writeLoginHistory(w, r, userID)
+}
 
-	jwtToken, err := auth.ValidateToken(tokenString)
-	if err != nil || !jwtToken.Valid {
-		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("invalid token"))
+func (h *Handler) writeLoginHistory(w http.ResponseWriter, r *http.Request, userID int) {
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 500 {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid limit"))
+			return
+		}
+		limit = n
+	}
+
+	attempts, err := h.limiter.History(auth.GetTenantIDFromContext(r.Context()), userID, limit)
+	if err != nil {
+		log.Printf("failed to get login history: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot get login history"))
 		return
 	}
 
-	var students []*types.UserDTO
+	utils.WriteJSON(w, http.StatusOK, attempts)
+}
 
-	students, err = h.store.GetAllStudents()
+// Снятие блокировки входа с аккаунта, для супервизора
+func (h *Handler) handleUnlockUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["userID"])
 	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("error getting teachers"))
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid user ID"))
+		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, students)
+	u, err := h.findTenantUser(r, userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("user not found"))
+		return
+	}
+
+	unlocked, err := h.limiter.Unlock(u.TenantID, u.Phone, auth.GetUserIDFromContext(r.Context()))
+	if err != nil {
+		log.Printf("failed to unlock user %d: %v", userID, err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot unlock user"))
+		return
+	}
+	if !unlocked {
+		utils.WriteJSON(w, http.StatusOK, map[string]string{"message": "user is not locked"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"message": "user unlocked"})
 }
 
-func (h *Handler) handleAlphaGetUser(w http.ResponseWriter, r *http.Request) {
-	tokenString := auth.GetTokenFromRequest(r)
-	if tokenString == "" {
-		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("missing or invalid JWT token"))
+// failLogin записывает неудачную попытку входа и отвечает ошибкой err.
+// Без записанной неудачи перебор не ограничен, поэтому если запись не удалась,
+// вход отклоняется с 500, а не с исходной ошибкой
+func (h *Handler) failLogin(w http.ResponseWriter, attempt types.LoginAttempt, status int, err error) {
+	if err := h.limiter.RecordFailure(attempt); err != nil {
+		writeThrottleError(w, err)
 		return
 	}
+	utils.WriteError(w, status, err)
+}
 
-	jwtToken, err := auth.ValidateToken(tokenString)
-	if err != nil || !jwtToken.Valid {
-		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("invalid token"))
+func writeThrottleError(w http.ResponseWriter, err error) {
+	var throttled *throttle.ThrottledError
+	if !errors.As(err, &throttled) {
+		log.Printf("login throttle error: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot log in"))
 		return
 	}
+	seconds := int(math.Ceil(throttled.RetryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	utils.WriteError(w, http.StatusTooManyRequests, throttled)
+}
 
-	claims, ok := jwtToken.Claims.(jwt.MapClaims)
-	if !ok {
-		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("no token"))
+// writeCRMUserError отвечает 404, если пользователя нет в AlfaCRM, и 502, если CRM недоступна.
+func writeCRMUserError(w http.ResponseWriter, err, notFound error) {
+	if errors.Is(err, alpha.ErrNotFound) {
+		utils.WriteError(w, http.StatusNotFound, notFound)
 		return
 	}
+	log.Printf("alpha error: %v", err)
+	utils.WriteError(w, alpha.HTTPStatus(err), fmt.Errorf("cannot get user from CRM"))
+}
 
-	role, ok := claims["role"].(string)
+func writeVerificationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, verification.ErrResendTooSoon), errors.Is(err, verification.ErrTooManyRequests):
+		utils.WriteError(w, http.StatusTooManyRequests, err)
+	case errors.Is(err, verification.ErrCodeNotFound),
+		errors.Is(err, verification.ErrCodeExpired),
+		errors.Is(err, verification.ErrTooManyAttempts),
+		errors.Is(err, verification.ErrInvalidCode):
+		utils.WriteError(w, http.StatusBadRequest, err)
+	default:
+		log.Printf("verification error: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot verify phone"))
+	}
+}
+
+func (h *Handler) handleGetUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	str, ok := vars["userID"]
 	if !ok {
-		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("invalid token"))
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("missing user ID"))
 		return
 	}
 
+	userID, err := strconv.Atoi(str)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid user ID"))
+		return
+	}
+
+	var user *types.UserDTO
+
+	user, err = h.findTenantUser(r, userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+
+	utils.WriteJSON(w, 200, user)
+}
+
+func (h *Handler) handleGetAllTeachers(w http.ResponseWriter, r *http.Request) {
+	teachers, err := h.store.GetAllTeachers(auth.GetTenantIDFromContext(r.Context()))
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("error getting teachers"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, teachers)
+}
+
+func (h *Handler) handleGetAllStudents(w http.ResponseWriter, r *http.Request) {
+	students, err := h.store.GetAllStudents(auth.GetTenantIDFromContext(r.Context()))
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("error getting students"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, students)
+}
+
+func (h *Handler) handleAlphaGetUser(w http.ResponseWriter, r *http.Request) {
+	role := auth.GetUserRoleFromContext(r.Context())
+
 	vars := mux.Vars(r)
 	str, ok := vars["userID"]
 	if !ok {
@@ -288,8 +745,8 @@ func (h *Handler) handleAlphaGetUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if role == "supervisor" {
-		platformUser, err := h.store.FindUserByID(userID)
+	if role == types.RoleSupervisor {
+		platformUser, err := h.findTenantUser(r, userID)
 		if err != nil {
 			log.Println(err)
 			utils.WriteError(w, http.StatusNotFound, fmt.Errorf("cant find user in platform"))
@@ -300,22 +757,17 @@ func (h *Handler) handleAlphaGetUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	platformUser, err := h.store.FindUserByID(userID)
+	platformUser, err := h.findTenantUser(r, userID)
 	if err != nil {
 		log.Println(err)
 		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("cant find user in platform"))
 		return
 	}
 
-	token, err := alpha.GetAlphaToken()
+	crm := h.crm.For(tenant.Account(r.Context()))
+	alphaUser, err := crm.GetUser(r.Context(), platformUser.Role, userID)
 	if err != nil {
-		fmt.Printf("Error getting alpha token: %v\n", err)
-		return
-	}
-
-	alphaUser, err := alpha.GetUserById(userID, token, platformUser.Role)
-	if err != nil {
-		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("no users with such user id: %s", userID))
+		writeCRMUserError(w, err, fmt.Errorf("no users with such user id: %d", userID))
 		return
 	}
 
@@ -323,3 +775,15 @@ func (h *Handler) handleAlphaGetUser(w http.ResponseWriter, r *http.Request) {
 
 	utils.WriteJSON(w, http.StatusOK, alphaUser)
 }
+
+// findTenantUser ищет пользователя только среди пользователей школы текущего запроса.
+func (h *Handler) findTenantUser(r *http.Request, userID int) (*types.UserDTO, error) {
+	u, err := h.store.FindUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if u.TenantID != tenant.FromContext(r.Context()).ID {
+		return nil, fmt.Errorf("user not found")
+	}
+	return u, nil
+}
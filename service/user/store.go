@@ -7,6 +7,7 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/prok05/ecom/types"
 	"log"
+	"strings"
 )
 
 type Store struct {
@@ -19,9 +20,9 @@ func NewStore(dbpool *pgxpool.Pool) *Store {
 	}
 }
 
-func (s *Store) GetAllTeachers() ([]*types.UserDTO, error) {
-	query := `SELECT id, first_name, last_name, middle_name, user_role FROM users WHERE user_role='teacher'`
-	rows, err := s.dbpool.Query(context.Background(), query)
+func (s *Store) GetAllTeachers(tenantID int) ([]*types.UserDTO, error) {
+	query := `SELECT id, first_name, last_name, middle_name, user_role FROM users WHERE user_role='teacher' AND tenant_id = $1`
+	rows, err := s.dbpool.Query(context.Background(), query, tenantID)
 	if err != nil {
 		return nil, err
 	}
@@ -43,9 +44,9 @@ func (s *Store) GetAllTeachers() ([]*types.UserDTO, error) {
 	return teachers, nil
 }
 
-func (s *Store) GetAllStudents() ([]*types.UserDTO, error) {
-	query := `SELECT id, first_name, last_name, middle_name, user_role FROM users WHERE user_role='student'`
-	rows, err := s.dbpool.Query(context.Background(), query)
+func (s *Store) GetAllStudents(tenantID int) ([]*types.UserDTO, error) {
+	query := `SELECT id, first_name, last_name, middle_name, user_role FROM users WHERE user_role='student' AND tenant_id = $1`
+	rows, err := s.dbpool.Query(context.Background(), query, tenantID)
 	if err != nil {
 		return nil, err
 	}
@@ -72,9 +73,10 @@ func (s *Store) FindUserByEmail(email string) (*types.User, error) {
 	panic("implement me")
 }
 
-func (s *Store) FindUserByPhone(phone string) (*types.User, error) {
+func (s *Store) FindUserByPhone(tenantID int, phone string) (*types.User, error) {
 	rows, err := s.dbpool.Query(context.Background(),
-		"SELECT * FROM users WHERE phone = $1", phone)
+		`SELECT id, tenant_id, phone, password, first_name, last_name, middle_name, user_role, is_active, created_at
+		 FROM users WHERE tenant_id = $1 AND phone = $2`, tenantID, phone)
 
 	if err != nil {
 		return nil, err
@@ -95,13 +97,13 @@ func (s *Store) FindUserByPhone(phone string) (*types.User, error) {
 
 func (s *Store) FindUserByID(id int) (*types.UserDTO, error) {
 	rows, err := s.dbpool.Query(context.Background(),
-		"SELECT id, phone, first_name, middle_name, last_name, user_role FROM users WHERE id = $1", id)
+		"SELECT id, tenant_id, phone, first_name, middle_name, last_name, user_role, is_active FROM users WHERE id = $1", id)
 	if err != nil {
 		return nil, err
 	}
 	u := new(types.UserDTO)
 	for rows.Next() {
-		if err := rows.Scan(&u.ID, &u.Phone, &u.FirstName, &u.MiddleName, &u.LastName, &u.Role); err != nil {
+		if err := rows.Scan(&u.ID, &u.TenantID, &u.Phone, &u.FirstName, &u.MiddleName, &u.LastName, &u.Role, &u.IsActive); err != nil {
 			return nil, err
 		}
 	}
@@ -131,8 +133,8 @@ func (s *Store) FindUsersByIDs(ids []int) (*[]types.UserDTO, error) {
 
 func (s *Store) CreateUser(user types.User) error {
 	_, err := s.dbpool.Exec(context.Background(),
-		"INSERT INTO users (id, phone, password, first_name, last_name, middle_name, user_role) VALUES ($1, $2, $3, $4, $5, $6, $7)",
-		user.ID, user.Phone, user.Password, user.FirstName, user.LastName, user.MiddleName, user.Role)
+		"INSERT INTO users (id, tenant_id, phone, password, first_name, last_name, middle_name, user_role) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		user.ID, user.TenantID, user.Phone, user.Password, user.FirstName, user.LastName, user.MiddleName, user.Role)
 	if err != nil {
 		log.Println(err)
 		return err
@@ -140,17 +142,168 @@ func (s *Store) CreateUser(user types.User) error {
 	return nil
 }
 
+func (s *Store) UpdatePassword(userID int, hashedPassword string) error {
+	_, err := s.dbpool.Exec(context.Background(),
+		"UPDATE users SET password = $1 WHERE id = $2", hashedPassword, userID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+// NextLocalUserID выдает ID для пользователя, которого нет в AlfaCRM.
+// Последовательность начинается с 1000000000, чтобы не пересекаться с ID из CRM,
+// к ней прибавляется смещение школы.
+func (s *Store) NextLocalUserID(idOffset int) (int, error) {
+	var id int
+	if err := s.dbpool.QueryRow(context.Background(), "SELECT $1 + nextval('local_user_id_seq')", idOffset).Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+const adminUserColumns = `id, phone, first_name, last_name, middle_name, user_role, is_active, password <> '',
+	balance::float8, paid_lesson_count, created_at, deactivated_at, COALESCE(deactivation_reason, ''), crm_synced_at`
+
+// ListUsers возвращает страницу пользователей по фильтру и общее количество найденных.
+func (s *Store) ListUsers(filter types.UserFilter) ([]types.AdminUser, int, error) {
+	where := []string{"tenant_id = $1"}
+	args := []any{filter.TenantID}
+
+	if filter.Role != "" {
+		args = append(args, filter.Role)
+		where = append(where, fmt.Sprintf("user_role = $%d", len(args)))
+	}
+	if filter.Active != nil {
+		args = append(args, *filter.Active)
+		where = append(where, fmt.Sprintf("is_active = $%d", len(args)))
+	}
+	if filter.Query != "" {
+		args = append(args, "%"+filter.Query+"%")
+		where = append(where, fmt.Sprintf(
+			"(phone ILIKE $%[1]d OR concat_ws(' ', last_name, first_name, middle_name) ILIKE $%[1]d)", len(args)))
+	}
+
+	whereSQL := " WHERE " + strings.Join(where, " AND ")
+
+	var total int
+	if err := s.dbpool.QueryRow(context.Background(), "SELECT COUNT(*) FROM users"+whereSQL, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	args = append(args, filter.Limit, (filter.Page-1)*filter.Limit)
+	query := fmt.Sprintf("SELECT %s FROM users%s ORDER BY last_name, first_name, id LIMIT $%d OFFSET $%d",
+		adminUserColumns, whereSQL, len(args)-1, len(args))
+
+	rows, err := s.dbpool.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users := make([]types.AdminUser, 0)
+	for rows.Next() {
+		u, err := scanAdminUser(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, *u)
+	}
+	return users, total, rows.Err()
+}
+
+func (s *Store) GetAdminUserByID(id int) (*types.AdminUser, error) {
+	rows, err := s.dbpool.Query(context.Background(), "SELECT "+adminUserColumns+" FROM users WHERE id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("user not found")
+	}
+	return scanAdminUser(rows)
+}
+
+func (s *Store) UpdateUserRole(userID int, role string) error {
+	_, err := s.dbpool.Exec(context.Background(),
+		"UPDATE users SET user_role = $1 WHERE id = $2", role, userID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (s *Store) SetUserActive(userID int, active bool) error {
+	_, err := s.dbpool.Exec(context.Background(),
+		`UPDATE users SET is_active = $1,
+			deactivated_at = CASE WHEN $1 THEN NULL ELSE NOW() END,
+			deactivation_reason = CASE WHEN $1 THEN NULL ELSE $3 END
+		 WHERE id = $2`,
+		active, userID, types.DeactivatedByAdmin)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+// ClaimUser завершает регистрацию пользователя, заранее созданного синхронизацией с AlfaCRM:
+// устанавливает пароль и телефон, под которым он будет входить. Возвращает false,
+// если пользователь уже зарегистрирован.
+func (s *Store) ClaimUser(userID int, phone, hashedPassword string) (bool, error) {
+	tag, err := s.dbpool.Exec(context.Background(),
+		"UPDATE users SET password = $1, phone = $2 WHERE id = $3 AND password = ''",
+		hashedPassword, phone, userID)
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func scanAdminUser(rows pgx.Rows) (*types.AdminUser, error) {
+	u := new(types.AdminUser)
+	err := rows.Scan(
+		&u.ID,
+		&u.Phone,
+		&u.FirstName,
+		&u.LastName,
+		&u.MiddleName,
+		&u.Role,
+		&u.IsActive,
+		&u.Registered,
+		&u.Balance,
+		&u.PaidLessonCount,
+		&u.CreatedAt,
+		&u.DeactivatedAt,
+		&u.DeactivationReason,
+		&u.CRMSyncedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
 func scanRowsIntoUser(rows pgx.Rows) (*types.User, error) {
 	user := new(types.User)
 
 	err := rows.Scan(
 		&user.ID,
+		&user.TenantID,
 		&user.Phone,
 		&user.Password,
 		&user.FirstName,
 		&user.LastName,
 		&user.MiddleName,
 		&user.Role,
+		&user.IsActive,
 		&user.CreatedAt,
 	)
 	if err != nil {
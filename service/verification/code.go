@@ -0,0 +1,148 @@
+package verification
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/prok05/ecom/config"
+	"github.com/prok05/ecom/types"
+	"log"
+	"math/big"
+	"time"
+)
+
+const (
+	PurposeRegister      = "register"
+	PurposePasswordReset = "password_reset"
+)
+
+var messages = map[string]string{
+	PurposeRegister:      "Код подтверждения регистрации: %s",
+	PurposePasswordReset: "Код для сброса пароля: %s",
+}
+
+var (
+	ErrResendTooSoon   = errors.New("code was sent recently, try again later")
+	ErrTooManyRequests = errors.New("too many codes requested, try again later")
+	ErrCodeNotFound    = errors.New("verification code not found")
+	ErrCodeExpired     = errors.New("verification code expired")
+	ErrTooManyAttempts = errors.New("too many attempts, request a new code")
+	ErrInvalidCode     = errors.New("invalid verification code")
+)
+
+// Service выдает и проверяет одноразовые коды подтверждения номера телефона.
+// Коды и лимиты отправки у каждой школы свои.
+type Service struct {
+	store  types.VerificationStore
+	sender types.SMSSender
+}
+
+func NewService(store types.VerificationStore, sender types.SMSSender) *Service {
+	return &Service{
+		store:  store,
+		sender: sender,
+	}
+}
+
+// SendCode генерирует новый код и отправляет его по SMS.
+// Повторная отправка ограничена по интервалу и по количеству кодов в час.
+func (s *Service) SendCode(tenantID int, phone, purpose string) error {
+	latest, err := s.store.GetLatestVerificationCode(tenantID, phone, purpose)
+	if err != nil {
+		return err
+	}
+	resendInterval := time.Second * time.Duration(config.Envs.VerificationResendSeconds)
+	if latest != nil && time.Since(latest.CreatedAt) < resendInterval {
+		return ErrResendTooSoon
+	}
+
+	count, err := s.store.CountVerificationCodesSince(tenantID, phone, purpose, time.Now().Add(-time.Hour))
+	if err != nil {
+		return err
+	}
+	if int64(count) >= config.Envs.VerificationMaxCodesPerHour {
+		return ErrTooManyRequests
+	}
+
+	code, err := generateCode()
+	if err != nil {
+		return err
+	}
+
+	verificationCode := types.VerificationCode{
+		TenantID:  tenantID,
+		Phone:     phone,
+		Purpose:   purpose,
+		CodeHash:  hashCode(tenantID, phone, purpose, code),
+		ExpiresAt: time.Now().Add(time.Second * time.Duration(config.Envs.VerificationCodeTTLSeconds)),
+	}
+	if err := s.store.CreateVerificationCode(&verificationCode); err != nil {
+		return err
+	}
+
+	if err := s.sender.Send(phone, fmt.Sprintf(messages[purpose], code)); err != nil {
+		log.Printf("failed to send sms to %s: %v", phone, err)
+		return err
+	}
+	return nil
+}
+
+// CheckCode проверяет последний выданный код. Успешно проверенный код
+// погашается и не может быть использован повторно.
+// Попытка расходуется до сравнения одним запросом, поэтому параллельные
+// проверки не превышают лимит попыток.
+func (s *Service) CheckCode(tenantID int, phone, purpose, code string) error {
+	latest, err := s.store.GetLatestVerificationCode(tenantID, phone, purpose)
+	if err != nil {
+		return err
+	}
+	if latest == nil || latest.ConsumedAt != nil {
+		return ErrCodeNotFound
+	}
+	if time.Now().After(latest.ExpiresAt) {
+		return ErrCodeExpired
+	}
+	if int64(latest.Attempts) >= config.Envs.VerificationMaxAttempts {
+		return ErrTooManyAttempts
+	}
+
+	codeHash, ok, err := s.store.UseVerificationAttempt(latest.ID, config.Envs.VerificationMaxAttempts)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		// код успели погасить или исчерпать параллельными запросами
+		return ErrTooManyAttempts
+	}
+
+	expected := []byte(codeHash)
+	actual := []byte(hashCode(tenantID, phone, purpose, code))
+	if subtle.ConstantTimeCompare(expected, actual) != 1 {
+		return ErrInvalidCode
+	}
+
+	consumed, err := s.store.ConsumeVerificationCode(latest.ID)
+	if err != nil {
+		return err
+	}
+	if !consumed {
+		return ErrCodeNotFound
+	}
+	return nil
+}
+
+func generateCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+func hashCode(tenantID int, phone, purpose, code string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d:%s:%s:%s", tenantID, phone, purpose, code)))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,95 @@
+package verification
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prok05/ecom/types"
+	"log"
+	"time"
+)
+
+type Store struct {
+	pool *pgxpool.Pool
+}
+
+func NewStore(pool *pgxpool.Pool) *Store {
+	return &Store{
+		pool: pool,
+	}
+}
+
+func (s *Store) CreateVerificationCode(code *types.VerificationCode) error {
+	err := s.pool.QueryRow(context.Background(),
+		`INSERT INTO verification_codes (tenant_id, phone, purpose, code_hash, expires_at)
+		 VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`,
+		code.TenantID, code.Phone, code.Purpose, code.CodeHash, code.ExpiresAt).Scan(&code.ID, &code.CreatedAt)
+	if err != nil {
+		log.Println("failed to create verification code:", err)
+		return err
+	}
+	return nil
+}
+
+func (s *Store) GetLatestVerificationCode(tenantID int, phone, purpose string) (*types.VerificationCode, error) {
+	var code types.VerificationCode
+	err := s.pool.QueryRow(context.Background(),
+		`SELECT id, tenant_id, phone, purpose, code_hash, attempts, expires_at, consumed_at, created_at
+		 FROM verification_codes
+		 WHERE tenant_id = $1 AND phone = $2 AND purpose = $3
+		 ORDER BY created_at DESC
+		 LIMIT 1`, tenantID, phone, purpose).Scan(
+		&code.ID,
+		&code.TenantID,
+		&code.Phone,
+		&code.Purpose,
+		&code.CodeHash,
+		&code.Attempts,
+		&code.ExpiresAt,
+		&code.ConsumedAt,
+		&code.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &code, nil
+}
+
+func (s *Store) CountVerificationCodesSince(tenantID int, phone, purpose string, since time.Time) (int, error) {
+	var count int
+	err := s.pool.QueryRow(context.Background(),
+		`SELECT COUNT(*) FROM verification_codes WHERE tenant_id = $1 AND phone = $2 AND purpose = $3 AND created_at > $4`,
+		tenantID, phone, purpose, since).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (s *Store) UseVerificationAttempt(codeID int, maxAttempts int64) (string, bool, error) {
+	var codeHash string
+	err := s.pool.QueryRow(context.Background(),
+		`UPDATE verification_codes SET attempts = attempts + 1
+		 WHERE id = $1 AND attempts < $2 AND consumed_at IS NULL AND expires_at > NOW()
+		 RETURNING code_hash`, codeID, maxAttempts).Scan(&codeHash)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return codeHash, true, nil
+}
+
+func (s *Store) ConsumeVerificationCode(codeID int) (bool, error) {
+	tag, err := s.pool.Exec(context.Background(),
+		`UPDATE verification_codes SET consumed_at = NOW() WHERE id = $1 AND consumed_at IS NULL`, codeID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
@@ -0,0 +1,166 @@
+package webhook
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/prok05/ecom/config"
+	"github.com/prok05/ecom/service/alpha"
+	"github.com/prok05/ecom/service/crmsync"
+	"github.com/prok05/ecom/service/tenant"
+	"github.com/prok05/ecom/service/ws"
+	"github.com/prok05/ecom/types"
+	"github.com/prok05/ecom/utils"
+	"log"
+	"net/http"
+)
+
+const (
+	EventLessonUpdated = "lesson.updated"
+	EventLessonDeleted = "lesson.deleted"
+	EventUserUpdated   = "user.updated"
+)
+
+// SecretHeader - заголовок с секретом вебхука, если его нельзя передать в адресе.
+const SecretHeader = "X-Webhook-Secret"
+
+// Handler принимает вебхуки AlfaCRM: обновляет копию уроков и пользователей
+// и рассылает изменения подключенным по WebSocket клиентам.
+type Handler struct {
+	tenants     *tenant.Resolver
+	lessonStore types.LessonStore
+	users       *crmsync.Syncer
+	crm         alpha.Provider
+	hub         *ws.Hub
+}
+
+func NewHandler(tenants *tenant.Resolver, lessonStore types.LessonStore, users *crmsync.Syncer, crm alpha.Provider, hub *ws.Hub) *Handler {
+	return &Handler{
+		tenants:     tenants,
+		lessonStore: lessonStore,
+		users:       users,
+		crm:         crm,
+		hub:         hub,
+	}
+}
+
+// RegisterRoutes регистрирует адрес вебхука. AlfaCRM не передает заголовки школы,
+// поэтому школа указывается в пути, а секрет - в параметре secret.
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/webhooks/alfacrm/{tenant}", h.handleAlfaCRM).Methods(http.MethodPost)
+}
+
+func (h *Handler) handleAlfaCRM(w http.ResponseWriter, r *http.Request) {
+	t, err := h.tenants.BySlug(mux.Vars(r)["tenant"])
+	if err != nil || !t.IsActive {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("unknown school"))
+		return
+	}
+	if !validSecret(t, r) {
+		utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("invalid webhook secret"))
+		return
+	}
+
+	var event types.AlphaWebhookEvent
+	if err := utils.ParseJSON(r, &event); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if event.EntityID == 0 {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("missing entity_id"))
+		return
+	}
+
+	account := alpha.AccountFor(t)
+	id := account.LocalID(event.EntityID)
+	switch event.Entity {
+	case "Lesson":
+		err = h.handleLesson(r.Context(), t, id, event.Event)
+	case "Customer":
+		err = h.handleUser(r.Context(), t, types.RoleStudent, id)
+	case "Teacher":
+		err = h.handleUser(r.Context(), t, types.RoleTeacher, id)
+	default:
+		// остальные сущности платформа не хранит
+	}
+	if err != nil {
+		log.Printf("alfacrm webhook %s %s %d for %s failed: %v", event.Event, event.Entity, event.EntityID, t.Slug, err)
+		utils.WriteError(w, http.StatusBadGateway, fmt.Errorf("cannot process webhook"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// handleLesson перечитывает урок из CRM, потому что fields_new содержит только
+// измененные поля. Уведомление получают прежние и новые участники урока.
+func (h *Handler) handleLesson(ctx context.Context, t *types.Tenant, lessonID int, action string) error {
+	old, err := h.lessonStore.GetLesson(t.ID, lessonID)
+	if err != nil {
+		return err
+	}
+
+	var lesson *types.GetLessonsResponseItem
+	if action != "delete" {
+		lesson, err = h.crm.For(alpha.AccountFor(t)).GetLesson(ctx, lessonID)
+		if errors.Is(err, alpha.ErrNotFound) {
+			lesson, err = nil, nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	recipients := make([]int, 0)
+	if old != nil {
+		recipients = append(recipients, old.TeacherIDs...)
+		recipients = append(recipients, old.CustomerIDs...)
+	}
+
+	if lesson == nil {
+		if err := h.lessonStore.DeleteLesson(t.ID, lessonID); err != nil {
+			return err
+		}
+		h.hub.Publish(t.ID, recipients, types.WSEvent{Type: EventLessonDeleted, EntityID: lessonID})
+		return nil
+	}
+
+	if err := h.lessonStore.SaveLesson(t.ID, *lesson); err != nil {
+		return err
+	}
+	recipients = append(recipients, lesson.TeacherIDs...)
+	recipients = append(recipients, lesson.CustomerIDs...)
+	h.hub.Publish(t.ID, recipients, types.WSEvent{Type: EventLessonUpdated, EntityID: lessonID, Data: lesson})
+	return nil
+}
+
+func (h *Handler) handleUser(ctx context.Context, t *types.Tenant, role string, userID int) error {
+	change, err := h.users.SyncUser(ctx, t, role, userID)
+	if err != nil {
+		return err
+	}
+	if change == nil {
+		return nil
+	}
+	h.hub.Publish(t.ID, []int{userID}, types.WSEvent{Type: EventUserUpdated, EntityID: userID, Data: change})
+	return nil
+}
+
+// validSecret сравнивает секрет запроса с секретом школы. Без настроенного секрета вебхук отключен.
+func validSecret(t *types.Tenant, r *http.Request) bool {
+	expected := t.WebhookSecret
+	if expected == "" {
+		expected = config.Envs.AlphaWebhookSecret
+	}
+	if expected == "" {
+		return false
+	}
+
+	actual := r.URL.Query().Get("secret")
+	if actual == "" {
+		actual = r.Header.Get(SecretHeader)
+	}
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(actual)) == 1
+}
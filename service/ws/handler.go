@@ -1,34 +1,38 @@
 package ws
 
 import (
-	"fmt"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/websocket"
 	"github.com/prok05/ecom/cache"
 	"github.com/prok05/ecom/service/auth"
 	"github.com/prok05/ecom/types"
-	"github.com/prok05/ecom/utils"
 	"log"
 	"net/http"
-	"strconv"
 	"sync"
 	"time"
 )
 
+// sendBufferSize - сколько сообщений ждет отправки клиенту. Клиент, который
+// не успевает их забирать, отключается
+const sendBufferSize = 256
+
 type Client struct {
-	conn    *websocket.Conn
-	send    chan types.Message
-	userID  int
-	role    string
-	chatIDs map[int]bool
-	mu      sync.Mutex
+	conn      *websocket.Conn
+	send      chan any
+	userID    int
+	tenantID  int
+	role      string
+	sessionID int
+	chatIDs   map[int]bool
+	mu        sync.Mutex
 }
 
 type Hub struct {
 	clients    map[*Client]bool
 	broadcast  chan types.Message
+	events     chan delivery
 	register   chan *Client
 	unregister chan *Client
+	disconnect chan disconnect
 	mu         sync.Mutex
 }
 
@@ -36,19 +40,76 @@ func NewHub() *Hub {
 	return &Hub{
 		clients:    make(map[*Client]bool),
 		broadcast:  make(chan types.Message),
+		events:     make(chan delivery),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		disconnect: make(chan disconnect),
 	}
 }
 
 func NewClient(hub *Hub, conn *websocket.Conn) *Client {
 	return &Client{
 		conn:    conn,
-		send:    make(chan types.Message),
+		send:    make(chan any, sendBufferSize),
 		chatIDs: make(map[int]bool),
 	}
 }
 
+// delivery - событие и его получатели в одной школе.
+// Личное событие не рассылается супервизорам.
+type delivery struct {
+	tenantID int
+	userIDs  map[int]bool
+	event    types.WSEvent
+	private  bool
+}
+
+// Publish отправляет событие подключенным пользователям userIDs школы tenantID.
+// Супервизоры школы получают все ее события.
+func (h *Hub) Publish(tenantID int, userIDs []int, event types.WSEvent) {
+	d := delivery{
+		tenantID: tenantID,
+		userIDs:  make(map[int]bool, len(userIDs)),
+		event:    event,
+	}
+	for _, id := range userIDs {
+		d.userIDs[id] = true
+	}
+	h.events <- d
+}
+
+// PublishPrivate отправляет событие только пользователям userIDs, без копии супервизорам.
+func (h *Hub) PublishPrivate(tenantID int, userIDs []int, event types.WSEvent) {
+	d := delivery{
+		tenantID: tenantID,
+		userIDs:  make(map[int]bool, len(userIDs)),
+		event:    event,
+		private:  true,
+	}
+	for _, id := range userIDs {
+		d.userIDs[id] = true
+	}
+	h.events <- d
+}
+
+// disconnect - соединения, которые нужно закрыть: все соединения пользователя
+// или только открытые в сессии sessionID.
+type disconnect struct {
+	userID    int
+	sessionID int
+}
+
+// DisconnectUser закрывает все соединения пользователя. Права проверяются только
+// при подключении, поэтому вызывается при отзыве всех сессий, блокировке и смене роли.
+func (h *Hub) DisconnectUser(userID int) {
+	h.disconnect <- disconnect{userID: userID}
+}
+
+// DisconnectSession закрывает соединения, открытые в отозванной сессии.
+func (h *Hub) DisconnectSession(sessionID int) {
+	h.disconnect <- disconnect{sessionID: sessionID}
+}
+
 func (h *Hub) Run() {
 	for {
 		select {
@@ -65,6 +126,16 @@ func (h *Hub) Run() {
 				log.Printf("Client unregistered: userID=%d", client.userID)
 			}
 			h.mu.Unlock()
+		case d := <-h.disconnect:
+			h.mu.Lock()
+			for client := range h.clients {
+				if (d.userID != 0 && client.userID == d.userID) || (d.sessionID != 0 && client.sessionID == d.sessionID) {
+					close(client.send)
+					delete(h.clients, client)
+					log.Printf("Client disconnected: userID=%d", client.userID)
+				}
+			}
+			h.mu.Unlock()
 		case message := <-h.broadcast:
 			h.mu.Lock()
 			for client := range h.clients {
@@ -78,6 +149,22 @@ func (h *Hub) Run() {
 				}
 			}
 			h.mu.Unlock()
+		case d := <-h.events:
+			h.mu.Lock()
+			for client := range h.clients {
+				if client.tenantID != d.tenantID {
+					continue
+				}
+				if d.userIDs[client.userID] || (!d.private && client.role == types.RoleSupervisor) {
+					select {
+					case client.send <- d.event:
+					default:
+						close(client.send)
+						delete(h.clients, client)
+					}
+				}
+			}
+			h.mu.Unlock()
 		}
 	}
 }
@@ -90,45 +177,13 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
+// Handler поднимает WebSocket-соединение. Аутентификация выполняется
+// снаружи через auth.Authorizer, здесь пользователь берется из контекста.
 func Handler(hub *Hub, messageStore types.MessageStore, chatStore types.ChatStore, userStore types.UserStore, tokenCache *cache.TokenCache) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		tokenString := auth.GetTokenFromRequest(r)
-		if tokenString == "" {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		jwtToken, err := auth.ValidateToken(tokenString)
-		if err != nil || !jwtToken.Valid {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		claims, ok := jwtToken.Claims.(jwt.MapClaims)
-		if !ok {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		userIDStr, ok := claims["userID"].(string)
-		if !ok {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		userID, err := strconv.Atoi(userIDStr)
-		if err != nil {
-			log.Printf("Unable to convert userID: %v", err)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		role, ok := claims["role"].(string)
-		if !ok {
-			utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("invalid token"))
-			log.Printf("Unable to get role: %v", err)
-			return
-		}
+		principal, _ := auth.PrincipalFromContext(r.Context())
+		userID := principal.UserID
+		role := principal.Role
 
 		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
@@ -137,14 +192,16 @@ func Handler(hub *Hub, messageStore types.MessageStore, chatStore types.ChatStor
 		}
 
 		client := &Client{
-			conn:    conn,
-			send:    make(chan types.Message),
-			role:    role,
-			userID:  userID,
-			chatIDs: make(map[int]bool),
+			conn:      conn,
+			send:      make(chan any, sendBufferSize),
+			role:      role,
+			userID:    userID,
+			tenantID:  principal.TenantID,
+			sessionID: principal.SessionID,
+			chatIDs:   make(map[int]bool),
 		}
 
-		if client.role == "student" {
+		if client.role == types.RoleStudent {
 			//token, err := tokenCache.GetToken()
 			//if err != nil {
 			//	log.Println("no alpha token")
@@ -175,7 +232,7 @@ func Handler(hub *Hub, messageStore types.MessageStore, chatStore types.ChatStor
 			for _, chat := range chats {
 				client.chatIDs[chat.ID] = true
 			}
-		} else if client.role == "teacher" {
+		} else if client.role == types.RoleTeacher {
 			chats, err := chatStore.GetAllChatsByUserID(userID)
 			if err != nil {
 				log.Printf("Error retrieving user chats: %v", err)
@@ -210,7 +267,7 @@ func (c *Client) readPump(hub *Hub, messageStore types.MessageStore, chatStore t
 			break
 		}
 
-		if c.role == "teacher" {
+		if c.role == types.RoleTeacher {
 			message := &types.Message{
 				ChatID:    payload.Message.ChatID,
 				SenderID:  c.userID,
@@ -228,7 +285,7 @@ func (c *Client) readPump(hub *Hub, messageStore types.MessageStore, chatStore t
 			message.TeacherID = c.userID
 
 			hub.broadcast <- *message
-		} else if c.role == "student" {
+		} else if c.role == types.RoleStudent {
 			chat, err := chatStore.GetChatByUserIDs(c.userID, payload.UserID)
 			if err != nil {
 				c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "Failed to retrieve chat"))
@@ -238,6 +295,7 @@ func (c *Client) readPump(hub *Hub, messageStore types.MessageStore, chatStore t
 				chat = &types.Chat{
 					ChatType: "",
 					Name:     "",
+					TenantID: c.tenantID,
 				}
 				err = chatStore.CreateChat(chat, []int{c.userID, payload.UserID})
 				if err != nil {
@@ -5,13 +5,30 @@ import (
 	"time"
 )
 
+const (
+	RoleTeacher    = "teacher"
+	RoleStudent    = "student"
+	RoleSupervisor = "supervisor"
+	RoleParent     = "parent"
+)
+
+// LocalUserIDStart - начало диапазона ID пользователей, которых нет в AlfaCRM.
+const LocalUserIDStart = 1000000000
+
 type UserStore interface {
 	FindUserByEmail(email string) (*User, error)
-	FindUserByPhone(phone string) (*User, error)
+	FindUserByPhone(tenantID int, phone string) (*User, error)
 	FindUserByID(id int) (*UserDTO, error)
 	CreateUser(User) error
-	GetAllTeachers() ([]*UserDTO, error)
-	GetAllStudents() ([]*UserDTO, error)
+	UpdatePassword(userID int, hashedPassword string) error
+	NextLocalUserID(idOffset int) (int, error)
+	ListUsers(filter UserFilter) ([]AdminUser, int, error)
+	GetAdminUserByID(id int) (*AdminUser, error)
+	UpdateUserRole(userID int, role string) error
+	SetUserActive(userID int, active bool) error
+	ClaimUser(userID int, phone, hashedPassword string) (bool, error)
+	GetAllTeachers(tenantID int) ([]*UserDTO, error)
+	GetAllStudents(tenantID int) ([]*UserDTO, error)
 	FindUsersByIDs(ids []int) (*[]UserDTO, error)
 }
 
@@ -25,8 +42,9 @@ type HomeworkStore interface {
 	SaveHomework(lessonID, studentID, teacherID int) (int, error)
 	AssignHomework(data HomeworkAssignment) (int, error)
 	DeleteHomework(homeworkID int) error
-	SaveHomeworkFile(homeworkID int, filepath string) error
+	SaveHomeworkFile(homeworkID, studentID int, filename, filepath string) error
 	UpdateSolutionStatus(solutionID, status int) error
+	UpdateSolutionReviewNotes(solutionID int, notes string) error
 	CountHomeworksWithStatus(lessonID, teacherID, status int) (int, error)
 	GetHomeworksByLessonAndStudentID(studentID int, lessonIDs []int) (map[int]*HomeworkInfo, error)
 	GetHomeworkFilesByHomeworkID(homeworkID int) ([]HomeworkFile, error)
@@ -35,12 +53,19 @@ type HomeworkStore interface {
 	GetHomeworkFilePathByID(fileID int) (string, error)
 	GetHomeworksByTeacherAndLessonID(lessonID, teacherID int, studentIDs []int) ([]HomeworkResponse, error)
 	GetHomeworkByLessonID(lessonID int) (*Homework, error)
-	GetHomeworksByTeacherID(teacherID int) ([]Homework, error)
+	// GetHomeworksByTeacherID возвращает ДЗ преподавателя, subjectID 0 - по всем предметам
+	GetHomeworksByTeacherID(teacherID, subjectID int) ([]Homework, error)
+	GetSubjectReport(tenantID int, dateFrom, dateTo string) ([]SubjectReport, error)
 	GetHomeworksByStudentID(studentID int) ([]HomeworkStudent, error)
 	GetHomeworkSolutions(homeworkID int) (*[]HomeworkSolution, error)
 	GetHomeworkTeacherFiles(homeworkID int) ([]File, error)
 	GetHomeworkSolutionByStudent(homeworkID int, studentID int) (*HomeworkSolution, error)
 	AssignSolution(data SolutionAssignment) error
+	GetHomeworkAccess(homeworkID int) (*HomeworkAccess, error)
+	IsStudentAssignedToHomework(homeworkID, studentID int) (bool, error)
+	GetSolutionAccess(solutionID int) (*HomeworkAccess, error)
+	GetHomeworkFileAccess(fileID int) (*HomeworkAccess, error)
+	GetHomeworkTeacherFileAccess(fileID int) (*HomeworkAccess, error)
 }
 
 type ChatStore interface {
@@ -53,22 +78,300 @@ type ChatStore interface {
 }
 
 type LessonStore interface {
-	SaveLessonRate(studentID, teacherID, lessonID int, lessonDate time.Time, rate int8) error
+	SaveLessonRate(rate NewLessonRate) error
 	CheckRateExists(studentID, teacherID, lessonID int) (bool, error)
-	GetLessonRates() ([]LessonRate, error)
+	GetLessonRates(filter LessonRateFilter) ([]LessonRate, error)
+	// GetLessonRateStats группирует оценки по преподавателю, предмету или периоду (LessonRateGroupBy*)
+	GetLessonRateStats(filter LessonRateFilter, groupBy string) ([]LessonRateGroup, error)
+	ListLessons(filter LessonFilter) ([]GetLessonsResponseItem, error)
+	GetLesson(tenantID, lessonID int) (*GetLessonsResponseItem, error)
+	// ReplaceLessons сохраняет уроки школы за период from..to и удаляет из него остальные
+	ReplaceLessons(tenantID int, from, to time.Time, lessons []GetLessonsResponseItem) (upserted, deleted int, err error)
+	SaveLesson(tenantID int, lesson GetLessonsResponseItem) error
+	DeleteLesson(tenantID, lessonID int) error
+	GetLessonSyncState(tenantID int) (*LessonSyncState, error)
+	SaveLessonSyncState(state *LessonSyncState) error
+	// GetStudentTeacherIDs - преподаватели проведенных с since уроков ученика по локальной копии
+	GetStudentTeacherIDs(tenantID, studentID int, since time.Time) ([]int, error)
+}
+
+type SessionStore interface {
+	CreateSession(session *Session) error
+	GetSessionByID(sessionID int) (*Session, error)
+	GetSessionByTokenHash(tokenHash string) (*Session, error)
+	GetSessionByPreviousTokenHash(tokenHash string) (*Session, error)
+	GetActiveSessionsByUserID(userID int) ([]Session, error)
+	IsSessionActive(sessionID int) (bool, error)
+	RotateRefreshToken(sessionID int, oldHash, newHash string, expiresAt time.Time) error
+	RevokeSession(sessionID int) error
+	RevokeAllUserSessions(userID int) error
+}
+
+type VerificationStore interface {
+	CreateVerificationCode(code *VerificationCode) error
+	GetLatestVerificationCode(tenantID int, phone, purpose string) (*VerificationCode, error)
+	CountVerificationCodesSince(tenantID int, phone, purpose string, since time.Time) (int, error)
+	// UseVerificationAttempt атомарно расходует попытку действующего кода и возвращает его хеш.
+	// ok == false, если код погашен, истек или попытки закончились
+	UseVerificationAttempt(codeID int, maxAttempts int64) (codeHash string, ok bool, err error)
+	// ConsumeVerificationCode гасит код, false - код уже погашен
+	ConsumeVerificationCode(codeID int) (bool, error)
+}
+
+// SMSSender отправляет SMS на номер телефона.
+type LoginThrottleStore interface {
+	RecordLoginAttempt(attempt *LoginAttempt) error
+	GetLoginAttemptsByUserID(tenantID, userID, limit int) ([]LoginAttempt, error)
+	CountLoginFailures(tenantID int, scope, key string, since time.Time) (int, *time.Time, error)
+	GetActiveLockout(tenantID int, scope, key string) (*LoginLockout, error)
+	CreateLockout(lockout *LoginLockout) error
+	ReleaseLockouts(tenantID int, scope, key string, unlockedBy int) (int, error)
+}
+
+type ParentStore interface {
+	LinkStudent(parentID, studentID int, source string, createdBy *int) error
+	UnlinkStudent(parentID, studentID int) (bool, error)
+	GetChildren(parentID int) ([]ParentChild, error)
+	IsParentOf(parentID, studentID int) (bool, error)
+	GetChildHomeworks(studentID int) ([]ChildHomework, error)
+	GetChildLessonRates(studentID int) ([]LessonRate, error)
+}
+
+type CRMSyncStore interface {
+	GetLocalUsers(tenant *Tenant) (map[int]CRMUser, error)
+	GetLocalUser(tenant *Tenant, userID int) (*CRMUser, error)
+	UpsertCRMUser(u CRMUser) error
+	SetCRMUserActive(userID int, active bool) error
+	SaveSyncReport(report *CRMSyncReport) error
+	GetSyncReports(tenantID, limit int) ([]CRMSyncReport, error)
+}
+
+type OutboxStore interface {
+	// ClaimDue выдает готовые к отправке сообщения и откладывает их на lease,
+	// чтобы их не взял другой обработчик
+	ClaimDue(limit int, lease time.Duration) ([]OutboxMessage, error)
+	MarkDelivered(id int) error
+	MarkFailed(id, attempts int, nextAttemptAt time.Time, lastError string, dead bool) error
+	GetMessages(tenantID int, status string, limit int) ([]OutboxMessage, error)
+	Replay(tenantID, id int) (bool, error)
+}
+
+type ReferenceStore interface {
+	GetSubjects(tenantID int) ([]ReferenceItem, error)
+	GetRooms(tenantID int) ([]ReferenceItem, error)
+	GetGroups(tenantID int) ([]LearningGroup, error)
+	// Replace* сохраняют справочник школы, записи, которых нет в списке, становятся неактивными
+	ReplaceSubjects(tenantID int, items []ReferenceItem) error
+	ReplaceRooms(tenantID int, items []ReferenceItem) error
+	ReplaceGroups(tenantID int, groups []LearningGroup) error
+	// LinkHomeworkSubjects проставляет subject_id ДЗ, у которых предмет указан только названием
+	LinkHomeworkSubjects(tenantID int) (int, error)
+}
+
+type NotificationStore interface {
+	CreateNotification(n *Notification) error
+	// GetNotifications возвращает последние уведомления пользователя и число непрочитанных
+	GetNotifications(userID int, unreadOnly bool, limit int) ([]Notification, int, error)
+	MarkNotificationRead(userID, id int) (bool, error)
+	MarkAllNotificationsRead(userID int) (int, error)
+}
+
+type BalanceStore interface {
+	// GetBalances возвращает остаток учеников и число их запланированных уроков до lessonsUntil
+	GetBalances(studentIDs []int, lessonsUntil time.Time) ([]StudentBalance, error)
+	GetLowBalances(filter LowBalanceFilter) ([]StudentBalance, error)
+	// GetPendingBalanceAlerts - ученики с числом оплаченных занятий меньше threshold, которых еще не предупредили
+	GetPendingBalanceAlerts(tenantID, threshold int) ([]BalanceAlert, error)
+	SaveBalanceAlert(alert BalanceAlert) error
+	// ClearBalanceAlerts снимает отметку с учеников, у которых снова threshold занятий или больше
+	ClearBalanceAlerts(tenantID, threshold int) (int, error)
+}
+
+type CalendarStore interface {
+	// SaveFeed создает ссылку на календарь пользователя или заменяет токен существующей
+	SaveFeed(userID int, tokenHash string) (*CalendarFeed, error)
+	GetFeed(userID int) (*CalendarFeed, error)
+	DeleteFeed(userID int) (bool, error)
+	// FindFeed ищет ссылку по хэшу токена и отмечает обращение к ней
+	FindFeed(tokenHash string) (*CalendarFeed, error)
+}
+
+type AttendanceStore interface {
+	// SaveAttendance заменяет отметки учеников урока. push - поставить посещаемость урока
+	// в очередь отправки в AlfaCRM
+	SaveAttendance(lesson AttendanceLesson, markedBy int, marks []AttendanceMark, push bool) error
+	GetLessonAttendance(tenantID, lessonID int) ([]AttendanceRecord, error)
+	GetAttendance(filter AttendanceFilter) ([]AttendanceRecord, error)
+	// GetAttendanceSummary возвращает посещаемость по ученикам, начиная с самой низкой
+	GetAttendanceSummary(filter AttendanceFilter) ([]AttendanceSummary, error)
+	// GetStudentAttendance - отметка ученика на уроке, пустая, если его не отмечали
+	GetStudentAttendance(tenantID, lessonID, studentID int) (string, error)
+}
+
+type TeacherStatsStore interface {
+	// GetTeacherStats считает показатели активных преподавателей школы за период,
+	// teacherID не 0 - только одного преподавателя
+	GetTeacherStats(tenantID, teacherID int, period StatsPeriod) ([]TeacherStats, error)
+}
+
+type MaterialStore interface {
+	CreateFolder(folder MaterialFolder) (*MaterialFolder, error)
+	GetFolder(tenantID, folderID int) (*MaterialFolder, error)
+	// GetFolders - вложенные папки, parentID nil - папки верхнего уровня
+	GetFolders(tenantID int, parentID *int) ([]MaterialFolder, error)
+	RenameFolder(tenantID, folderID int, name string) error
+	// DeleteFolder удаляет пустую папку, false - в ней есть папки или материалы
+	DeleteFolder(tenantID, folderID int) (bool, error)
+
+	CreateMaterial(material Material) (*Material, error)
+	GetMaterial(tenantID, materialID int) (*Material, error)
+	GetMaterials(filter MaterialFilter) ([]Material, error)
+	UpdateMaterial(material Material) error
+	DeleteMaterial(tenantID, materialID int) error
+
+	// AttachMaterial открывает материал ученикам урока, повторное прикрепление обновляет состав урока
+	AttachMaterial(link LessonMaterialLink) error
+	DetachMaterial(tenantID, lessonID, materialID int) (bool, error)
+	GetLessonMaterials(tenantID, lessonID int) ([]Material, error)
+	// GetLessonMaterialsFor - материалы уроков ученика или преподавателя, начиная с последнего урока
+	GetLessonMaterialsFor(filter LessonMaterialFilter) ([]LessonMaterials, error)
+	// CountLessonMaterials - число материалов по урокам, уроки без материалов не попадают
+	CountLessonMaterials(tenantID int, lessonIDs []int) (map[int]int, error)
+	// IsSharedWith - прикреплен ли материал к уроку, в котором участвует пользователь
+	IsSharedWith(tenantID, materialID, userID int) (bool, error)
+}
+
+type TenantStore interface {
+	GetTenants() ([]Tenant, error)
+}
+
+type SMSSender interface {
+	Send(phone, text string) error
+}
+
+// Tenant - школа или филиал со своим аккаунтом AlfaCRM.
+// Пустые параметры подключения к CRM берутся из config.Envs.
+type Tenant struct {
+	ID          int    `json:"id"`
+	Slug        string `json:"slug"`
+	Name        string `json:"name"`
+	Host        string `json:"host,omitempty"`
+	CRMHost     string `json:"-"`
+	CRMBranchID int    `json:"-"`
+	CRMEmail    string `json:"-"`
+	CRMAPIKey   string `json:"-"`
+	CRMAppKey   string `json:"-"`
+	IDOffset    int    `json:"-"`
+	IsActive    bool   `json:"-"`
+	// WebhookSecret - секрет в адресе вебхука AlfaCRM, пустой берется из config.Envs
+	WebhookSecret string `json:"-"`
+	// Timezone - часовой пояс времени уроков в AlfaCRM, например Europe/Moscow, пустой берется из config.Envs
+	Timezone string `json:"-"`
 }
 
 type User struct {
 	ID         int       `json:"id"`
+	TenantID   int       `json:"-"`
 	FirstName  string    `json:"firstName"`
 	LastName   string    `json:"lastName"`
 	MiddleName string    `json:"middleName"`
 	Phone      string    `json:"phone"`
 	Password   string    `json:"-"`
 	Role       string    `json:"role"`
+	IsActive   bool      `json:"-"`
 	CreatedAt  time.Time `json:"createdAt"`
 }
 
+type Session struct {
+	ID               int        `json:"id"`
+	UserID           int        `json:"user_id"`
+	RefreshTokenHash string     `json:"-"`
+	UserAgent        string     `json:"user_agent"`
+	IP               string     `json:"ip"`
+	CreatedAt        time.Time  `json:"created_at"`
+	LastUsedAt       time.Time  `json:"last_used_at"`
+	ExpiresAt        time.Time  `json:"expires_at"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+	Current          bool       `json:"current"`
+}
+
+type VerificationCode struct {
+	ID         int
+	TenantID   int
+	Phone      string
+	Purpose    string
+	CodeHash   string
+	Attempts   int
+	ExpiresAt  time.Time
+	ConsumedAt *time.Time
+	CreatedAt  time.Time
+}
+
+const (
+	LockoutScopePhone = "phone"
+	LockoutScopeIP    = "ip"
+)
+
+type LoginAttempt struct {
+	ID        int       `json:"id"`
+	TenantID  int       `json:"-"`
+	Phone     string    `json:"phone"`
+	UserID    *int      `json:"user_id,omitempty"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Success   bool      `json:"success"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type LoginLockout struct {
+	ID          int
+	TenantID    int
+	Scope       string
+	Key         string
+	LockedUntil time.Time
+	Reason      string
+	UnlockedAt  *time.Time
+	UnlockedBy  *int
+	CreatedAt   time.Time
+}
+
+const (
+	ParentLinkManual = "manual"
+	ParentLinkAlpha  = "alpha"
+)
+
+// ParentChild - ученик, привязанный к родителю. Если ученик еще не зарегистрирован,
+// известен только его ID в AlfaCRM.
+type ParentChild struct {
+	StudentID  int       `json:"student_id"`
+	FirstName  string    `json:"first_name"`
+	LastName   string    `json:"last_name"`
+	MiddleName string    `json:"middle_name"`
+	Registered bool      `json:"registered"`
+	Source     string    `json:"source"`
+	LinkedAt   time.Time `json:"linked_at"`
+}
+
+// ChildHomework - домашнее задание ученика с оценкой преподавателя, для родителя.
+type ChildHomework struct {
+	HomeworkID       int       `json:"homework_id"`
+	LessonID         int       `json:"lesson_id"`
+	LessonDate       time.Time `json:"lesson_date"`
+	LessonTopic      string    `json:"lesson_topic"`
+	SubjectTitle     string    `json:"subject_title"`
+	TeacherID        int       `json:"teacher_id"`
+	TeacherFirstName string    `json:"teacher_first_name"`
+	TeacherLastName  string    `json:"teacher_last_name"`
+	Status           int       `json:"status"`
+	ReviewNotes      string    `json:"review_notes"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+type LinkChildPayload struct {
+	StudentID int `json:"student_id" validate:"required"`
+}
+
 type UserDTO struct {
 	ID         int    `json:"id"`
 	Phone      string `json:"phone"`
@@ -76,6 +379,117 @@ type UserDTO struct {
 	LastName   string `json:"last_name"`
 	MiddleName string `json:"middle_name"`
 	Role       string `json:"role"`
+	IsActive   bool   `json:"-"`
+	TenantID   int    `json:"-"`
+}
+
+// AdminUser - пользователь в административном API.
+type AdminUser struct {
+	ID                 int        `json:"id"`
+	Phone              string     `json:"phone"`
+	FirstName          string     `json:"first_name"`
+	LastName           string     `json:"last_name"`
+	MiddleName         string     `json:"middle_name"`
+	Role               string     `json:"role"`
+	IsActive           bool       `json:"is_active"`
+	Registered         bool       `json:"registered"`
+	Balance            *float64   `json:"balance,omitempty"`
+	PaidLessonCount    *int       `json:"paid_lesson_count,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
+	DeactivatedAt      *time.Time `json:"deactivated_at,omitempty"`
+	DeactivationReason string     `json:"deactivation_reason,omitempty"`
+	CRMSyncedAt        *time.Time `json:"crm_synced_at,omitempty"`
+}
+
+const (
+	DeactivatedByAdmin = "admin"
+	DeactivatedByCRM   = "crm"
+)
+
+// CRMUser - профиль пользователя в том виде, в каком его синхронизирует crmsync.
+type CRMUser struct {
+	ID                 int
+	TenantID           int
+	Phone              string
+	FirstName          string
+	LastName           string
+	MiddleName         string
+	Role               string
+	Balance            *float64
+	PaidLessonCount    *int
+	IsActive           bool
+	DeactivationReason string
+}
+
+type CRMSyncChange struct {
+	UserID int      `json:"user_id"`
+	Role   string   `json:"role"`
+	Action string   `json:"action"`
+	Fields []string `json:"fields,omitempty"`
+}
+
+// OutboxMessage - изменение, ожидающее отправки в AlfaCRM.
+type OutboxMessage struct {
+	ID            int            `json:"id"`
+	TenantID      int            `json:"tenant_id"`
+	Kind          string         `json:"kind"`
+	LessonID      int            `json:"lesson_id"`
+	Payload       map[string]any `json:"payload"`
+	Status        string         `json:"status"`
+	Attempts      int            `json:"attempts"`
+	NextAttemptAt time.Time      `json:"next_attempt_at"`
+	LastError     string         `json:"last_error,omitempty"`
+	CreatedAt     time.Time      `json:"created_at"`
+	DeliveredAt   *time.Time     `json:"delivered_at,omitempty"`
+}
+
+// CRMSyncReport - итог одного запуска синхронизации пользователей с AlfaCRM.
+type CRMSyncReport struct {
+	ID          int             `json:"id"`
+	TenantID    int             `json:"tenant_id"`
+	StartedAt   time.Time       `json:"started_at"`
+	FinishedAt  time.Time       `json:"finished_at"`
+	Created     int             `json:"created"`
+	Updated     int             `json:"updated"`
+	Deactivated int             `json:"deactivated"`
+	Reactivated int             `json:"reactivated"`
+	Skipped     int             `json:"skipped"`
+	Errors      []string        `json:"errors"`
+	Changes     []CRMSyncChange `json:"changes"`
+}
+
+// UserFilter - фильтр списка пользователей. Query ищет по ФИО и телефону.
+type UserFilter struct {
+	TenantID int
+	Role     string
+	Query    string
+	Active   *bool
+	Page     int
+	Limit    int
+}
+
+type UserListResponse struct {
+	Total int         `json:"total"`
+	Page  int         `json:"page"`
+	Limit int         `json:"limit"`
+	Items []AdminUser `json:"items"`
+}
+
+type CreateUserPayload struct {
+	Phone      string `json:"phone" validate:"required"`
+	Password   string `json:"password" validate:"required"`
+	FirstName  string `json:"first_name" validate:"required"`
+	LastName   string `json:"last_name" validate:"required"`
+	MiddleName string `json:"middle_name"`
+	Role       string `json:"role" validate:"required,oneof=teacher student supervisor parent"`
+}
+
+type UpdateUserRolePayload struct {
+	Role string `json:"role" validate:"required,oneof=teacher student supervisor parent"`
+}
+
+type SetPasswordPayload struct {
+	Password string `json:"password" validate:"required"`
 }
 
 type Message struct {
@@ -87,6 +501,13 @@ type Message struct {
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// WSEvent - уведомление об изменении данных, которое получают подключенные по WebSocket клиенты.
+type WSEvent struct {
+	Type     string `json:"type"` // например lesson.updated, lesson.deleted, user.updated
+	EntityID int    `json:"entity_id"`
+	Data     any    `json:"data,omitempty"`
+}
+
 type MessagePayload struct {
 	Type    string  `json:"type"`
 	UserID  int     `json:"user_id"`
@@ -99,6 +520,7 @@ type Homework struct {
 	LessonDate       time.Time `json:"lesson_date"`
 	LessonTopic      string    `json:"lesson_topic"`
 	TeacherID        int       `json:"teacher_id"`
+	SubjectID        *int      `json:"subject_id"`
 	SubjectTitle     string    `json:"subject_title"`
 	Description      string    `json:"description"`
 	CreatedAt        time.Time `json:"created_at_at"`
@@ -134,6 +556,7 @@ type HomeworkInfo struct {
 
 type Chat struct {
 	ID        int       `json:"id"`
+	TenantID  int       `json:"-"`
 	ChatType  string    `json:"chat_type"`
 	Name      string    `json:"name"`
 	CreatedAt time.Time `json:"created_at"`
@@ -165,14 +588,32 @@ type AllChatsItem struct {
 	Participants []Participant `json:"participants"`
 }
 
+// Администратор не регистрируется сам, его создают через /admin/users или cmd/create_admin
+type RegisterCodePayload struct {
+	Phone string `json:"phone" validate:"required"`
+	Role  string `json:"role" validate:"required,oneof=teacher student parent"`
+}
+
 type RegisterUserPayload struct {
 	Phone    string `json:"phone" validate:"required"`
-	Role     string `json:"role" validate:"required"`
+	Role     string `json:"role" validate:"required,oneof=teacher student parent"`
 	Password string `json:"password" validate:"required"`
+	Code     string `json:"code" validate:"required"`
 }
 
-type LoginUserPayload struct {
+type ForgotPasswordPayload struct {
+	Phone string `json:"phone" validate:"required"`
+}
+
+type ResetPasswordPayload struct {
 	Phone    string `json:"phone" validate:"required"`
+	Code     string `json:"code" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+type LoginUserPayload struct {
+	// max - длина login_attempts.phone, иначе попытку нельзя записать
+	Phone    string `json:"phone" validate:"required,max=15"`
 	Password string `json:"password" validate:"required"`
 }
 
@@ -187,6 +628,69 @@ type AlphaAuthResponse struct {
 	Token string `json:"token"`
 }
 
+// AlphaUserQuery - фильтр teacher/index и customer/index. Пустые поля не отправляются.
+type AlphaUserQuery struct {
+	ID    int    `json:"id,omitempty"`
+	Phone string `json:"phone,omitempty"`
+	Page  int    `json:"page"`
+}
+
+// AlphaLessonQuery - фильтр lesson/index. Пустые поля не отправляются.
+type AlphaLessonQuery struct {
+	ID         int    `json:"id,omitempty"`
+	CustomerID int    `json:"customer_id,omitempty"`
+	TeacherID  int    `json:"teacher_id,omitempty"`
+	Status     int    `json:"status,omitempty"`
+	Page       int    `json:"page"`
+	DateFrom   string `json:"date_from,omitempty"`
+	DateTo     string `json:"date_to,omitempty"`
+}
+
+// AlphaPageQuery - номер страницы для index-методов без фильтра.
+type AlphaPageQuery struct {
+	Page int `json:"page"`
+}
+
+type AlphaSubject struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type AlphaRoom struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	IsEnabled int    `json:"is_enabled"`
+}
+
+type AlphaGroup struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	TeacherIDs []int  `json:"teacher_ids"`
+	BDate      string `json:"b_date"`
+	EDate      string `json:"e_date"`
+}
+
+// AlphaGroupCustomer - участие клиента в группе (cgi/index).
+type AlphaGroupCustomer struct {
+	ID         int    `json:"id"`
+	CustomerID int    `json:"customer_id"`
+	GroupID    int    `json:"group_id"`
+	BDate      string `json:"b_date"`
+	EDate      string `json:"e_date"`
+}
+
+// AlphaWebhookEvent - уведомление AlfaCRM об изменении сущности. EntityID - ID в CRM.
+type AlphaWebhookEvent struct {
+	BranchID  int            `json:"branch_id"`
+	Event     string         `json:"event"`  // create, update, delete
+	Entity    string         `json:"entity"` // Lesson, Customer, Teacher и другие
+	EntityID  int            `json:"entity_id"`
+	FieldsOld map[string]any `json:"fields_old"`
+	FieldsNew map[string]any `json:"fields_new"`
+	UserID    int            `json:"user_id"`
+	Datetime  string         `json:"datetime"`
+}
+
 type GetUserResponse struct {
 	Total int                   `json:"total"`
 	Count int                   `json:"count"`
@@ -195,11 +699,14 @@ type GetUserResponse struct {
 }
 
 type GetUserResponseItem struct {
-	ID              int    `json:"id"`
-	Name            string `json:"name"`
-	Balance         string `json:"balance"`
-	PaidLessonCount int    `json:"paid_lesson_count"`
-	Role            string `json:"role"`
+	ID              int      `json:"id"`
+	Name            string   `json:"name"`
+	Balance         string   `json:"balance"`
+	PaidLessonCount int      `json:"paid_lesson_count"`
+	LegalName       string   `json:"legal_name"`
+	Phone           []string `json:"phone"`
+	IsStudy         int      `json:"is_study"`
+	Role            string   `json:"role"`
 }
 
 type GetLessonsPayload struct {
@@ -228,17 +735,115 @@ type GetLessonsResponseItem struct {
 	RoomID         int    `json:"room_id"`
 	TeacherIDs     []int  `json:"teacher_ids"`
 	CustomerIDs    []int  `json:"customer_ids"`
+	GroupIDs       []int  `json:"group_ids"`
 	Streaming      any    `json:"streaming"`
 	Topic          string `json:"topic"`
 	Note           string `json:"note"`
 	Homework       any    `json:"homework"`
 	HomeworkStatus int    `json:"homework_status"`
 	HomeworkID     *int   `json:"homework_id"`
+
+	// названия из справочников, AlfaCRM их не возвращает
+	SubjectName string          `json:"subject_name,omitempty"`
+	RoomName    string          `json:"room_name,omitempty"`
+	Groups      []ReferenceItem `json:"groups,omitempty"`
+	// MaterialsCount - число материалов, прикрепленных к уроку в библиотеке
+	MaterialsCount int `json:"materials_count,omitempty"`
+}
+
+// ReferenceItem - запись справочника AlfaCRM: предмет, аудитория или группа.
+type ReferenceItem struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	IsActive bool   `json:"is_active"`
+}
+
+// LearningGroup - группа AlfaCRM с текущими учениками. ID учеников и преподавателей локальные.
+type LearningGroup struct {
+	ReferenceItem
+	TeacherIDs  []int   `json:"teacher_ids"`
+	CustomerIDs []int   `json:"customer_ids"`
+	BDate       *string `json:"b_date"`
+	EDate       *string `json:"e_date"`
+}
+
+// ReferenceSyncReport - итог синхронизации справочников школы.
+type ReferenceSyncReport struct {
+	TenantID        int       `json:"tenant_id"`
+	Subjects        int       `json:"subjects"`
+	Rooms           int       `json:"rooms"`
+	Groups          int       `json:"groups"`
+	HomeworksLinked int       `json:"homeworks_linked"`
+	Errors          []string  `json:"errors"`
+	StartedAt       time.Time `json:"started_at"`
+	FinishedAt      time.Time `json:"finished_at"`
+}
+
+// SubjectReport - сводка по ДЗ одного предмета.
+type SubjectReport struct {
+	SubjectID    *int   `json:"subject_id"`
+	SubjectName  string `json:"subject_name"`
+	Homeworks    int    `json:"homeworks"`
+	Solutions    int    `json:"solutions"`
+	Accepted     int    `json:"accepted"`
+	UnderReview  int    `json:"under_review"`
+	NotSubmitted int    `json:"not_submitted"`
+	Rejected     int    `json:"rejected"`
 }
 
 type AllFutureLessonsResponse struct {
 	Count int                      `json:"count"`
 	Items []GetLessonsResponseItem `json:"items"`
+	// SyncedAt - время последней синхронизации уроков с AlfaCRM, nil если уроки получены из CRM напрямую
+	SyncedAt *time.Time `json:"synced_at"`
+	// Stale - CRM недоступна, уроки из локальной копии могут быть устаревшими
+	Stale bool `json:"stale"`
+}
+
+// LessonFilter - выборка уроков из локальной копии. Нулевые поля не фильтруют.
+type LessonFilter struct {
+	TenantID   int
+	CustomerID int
+	TeacherID  int
+	SubjectID  int
+	Statuses   []int
+	DateFrom   string
+	DateTo     string
+	// уроки упорядочены по дате, времени начала и ID, Desc - от поздних к ранним
+	Desc bool
+	// After - вернуть уроки, которые идут после курсора в порядке сортировки
+	After *LessonCursor
+	// Limit - 0 без ограничения
+	Limit int
+}
+
+// LessonCursor - позиция урока в сортировке ListLessons.
+type LessonCursor struct {
+	Date     string `json:"d"`
+	TimeFrom string `json:"t,omitempty"` // пусто, если время урока не указано
+	ID       int    `json:"id"`
+	Desc     bool   `json:"desc,omitempty"`
+}
+
+// LessonPage - страница GET /lessons. Items - уроки целиком или только запрошенные поля.
+type LessonPage struct {
+	Count      int        `json:"count"`
+	Items      []any      `json:"items"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+	SyncedAt   *time.Time `json:"synced_at"`
+	Stale      bool       `json:"stale"`
+}
+
+// LessonSyncState - результат последней синхронизации уроков школы.
+type LessonSyncState struct {
+	TenantID    int        `json:"tenant_id"`
+	SyncedAt    *time.Time `json:"synced_at"`
+	WindowFrom  *time.Time `json:"window_from"`
+	WindowTo    *time.Time `json:"window_to"`
+	Upserted    int        `json:"upserted"`
+	Deleted     int        `json:"deleted"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
 }
 
 type LessonRate struct {
@@ -254,14 +859,82 @@ type LessonRate struct {
 	LessonID          string    `json:"lesson_id"`
 	LessonDate        time.Time `json:"lesson_date"`
 	Rate              int8      `json:"rate"`
+
+	SubjectID *int            `json:"subject_id,omitempty"`
+	Comment   string          `json:"comment,omitempty"`
+	Scores    map[string]int8 `json:"scores,omitempty"`
+	CreatedAt *time.Time      `json:"created_at,omitempty"`
 }
 
+// Категории, по которым ученик может дополнительно оценить урок.
+const (
+	RateCategoryExplanation = "explanation"
+	RateCategoryEngagement  = "engagement"
+	RateCategoryPunctuality = "punctuality"
+	RateCategoryMaterials   = "materials"
+)
+
+// RateLessonPayload - оценка урока учеником. StudentID и LessonDate берутся
+// из токена и урока, значения из запроса игнорируются.
 type RateLessonPayload struct {
-	StudentID  int       `json:"student_id"`
-	TeacherID  int       `json:"teacher_id"`
-	LessonID   int       `json:"lesson_id"`
-	LessonDate time.Time `json:"lesson_date"`
-	Rate       int8      `json:"rate"`
+	StudentID  int             `json:"student_id"`
+	TeacherID  int             `json:"teacher_id" validate:"required"`
+	LessonID   int             `json:"lesson_id" validate:"required"`
+	LessonDate time.Time       `json:"lesson_date"`
+	Rate       int8            `json:"rate" validate:"required,min=1,max=5"`
+	Comment    string          `json:"comment" validate:"max=2000"`
+	Scores     map[string]int8 `json:"scores" validate:"omitempty,dive,keys,oneof=explanation engagement punctuality materials,endkeys,min=1,max=5"`
+}
+
+// NewLessonRate - проверенная оценка урока для сохранения.
+type NewLessonRate struct {
+	TenantID   int
+	StudentID  int
+	TeacherID  int
+	LessonID   int
+	SubjectID  int
+	LessonDate time.Time
+	Rate       int8
+	Comment    string
+	Scores     map[string]int8
+}
+
+const (
+	LessonRateGroupByTeacher = "teacher"
+	LessonRateGroupBySubject = "subject"
+	LessonRateGroupByWeek    = "week"
+	LessonRateGroupByMonth   = "month"
+)
+
+// LessonRateFilter - нулевые поля не фильтруют, период по дате урока.
+type LessonRateFilter struct {
+	TenantID  int
+	TeacherID int
+	SubjectID int
+	DateFrom  *time.Time
+	DateTo    *time.Time
+}
+
+// LessonRateGroup - сводка оценок преподавателя, предмета или периода.
+// Key - ID преподавателя или предмета либо начало периода (YYYY-MM-DD).
+// Distribution[i] - число оценок i+1, Categories - средние по категориям.
+// Trend - изменение среднего к предыдущему периоду, нет - не с чем сравнить.
+type LessonRateGroup struct {
+	Key          string             `json:"key"`
+	Name         string             `json:"name,omitempty"`
+	Count        int                `json:"count"`
+	Average      float64            `json:"average"`
+	Distribution [5]int             `json:"distribution"`
+	Categories   map[string]float64 `json:"categories"`
+	Comments     int                `json:"comments"`
+	Trend        *float64           `json:"trend,omitempty"`
+}
+
+type LessonRateStats struct {
+	GroupBy  string            `json:"group_by"`
+	DateFrom string            `json:"date_from,omitempty"`
+	DateTo   string            `json:"date_to,omitempty"`
+	Groups   []LessonRateGroup `json:"groups"`
 }
 
 // HOMEWORK
@@ -278,6 +951,7 @@ type HomeworkAssignment struct {
 	TeacherID    int
 	LessonID     int
 	StudentIDs   []int
+	SubjectID    *int
 	SubjectTitle string
 	LessonTopic  string
 	LessonDate   time.Time
@@ -309,7 +983,8 @@ type SolutionFile struct {
 }
 
 type UpdateSolutionPayload struct {
-	Status int `json:"status"`
+	Status      int     `json:"status"`
+	ReviewNotes *string `json:"review_notes"`
 }
 
 type HomeworkResponse struct {
@@ -320,7 +995,317 @@ type HomeworkResponse struct {
 	Files          []int  `json:"file_id"`
 }
 
+// HomeworkAccess - данные о владельцах домашнего задания, решения или файла,
+// по которым проверяется доступ. StudentID заполнен только для решений и файлов ученика.
+type HomeworkAccess struct {
+	TenantID   int
+	HomeworkID int
+	TeacherID  int
+	StudentID  int
+}
+
 type HomeworkFile struct {
 	ID       int    `json:"id"`
 	FilePath string `json:"file_path"`
 }
+
+// Notification - уведомление пользователя внутри платформы.
+type Notification struct {
+	ID        int            `json:"id"`
+	TenantID  int            `json:"-"`
+	UserID    int            `json:"user_id"`
+	Type      string         `json:"type"` // например balance.low
+	Title     string         `json:"title"`
+	Body      string         `json:"body"`
+	Data      map[string]any `json:"data,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+	ReadAt    *time.Time     `json:"read_at,omitempty"`
+}
+
+const NotificationBalanceLow = "balance.low"
+
+type NotificationsResponse struct {
+	Unread int            `json:"unread"`
+	Items  []Notification `json:"items"`
+}
+
+// StudentBalance - остаток на счете ученика по данным AlfaCRM.
+type StudentBalance struct {
+	StudentID       int        `json:"student_id"`
+	FirstName       string     `json:"first_name"`
+	LastName        string     `json:"last_name"`
+	MiddleName      string     `json:"middle_name"`
+	Phone           string     `json:"phone,omitempty"`
+	IsActive        bool       `json:"is_active"`
+	Balance         *float64   `json:"balance"`
+	PaidLessonCount *int       `json:"paid_lesson_count"`
+	PlannedLessons  int        `json:"planned_lessons"`
+	LowBalance      bool       `json:"low_balance"`
+	SyncedAt        *time.Time `json:"synced_at"`
+}
+
+// AccountSummary - остаток ученика или детей родителя.
+type AccountSummary struct {
+	Students []StudentBalance `json:"students"`
+	// PlannedUntil - до какой даты считаются запланированные уроки
+	PlannedUntil string `json:"planned_until"`
+	Threshold    int    `json:"low_paid_lessons_threshold"`
+}
+
+type LowBalanceFilter struct {
+	TenantID int
+	// в отчет попадают ученики с балансом не больше MaxBalance или с числом оплаченных занятий меньше PaidLessonsBelow
+	MaxBalance       float64
+	PaidLessonsBelow int
+	IncludeInactive  bool
+}
+
+// BalanceAlert - предупреждение о заканчивающихся занятиях ученика.
+type BalanceAlert struct {
+	TenantID        int
+	StudentID       int
+	FirstName       string
+	LastName        string
+	PaidLessonCount int
+	ParentIDs       []int
+}
+
+// CalendarFeed - ссылка на ICS-календарь уроков пользователя.
+type CalendarFeed struct {
+	UserID        int        `json:"user_id"`
+	TenantID      int        `json:"-"`
+	Role          string     `json:"-"`
+	URL           string     `json:"url,omitempty"` // только сразу после создания, токен не хранится
+	CreatedAt     time.Time  `json:"created_at"`
+	RotatedAt     *time.Time `json:"rotated_at,omitempty"`
+	LastFetchedAt *time.Time `json:"last_fetched_at,omitempty"`
+}
+
+const (
+	AttendancePresent = "present"
+	AttendanceAbsent  = "absent"
+	AttendanceLate    = "late"
+)
+
+// AttendanceMark - отметка ученика на уроке.
+type AttendanceMark struct {
+	StudentID int    `json:"student_id" validate:"required"`
+	Status    string `json:"status" validate:"required,oneof=present absent late"`
+	Note      string `json:"note" validate:"max=500"`
+}
+
+type MarkAttendancePayload struct {
+	Items []AttendanceMark `json:"items" validate:"required,min=1,dive"`
+}
+
+// AttendanceLesson - урок, для которого сохраняются отметки.
+type AttendanceLesson struct {
+	TenantID   int
+	LessonID   int
+	LessonDate time.Time
+	TeacherIDs []int
+}
+
+type AttendanceRecord struct {
+	LessonID   int       `json:"lesson_id"`
+	LessonDate string    `json:"lesson_date"`
+	StudentID  int       `json:"student_id"`
+	FirstName  string    `json:"first_name"`
+	LastName   string    `json:"last_name"`
+	Status     string    `json:"status"`
+	Note       string    `json:"note,omitempty"`
+	MarkedBy   int       `json:"marked_by"`
+	MarkedAt   time.Time `json:"marked_at"`
+}
+
+// AttendanceSummary - посещаемость ученика за период. Rate - доля уроков, на которых
+// ученик был, включая опоздания.
+type AttendanceSummary struct {
+	StudentID int     `json:"student_id"`
+	FirstName string  `json:"first_name"`
+	LastName  string  `json:"last_name"`
+	Total     int     `json:"total"`
+	Present   int     `json:"present"`
+	Late      int     `json:"late"`
+	Absent    int     `json:"absent"`
+	Rate      float64 `json:"rate"`
+}
+
+// AttendanceHistory - отметки ученика и итог по ним.
+type AttendanceHistory struct {
+	Summary AttendanceSummary  `json:"summary"`
+	Items   []AttendanceRecord `json:"items"`
+}
+
+// AttendanceFilter - нулевые поля не фильтруют. TeacherID - только уроки преподавателя.
+type AttendanceFilter struct {
+	TenantID  int
+	StudentID int
+	TeacherID int
+	DateFrom  *time.Time
+	DateTo    *time.Time
+}
+
+// AlphaAttendance - посещение урока клиентом в формате lesson/update (поле details).
+type AlphaAttendance struct {
+	CustomerID int    `json:"customer_id"`
+	IsAttend   int    `json:"is_attend"`
+	Note       string `json:"note,omitempty"`
+}
+
+// StatsPeriod - период статистики, обе даты включительно.
+type StatsPeriod struct {
+	From time.Time
+	To   time.Time
+}
+
+// TeacherStats - показатели преподавателя за период. Средние равны nil, если не из чего считать.
+type TeacherStats struct {
+	TeacherID   int                  `json:"teacher_id"`
+	FirstName   string               `json:"first_name"`
+	LastName    string               `json:"last_name"`
+	MiddleName  string               `json:"middle_name"`
+	DateFrom    string               `json:"date_from"`
+	DateTo      string               `json:"date_to"`
+	LessonsHeld int                  `json:"lessons_held"`
+	Ratings     TeacherRatingStats   `json:"ratings"`
+	Homework    TeacherHomeworkStats `json:"homework"`
+	Chat        TeacherChatStats     `json:"chat"`
+}
+
+// TeacherRatingStats - оценки уроков преподавателя по дате урока.
+type TeacherRatingStats struct {
+	Count        int      `json:"count"`
+	Average      *float64 `json:"average"`
+	Distribution [5]int   `json:"distribution"`
+	Comments     int      `json:"comments"`
+}
+
+// TeacherHomeworkStats - ДЗ, заданные за период, статусы их решений и проверки за период.
+// ReviewHours - время от сдачи решения до его проверки.
+type TeacherHomeworkStats struct {
+	Assigned          int      `json:"assigned"`
+	Solutions         int      `json:"solutions"`
+	NotSubmitted      int      `json:"not_submitted"`
+	UnderReview       int      `json:"under_review"`
+	Accepted          int      `json:"accepted"`
+	Rejected          int      `json:"rejected"`
+	Reviewed          int      `json:"reviewed"`
+	AvgReviewHours    *float64 `json:"avg_review_hours"`
+	MedianReviewHours *float64 `json:"median_review_hours"`
+}
+
+// TeacherChatStats - ответы на сообщения учеников и родителей. Вопрос - первое сообщение
+// собеседника после ответа преподавателя или в начале чата.
+type TeacherChatStats struct {
+	Questions             int      `json:"questions"`
+	Answered              int      `json:"answered"`
+	AvgResponseMinutes    *float64 `json:"avg_response_minutes"`
+	MedianResponseMinutes *float64 `json:"median_response_minutes"`
+}
+
+// TeacherStatsReport - показатели преподавателя и те же показатели за предыдущий период той же длины.
+type TeacherStatsReport struct {
+	Current  TeacherStats `json:"current"`
+	Previous TeacherStats `json:"previous"`
+}
+
+// TeacherRanking - место преподавателя в сводке по выбранному показателю.
+type TeacherRanking struct {
+	Rank int `json:"rank"`
+	TeacherStatsReport
+}
+
+const (
+	MaterialKindFile = "file"
+	MaterialKindLink = "link"
+)
+
+// MaterialFolder - папка библиотеки материалов школы.
+type MaterialFolder struct {
+	ID        int       `json:"id"`
+	TenantID  int       `json:"-"`
+	ParentID  *int      `json:"parent_id"`
+	Name      string    `json:"name"`
+	OwnerID   int       `json:"owner_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CreateFolderPayload struct {
+	Name     string `json:"name" validate:"required,max=255"`
+	ParentID *int   `json:"parent_id"`
+}
+
+type RenameFolderPayload struct {
+	Name string `json:"name" validate:"required,max=255"`
+}
+
+// Material - файл или ссылка из библиотеки. Filepath - путь к файлу на диске.
+type Material struct {
+	ID          int       `json:"id"`
+	TenantID    int       `json:"-"`
+	FolderID    *int      `json:"folder_id"`
+	SubjectID   *int      `json:"subject_id"`
+	Kind        string    `json:"kind"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Filename    string    `json:"filename,omitempty"`
+	Size        int64     `json:"size,omitempty"`
+	URL         string    `json:"url,omitempty"`
+	Filepath    string    `json:"-"`
+	OwnerID     int       `json:"owner_id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// UpdateMaterialPayload - нулевые поля не меняются, folder_id 0 переносит материал
+// в корень библиотеки, subject_id 0 снимает предмет.
+type UpdateMaterialPayload struct {
+	Title       *string `json:"title" validate:"omitempty,min=1,max=500"`
+	Description *string `json:"description" validate:"omitempty,max=5000"`
+	FolderID    *int    `json:"folder_id" validate:"omitempty,min=0"`
+	SubjectID   *int    `json:"subject_id" validate:"omitempty,min=0"`
+}
+
+type AttachMaterialPayload struct {
+	MaterialID int `json:"material_id" validate:"required"`
+}
+
+// MaterialFilter - нулевые поля не фильтруют. Query ищет по названию и описанию.
+type MaterialFilter struct {
+	TenantID  int
+	FolderID  *int
+	SubjectID int
+	OwnerID   int
+	Query     string
+}
+
+// LessonMaterialLink - прикрепление материала к уроку с участниками урока на этот момент.
+type LessonMaterialLink struct {
+	TenantID    int
+	LessonID    int
+	MaterialID  int
+	LessonDate  time.Time
+	SubjectID   int
+	TeacherIDs  []int
+	CustomerIDs []int
+	SharedBy    int
+}
+
+// LessonMaterialFilter - материалы уроков ученика (StudentID) или преподавателя (TeacherID)
+// до Until включительно.
+type LessonMaterialFilter struct {
+	TenantID  int
+	StudentID int
+	TeacherID int
+	DateFrom  *time.Time
+	Until     time.Time
+}
+
+// LessonMaterials - материалы одного урока.
+type LessonMaterials struct {
+	LessonID   int        `json:"lesson_id"`
+	LessonDate string     `json:"lesson_date"`
+	SubjectID  *int       `json:"subject_id"`
+	Materials  []Material `json:"materials"`
+}
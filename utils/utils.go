@@ -4,13 +4,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/go-playground/validator/v10"
+	"github.com/prok05/ecom/config"
 	"io"
 	"log"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -33,6 +36,112 @@ func WriteError(w http.ResponseWriter, status int, err error) {
 	WriteJSON(w, status, map[string]string{"error": err.Error()})
 }
 
+// StaleHeader - заголовок ответа с данными из локальной копии, пока AlfaCRM недоступна
+const StaleHeader = "X-Data-Stale"
+
+// MarkStale помечает ответ как устаревший, вызывается до WriteJSON.
+func MarkStale(w http.ResponseWriter) {
+	w.Header().Set(StaleHeader, "true")
+}
+
+// trustedProxies - подсети reverse proxy из TRUSTED_PROXIES
+var trustedProxies = parseTrustedProxies(config.Envs.TrustedProxies)
+
+// ClientIP возвращает IP клиента. X-Forwarded-For учитывается, только если соединение
+// пришло от доверенного прокси: адреса в заголовке просматриваются справа налево,
+// и клиентом считается первый адрес не из доверенных подсетей.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote := net.ParseIP(host)
+	if remote == nil {
+		return host
+	}
+	if !isTrustedProxy(remote) {
+		return remote.String()
+	}
+
+	client := remote
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			// подделанный или битый адрес, дальше заголовку верить нельзя
+			break
+		}
+		client = ip
+		if !isTrustedProxy(ip) {
+			break
+		}
+	}
+	return client.String()
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxies разбирает адреса и подсети через запятую, отдельный адрес
+// считается подсетью из одного адреса. Ошибочные значения пропускаются.
+func parseTrustedProxies(list string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, v := range strings.Split(list, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				log.Printf("invalid trusted proxy %q", v)
+				continue
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(v)
+		if err != nil {
+			log.Printf("invalid trusted proxy %q: %v", v, err)
+			continue
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// NormalizePhone приводит российский номер к виду +7XXXXXXXXXX, чтобы номера
+// из AlfaCRM в разных форматах можно было сравнивать. Прочие номера возвращаются
+// как есть без пробелов, скобок и дефисов.
+func NormalizePhone(phone string) string {
+	digits := make([]rune, 0, len(phone))
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			digits = append(digits, r)
+		}
+	}
+	if len(digits) == 0 {
+		return ""
+	}
+	if len(digits) == 11 && (digits[0] == '8' || digits[0] == '7') {
+		digits[0] = '7'
+	}
+	if len(digits) == 10 && digits[0] == '9' {
+		digits = append([]rune{'7'}, digits...)
+	}
+	return "+" + string(digits)
+}
+
 func StringsToInts(strings []string) ([]int, error) {
 	ints := make([]int, 0)
 
@@ -47,6 +156,12 @@ func StringsToInts(strings []string) ([]int, error) {
 }
 
 func WriteFile(fhs *multipart.FileHeader) (string, error) {
+	return SaveUpload(fhs, "homework")
+}
+
+// SaveUpload сохраняет загруженный файл в ./uploads под именем prefix-<время><расширение>
+// и возвращает путь к нему.
+func SaveUpload(fhs *multipart.FileHeader, prefix string) (string, error) {
 	src, err := fhs.Open()
 	if err != nil {
 		log.Println("error open file")
@@ -55,16 +170,18 @@ func WriteFile(fhs *multipart.FileHeader) (string, error) {
 	defer src.Close()
 
 	ext := filepath.Ext(fhs.Filename)
-	fileName := fmt.Sprintf("homework-%d%s", time.Now().UnixNano(), ext)
+	fileName := fmt.Sprintf("%s-%d%s", prefix, time.Now().UnixNano(), ext)
 	dest, err := os.Create("./uploads/" + fileName)
 	if err != nil {
 		log.Println("error create file", err)
+		return "", err
 	}
 	defer dest.Close()
 
 	_, err = io.Copy(dest, src)
 	if err != nil {
 		log.Println("error copy file", err)
+		os.Remove(dest.Name())
 		return "", err
 	}
 	return dest.Name(), nil